@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const ackReaperInterval = time.Minute
+
+// ack is the acknowledgement of a failing service, pod or check, stored as a JSON value in the acks configMap.
+// Acks used to be stored as plain messages; those are still read, as acks without metadata that never expire.
+type ack struct {
+	Message   string     `json:"message"`
+	Author    string     `json:"author,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Ticket    string     `json:"ticket,omitempty"`
+	// Silence is the ID of the silence the ack stands for. Such acks are never stored, they are attached to the
+	// check results of silenced services.
+	Silence string `json:"silence,omitempty"`
+}
+
+// newAck creates an ack starting now. A zero duration means that the ack never expires.
+func newAck(message string, author string, ticket string, duration time.Duration, now time.Time) ack {
+	a := ack{
+		Message:   message,
+		Author:    author,
+		CreatedAt: now.UTC(),
+		Ticket:    ticket,
+	}
+	if duration > 0 {
+		expiresAt := a.CreatedAt.Add(duration)
+		a.ExpiresAt = &expiresAt
+	}
+	return a
+}
+
+// parseAck parses a value of the acks configMap, which is either a JSON encoded ack or a plain message.
+func parseAck(value string) ack {
+	var a ack
+	if strings.HasPrefix(strings.TrimSpace(value), "{") && json.Unmarshal([]byte(value), &a) == nil && a.Message != "" {
+		return a
+	}
+	return ack{Message: value}
+}
+
+func (a ack) encode() (string, error) {
+	value, err := json.Marshal(a)
+	if err != nil {
+		return "", fmt.Errorf("cannot encode ack [%s]: %v", a.Message, err)
+	}
+	return string(value), nil
+}
+
+func (a ack) isExpired(now time.Time) bool {
+	return a.ExpiresAt != nil && !now.Before(*a.ExpiresAt)
+}
+
+// details describes the metadata of the ack, e.g. "by jane.doe, since 2024-01-02 15:04:05 UTC, until 2024-01-03 15:04:05 UTC, ticket UPPSF-1234".
+// It is empty for acks stored as plain messages.
+func (a ack) details() string {
+	var details []string
+	if a.Author != "" {
+		details = append(details, "by "+a.Author)
+	}
+	if !a.CreatedAt.IsZero() {
+		details = append(details, "since "+a.CreatedAt.Format(timeLayout))
+	}
+	if a.ExpiresAt != nil {
+		details = append(details, "until "+a.ExpiresAt.Format(timeLayout))
+	}
+	if a.Ticket != "" {
+		details = append(details, "ticket "+a.Ticket)
+	}
+	if a.Silence != "" {
+		details = append(details, "silence "+a.Silence)
+	}
+	return strings.Join(details, ", ")
+}
+
+// summary is the ack message followed by its metadata, as shown to the consumers of the JSON output.
+func (a ack) summary() string {
+	if details := a.details(); details != "" {
+		return fmt.Sprintf("%s (%s)", a.Message, details)
+	}
+	return a.Message
+}
+
+// activeAck returns the ack value to attach to check results, or an empty string if the ack has expired
+// and is only waiting to be removed by the reaper.
+func activeAck(value string, now time.Time) string {
+	if value == "" || parseAck(value).isExpired(now) {
+		return ""
+	}
+	return value
+}
+
+func (a ack) isSilence() bool {
+	return a.Silence != ""
+}
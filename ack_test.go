@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAckPlainMessage(t *testing.T) {
+	a := parseAck("investigating the outage")
+	assert.Equal(t, "investigating the outage", a.Message)
+	assert.Empty(t, a.details())
+	assert.False(t, a.isExpired(time.Now()))
+}
+
+func TestParseAckStructuredValue(t *testing.T) {
+	now := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	value, err := newAck("known issue", "jane.doe", "UPPSF-1", time.Hour, now).encode()
+	assert.NoError(t, err)
+
+	a := parseAck(value)
+	assert.Equal(t, "known issue", a.Message)
+	assert.Equal(t, "jane.doe", a.Author)
+	assert.Equal(t, "UPPSF-1", a.Ticket)
+	assert.True(t, now.Equal(a.CreatedAt))
+	assert.False(t, a.isExpired(now.Add(59*time.Minute)))
+	assert.True(t, a.isExpired(now.Add(time.Hour)))
+}
+
+func TestParseAckJSONLookingMessage(t *testing.T) {
+	a := parseAck("{not json")
+	assert.Equal(t, "{not json", a.Message)
+}
+
+func TestNewAckWithoutDurationNeverExpires(t *testing.T) {
+	a := newAck("known issue", "", "", 0, time.Now())
+	assert.Nil(t, a.ExpiresAt)
+	assert.False(t, a.isExpired(time.Now().Add(365*24*time.Hour)))
+}
+
+func TestActiveAck(t *testing.T) {
+	now := time.Now()
+	expired, err := newAck("old issue", "", "", time.Minute, now.Add(-time.Hour)).encode()
+	assert.NoError(t, err)
+	active, err := newAck("known issue", "", "", time.Hour, now).encode()
+	assert.NoError(t, err)
+
+	assert.Equal(t, "", activeAck("", now))
+	assert.Equal(t, "plain ack", activeAck("plain ack", now))
+	assert.Equal(t, active, activeAck(active, now))
+	assert.Equal(t, "", activeAck(expired, now))
+}
+
+func TestPodAckKeys(t *testing.T) {
+	p := pod{name: "service1-7d9f8-x2x4z", namespace: "publishing"}
+	assert.Equal(t, "pod.publishing.service1-7d9f8-x2x4z", p.ackKey())
+	assert.Equal(t, "check.publishing.service1-7d9f8-x2x4z.Check-connectivity-to-Kafka--eu-west-1-", p.checkAckKey("Check connectivity to Kafka (eu-west-1)"))
+	assert.Equal(t, "pod.default.service1-7d9f8-x2x4z", pod{name: "service1-7d9f8-x2x4z"}.ackKey())
+}
+
+func TestParsePodAckKey(t *testing.T) {
+	p := pod{name: "service1.7d9f8", namespace: "publishing"}
+	for _, key := range []string{p.ackKey(), p.checkAckKey("kafka")} {
+		namespace, podName, ok := parsePodAckKey(key)
+		assert.True(t, ok, key)
+		assert.Equal(t, "publishing", namespace)
+		assert.Equal(t, "service1.7d9f8", podName)
+	}
+
+	// the keys of services, including those of services in namespaces named like the prefixes
+	for _, key := range []string{"service1", "publishing.service1", "pod.service1", "check.service1"} {
+		_, _, ok := parsePodAckKey(key)
+		assert.False(t, ok, key)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// failingChecksReason is the reason of the failure of a pod whose health was fetched, but which has failing checks.
+// The other reasons are the kinds of transport errors, see probeFailure.
+const failingChecksReason = "failing checks"
+
+// podFailure describes why a pod of a service is failing.
+type podFailure struct {
+	Pod   string `json:"pod"`
+	Acked bool   `json:"acked,omitempty"`
+	// Reason tells the failing checks of the pod apart from the failures to fetch its health (timeout, non-200 status, invalid response...)
+	Reason        string         `json:"reason"`
+	Error         string         `json:"error,omitempty"`
+	FailingChecks []failingCheck `json:"failingChecks,omitempty"`
+	// Restarts is the number of restarts of the containers of the pod
+	Restarts int32 `json:"restarts,omitempty"`
+}
+
+type failingCheck struct {
+	Name     string `json:"name"`
+	Severity uint8  `json:"severity"`
+}
+
+// podFailures lists the failing pods of a service, in the order of the pods.
+type podFailures []podFailure
+
+// checkBreakdown details the check of a service: the quorum of its pods, the pods that are failing and the versions they run.
+type checkBreakdown struct {
+	quorum      quorumStatus
+	failingPods podFailures
+	versions    versionStatus
+	// probedPods are the names of the pods probed in the check, in the order of the pods
+	probedPods []string
+}
+
+// newCheckBreakdown derives the quorum and the failing pods of a service from the probes its check is evaluated with.
+func newCheckBreakdown(probe serviceProbe, workloads map[string]workload, now time.Time) checkBreakdown {
+	failingPods := getFailingPods(probe, now)
+	quorum := evaluateQuorum(probe, workloads, failingPods)
+	probedPods := make([]string, 0, len(probe.pods))
+	for _, podProbe := range probe.pods {
+		probedPods = append(probedPods, podProbe.pod.name)
+	}
+	return checkBreakdown{
+		quorum:      quorum,
+		failingPods: failingPods,
+		versions:    getVersions(probe, quorum),
+		probedPods:  probedPods,
+	}
+}
+
+// getFailingPods lists the failing pods of a service. Only the failing checks that have not been acked are listed,
+// as the acked ones do not make a pod fail.
+func getFailingPods(probe serviceProbe, now time.Time) podFailures {
+	var failingPods podFailures
+	for _, podProbe := range probe.pods {
+		if _, err := podProbe.status(now); err == nil {
+			continue
+		}
+
+		failure := podFailure{
+			Pod:      podProbe.pod.name,
+			Acked:    activeAck(podProbe.pod.ack, now) != "",
+			Restarts: podProbe.pod.lifecycle.restarts,
+		}
+		if podProbe.err != nil {
+			failure.Reason = string(getProbeFailure(podProbe.err))
+			failure.Error = podProbe.err.Error()
+		} else {
+			failure.Reason = failingChecksReason
+			failure.FailingChecks = podProbe.failingChecks(now)
+		}
+		failingPods = append(failingPods, failure)
+	}
+	return failingPods
+}
+
+func (b podFailures) countAcked() int {
+	acked := 0
+	for _, failure := range b {
+		if failure.Acked {
+			acked++
+		}
+	}
+	return acked
+}
+
+func (b podFailures) names() []string {
+	names := make([]string, 0, len(b))
+	for _, failure := range b {
+		names = append(names, failure.Pod)
+	}
+	return names
+}
+
+// summary describes the failing pods in a line, e.g. "pod-a: failing checks Kafka (severity 1); pod-b (acked): crash loop after 5 restarts".
+func (b podFailures) summary() string {
+	descriptions := make([]string, 0, len(b))
+	for _, failure := range b {
+		description := failure.Pod
+		if failure.Acked {
+			description += " (acked)"
+		}
+		description = fmt.Sprintf("%s: %s", description, failure.Reason)
+		if failure.Restarts != 0 {
+			description = fmt.Sprintf("%s after %d restarts", description, failure.Restarts)
+		}
+
+		checks := make([]string, 0, len(failure.FailingChecks))
+		for _, check := range failure.FailingChecks {
+			checks = append(checks, fmt.Sprintf("%s (severity %d)", check.Name, check.Severity))
+		}
+		if len(checks) != 0 {
+			description = fmt.Sprintf("%s %s", description, strings.Join(checks, ", "))
+		}
+		descriptions = append(descriptions, description)
+	}
+	return strings.Join(descriptions, "; ")
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestGetHealthChecksForPodOnceClassifiesFailures(t *testing.T) {
+	tests := []struct {
+		name            string
+		doFunc          func(req *http.Request) (*http.Response, error)
+		expectedFailure probeFailure
+	}{
+		{
+			name: "timeout",
+			doFunc: func(_ *http.Request) (*http.Response, error) {
+				return nil, fmt.Errorf("Get \"http://10.2.3.4:8080/__health\": %w", timeoutError{})
+			},
+			expectedFailure: probeTimeout,
+		},
+		{
+			name: "unreachable",
+			doFunc: func(_ *http.Request) (*http.Response, error) {
+				return nil, errors.New("connection refused")
+			},
+			expectedFailure: probeRequestFailed,
+		},
+		{
+			name:            "non-200 status",
+			doFunc:          respondWith(http.StatusServiceUnavailable, ""),
+			expectedFailure: probeNon200Status,
+		},
+		{
+			name:            "invalid JSON",
+			doFunc:          respondWith(http.StatusOK, "not json"),
+			expectedFailure: probeInvalidResponse,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://10.2.3.4:8080/__health", nil)
+			_, _, err := getHealthChecksForPodOnce(req, &mockHTTPClient{doFunc: test.doFunc})
+			assert.Error(t, err)
+			assert.Equal(t, test.expectedFailure, getProbeFailure(err))
+		})
+	}
+}
+
+func TestGetProbeFailureOfUnclassifiedErrors(t *testing.T) {
+	assert.Equal(t, probeTimeout, getProbeFailure(fmt.Errorf("cannot send request to 10.2.3.4:8080: %w", context.DeadlineExceeded)))
+	assert.Equal(t, probeRequestFailed, getProbeFailure(context.Canceled))
+}
+
+func TestNewCheckBreakdownListsOnlyFailingPods(t *testing.T) {
+	now := time.Now()
+	ackedCheck := pod{name: "pod-b", checkAcks: map[string]string{ackKeySegment("Neo4j"): "known issue"}}
+	probe := serviceProbe{pods: []podProbe{
+		{pod: pod{name: "pod-a"}, health: healthcheckResponse{Checks: []podCheck{{Name: "Kafka", OK: true, Severity: 1}}}},
+		{pod: ackedCheck, health: healthcheckResponse{Checks: []podCheck{
+			{Name: "Kafka", OK: false, Severity: 2},
+			{Name: "Neo4j", OK: false, Severity: 1},
+			{Name: "Mongo", OK: false, Severity: 3},
+		}}},
+		{pod: pod{name: "pod-c"}, err: &probeError{failure: probeTimeout, msg: "Error performing healthcheck request: i/o timeout"}},
+		{pod: pod{name: "pod-d", ack: "bad node"}, err: &probeError{failure: probeInvalidResponse, msg: "Error parsing healthcheck response: EOF"}},
+	}}
+
+	breakdown := newCheckBreakdown(probe, nil, now)
+
+	assert.Equal(t, podFailures{
+		{Pod: "pod-b", Reason: failingChecksReason, FailingChecks: []failingCheck{{Name: "Kafka", Severity: 2}, {Name: "Mongo", Severity: 3}}},
+		{Pod: "pod-c", Reason: "timeout", Error: "Error performing healthcheck request: i/o timeout"},
+		{Pod: "pod-d", Acked: true, Reason: "invalid response", Error: "Error parsing healthcheck response: EOF"},
+	}, breakdown.failingPods)
+	assert.Equal(t, 1, breakdown.failingPods.countAcked())
+	assert.Equal(t, "pod-b: failing checks Kafka (severity 2), Mongo (severity 3); pod-c: timeout; pod-d (acked): invalid response", breakdown.failingPods.summary())
+	assert.Equal(t, quorumStatus{Policy: "all", Healthy: 2, Desired: 4, Required: 4}, breakdown.quorum)
+	assert.Equal(t, []string{"pod-a", "pod-b", "pod-c", "pod-d"}, breakdown.probedPods)
+}
+
+func TestNewCheckBreakdownOfHealthyService(t *testing.T) {
+	probe := serviceProbe{pods: []podProbe{
+		{pod: pod{name: "pod-a"}, health: healthcheckResponse{Checks: []podCheck{{Name: "Kafka", OK: true}}}},
+	}}
+
+	breakdown := newCheckBreakdown(probe, nil, time.Now())
+	assert.Empty(t, breakdown.failingPods)
+	assert.True(t, breakdown.quorum.met())
+	assert.False(t, breakdown.quorum.Degraded)
+}
+
+func TestPodProbeStatusListsAllFailingChecks(t *testing.T) {
+	probe := podProbe{pod: pod{name: "pod-a"}, health: healthcheckResponse{Checks: []podCheck{
+		{Name: "Kafka", OK: false, Severity: 2},
+		{Name: "Neo4j", OK: false, Severity: 1},
+	}}}
+
+	_, err := probe.status(time.Now())
+	assert.EqualError(t, err, "failing checks are: Kafka, Neo4j")
+}
@@ -1,30 +1,70 @@
 package main
 
-import fthealth "github.com/Financial-Times/go-fthealth/v1_1"
+import (
+	"sync"
+	"time"
 
-type cachedHealth struct {
-	toWriteToCache  chan fthealth.CheckResult
-	toReadFromCache chan fthealth.CheckResult
-	terminate       chan bool
+	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
+)
+
+type storedResult struct {
+	checkResult fthealth.CheckResult
+	breakdown   checkBreakdown
+	lastUpdated time.Time
+}
+
+// resultStore keeps the latest check result of every scheduled service, and adds every result to the history of the service if it is given.
+// A service without an entry has no result yet.
+type resultStore struct {
+	sync.RWMutex
+	results map[string]storedResult
+	history *healthHistory
+}
+
+func newResultStore(history *healthHistory) *resultStore {
+	return &resultStore{
+		results: make(map[string]storedResult),
+		history: history,
+	}
+}
+
+func (s *resultStore) get(serviceName string) (storedResult, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	result, ok := s.results[serviceName]
+	return result, ok
+}
+
+func (s *resultStore) set(serviceName string, checkResult fthealth.CheckResult, breakdown checkBreakdown) {
+	now := time.Now()
+	s.Lock()
+	s.results[serviceName] = storedResult{
+		checkResult: checkResult,
+		breakdown:   breakdown,
+		lastUpdated: now,
+	}
+	s.Unlock()
+	if s.history != nil {
+		s.history.record(serviceName, checkResult, breakdown, now)
+	}
 }
 
-func newCachedHealth() *cachedHealth {
-	a := make(chan fthealth.CheckResult)
-	b := make(chan fthealth.CheckResult)
-	terminate := make(chan bool)
-	return &cachedHealth{
-		toWriteToCache:  a,
-		toReadFromCache: b,
-		terminate:       terminate,
+func (s *resultStore) delete(serviceName string) {
+	s.Lock()
+	delete(s.results, serviceName)
+	s.Unlock()
+	if s.history != nil {
+		s.history.forget(serviceName)
 	}
 }
 
-func (c *cachedHealth) maintainLatest() {
-	var aux fthealth.CheckResult
-	for {
-		select {
-		case aux = <-c.toWriteToCache:
-		case c.toReadFromCache <- aux:
-		}
+// snapshot returns a copy of all the stored results, so callers can read them without holding the lock.
+func (s *resultStore) snapshot() map[string]storedResult {
+	s.RLock()
+	defer s.RUnlock()
+	results := make(map[string]storedResult, len(s.results))
+	for serviceName, result := range s.results {
+		results[serviceName] = result
 	}
+	return results
 }
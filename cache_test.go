@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResultStoreGetWithoutResult(t *testing.T) {
+	store := newResultStore(nil)
+	_, ok := store.get("service1")
+	assert.False(t, ok)
+}
+
+func TestResultStoreSetGetDelete(t *testing.T) {
+	store := newResultStore(nil)
+	before := time.Now()
+	store.set("service1", fthealth.CheckResult{Name: "service1", Ok: true}, checkBreakdown{})
+
+	result, ok := store.get("service1")
+	assert.True(t, ok)
+	assert.True(t, result.checkResult.Ok)
+	assert.False(t, result.lastUpdated.Before(before))
+
+	store.set("service1", fthealth.CheckResult{Name: "service1", Ok: false}, checkBreakdown{})
+	result, _ = store.get("service1")
+	assert.False(t, result.checkResult.Ok)
+
+	store.delete("service1")
+	_, ok = store.get("service1")
+	assert.False(t, ok)
+}
+
+func TestResultStoreSnapshotIsACopy(t *testing.T) {
+	store := newResultStore(nil)
+	store.set("service1", fthealth.CheckResult{Name: "service1"}, checkBreakdown{})
+
+	snapshot := store.snapshot()
+	store.set("service2", fthealth.CheckResult{Name: "service2"}, checkBreakdown{})
+	store.delete("service1")
+
+	assert.Len(t, snapshot, 1)
+	assert.Equal(t, "service1", snapshot["service1"].checkResult.Name)
+	assert.Len(t, store.snapshot(), 1)
+}
+
+func TestCollectChecksFromCachesUsesStoredResults(t *testing.T) {
+	controller, _ := initializeMockController(nil)
+	defer controller.scheduler.stop()
+	controller.results.set("test-service-name", fthealth.CheckResult{Name: "test-service-name", Ok: true, CheckOutput: "from cache"}, checkBreakdown{})
+
+	checks, _, err := controller.collectChecksFromCachesFor(context.TODO(), map[string]category{"default": {name: "default"}})
+	assert.NoError(t, err)
+	assert.Len(t, checks, 2)
+	for _, check := range checks {
+		if check.Name == "test-service-name" {
+			assert.Equal(t, "from cache", check.CheckOutput)
+		} else {
+			assert.NotEqual(t, "from cache", check.CheckOutput)
+		}
+	}
+}
+
+func TestCollectChecksFromCachesUsesStoredBreakdowns(t *testing.T) {
+	controller, _ := initializeMockController(nil)
+	defer controller.scheduler.stop()
+	breakdown := checkBreakdown{failingPods: podFailures{{Pod: "pod-a", Reason: "timeout"}}}
+	controller.results.set("test-service-name", fthealth.CheckResult{Name: "test-service-name", Ok: false}, breakdown)
+
+	_, breakdowns, err := controller.collectChecksFromCachesFor(context.TODO(), map[string]category{"default": {name: "default"}})
+	assert.NoError(t, err)
+	assert.Equal(t, breakdown, breakdowns["test-service-name"])
+}
+
+func TestServiceChurnDoesNotLeakGoroutines(t *testing.T) {
+	controller, _ := initializeMockController(nil)
+	defer controller.scheduler.stop()
+
+	churn := func() {
+		for i := 0; i < 50; i++ {
+			controller.scheduleService(context.TODO(), service{name: fmt.Sprintf("service%d", i)})
+		}
+		for i := 0; i < 50; i++ {
+			controller.scheduleService(context.TODO(), service{name: fmt.Sprintf("service%d", i), appPort: 8081})
+		}
+		for i := 0; i < 50; i++ {
+			controller.unscheduleService(fmt.Sprintf("service%d", i))
+		}
+	}
+
+	churn()
+	assert.Eventually(t, func() bool {
+		return len(controller.getMeasuredServices()) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	baseline := runtime.NumGoroutine()
+
+	for round := 0; round < 5; round++ {
+		churn()
+	}
+
+	assert.Eventually(t, func() bool {
+		return len(controller.getMeasuredServices()) == 0 && len(controller.getCachedResults()) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	// polled by hand, as assert.Eventually runs its condition in an extra goroutine
+	goroutines := runtime.NumGoroutine()
+	for deadline := time.Now().Add(5 * time.Second); goroutines > baseline && time.Now().Before(deadline); goroutines = runtime.NumGoroutine() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, goroutines, baseline, "goroutines leaked across service churn")
+}
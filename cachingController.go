@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"math"
-	"reflect"
 	"time"
 
 	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
@@ -15,123 +14,138 @@ const (
 	defaultRefreshPeriod = 60 * time.Second
 )
 
-func newMeasuredService(service service) measuredService {
-	cachedHealth := newCachedHealth()
-	go cachedHealth.maintainLatest()
-
-	cachedHealthMetric := newCachedHealth()
-	go cachedHealthMetric.maintainLatest()
-	return measuredService{
-		service:            service,
-		cachedHealth:       cachedHealth,
-		cachedHealthMetric: cachedHealthMetric,
-	}
-}
-
-func (c *healthCheckController) collectChecksFromCachesFor(ctx context.Context, categories map[string]category) ([]fthealth.CheckResult, error) {
+func (c *healthCheckController) collectChecksFromCachesFor(ctx context.Context, categories map[string]category) ([]fthealth.CheckResult, map[string]checkBreakdown, error) {
 	var checkResults []fthealth.CheckResult
+	breakdowns := make(map[string]checkBreakdown)
 	serviceNames := getServiceNamesFromCategories(categories)
 	services := c.healthCheckService.getServicesMapByNames(serviceNames)
 	servicesThatAreNotInCache := make(map[string]service)
+	cachedResults := c.results.snapshot()
+	now := time.Now()
 	c.healthCheckService.RLockServices()
 	for _, service := range services {
-		if mService, ok := c.measuredServices[service.name]; ok {
-			checkResult := <-mService.cachedHealth.toReadFromCache
-			checkResults = append(checkResults, checkResult)
+		if result, ok := cachedResults[service.key()]; ok {
+			// acks may have been added, removed or expired since the result was cached
+			result.checkResult.Ack = activeAck(service.ack, now)
+			checkResults = append(checkResults, result.checkResult)
+			breakdowns[service.key()] = result.breakdown
 		} else {
-			servicesThatAreNotInCache[service.name] = service
+			servicesThatAreNotInCache[service.key()] = service
 		}
 	}
 	c.healthCheckService.RUnlockServices()
 
 	if len(servicesThatAreNotInCache) != 0 {
-		notCachedChecks, err := c.runServiceChecksByServiceNames(ctx, servicesThatAreNotInCache, categories)
+		notCachedChecks, notCachedBreakdowns, err := c.runServiceChecksByServiceNames(ctx, servicesThatAreNotInCache)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		checkResults = append(checkResults, notCachedChecks...)
+		for serviceKey, breakdown := range notCachedBreakdowns {
+			breakdowns[serviceKey] = breakdown
+		}
 	}
 
-	return checkResults, nil
+	return checkResults, breakdowns, nil
+}
+
+func (c *healthCheckController) watchServiceEvents() {
+	for event := range c.healthCheckService.getServiceEvents() {
+		switch event.eventType {
+		case serviceUpserted:
+			c.scheduleService(context.Background(), event.service)
+		case serviceRemoved:
+			c.unscheduleService(event.service.key())
+		}
+	}
 }
 
-func (c *healthCheckController) updateCachedHealth(ctx context.Context, services map[string]service, categories map[string]category) {
-	// adding new services, not touching existing
-	refreshPeriod := findShortestPeriod(categories)
+func (c *healthCheckController) scheduleService(ctx context.Context, service service) {
 	categories, err := c.healthCheckService.getCategories(ctx)
 	if err != nil {
-		log.WithError(err).Warn("Cannot read categories. Using minimum refresh period for services")
+		log.WithError(err).Warn("Cannot read categories. Using default refresh period for services")
 	}
-	for _, service := range services {
-		if mService, ok := c.measuredServices[service.name]; !ok || !reflect.DeepEqual(service, c.measuredServices[service.name].service) {
-			if ok {
-				mService.cachedHealth.terminate <- true
-			}
-			newMService := newMeasuredService(service)
-			c.measuredServices[service.name] = newMService
-			for _, category := range categories {
-				if isStringInSlice(service.name, category.services) {
-					refreshPeriod = category.refreshPeriod
-					break
-				}
-			}
+	refreshPeriod := getRefreshPeriodForService(service.key(), categories)
 
-			log.Infof("Scheduling check for service [%s] with refresh period [%v].\n", service.name, refreshPeriod)
-			go c.scheduleCheck(newMService, refreshPeriod, time.NewTimer(0))
-		}
-	}
+	log.Infof("Scheduling check for service [%s] with refresh period [%v].", service.key(), refreshPeriod)
+	c.scheduler.schedule(service, refreshPeriod)
 }
 
-func (c *healthCheckController) scheduleCheck(mService measuredService, refreshPeriod time.Duration, timer *time.Timer) {
-	// wait
-	select {
-	case <-mService.cachedHealth.terminate:
-		return
-	case <-timer.C:
-	}
+func (c *healthCheckController) unscheduleService(serviceKey string) {
+	log.Infof("Service %s doesn't exist anymore, removing it from cache", serviceKey)
+	c.scheduler.unschedule(serviceKey)
+	c.sticky.forgetService(serviceKey)
+}
 
-	if !c.healthCheckService.isServicePresent(mService.service.name) {
-		log.Infof("Service with name %s doesn't exist anymore, removing it from cache", mService.service.name)
-		delete(c.measuredServices, mService.service.name)
-		mService.cachedHealth.terminate <- true
-		return
+func (c *healthCheckController) runScheduledCheck(ctx context.Context, mService measuredService) (fthealth.CheckResult, checkBreakdown, bool) {
+	serviceToBeChecked := mService.service
+	// the ack may have changed since the check was scheduled
+	if currentService, err := c.healthCheckService.getServiceByName(serviceToBeChecked.key()); err == nil {
+		serviceToBeChecked.ack = currentService.ack
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	// run check
-	deployments, err := c.healthCheckService.getDeployments(ctx)
+	workloads, err := c.healthCheckService.getWorkloads(ctx, []service{serviceToBeChecked})
 	if err != nil {
 		log.WithError(err).Errorf("Cannot run scheduled health check for service %s", mService.service.name)
-	} else {
-		serviceToBeChecked := mService.service
+		return fthealth.CheckResult{}, checkBreakdown{}, false
+	}
 
-		checks := []fthealth.Check{newServiceHealthCheck(ctx, serviceToBeChecked, deployments, c.healthCheckService)}
+	var probe serviceProbe
+	checks := []fthealth.Check{newServiceHealthCheck(ctx, serviceToBeChecked, workloads, c.healthCheckService, &probe)}
 
-		checkResult := fthealth.RunCheck(fthealth.HealthCheck{
-			SystemCode:  serviceToBeChecked.name,
-			Name:        serviceToBeChecked.name,
-			Description: fmt.Sprintf("Checks the health of %v", serviceToBeChecked.name),
-			Checks:      checks,
-		}).Checks[0]
+	checkResult := fthealth.RunCheck(fthealth.HealthCheck{
+		SystemCode:  serviceToBeChecked.name,
+		Name:        serviceToBeChecked.name,
+		Description: fmt.Sprintf("Checks the health of %v", serviceToBeChecked.name),
+		Checks:      checks,
+	}).Checks[0]
 
-		checkResult.Ack = serviceToBeChecked.ack
+	now := time.Now()
+	checkResult.Ack = activeAck(serviceToBeChecked.ack, now)
 
-		if !checkResult.Ok {
-			severity := c.getSeverityForService(ctx, checkResult.Name, serviceToBeChecked.appPort)
-			checkResult.Severity = severity
-		}
+	if !checkResult.Ok {
+		checkResult.Severity = getSeverityForService(probe, now)
+	}
 
-		mService.cachedHealth.toWriteToCache <- checkResult
-		mService.cachedHealthMetric.toWriteToCache <- checkResult
+	// the service was unscheduled or rescheduled while the check was running
+	if ctx.Err() != nil {
+		return fthealth.CheckResult{}, checkBreakdown{}, false
 	}
 
-	go c.scheduleCheck(mService, refreshPeriod, time.NewTimer(refreshPeriod))
+	c.evaluateStickyCategories(ctx, checkResult)
+
+	return checkResult, newCheckBreakdown(probe, workloads, now), true
 }
 
 func (c *healthCheckController) getMeasuredServices() map[string]measuredService {
-	return c.measuredServices
+	return c.scheduler.getMeasuredServices()
+}
+
+func (c *healthCheckController) getCachedResults() map[string]storedResult {
+	return c.results.snapshot()
+}
+
+// getServiceHistory returns the part of the history of the service selected by the filter.
+func (c *healthCheckController) getServiceHistory(serviceName string, filter historyFilter) (historyReport, error) {
+	serviceToReport, err := c.healthCheckService.getServiceByName(serviceName)
+	if err != nil {
+		return historyReport{}, err
+	}
+	return c.history.report(serviceToReport.key(), filter), nil
+}
+
+// getRefreshPeriodForService returns the refresh period of the first category listing the service,
+// falling back to the shortest refresh period across all categories.
+func getRefreshPeriodForService(serviceKey string, categories map[string]category) time.Duration {
+	for _, category := range categories {
+		for _, serviceEntry := range category.services {
+			if matchesServiceEntry(serviceEntry, serviceKey) {
+				return category.refreshPeriod
+			}
+		}
+	}
+
+	return findShortestPeriod(categories)
 }
 
 func findShortestPeriod(categories map[string]category) time.Duration {
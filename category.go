@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/Financial-Times/go-logger"
+)
+
+const (
+	lastDisabledCategoryKey = "category.lastDisabled"
+	lastEnabledCategoryKey  = "category.lastEnabled"
+	// stickyActor is the actor of the changes made by the evaluation of the sticky categories
+	stickyActor = "aggregate-healthcheck"
+)
+
+// categoryChange records why, when and by whom a category was disabled or enabled. It is stored as a JSON value
+// in the configMap of the category, next to the category.enabled flag it explains.
+type categoryChange struct {
+	At     time.Time `json:"at"`
+	By     string    `json:"by,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+	// Service, Failures and Output describe the failing service that made a sticky category disabled
+	Service  string `json:"service,omitempty"`
+	Failures int    `json:"failures,omitempty"`
+	Output   string `json:"output,omitempty"`
+}
+
+// categoryStatus is the state of a category, as shown on the categories page and in the JSON outputs.
+type categoryStatus struct {
+	Name         string          `json:"name"`
+	Enabled      bool            `json:"enabled"`
+	Sticky       bool            `json:"sticky"`
+	LastDisabled *categoryChange `json:"lastDisabled,omitempty"`
+	LastEnabled  *categoryChange `json:"lastEnabled,omitempty"`
+}
+
+func (c categoryChange) encode() (string, error) {
+	value, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("cannot encode category change: %v", err)
+	}
+	return string(value), nil
+}
+
+// parseCategoryChange parses a change stored in the configMap of a category. Missing or invalid values are ignored.
+func parseCategoryChange(categoryName string, value string) *categoryChange {
+	if value == "" {
+		return nil
+	}
+
+	var c categoryChange
+	if err := json.Unmarshal([]byte(value), &c); err != nil {
+		log.WithError(err).Warnf("Ignoring invalid change of category %s", categoryName)
+		return nil
+	}
+	return &c
+}
+
+// describe summarises the change, e.g. "by jane.doe at 2024-01-02 15:04:05 UTC: failover".
+func (c categoryChange) describe() string {
+	description := "at " + c.At.Format(timeLayout)
+	if c.By != "" {
+		description = fmt.Sprintf("by %s %s", c.By, description)
+	}
+	if c.Reason != "" {
+		description = fmt.Sprintf("%s: %s", description, c.Reason)
+	}
+	return description
+}
+
+// isDisabledBySticky tells whether the category was disabled by the evaluation of the sticky categories,
+// as opposed to through the disable-category endpoint.
+func (c category) isDisabledBySticky() bool {
+	return !c.isEnabled && c.lastDisabled != nil && c.lastDisabled.By == stickyActor
+}
+
+func (c category) status() categoryStatus {
+	return categoryStatus{
+		Name:         c.name,
+		Enabled:      c.isEnabled,
+		Sticky:       c.isSticky,
+		LastDisabled: c.lastDisabled,
+		LastEnabled:  c.lastEnabled,
+	}
+}
+
+// getCategoryStatuses returns the statuses of the categories, ordered by name.
+func getCategoryStatuses(categories map[string]category) []categoryStatus {
+	statuses := make([]categoryStatus, 0, len(categories))
+	for _, c := range categories {
+		statuses = append(statuses, c.status())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"net/http"
 	"time"
 
@@ -13,106 +14,169 @@ import (
 	log "github.com/Financial-Times/go-logger"
 )
 
-type healthcheckResponse struct {
-	Name   string
-	Checks []struct {
-		Name     string
-		OK       bool
-		Severity uint8
-	}
+// probeFailure is the kind of failure to fetch the health of a pod, as opposed to the failure of one of its checks.
+type probeFailure string
+
+const (
+	probeTimeout         probeFailure = "timeout"
+	probeRequestFailed   probeFailure = "request failed"
+	probeNon200Status    probeFailure = "non-200 status"
+	probeInvalidResponse probeFailure = "invalid response"
+	// probeMisconfigured is a service whose pods cannot be probed as configured, e.g. with a missing secret
+	probeMisconfigured probeFailure = "misconfigured"
+	// the pods that are not probed because of their state, see podLifecycle
+	probeCrashLoop      probeFailure = "crash loop"
+	probeImagePull      probeFailure = "image pull failure"
+	probeContainerError probeFailure = "container error"
+	probeNotRunning     probeFailure = "not running"
+)
+
+// probeError is a failure to fetch the health of a pod, classified by its kind.
+type probeError struct {
+	failure probeFailure
+	msg     string
 }
 
-func (hs *k8sHealthcheckService) checkServiceHealth(ctx context.Context, service service, deployments map[string]deployment) (string, error) {
-	var err error
-	pods, err := hs.getPodsForService(ctx, service.name)
-	if err != nil {
-		return "", fmt.Errorf("cannot retrieve pods for service with name %s to perform healthcheck: %s", service.name, err.Error())
+func (e *probeError) Error() string {
+	return e.msg
+}
+
+// getProbeFailure returns the kind of the failure to fetch the health of a pod.
+// The errors that were not classified when they happened, like a cancelled context, are failed requests.
+func getProbeFailure(err error) probeFailure {
+	var pErr *probeError
+	if errors.As(err, &pErr) {
+		return pErr.failure
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return probeTimeout
 	}
+	return probeRequestFailed
+}
 
-	noOfUnavailablePods := 0
-	for _, currentPod := range pods {
-		if err = hs.checkPodHealth(currentPod, service.appPort); err != nil {
-			noOfUnavailablePods++
-		}
+type healthcheckResponse struct {
+	Name   string     `json:"name,omitempty"`
+	Checks []podCheck `json:"checks"`
+}
+
+// podCheck is one of the checks listed by the __health endpoint of a pod, or the single check reported by the other probes.
+type podCheck struct {
+	Name     string `json:"name"`
+	OK       bool   `json:"ok"`
+	Severity uint8  `json:"severity"`
+}
+
+// checkServiceHealth evaluates the health of a service from the probes of its pods: the service is ok as long as
+// it has the quorum of healthy pods, all of them by default, or its Deployment is rolling out, and fails when its rollout is stuck.
+// A monitored CronJob is evaluated by its last successful run instead.
+func checkServiceHealth(probe serviceProbe, workloads map[string]workload, now time.Time) (string, error) {
+	service := probe.service
+	if service.isCronJob() {
+		return checkCronJobHealth(service, workloads, now)
 	}
 
-	totalNoOfPods := len(pods)
-	outputMsg := fmt.Sprintf("%v/%v pods available", totalNoOfPods-noOfUnavailablePods, totalNoOfPods)
+	// unavailable pods that have been acked do not make the service fail
+	breakdown := newCheckBreakdown(probe, workloads, now)
+	noOfAckedPods := breakdown.failingPods.countAcked()
+	noOfUnavailablePods := len(breakdown.failingPods) - noOfAckedPods
 
-	if noOfUnavailablePods != 0 {
+	totalNoOfPods := len(probe.pods)
+	noOfStartingPods := probe.countStarting()
+	outputMsg := fmt.Sprintf("%v/%v pods available", totalNoOfPods-noOfStartingPods-noOfUnavailablePods-noOfAckedPods, totalNoOfPods-noOfStartingPods)
+	if noOfAckedPods != 0 {
+		outputMsg = fmt.Sprintf("%s, %v unavailable acked", outputMsg, noOfAckedPods)
+	}
+	if noOfStartingPods != 0 {
+		outputMsg = fmt.Sprintf("%s, %v starting", outputMsg, noOfStartingPods)
+	}
+	if service.quorum != nil {
+		outputMsg = fmt.Sprintf("%s, %v required", outputMsg, breakdown.quorum.Required)
+	}
+	if breakdown.quorum.rollingOut() {
+		outputMsg = fmt.Sprintf("%s, rolling out (%s)", outputMsg, breakdown.quorum.Workload.Message)
+	}
+	if breakdown.quorum.rolloutStuck() {
+		outputMsg = fmt.Sprintf("%s, rollout stuck (%s)", outputMsg, breakdown.quorum.Workload.Message)
+	}
+	if breakdown.quorum.Degraded {
+		outputMsg = fmt.Sprintf("%s, degraded", outputMsg)
+	}
+	if len(breakdown.failingPods) != 0 {
+		outputMsg = fmt.Sprintf("%s - %s", outputMsg, breakdown.failingPods.summary())
+	}
+	// a version skew is a warning, which does not make the service fail
+	if breakdown.versions.Skew {
+		outputMsg = fmt.Sprintf("%s - %s", outputMsg, breakdown.versions.summary())
+	}
+
+	// the pods of a service rolling out are expected to be unavailable for a while, until its progress deadline is exceeded
+	if breakdown.quorum.rolloutStuck() || (!breakdown.quorum.met() && !breakdown.quorum.rollingOut()) {
 		return "", errors.New(outputMsg)
 	}
+	// a service without pods wants some unless its workload is scaled down, which daemons never are
 	if service.isDaemon {
 		if totalNoOfPods == 0 {
 			return "", errors.New(outputMsg)
 		}
-	} else {
-		if _, exists := deployments[service.name]; !exists {
-			return "", fmt.Errorf("cannot find deployment for service with name %s", service.name)
-		}
-		if totalNoOfPods == 0 && deployments[service.name].desiredReplicas != 0 {
-			return "", errors.New(outputMsg)
-		}
+	} else if _, exists := workloads[service.key()]; !exists {
+		return "", fmt.Errorf("cannot find the workload of service with name %s", service.key())
 	}
 
 	return outputMsg, nil
 }
 
-func (hs *k8sHealthcheckService) checkPodHealth(pod pod, appPort int32) error {
-	health, err := hs.getHealthChecksForPod(pod, appPort)
-	if err != nil {
-		log.WithError(err).Errorf("Cannot perform healthcheck for pod with name %s", pod.name)
-		return errors.New("cannot perform healthcheck for pod")
-	}
-
-	for _, check := range health.Checks {
-		if !check.OK {
-			return fmt.Errorf("failing check is: %s", check.Name)
+// getHealthChecksForPod probes the pod the way its service is probed, retrying as long as the caller's context allows it.
+func (hs *k8sHealthcheckService) getHealthChecksForPod(ctx context.Context, pod pod, service service) (healthcheckResponse, healthcheckAttempts, error) {
+	endpoints := service.probedEndpoints()
+	probers := make([]prober, len(endpoints))
+	for i, endpoint := range endpoints {
+		endpointService := service
+		if endpoint.path != "" {
+			endpointService.endpoint.path = endpoint.path
 		}
+		probers[i] = hs.newProber(endpointService)
 	}
 
-	return nil
+	return withRetries(ctx, hs.retries, func() (healthcheckResponse, bool, error) {
+		return probeEndpoints(ctx, pod, endpoints, probers)
+	})
 }
 
-func (hs *k8sHealthcheckService) getIndividualPodSeverity(pod pod, appPort int32) (uint8, bool, error) {
-	health, err := hs.getHealthChecksForPod(pod, appPort)
-
-	if err != nil {
-		return defaultSeverity, false, fmt.Errorf("cannot get severity for pod with name %s: %s", pod.name, err.Error())
-	}
-
-	finalSeverity := uint8(2)
-	checkFailed := false
-	for _, check := range health.Checks {
-		if !check.OK {
-			checkFailed = true
-			if check.Severity < finalSeverity {
-				return check.Severity, checkFailed, nil
-			}
+// withRetries retries the attempt after the failures that may be transient (transport errors, timeouts and 5xx statuses)
+// with an exponential backoff, until the retries or the elapsed time of the policy are exhausted, or the context is done.
+// The outcome of every attempt is returned along with the health of the pod, or the error of the last attempt.
+func withRetries(ctx context.Context, policy retryPolicy, attempt func() (healthcheckResponse, bool, error)) (healthcheckResponse, healthcheckAttempts, error) {
+	start := time.Now()
+	var attempts healthcheckAttempts
+	for retry := 1; ; retry++ {
+		attemptStart := time.Now()
+		health, retryable, err := attempt()
+		attempts = append(attempts, healthcheckAttempt{err: err, duration: time.Since(attemptStart)})
+		if err == nil {
+			return health, attempts, nil
+		}
+		if !retryable || retry > policy.maxRetries || ctx.Err() != nil {
+			return healthcheckResponse{}, attempts, err
 		}
-	}
 
-	return finalSeverity, checkFailed, nil
-}
+		backoff := policy.backoff(retry, rand.Float64())
+		if policy.maxElapsed > 0 && time.Since(start)+backoff > policy.maxElapsed {
+			log.WithError(err).Errorf("Error performing healthcheck request, not retrying as it would take more than %s", policy.maxElapsed)
+			return healthcheckResponse{}, attempts, err
+		}
 
-func (hs *k8sHealthcheckService) getHealthChecksForPod(pod pod, appPort int32) (healthcheckResponse, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("http://%s:%d/__health", pod.ip, appPort), nil)
-	if err != nil {
-		return healthcheckResponse{}, errors.New("Error constructing healthcheck request: " + err.Error())
+		log.WithError(err).Errorf("Error performing healthcheck request, retrying request in %s", backoff.Round(time.Millisecond))
+		if sleepErr := sleepContext(ctx, backoff); sleepErr != nil {
+			return healthcheckResponse{}, attempts, err
+		}
 	}
-	req.Header.Set("Accept", "application/json")
-
-	return getHealthChecksForPodWithRetry(req, hs.httpClient, hs.maxCheckAttempts, hs.checkCooldown)
 }
 
-func getHealthChecksForPodWithRetry(req *http.Request, httpClient httpClient, remainingAttempts int, cooldown time.Duration) (healthcheckResponse, error) {
+// getHealthChecksForPodOnce performs a single attempt, and tells whether its failure is worth retrying.
+func getHealthChecksForPodOnce(req *http.Request, httpClient httpClient) (healthcheckResponse, bool, error) {
 	resp, err := httpClient.Do(req)
-	if err != nil && remainingAttempts == 0 {
-		return healthcheckResponse{}, errors.New("Error performing healthcheck request: " + err.Error())
-	} else if err != nil {
-		log.WithError(err).Errorf("Error performing healthcheck request, retrying request in %.0f seconds", cooldown.Seconds())
-		time.Sleep(cooldown)
-		return getHealthChecksForPodWithRetry(req, httpClient, remainingAttempts-1, cooldown)
+	if err != nil {
+		return transportFailure(req.Context(), "Error performing healthcheck request: ", err)
 	}
 
 	defer func() {
@@ -122,23 +186,26 @@ func getHealthChecksForPodWithRetry(req *http.Request, httpClient httpClient, re
 	}()
 
 	if resp.StatusCode != 200 {
-		return healthcheckResponse{}, fmt.Errorf("healthcheck endpoint returned non-200 status (%v)", resp.StatusCode)
+		return healthcheckResponse{}, resp.StatusCode >= http.StatusInternalServerError, &probeError{failure: probeNon200Status, msg: fmt.Sprintf("healthcheck endpoint returned non-200 status (%v)", resp.StatusCode)}
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return healthcheckResponse{}, errors.New("Error reading healthcheck response: " + err.Error())
+		return healthcheckResponse{}, true, &probeError{failure: probeInvalidResponse, msg: "Error reading healthcheck response: " + err.Error()}
 	}
 
 	health := &healthcheckResponse{}
 	if err = json.Unmarshal(body, &health); err != nil {
-		return healthcheckResponse{}, errors.New("Error parsing healthcheck response: " + err.Error())
+		return healthcheckResponse{}, false, &probeError{failure: probeInvalidResponse, msg: "Error parsing healthcheck response: " + err.Error()}
 	}
 
-	return *health, nil
+	return *health, false, nil
 }
 
-func newPodHealthCheck(pod pod, service service, healthcheckService healthcheckService) fthealth.Check {
+// newPodHealthCheck reports the health of the pod from a probe made beforehand, so the pods are probed within the limits of probePods
+// and the severity of a failing pod is computed from the same probe.
+func newPodHealthCheck(service service, probe podProbe) fthealth.Check {
+	pod := probe.pod
 	var checkName string
 	if service.isDaemon {
 		checkName = fmt.Sprintf("%s (%s)", pod.name, pod.node)
@@ -147,26 +214,35 @@ func newPodHealthCheck(pod pod, service service, healthcheckService healthcheckS
 	}
 
 	return fthealth.Check{
+		ID:               pod.key(),
 		BusinessImpact:   "On its own this failure does not have a business impact but it represents a degradation of the cluster health.",
 		Name:             checkName,
 		PanicGuide:       "https://runbooks.in.ft.com/upp-aggregate-healthcheck",
 		Severity:         defaultSeverity,
 		TechnicalSummary: "The pod is not healthy. Please check the panic guide.",
 		Checker: func() (string, error) {
-			return "", healthcheckService.checkPodHealth(pod, service.appPort)
+			return probe.status(time.Now())
 		},
 	}
 }
 
-func newServiceHealthCheck(ctx context.Context, service service, deployments map[string]deployment, healthcheckService healthcheckService) fthealth.Check {
+// newServiceHealthCheck checks the service with a single probe of its pods, which is kept in probe so the severity of a failing service
+// is computed from the same probe.
+func newServiceHealthCheck(ctx context.Context, service service, workloads map[string]workload, healthcheckService healthcheckService, probe *serviceProbe) fthealth.Check {
 	return fthealth.Check{
+		ID:               service.key(),
 		BusinessImpact:   "On its own this failure does not have a business impact but it represents a degradation of the cluster health.",
 		Name:             service.name,
 		PanicGuide:       "https://runbooks.in.ft.com/upp-aggregate-healthcheck",
 		Severity:         defaultSeverity,
 		TechnicalSummary: "The service is not healthy. Please check the panic guide.",
 		Checker: func() (string, error) {
-			return healthcheckService.checkServiceHealth(ctx, service, deployments)
+			var err error
+			*probe, err = healthcheckService.probeService(ctx, service)
+			if err != nil {
+				return "", err
+			}
+			return checkServiceHealth(*probe, workloads, time.Now())
 		},
 	}
 }
@@ -2,10 +2,11 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -34,13 +35,17 @@ func (m *mockHTTPClientWithChangingResponses) Do(req *http.Request) (*http.Respo
 	return m.doFuncOther(req)
 }
 
-func Test_getHealthChecksForPodWithRetry(t *testing.T) {
-	testRequest := httptest.NewRequest(http.MethodGet, "/health", nil)
+// getHealthChecksForPodWith fetches the health of a pod the way the scheduled checks do, with the client and the retry policy.
+func getHealthChecksForPodWith(ctx context.Context, client httpClient, policy retryPolicy) (healthcheckResponse, healthcheckAttempts, error) {
+	hs := &k8sHealthcheckService{httpClient: client, retries: policy}
+	return hs.getHealthChecksForPod(ctx, pod{name: "pod-1", ip: validIP}, service{name: "service1", appPort: 8080})
+}
+
+func Test_getHealthChecksForPod(t *testing.T) {
 	testHealthcheckResponseBody := `{"schemaVersion":1,"systemCode":"content-unroller","name":"Content Unroller","description":"Content Unroller - unroll images and dynamic content for a given content","checks":[{"id":"check-connect-content-public-read","name":"Check connectivity to content-public-read","ok":true,"severity":1,"businessImpact":"Unrolled images and dynamic content won't be available","technicalSummary":"Cannot connect to content-public-read.","panicGuide":"https://dewey.in.ft.com/runbooks/contentreadapi","checkOutput":"Ok","lastUpdated":"2025-08-26T09:09:44.953777115Z"}],"ok":true}`
 	type args struct {
-		httpClient        httpClient
-		remainingAttempts int
-		cooldown          time.Duration
+		httpClient httpClient
+		policy     retryPolicy
 	}
 	tests := []struct {
 		name    string
@@ -58,8 +63,7 @@ func Test_getHealthChecksForPodWithRetry(t *testing.T) {
 						}, nil
 					},
 				},
-				remainingAttempts: 1,
-				cooldown:          0,
+				policy: retryPolicy{maxRetries: 1},
 			},
 			wantErr: assert.NoError,
 		},
@@ -71,8 +75,7 @@ func Test_getHealthChecksForPodWithRetry(t *testing.T) {
 						return nil, fmt.Errorf("timed out")
 					},
 				},
-				remainingAttempts: 1,
-				cooldown:          0,
+				policy: retryPolicy{maxRetries: 1},
 			},
 			wantErr: assert.Error,
 		},
@@ -87,8 +90,7 @@ func Test_getHealthChecksForPodWithRetry(t *testing.T) {
 						}, nil
 					},
 				},
-				remainingAttempts: 1,
-				cooldown:          0,
+				policy: retryPolicy{maxRetries: 1},
 			},
 			wantErr: assert.Error,
 		},
@@ -103,8 +105,7 @@ func Test_getHealthChecksForPodWithRetry(t *testing.T) {
 						}, nil
 					},
 				},
-				remainingAttempts: 1,
-				cooldown:          0,
+				policy: retryPolicy{maxRetries: 1},
 			},
 			wantErr: assert.Error,
 		},
@@ -122,13 +123,12 @@ func Test_getHealthChecksForPodWithRetry(t *testing.T) {
 						}, nil
 					},
 				},
-				remainingAttempts: 2,
-				cooldown:          0,
+				policy: retryPolicy{maxRetries: 2},
 			},
 			wantErr: assert.NoError,
 		},
 		{
-			name: "Healthcheck returns non 200 status code on first attempt, 200 after",
+			name: "Healthcheck returns 5xx status code on first attempt, 200 after",
 			args: args{
 				httpClient: &mockHTTPClientWithChangingResponses{
 					doFuncFirst: func(_ *http.Request) (*http.Response, error) {
@@ -144,16 +144,113 @@ func Test_getHealthChecksForPodWithRetry(t *testing.T) {
 						}, nil
 					},
 				},
-				remainingAttempts: 2,
-				cooldown:          0,
+				policy: retryPolicy{maxRetries: 2},
 			},
-			wantErr: assert.Error,
+			wantErr: assert.NoError,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := getHealthChecksForPodWithRetry(testRequest, tt.args.httpClient, tt.args.remainingAttempts, tt.args.cooldown)
+			_, _, err := getHealthChecksForPodWith(context.Background(), tt.args.httpClient, tt.args.policy)
 			assert.True(t, tt.wantErr(t, err))
 		})
 	}
 }
+
+// countingHTTPClient counts the requests it is sent, and responds to all of them the same way.
+type countingHTTPClient struct {
+	requests int
+	respond  func(req *http.Request) (*http.Response, error)
+}
+
+func (c *countingHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	c.requests++
+	return c.respond(req)
+}
+
+func respondWith(statusCode int, body string) func(req *http.Request) (*http.Response, error) {
+	return func(_ *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: statusCode, Body: io.NopCloser(bytes.NewBufferString(body))}, nil
+	}
+}
+
+func TestGetHealthChecksForPodOnlyRetriesTransientFailures(t *testing.T) {
+	tests := []struct {
+		name             string
+		respond          func(req *http.Request) (*http.Response, error)
+		expectedRequests int
+	}{
+		{
+			name: "transport error",
+			respond: func(_ *http.Request) (*http.Response, error) {
+				return nil, errors.New("timed out")
+			},
+			expectedRequests: 3,
+		},
+		{name: "5xx status", respond: respondWith(http.StatusServiceUnavailable, ""), expectedRequests: 3},
+		{name: "4xx status", respond: respondWith(http.StatusNotFound, ""), expectedRequests: 1},
+		{name: "invalid JSON", respond: respondWith(http.StatusOK, "not json"), expectedRequests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &countingHTTPClient{respond: tt.respond}
+
+			_, attempts, err := getHealthChecksForPodWith(context.Background(), client, retryPolicy{maxRetries: 2})
+			assert.Error(t, err)
+			assert.Equal(t, tt.expectedRequests, client.requests)
+			assert.Len(t, attempts, tt.expectedRequests)
+		})
+	}
+}
+
+func TestGetHealthChecksForPodStopsWhenTheContextIsDone(t *testing.T) {
+	client := &countingHTTPClient{respond: respondWith(http.StatusServiceUnavailable, "")}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, _, err := getHealthChecksForPodWith(ctx, client, retryPolicy{maxRetries: 5, initialBackoff: time.Minute})
+	assert.EqualError(t, err, "healthcheck endpoint returned non-200 status (503)")
+	assert.Less(t, time.Since(start), time.Second, "The backoff should be interrupted by the context")
+	assert.Equal(t, 1, client.requests)
+}
+
+func TestGetHealthChecksForPodStopsAfterMaxElapsedTime(t *testing.T) {
+	client := &countingHTTPClient{respond: respondWith(http.StatusServiceUnavailable, "")}
+
+	policy := retryPolicy{maxRetries: 5, initialBackoff: 10 * time.Millisecond, maxElapsed: 25 * time.Millisecond}
+	_, attempts, err := getHealthChecksForPodWith(context.Background(), client, policy)
+	assert.Error(t, err)
+	// the attempts start at 0ms and 10ms, while the third one would start after 30ms
+	assert.Equal(t, 2, client.requests)
+	assert.Len(t, attempts, 2)
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := retryPolicy{initialBackoff: time.Second, maxBackoff: 5 * time.Second}
+	assert.Equal(t, time.Second, policy.backoff(1, 0.5))
+	assert.Equal(t, 2*time.Second, policy.backoff(2, 0.5))
+	assert.Equal(t, 4*time.Second, policy.backoff(3, 0.5))
+	assert.Equal(t, 5*time.Second, policy.backoff(4, 0.5))
+	assert.Equal(t, 5*time.Second, policy.backoff(100, 0.5))
+
+	policy.jitter = 0.2
+	assert.Equal(t, 1600*time.Millisecond, policy.backoff(2, 0))
+	assert.Equal(t, 2400*time.Millisecond, policy.backoff(2, 1))
+}
+
+func TestPodProbeStatusReportsAttempts(t *testing.T) {
+	client := &mockHTTPClientWithChangingResponses{
+		doFuncFirst: respondWith(http.StatusServiceUnavailable, ""),
+		doFuncOther: respondWith(http.StatusOK, `{"checks":[{"name":"check","ok":true}]}`),
+	}
+	hcService := &k8sHealthcheckService{httpClient: client, retries: retryPolicy{maxRetries: 1}}
+
+	output, err := hcService.probePod(context.Background(), pod{name: "pod-1", ip: validIP}, service{appPort: 8080}).status(time.Now())
+	assert.NoError(t, err)
+	assert.Regexp(t, `^retried: attempt 1 failed after \S+: healthcheck endpoint returned non-200 status \(503\); attempt 2 succeeded after \S+$`, output)
+
+	hcService.httpClient = &mockHTTPClient{doFunc: respondWith(http.StatusNotFound, "")}
+	_, err = hcService.probePod(context.Background(), pod{name: "pod-1", ip: validIP}, service{appPort: 8080}).status(time.Now())
+	assert.Regexp(t, `^cannot perform healthcheck for pod: attempt 1 failed after \S+: healthcheck endpoint returned non-200 status \(404\)$`, err.Error())
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"sort"
-	"sync"
 	"time"
 
 	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
@@ -12,101 +11,271 @@ import (
 )
 
 type healthCheckController struct {
-	healthCheckService             healthcheckService
-	environment                    string
-	measuredServices               map[string]measuredService
-	stickyCategoriesFailedServices map[string]int
+	healthCheckService healthcheckService
+	environment        string
+	scheduler          *checkScheduler
+	results            *resultStore
+	history            *healthHistory
+	sticky             *stickyTracker
+	leader             *leaderElector
 }
 
 type controller interface {
-	buildServicesHealthResult(context.Context, []string, bool) (fthealth.HealthResult, map[string]category, error)
-	runServiceChecksByServiceNames(context.Context, map[string]service, map[string]category) ([]fthealth.CheckResult, error)
-	runServiceChecksFor(context.Context, map[string]category) ([]fthealth.CheckResult, error)
-	buildPodsHealthResult(context.Context, string) (fthealth.HealthResult, error)
-	runPodChecksFor(context.Context, string) ([]fthealth.CheckResult, error)
-	collectChecksFromCachesFor(context.Context, map[string]category) ([]fthealth.CheckResult, error)
-	updateCachedHealth(context.Context, map[string]service, map[string]category)
-	scheduleCheck(measuredService, time.Duration, *time.Timer)
+	buildServicesHealthResult(context.Context, []string, bool) (servicesHealth, map[string]category, error)
+	runServiceChecksByServiceNames(context.Context, map[string]service) ([]fthealth.CheckResult, map[string]checkBreakdown, error)
+	runServiceChecksFor(context.Context, map[string]category) ([]fthealth.CheckResult, map[string]checkBreakdown, error)
+	buildPodsHealthResult(context.Context, string) (podsHealth, error)
+	runPodChecksFor(context.Context, string) ([]fthealth.CheckResult, map[string]podVersion, error)
+	collectChecksFromCachesFor(context.Context, map[string]category) ([]fthealth.CheckResult, map[string]checkBreakdown, error)
+	scheduleService(context.Context, service)
+	unscheduleService(string)
 	getIndividualPodHealth(context.Context, string) ([]byte, string, error)
-	addAck(context.Context, string, string) error
-	updateStickyCategory(context.Context, string, bool) error
+	addAck(context.Context, string, ack) error
+	addPodAck(context.Context, string, string, ack) error
+	updateStickyCategory(context.Context, string, bool, categoryChange) (categoryStatus, error)
+	getCategoryStatuses(context.Context) ([]categoryStatus, error)
 	removeAck(context.Context, string) error
+	removePodAck(context.Context, string, string) error
+	getSilences(context.Context) ([]silence, error)
+	addSilence(context.Context, silence) error
+	removeSilence(context.Context, string) error
 	getEnvironment() string
-	getSeverityForService(context.Context, string, int32) uint8
-	getSeverityForPod(context.Context, string, int32) uint8
+	getLeaderStatus() leaderStatus
 	getMeasuredServices() map[string]measuredService
+	getCachedResults() map[string]storedResult
+	getServiceHistory(string, historyFilter) (historyReport, error)
 }
 
-func initializeController(environment string, maxCheckAttempts int, checkCooldown time.Duration) *healthCheckController {
-	service := initializeHealthCheckService(maxCheckAttempts, checkCooldown)
-	measuredServices := make(map[string]measuredService)
-	stickyCategoriesFailedServices := make(map[string]int)
-
-	return &healthCheckController{
-		healthCheckService:             service,
-		environment:                    environment,
-		measuredServices:               measuredServices,
-		stickyCategoriesFailedServices: stickyCategoriesFailedServices,
+func initializeController(environment string, retries retryPolicy, limits checkLimits, startupGrace time.Duration, schedulerWorkers int, namespaces []string,
+	leaderElection leaderElectionConfig, historySize historyLimits) *healthCheckController {
+	service := initializeHealthCheckService(retries, limits, startupGrace, namespaces)
+	history := newHealthHistory(historySize)
+	controller := &healthCheckController{
+		healthCheckService: service,
+		environment:        environment,
+		results:            newResultStore(history),
+		history:            history,
+		sticky:             newStickyTracker(),
+		leader:             newLeaderElector(leaderElection),
 	}
+	go controller.leader.run(context.Background(), service.k8sClient)
+
+	controller.scheduler = newCheckScheduler(controller.runScheduledCheck, schedulerWorkers, controller.results)
+	controller.scheduler.start()
+
+	go controller.watchServiceEvents()
+	go controller.runAckReaper(ackReaperInterval)
+
+	return controller
 }
 
 func (c *healthCheckController) getEnvironment() string {
 	return c.environment
 }
 
-func (c *healthCheckController) updateStickyCategory(ctx context.Context, categoryName string, isEnabled bool) error {
-	return c.healthCheckService.updateCategory(ctx, categoryName, isEnabled)
+func (c *healthCheckController) getLeaderStatus() leaderStatus {
+	return c.leader.status()
+}
+
+// updateStickyCategory enables or disables a category on behalf of a user, and returns its updated status.
+func (c *healthCheckController) updateStickyCategory(ctx context.Context, categoryName string, isEnabled bool, change categoryChange) (categoryStatus, error) {
+	updatedCategory, err := c.healthCheckService.updateCategory(ctx, categoryName, isEnabled, change)
+	if err != nil {
+		return categoryStatus{}, err
+	}
+	return updatedCategory.status(), nil
+}
+
+func (c *healthCheckController) getCategoryStatuses(ctx context.Context) ([]categoryStatus, error) {
+	categories, err := c.healthCheckService.getCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return getCategoryStatuses(categories), nil
 }
 
 func (c *healthCheckController) removeAck(ctx context.Context, serviceName string) error {
-	if !c.healthCheckService.isServicePresent(serviceName) {
-		return fmt.Errorf("cannot find service with name %s", serviceName)
+	srv, err := c.healthCheckService.getServiceByName(serviceName)
+	if err != nil {
+		return err
 	}
 
-	err := c.healthCheckService.removeAck(ctx, serviceName)
+	err = c.healthCheckService.removeAck(ctx, srv.ackKey())
 
 	if err != nil {
-		return fmt.Errorf("failed to remove ack for service %s: %s", serviceName, err.Error())
+		return fmt.Errorf("failed to remove ack for service %s: %w", serviceName, err)
 	}
 
 	return nil
 }
 
-func (c *healthCheckController) addAck(ctx context.Context, serviceName, ackMessage string) error {
-	if !c.healthCheckService.isServicePresent(serviceName) {
-		return fmt.Errorf("cannot find service with name %s", serviceName)
+func (c *healthCheckController) addAck(ctx context.Context, serviceName string, serviceAck ack) error {
+	srv, err := c.healthCheckService.getServiceByName(serviceName)
+	if err != nil {
+		return err
 	}
 
-	err := c.healthCheckService.addAck(ctx, serviceName, ackMessage)
+	ackValue, err := serviceAck.encode()
+	if err != nil {
+		return err
+	}
+
+	err = c.healthCheckService.addAck(ctx, srv.ackKey(), ackValue)
 
 	if err != nil {
-		return fmt.Errorf("failed to add ack message [%s] for service %s: %s", ackMessage, serviceName, err.Error())
+		return fmt.Errorf("failed to add ack message [%s] for service %s: %w", serviceAck.Message, serviceName, err)
 	}
 
 	return nil
 }
 
-func (c *healthCheckController) buildServicesHealthResult(ctx context.Context, providedCategories []string, useCache bool) (fthealth.HealthResult, map[string]category, error) {
+// addPodAck acks the pod identified by its namespace-qualified key or, if a check name is given, only that check of the pod.
+func (c *healthCheckController) addPodAck(ctx context.Context, podKey string, checkName string, podAck ack) error {
+	ackKey, err := c.getPodAckKey(ctx, podKey, checkName)
+	if err != nil {
+		return err
+	}
+
+	ackValue, err := podAck.encode()
+	if err != nil {
+		return err
+	}
+
+	err = c.healthCheckService.addAck(ctx, ackKey, ackValue)
+
+	if err != nil {
+		return fmt.Errorf("failed to add ack message [%s] for pod %s: %w", podAck.Message, podKey, err)
+	}
+
+	return nil
+}
+
+// removePodAck removes the ack of the pod identified by its namespace-qualified key, or of a single check of the pod.
+func (c *healthCheckController) removePodAck(ctx context.Context, podKey string, checkName string) error {
+	ackKey, err := c.getPodAckKey(ctx, podKey, checkName)
+	if err != nil {
+		return err
+	}
+
+	err = c.healthCheckService.removeAck(ctx, ackKey)
+
+	if err != nil {
+		return fmt.Errorf("failed to remove ack for pod %s: %w", podKey, err)
+	}
+
+	return nil
+}
+
+func (c *healthCheckController) getPodAckKey(ctx context.Context, podKey string, checkName string) (string, error) {
+	namespace, podName := splitObjectKey(podKey)
+	ackedPod, err := c.healthCheckService.getPodByName(ctx, namespace, podName)
+	if err != nil {
+		return "", err
+	}
+
+	if checkName != "" {
+		return ackedPod.checkAckKey(checkName), nil
+	}
+	return ackedPod.ackKey(), nil
+}
+
+func (c *healthCheckController) getSilences(ctx context.Context) ([]silence, error) {
+	return c.healthCheckService.getSilences(ctx)
+}
+
+func (c *healthCheckController) addSilence(ctx context.Context, s silence) error {
+	return c.healthCheckService.addSilence(ctx, s)
+}
+
+func (c *healthCheckController) removeSilence(ctx context.Context, silenceID string) error {
+	return c.healthCheckService.removeSilence(ctx, silenceID)
+}
+
+// runAckReaper periodically removes the expired acks and the ended silences, so they stop hiding failing services.
+// Only the leader removes them.
+func (c *healthCheckController) runAckReaper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !c.leader.isLeader() {
+			continue
+		}
+		c.reapExpiredAcks(context.Background())
+		c.reapEndedSilences(context.Background())
+	}
+}
+
+func (c *healthCheckController) reapExpiredAcks(ctx context.Context) {
+	expiredAcks, err := c.healthCheckService.removeExpiredAcks(ctx, time.Now())
+	if err != nil {
+		log.WithError(err).Error("Cannot remove expired acks")
+		return
+	}
+
+	if len(expiredAcks) > 0 {
+		log.Infof("Removed expired acks and acks of deleted pods %v", expiredAcks)
+	}
+}
+
+func (c *healthCheckController) reapEndedSilences(ctx context.Context) {
+	endedSilences, err := c.healthCheckService.removeEndedSilences(ctx, time.Now())
+	if err != nil {
+		log.WithError(err).Error("Cannot remove ended silences")
+		return
+	}
+
+	if len(endedSilences) > 0 {
+		log.Infof("Removed ended silences %v", endedSilences)
+	}
+}
+
+// silenceChecks attaches the active silences to the check results of the services they target.
+// The categories are all the available categories, not only the requested ones, as silences may target any of them.
+func (c *healthCheckController) silenceChecks(ctx context.Context, checkResults []fthealth.CheckResult, categories map[string]category, serviceForCheck func(fthealth.CheckResult) (service, bool)) {
+	silences, err := c.healthCheckService.getSilences(ctx)
+	if err != nil {
+		log.WithError(err).Warn("Cannot read silences, the checks will not be silenced")
+		return
+	}
+
+	applySilences(checkResults, silences, categories, serviceForCheck, time.Now())
+}
+
+// servicesHealth is the health of the services, along with the breakdown of the check of every service
+// and the latest samples of its history, by service key.
+type servicesHealth struct {
+	fthealth.HealthResult
+	breakdowns map[string]checkBreakdown
+	timelines  map[string][]healthSample
+}
+
+func (c *healthCheckController) buildServicesHealthResult(ctx context.Context, providedCategories []string, useCache bool) (servicesHealth, map[string]category, error) {
 	var checkResults []fthealth.CheckResult
+	var breakdowns map[string]checkBreakdown
 	desc := "Health of the whole cluster of the moment served without cache."
 	availableCategories, err := c.healthCheckService.getCategories(ctx)
 	if err != nil {
-		return fthealth.HealthResult{}, nil, fmt.Errorf("cannot build health check result for services: %v", err.Error())
+		return servicesHealth{}, nil, fmt.Errorf("cannot build health check result for services: %v", err.Error())
 	}
 
 	matchingCategories := getMatchingCategories(providedCategories, availableCategories)
 
 	if useCache {
 		desc = "Health of the whole cluster served from cache."
-		checkResults, err = c.collectChecksFromCachesFor(ctx, matchingCategories)
+		checkResults, breakdowns, err = c.collectChecksFromCachesFor(ctx, matchingCategories)
 	} else {
-		checkResults, err = c.runServiceChecksFor(ctx, matchingCategories)
+		checkResults, breakdowns, err = c.runServiceChecksFor(ctx, matchingCategories)
 	}
 	if err != nil {
-		return fthealth.HealthResult{}, nil, fmt.Errorf("cannot build health check result for services: %v", err.Error())
+		return servicesHealth{}, nil, fmt.Errorf("cannot build health check result for services: %v", err.Error())
 	}
 
-	c.disableStickyFailingCategories(ctx, matchingCategories, checkResults)
+	services := c.healthCheckService.getServicesMapByNames(nil)
+	c.silenceChecks(ctx, checkResults, availableCategories, func(checkResult fthealth.CheckResult) (service, bool) {
+		srv, ok := services[checkResult.ID]
+		return srv, ok
+	})
 
 	finalOk, finalSeverity := getFinalResult(checkResults, matchingCategories)
 
@@ -122,18 +291,33 @@ func (c *healthCheckController) buildServicesHealthResult(ctx context.Context, p
 
 	sort.Sort(byNameComparator(health.Checks))
 
-	return health, matchingCategories, nil
+	var timelines map[string][]healthSample
+	if c.history != nil {
+		serviceKeys := make([]string, 0, len(checkResults))
+		for _, checkResult := range checkResults {
+			serviceKeys = append(serviceKeys, checkResult.ID)
+		}
+		timelines = c.history.timelines(serviceKeys, timelineSamples)
+	}
+
+	return servicesHealth{HealthResult: health, breakdowns: breakdowns, timelines: timelines}, matchingCategories, nil
 }
 
-func (c *healthCheckController) runServiceChecksByServiceNames(ctx context.Context, services map[string]service, categories map[string]category) ([]fthealth.CheckResult, error) {
-	deployments, err := c.healthCheckService.getDeployments(ctx)
+func (c *healthCheckController) runServiceChecksByServiceNames(ctx context.Context, services map[string]service) ([]fthealth.CheckResult, map[string]checkBreakdown, error) {
+	servicesToCheck := make([]service, 0, len(services))
+	for _, s := range services {
+		servicesToCheck = append(servicesToCheck, s)
+	}
+	workloads, err := c.healthCheckService.getWorkloads(ctx, servicesToCheck)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	checks := make([]fthealth.Check, 0, len(services))
+	probes := make(map[string]*serviceProbe, len(services))
 	for _, service := range services {
-		check := newServiceHealthCheck(ctx, service, deployments, c.healthCheckService)
+		probes[service.key()] = &serviceProbe{}
+		check := newServiceHealthCheck(ctx, service, workloads, c.healthCheckService, probes[service.key()])
 		checks = append(checks, check)
 	}
 
@@ -144,90 +328,100 @@ func (c *healthCheckController) runServiceChecksByServiceNames(ctx context.Conte
 		Checks:      checks,
 	}).Checks
 
-	wg := sync.WaitGroup{}
-	tempCtx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	for i := range healthChecks {
-		wg.Add(1)
-		go func(context context.Context, i int) {
-			healthCheck := healthChecks[i]
-			if !healthCheck.Ok {
-				if unhealthyService, ok := services[healthCheck.Name]; ok {
-					severity := c.getSeverityForService(context, healthCheck.Name, unhealthyService.appPort)
-					healthChecks[i].Severity = severity
-				} else {
-					log.Warnf("Cannot compute severity for service with name %s because it was not found. Using default value.", healthCheck.Name)
-				}
-			}
-			wg.Done()
-		}(tempCtx, i)
+	// the severity and the breakdown of a failing service are computed from the probes its check was evaluated with
+	now := time.Now()
+	breakdowns := make(map[string]checkBreakdown)
+	for i, healthCheck := range healthChecks {
+		if !healthCheck.Ok {
+			healthChecks[i].Severity = getSeverityForService(*probes[healthCheck.ID], now)
+		}
+		breakdowns[healthCheck.ID] = newCheckBreakdown(*probes[healthCheck.ID], workloads, now)
 	}
-	wg.Wait()
 
 	for _, service := range services {
-		if service.ack != "" {
-			updateHealthCheckWithAckMsg(healthChecks, service.name, service.ack)
+		if ackValue := activeAck(service.ack, now); ackValue != "" {
+			updateHealthCheckWithAckMsg(healthChecks, service.key(), ackValue)
 		}
 	}
 
-	c.updateCachedHealth(tempCtx, services, categories)
-	return healthChecks, nil
+	return healthChecks, breakdowns, nil
 }
 
-func (c *healthCheckController) runServiceChecksFor(ctx context.Context, categories map[string]category) (healthChecks []fthealth.CheckResult, err error) {
+func (c *healthCheckController) runServiceChecksFor(ctx context.Context, categories map[string]category) (healthChecks []fthealth.CheckResult, breakdowns map[string]checkBreakdown, err error) {
 	serviceNames := getServiceNamesFromCategories(categories)
 	services := c.healthCheckService.getServicesMapByNames(serviceNames)
-	healthChecks, err = c.runServiceChecksByServiceNames(ctx, services, categories)
+	healthChecks, breakdowns, err = c.runServiceChecksByServiceNames(ctx, services)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return healthChecks, err
+	return healthChecks, breakdowns, err
 }
 
+// evaluateStickyCategories records the result of a scheduled check of a service for each sticky category listing the service,
+// and disables or re-enables the categories accordingly. Only the leader evaluates the sticky categories, so replicas
+// never disable a category on their own.
+//
 //nolint:gocognit
-func (c *healthCheckController) disableStickyFailingCategories(ctx context.Context, categories map[string]category, healthChecks []fthealth.CheckResult) {
-	for catIndex, category := range categories {
-		if !isEnabledAndSticky(category) {
+func (c *healthCheckController) evaluateStickyCategories(ctx context.Context, checkResult fthealth.CheckResult) {
+	if !c.leader.isLeader() {
+		return
+	}
+
+	categories, err := c.healthCheckService.getCategories(ctx)
+	if err != nil {
+		log.WithError(err).Warnf("Cannot read categories, the sticky categories of service %s are not evaluated", checkResult.ID)
+		return
+	}
+
+	now := time.Now()
+	for _, category := range categories {
+		if !category.isSticky || !categoryContainsService(category, checkResult.ID) {
 			continue
 		}
 
-		for _, serviceName := range category.services {
-			for _, healthCheck := range healthChecks {
-				if healthCheck.Name == serviceName && !healthCheck.Ok {
-					c.stickyCategoriesFailedServices[serviceName]++
-					log.Infof("Sticky category [%s]: service [%s] -- check %v/%v.", category.name, serviceName, c.stickyCategoriesFailedServices[serviceName], category.failureThreshold)
-
-					if c.isCategoryThresholdExceeded(serviceName, category.failureThreshold) {
-						log.Infof("Sticky category [%s] is unhealthy, disabling it. Threshold exceeded for: [%s]", category.name, serviceName)
-						category.isEnabled = false
-						categories[catIndex] = category
-
-						err := c.healthCheckService.updateCategory(ctx, category.name, false)
-						if err != nil {
-							log.WithError(err).Errorf("Cannot disable sticky category with name %s.", category.name)
-						} else {
-							log.Infof("Category [%s] disabled", category.name)
-							c.stickyCategoriesFailedServices[serviceName] = 0
-						}
-					}
-				}
+		action, failures := c.sticky.record(category, checkResult.ID, checkResult.Ok, now)
+		if !checkResult.Ok && category.isEnabled {
+			log.Infof("Sticky category [%s]: service [%s] -- failure %v/%v.", category.name, checkResult.ID, failures, category.failureThreshold)
+		}
+
+		switch action {
+		case stickyDisable:
+			log.Infof("Sticky category [%s] is unhealthy, disabling it. Threshold exceeded for: [%s]", category.name, checkResult.ID)
+			change := categoryChange{
+				At:       now.UTC(),
+				By:       stickyActor,
+				Reason:   fmt.Sprintf("service %s failed %d/%d checks", checkResult.ID, failures, category.failureThreshold),
+				Service:  checkResult.ID,
+				Failures: failures,
+				Output:   checkResult.CheckOutput,
+			}
+			if _, err := c.healthCheckService.updateCategory(ctx, category.name, false, change); err != nil {
+				log.WithError(err).Errorf("Cannot disable sticky category with name %s.", category.name)
+				continue
 			}
+			log.Infof("Category [%s] disabled", category.name)
+			c.sticky.categoryUpdated(category.name)
+		case stickyReEnable:
+			log.Infof("Sticky category [%s] has been healthy for %v runs, re-enabling it.", category.name, category.autoReEnableAfter)
+			change := categoryChange{
+				At:     now.UTC(),
+				By:     stickyActor,
+				Reason: fmt.Sprintf("all services healthy for %d checks", category.autoReEnableAfter),
+			}
+			if _, err := c.healthCheckService.updateCategory(ctx, category.name, true, change); err != nil {
+				log.WithError(err).Errorf("Cannot re-enable sticky category with name %s.", category.name)
+				continue
+			}
+			log.Infof("Category [%s] re-enabled", category.name)
+			c.sticky.categoryUpdated(category.name)
 		}
 	}
 }
 
-func (c *healthCheckController) isCategoryThresholdExceeded(serviceName string, failureThreshold int) bool {
-	return c.stickyCategoriesFailedServices[serviceName] >= failureThreshold
-}
-
-func isEnabledAndSticky(category category) bool {
-	return category.isSticky && category.isEnabled
-}
-
-func updateHealthCheckWithAckMsg(healthChecks []fthealth.CheckResult, name string, ackMsg string) {
+func updateHealthCheckWithAckMsg(healthChecks []fthealth.CheckResult, serviceKey string, ackMsg string) {
 	for i, healthCheck := range healthChecks {
-		if healthCheck.Name == name {
+		if healthCheck.ID == serviceKey {
 			healthChecks[i].Ack = ackMsg
 			return
 		}
@@ -12,6 +12,7 @@ import (
 
 	"strconv"
 	"strings"
+	"sync/atomic"
 
 	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
 	"github.com/stretchr/testify/assert"
@@ -32,6 +33,7 @@ const (
 	validEnvName            = "valid-env-name"
 	ip                      = "10.2.51.2"
 	severity1               = uint8(1)
+	serviceWithAckedPod     = "serviceWithAckedPod"
 )
 
 func init() {
@@ -50,9 +52,17 @@ var defaultPods = []pod{
 }
 
 type MockService struct {
-	httpClient          *http.Client
-	getServiceByNameErr error
-	getDeploymentsErr   error
+	httpClient           *http.Client
+	getServiceByNameErr  error
+	getDeploymentsErr    error
+	serviceEvents        chan serviceEvent
+	expiredAcks          []string
+	removeExpiredAcksErr error
+	ackKeys              []string
+	silences             []silence
+	categories           map[string]category
+	updatedCategories    map[string]bool
+	categoryChanges      map[string]categoryChange
 }
 
 func (m *MockService) RLockServices() {}
@@ -60,6 +70,10 @@ func (m *MockService) RLockServices() {}
 func (m *MockService) RUnlockServices() {}
 
 func (m *MockService) getCategories(_ context.Context) (map[string]category, error) {
+	if m.categories != nil {
+		return m.categories, nil
+	}
+
 	categories := make(map[string]category)
 
 	categories["default"] = category{
@@ -73,21 +87,47 @@ func (m *MockService) getCategories(_ context.Context) (map[string]category, err
 	return categories, nil
 }
 
-func (m *MockService) updateCategory(_ context.Context, categoryName string, _ bool) error {
+func (m *MockService) updateCategory(_ context.Context, categoryName string, isEnabled bool, change categoryChange) (category, error) {
 	if categoryName == nonExistingCategoryName {
-		return errors.New("Cannot find category")
+		return category{}, notFoundError{msg: "Cannot find category"}
+	}
+	if m.updatedCategories == nil {
+		m.updatedCategories = make(map[string]bool)
+		m.categoryChanges = make(map[string]categoryChange)
 	}
+	m.updatedCategories[categoryName] = isEnabled
+	m.categoryChanges[categoryName] = change
 
-	return nil
+	c, ok := m.categories[categoryName]
+	if !ok {
+		c = category{name: categoryName}
+	}
+	c.isEnabled = isEnabled
+	if isEnabled {
+		c.lastEnabled = &change
+	} else {
+		c.lastDisabled = &change
+	}
+	if ok {
+		m.categories[categoryName] = c
+	}
+
+	return c, nil
 }
 
-func (m *MockService) getDeployments(_ context.Context) (map[string]deployment, error) {
-	return map[string]deployment{
+func (m *MockService) getWorkloads(_ context.Context, _ []service) (map[string]workload, error) {
+	return map[string]workload{
 		"test-service-name": {
+			kind:            deploymentWorkload,
+			name:            "test-service-name",
 			desiredReplicas: 2,
+			readyReplicas:   2,
 		},
 		"test-service-name-2": {
+			kind:            deploymentWorkload,
+			name:            "test-service-name-2",
 			desiredReplicas: 2,
+			readyReplicas:   2,
 		},
 	}, m.getDeploymentsErr
 }
@@ -98,10 +138,10 @@ func (m *MockService) isServicePresent(serviceName string) bool {
 
 func (m *MockService) getServiceByName(serviceName string) (service, error) {
 	if serviceName == nonExistingServiceName {
-		return service{}, fmt.Errorf("Cannot find service with name %s", serviceName)
+		return service{}, notFoundError{msg: fmt.Sprintf("Cannot find service with name %s", serviceName)}
 	}
 	return service{
-		name:        "test-service-name",
+		name:        serviceName,
 		ack:         "test ack",
 		isResilient: strings.HasPrefix(serviceName, "resilient"),
 	}, m.getServiceByNameErr
@@ -133,8 +173,8 @@ func createPods(goodCount int, notOkSeverities []int) []pod {
 	return pods
 }
 
-func (m *MockService) getPodsForService(_ context.Context, serviceName string) ([]pod, error) {
-	switch serviceName {
+func (m *MockService) getPodsForService(_ context.Context, s service) ([]pod, error) {
+	switch s.name {
 	case "invalidNameForService":
 		return []pod{}, errors.New("invalid pod name")
 	case "resilient-notok-sev1":
@@ -149,12 +189,17 @@ func (m *MockService) getPodsForService(_ context.Context, serviceName string) (
 		return createPods(1, []int{1}), nil
 	case "non-resilient-halfok-sev2":
 		return createPods(1, []int{2}), nil
+	case serviceWithAckedPod:
+		return []pod{
+			{name: "acked-pod", namespace: "default", ip: ip, ack: "bad node"},
+			{name: "other-pod", namespace: "default", ip: ip},
+		}, nil
 	default:
 		return defaultPods, nil
 	}
 }
 
-func (m *MockService) getPodByName(_ context.Context, podName string) (pod, error) {
+func (m *MockService) getPodByName(_ context.Context, _ string, podName string) (pod, error) {
 	switch podName {
 	case nonExistingPodName:
 		{
@@ -183,84 +228,187 @@ func (m *MockService) getPodByName(_ context.Context, podName string) (pod, erro
 	}
 }
 
-func (m *MockService) checkServiceHealth(_ context.Context, _ service, _ map[string]deployment) (string, error) {
-	return "", errors.New("Error reading healthcheck response: ")
+func (m *MockService) probeService(ctx context.Context, s service) (serviceProbe, error) {
+	pods, err := m.getPodsForService(ctx, s)
+	if err != nil {
+		return serviceProbe{service: s}, err
+	}
+
+	return serviceProbe{service: s, pods: m.probePods(ctx, pods, s)}, nil
 }
 
-func (m *MockService) checkPodHealth(pod, int32) error {
-	return errors.New("Error reading healthcheck response: ")
+func (m *MockService) probePods(ctx context.Context, pods []pod, s service) []podProbe {
+	probes := make([]podProbe, 0, len(pods))
+	for _, p := range pods {
+		probes = append(probes, m.probePod(ctx, p, s))
+	}
+	return probes
 }
 
-func (m *MockService) getIndividualPodSeverity(pod pod, _ int32) (uint8, bool, error) {
-	switch pod.name {
-	case failingPod:
-		return 1, false, errors.New("Test")
-	case podWithCriticalSeverity:
-		return 1, true, nil
-	case "notok-pod-1":
-		return 1, true, nil
-	case "notok-pod-2":
-		return 2, true, nil
+func (m *MockService) probePod(_ context.Context, p pod, _ service) podProbe {
+	switch {
+	case p.name == failingPod || p.name == nonExistingPodName:
+		return podProbe{pod: p, err: errors.New("Test")}
+	case p.name == podWithCriticalSeverity:
+		return newFailingPodProbe(p, 1)
+	case strings.HasPrefix(p.name, "notok-pod-"):
+		severity, _ := strconv.Atoi(strings.TrimPrefix(p.name, "notok-pod-"))
+		return newFailingPodProbe(p, uint8(severity))
+	case strings.HasPrefix(p.name, "ok-pod-"):
+		return podProbe{pod: p, health: healthcheckResponse{Checks: []podCheck{{Name: "check", OK: true, Severity: 1}}}}
 	default:
-		return defaultSeverity, false, nil
+		return podProbe{pod: p, err: errors.New("Error reading healthcheck response: ")}
 	}
 }
 
-func (m *MockService) getHealthChecksForPod(pod pod, _ int32) (healthcheckResponse, error) {
-	if pod.name == nonExistingPodName {
-		return healthcheckResponse{}, errors.New("Cannot find pod")
-	}
-	return healthcheckResponse{}, nil
+func newFailingPodProbe(p pod, severity uint8) podProbe {
+	return podProbe{pod: p, health: healthcheckResponse{Checks: []podCheck{{Name: "check", OK: false, Severity: severity}}}}
 }
 
 func (m *MockService) addAck(_ context.Context, serviceName string, _ string) error {
 	if serviceName == serviceNameForAckErr {
 		return errors.New("Error")
 	}
+	m.ackKeys = append(m.ackKeys, serviceName)
 	return nil
 }
 func (m *MockService) removeAck(_ context.Context, serviceName string) error {
 	if serviceName == serviceNameForAckErr {
 		return errors.New("Cannot remove ack")
 	}
+	m.ackKeys = append(m.ackKeys, serviceName)
+	return nil
+}
+func (m *MockService) removeExpiredAcks(_ context.Context, _ time.Time) ([]string, error) {
+	return m.expiredAcks, m.removeExpiredAcksErr
+}
+
+func (m *MockService) getSilences(context.Context) ([]silence, error) {
+	return m.silences, nil
+}
+
+func (m *MockService) addSilence(_ context.Context, s silence) error {
+	m.silences = append(m.silences, s)
 	return nil
 }
-func (m *MockService) getHTTPClient() httpClient {
-	return m.httpClient
+
+func (m *MockService) removeSilence(_ context.Context, silenceID string) error {
+	for i, s := range m.silences {
+		if s.ID == silenceID {
+			m.silences = append(m.silences[:i], m.silences[i+1:]...)
+			return nil
+		}
+	}
+	return notFoundError{msg: "Cannot find silence"}
+}
+
+func (m *MockService) removeEndedSilences(context.Context, time.Time) ([]string, error) {
+	return nil, nil
+}
+
+func (m *MockService) getHTTPEndpoint(_ service, path string) (httpEndpoint, error) {
+	return httpEndpoint{client: m.httpClient, scheme: httpScheme, path: path}, nil
+}
+
+func (m *MockService) getServiceEvents() <-chan serviceEvent {
+	return m.serviceEvents
 }
 
 func initializeMockController(httpClient *http.Client) (hcc *healthCheckController, service *MockService) {
-	measuredServices := make(map[string]measuredService)
 	service = new(MockService)
 	service.httpClient = httpClient
-	stickyCategoriesFailedServices := make(map[string]int)
+	hcc = &healthCheckController{
+		healthCheckService: service,
+		environment:        "test",
+		results:            newResultStore(nil),
+		sticky:             newStickyTracker(),
+		leader:             newLeaderElector(leaderElectionConfig{}),
+	}
+	hcc.scheduler = newCheckScheduler(hcc.runScheduledCheck, defaultSchedulerWorkers, hcc.results)
+	hcc.scheduler.start()
+
+	return hcc, service
+}
 
-	return &healthCheckController{
-		healthCheckService:             service,
-		environment:                    "test",
-		measuredServices:               measuredServices,
-		stickyCategoriesFailedServices: stickyCategoriesFailedServices,
-	}, service
+func isMeasured(c *healthCheckController, serviceName string) func() bool {
+	return func() bool {
+		_, ok := c.scheduler.getMeasuredService(serviceName)
+		return ok
+	}
 }
 
 func TestAddAckNilError(t *testing.T) {
 	controller, _ := initializeMockController(nil)
-	err := controller.addAck(context.TODO(), "abc", "abc")
+	err := controller.addAck(context.TODO(), "abc", ack{Message: "abc"})
 	assert.Nil(t, err)
 }
 
 func TestAddAckInvalidServiceName(t *testing.T) {
 	controller, _ := initializeMockController(nil)
-	err := controller.addAck(context.TODO(), nonExistingServiceName, "abc")
+	err := controller.addAck(context.TODO(), nonExistingServiceName, ack{Message: "abc"})
 	assert.NotNil(t, err)
 }
 
 func TestAddAckInvalidServiceNameWillAckingError(t *testing.T) {
 	controller, _ := initializeMockController(nil)
-	err := controller.addAck(context.TODO(), serviceNameForAckErr, "abc")
+	err := controller.addAck(context.TODO(), serviceNameForAckErr, ack{Message: "abc"})
 	assert.NotNil(t, err)
 }
 
+func TestReapExpiredAcks(t *testing.T) {
+	controller, service := initializeMockController(nil)
+	service.expiredAcks = []string{"service1"}
+	controller.reapExpiredAcks(context.TODO())
+
+	service.removeExpiredAcksErr = errors.New("cannot update acks")
+	controller.reapExpiredAcks(context.TODO())
+}
+
+func TestCollectChecksFromCacheUsesCurrentAcks(t *testing.T) {
+	controller, _ := initializeMockController(nil)
+	defer controller.scheduler.stop()
+	controller.results.set("test-service-name", fthealth.CheckResult{ID: "test-service-name", Name: "test-service-name", Ok: false, Ack: "stale ack"}, checkBreakdown{})
+	controller.results.set("test-service-name-2", fthealth.CheckResult{ID: "test-service-name-2", Name: "test-service-name-2", Ok: false}, checkBreakdown{})
+
+	checks, _, err := controller.collectChecksFromCachesFor(context.TODO(), map[string]category{"default": {name: "default"}})
+	assert.NoError(t, err)
+	for _, check := range checks {
+		if check.Name == "test-service-name" {
+			assert.Equal(t, "test ack", check.Ack, "The cached result should carry the current ack of the service")
+		} else {
+			assert.Empty(t, check.Ack)
+		}
+	}
+}
+
+func TestAddPodAck(t *testing.T) {
+	controller, service := initializeMockController(nil)
+	assert.NoError(t, controller.addPodAck(context.TODO(), "default/test-pod-name", "", ack{Message: "bad node"}))
+	assert.NoError(t, controller.addPodAck(context.TODO(), "default/test-pod-name", "Check connectivity to Kafka", ack{Message: "broker maintenance"}))
+	assert.NoError(t, controller.removePodAck(context.TODO(), "default/test-pod-name", "Check connectivity to Kafka"))
+
+	p := pod{name: "test-pod-name-8425234-9hdfg "}
+	assert.Equal(t, []string{p.ackKey(), p.checkAckKey("Check connectivity to Kafka"), p.checkAckKey("Check connectivity to Kafka")}, service.ackKeys)
+}
+
+func TestAddPodAckNonExistingPod(t *testing.T) {
+	controller, _ := initializeMockController(nil)
+	assert.Error(t, controller.addPodAck(context.TODO(), nonExistingPodName, "", ack{Message: "bad node"}))
+	assert.Error(t, controller.removePodAck(context.TODO(), nonExistingPodName, ""))
+}
+
+func TestRunPodChecksForPrefersPodAcks(t *testing.T) {
+	controller, _ := initializeMockController(nil)
+	checks, _, err := controller.runPodChecksFor(context.TODO(), serviceWithAckedPod)
+	assert.NoError(t, err)
+
+	acks := make(map[string]string)
+	for _, check := range checks {
+		acks[check.ID] = check.Ack
+	}
+	assert.Equal(t, map[string]string{"default/acked-pod": "bad node", "default/other-pod": "test ack"}, acks)
+}
+
 func TestRemoveAckNonExistingServiceErr(t *testing.T) {
 	controller, _ := initializeMockController(nil)
 	err := controller.removeAck(context.TODO(), nonExistingServiceName)
@@ -298,6 +446,39 @@ func TestBuildServicesHealthResultFromCache(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestBuildServicesHealthResultAppliesSilences(t *testing.T) {
+	controller, service := initializeMockController(nil)
+	defer controller.scheduler.stop()
+	now := time.Now()
+	service.silences = []silence{
+		{ID: "s1", Comment: "maintenance", StartsAt: now.Add(-time.Hour), EndsAt: now.Add(time.Hour), Services: []string{"test-service-name", "test-service-name-2"}},
+	}
+	controller.results.set("test-service-name", fthealth.CheckResult{ID: "test-service-name", Name: "test-service-name", Ok: false}, checkBreakdown{})
+	controller.results.set("test-service-name-2", fthealth.CheckResult{ID: "test-service-name-2", Name: "test-service-name-2", Ok: false}, checkBreakdown{})
+
+	health, _, err := controller.buildServicesHealthResult(context.TODO(), []string{"default"}, true)
+	assert.NoError(t, err)
+	for _, check := range health.Checks {
+		if check.Name == "test-service-name" {
+			assert.Equal(t, "test ack", check.Ack, "An acked service should keep its ack")
+		} else {
+			assert.Equal(t, "s1", parseAck(check.Ack).Silence)
+		}
+	}
+}
+
+func TestAddAndRemoveSilence(t *testing.T) {
+	controller, _ := initializeMockController(nil)
+	assert.NoError(t, controller.addSilence(context.TODO(), silence{ID: "s1"}))
+
+	silences, err := controller.getSilences(context.TODO())
+	assert.NoError(t, err)
+	assert.Len(t, silences, 1)
+
+	assert.NoError(t, controller.removeSilence(context.TODO(), "s1"))
+	assert.Equal(t, http.StatusNotFound, getStatusCodeForError(controller.removeSilence(context.TODO(), "s1")))
+}
+
 func TestGetIndividualPodHealthHappyFlowWithGetServiceByNameErr(t *testing.T) {
 	httpClient := initializeMockHTTPClient(http.StatusOK, "")
 	controller, m := initializeMockController(httpClient)
@@ -350,32 +531,31 @@ func TestBuildPodsHealthResultInvalidServiceName(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
-func TestGetSeverityForPodInvalidPodName(t *testing.T) {
-	controller, _ := initializeMockController(nil)
-	severity := controller.getSeverityForPod(context.TODO(), nonExistingPodName, 8080)
-	assert.Equal(t, defaultSeverity, severity)
-}
-
 func TestComputeSeverityByPods(t *testing.T) {
-	controller, _ := initializeMockController(nil)
-	severity := controller.computeSeverityByPods([]pod{{name: nonExistingPodName}}, 8080)
+	severity := computeSeverityByPods([]podProbe{{pod: pod{name: nonExistingPodName}, err: errors.New("Cannot find pod")}}, time.Now())
 	assert.Equal(t, defaultSeverity, severity)
 }
 
 func TestComputeSeverityForPodWithCriticalSeverity(t *testing.T) {
-	controller, _ := initializeMockController(nil)
-	severity := controller.computeSeverityByPods([]pod{{name: failingPod}, {name: podWithCriticalSeverity}}, 8080)
+	_, hcService := initializeMockController(nil)
+	probes := []podProbe{
+		hcService.probePod(context.TODO(), pod{name: failingPod}, service{appPort: 8080}),
+		hcService.probePod(context.TODO(), pod{name: podWithCriticalSeverity}, service{appPort: 8080}),
+	}
+	severity := computeSeverityByPods(probes, time.Now())
 	assert.Equal(t, uint8(1), severity)
 }
 
-func TestGetSeverityForServiceInvalidServiceName(t *testing.T) {
-	controller, _ := initializeMockController(nil)
-	severity := controller.getSeverityForService(context.TODO(), invalidNameForService, 8080)
-	assert.Equal(t, defaultSeverity, severity)
+func TestGetSeverityForServiceWithoutProbedPods(t *testing.T) {
+	_, service := initializeMockController(nil)
+	s, _ := service.getServiceByName(invalidNameForService)
+	probe, err := service.probeService(context.TODO(), s)
+	assert.Error(t, err)
+	assert.Equal(t, defaultSeverity, getSeverityForService(probe, time.Now()))
 }
 
 func TestGetSeverityForResilientService(t *testing.T) {
-	controller, _ := initializeMockController(nil)
+	_, service := initializeMockController(nil)
 
 	var testCases = []struct {
 		serviceName      string
@@ -414,26 +594,76 @@ func TestGetSeverityForResilientService(t *testing.T) {
 		},
 	}
 	for _, tc := range testCases {
-		actualSeverity := controller.getSeverityForService(context.TODO(), tc.serviceName, 8080)
+		s, _ := service.getServiceByName(tc.serviceName)
+		probe, err := service.probeService(context.TODO(), s)
+		assert.NoError(t, err)
+		actualSeverity := getSeverityForService(probe, time.Now())
 		assert.Equal(t, tc.expectedSeverity, actualSeverity, tc.description)
 	}
 }
 
-func TestGetSeverityForNonResilientService(t *testing.T) {
-	controller, _ := initializeMockController(nil)
-	severity := controller.getSeverityForService(context.TODO(), invalidNameForService, 8080)
-	assert.Equal(t, defaultSeverity, severity)
+func TestGetSeverityForServiceLeavesOutAckedPods(t *testing.T) {
+	probe := serviceProbe{
+		service: service{name: "non-resilient"},
+		pods: []podProbe{
+			newFailingPodProbe(pod{name: "acked-pod", ack: "bad node"}, 1),
+			newFailingPodProbe(pod{name: "other-pod"}, 2),
+		},
+	}
+	assert.Equal(t, defaultSeverity, getSeverityForService(probe, time.Now()))
+}
+
+func TestServiceCheckProbesEachPodOnce(t *testing.T) {
+	var requests atomic.Int32
+	hcService := initializeMockService(t, nil,
+		newTestPod("service1-pod-a", "service1"),
+		newTestPod("service1-pod-b", "service1"))
+	hcService.httpClient = &mockHTTPClient{doFunc: func(req *http.Request) (*http.Response, error) {
+		requests.Add(1)
+		return respondWith(http.StatusOK, namedFailingChecksResponseBody)(req)
+	}}
+	controller := &healthCheckController{healthCheckService: hcService}
+	s := service{name: "service1", namespace: "default", isDaemon: true, isResilient: true, appPort: 8080}
+
+	results, _, err := controller.runServiceChecksByServiceNames(context.TODO(), map[string]service{s.key(): s})
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.False(t, results[0].Ok)
+	assert.Equal(t, int32(2), requests.Load(), "The severity of the failing service should be computed from the probes of its check")
+}
+
+func TestServiceCheckReturnsBreakdownOfFailingPods(t *testing.T) {
+	hcService := initializeMockService(t, nil,
+		newTestPod("service1-pod-a", "service1"),
+		newTestPod("service1-pod-b", "service1"))
+	hcService.httpClient = &mockHTTPClient{doFunc: respondWith(http.StatusOK, namedFailingChecksResponseBody)}
+	controller := &healthCheckController{healthCheckService: hcService}
+	s := service{name: "service1", namespace: "default", isDaemon: true, appPort: 8080}
+
+	results, breakdowns, err := controller.runServiceChecksByServiceNames(context.TODO(), map[string]service{s.key(): s})
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.False(t, results[0].Ok)
+
+	breakdown := breakdowns[s.key()]
+	assert.Equal(t, quorumStatus{Policy: "all", Healthy: 0, Desired: 2, Required: 2}, breakdown.quorum)
+	assert.Len(t, breakdown.failingPods, 2)
+	for _, failure := range breakdown.failingPods {
+		assert.Equal(t, failingChecksReason, failure.Reason)
+		assert.Equal(t, []failingCheck{{Name: "Check connectivity to Kafka", Severity: 1}}, failure.FailingChecks)
+		assert.Contains(t, results[0].CheckOutput, failure.Pod)
+	}
 }
 
 func TestUpdateStickyCategoryInvalidCategoryName(t *testing.T) {
 	controller, _ := initializeMockController(nil)
-	err := controller.updateStickyCategory(context.TODO(), nonExistingCategoryName, false)
+	_, err := controller.updateStickyCategory(context.TODO(), nonExistingCategoryName, false, categoryChange{})
 	assert.NotNil(t, err)
 }
 
 func TestUpdateStickyCategoryHappyFlow(t *testing.T) {
 	controller, _ := initializeMockController(nil)
-	err := controller.updateStickyCategory(context.TODO(), validCat, false)
+	_, err := controller.updateStickyCategory(context.TODO(), validCat, false, categoryChange{})
 	assert.Nil(t, err)
 }
 
@@ -514,74 +744,197 @@ func TestRunServiceChecksForStickyCategoryUpdateError(t *testing.T) {
 	assert.False(t, categories["test"].isEnabled)
 }
 
-func TestDisableStickyFailingCategoriesThresholdNotReached(t *testing.T) {
-	categories := make(map[string]category)
-	categories["publishing"] = category{
-		services: []string{"service1", "service2"},
+func TestEvaluateStickyCategoriesDisablesCategoryAfterThreshold(t *testing.T) {
+	controller, service := initializeMockController(nil)
+	service.categories = map[string]category{
+		"publishing": {name: "publishing", services: []string{"service1"}, isEnabled: true, failureThreshold: 1},
+		"read":       {name: "read", services: []string{"test-service-name"}, isSticky: true, isEnabled: true, failureThreshold: 2},
 	}
-	categories["test"] = category{
-		services:  []string{"test-service-name"},
-		isSticky:  true,
-		isEnabled: true,
-	}
-	healthchecks := []fthealth.CheckResult{
-		{
-			ID: "service1",
-			Ok: true,
-		},
-		{
-			ID: "service2",
-			Ok: true,
-		},
-		{
-			ID: "test-service-name",
-			Ok: false,
-		},
+	failing := fthealth.CheckResult{ID: "default/test-service-name", Name: "test-service-name", Ok: false}
+
+	controller.evaluateStickyCategories(context.TODO(), failing)
+	assert.Empty(t, service.updatedCategories)
+
+	controller.evaluateStickyCategories(context.TODO(), failing)
+	assert.Equal(t, map[string]bool{"read": false}, service.updatedCategories)
+}
+
+func TestEvaluateStickyCategoriesRecordsWhyCategoryIsDisabled(t *testing.T) {
+	controller, service := initializeMockController(nil)
+	service.categories = map[string]category{
+		"read": {name: "read", services: []string{"test-service-name"}, isSticky: true, isEnabled: true, failureThreshold: 1},
 	}
 
+	controller.evaluateStickyCategories(context.TODO(), fthealth.CheckResult{ID: "default/test-service-name", Name: "test-service-name", Ok: false, CheckOutput: "0/2 pods available"})
+
+	change := service.categoryChanges["read"]
+	assert.Equal(t, stickyActor, change.By)
+	assert.Equal(t, "default/test-service-name", change.Service)
+	assert.Equal(t, 1, change.Failures)
+	assert.Equal(t, "0/2 pods available", change.Output)
+	assert.False(t, change.At.IsZero())
+}
+
+func TestUpdateStickyCategoryReturnsTheUpdatedStatus(t *testing.T) {
 	controller, _ := initializeMockController(nil)
-	controller.disableStickyFailingCategories(context.TODO(), categories, healthchecks)
-	assert.True(t, categories["test"].isEnabled)
+	change := categoryChange{At: time.Now(), By: "jane.doe", Reason: "failover"}
+
+	status, err := controller.updateStickyCategory(context.TODO(), validCat, false, change)
+	assert.NoError(t, err)
+	assert.False(t, status.Enabled)
+	assert.Equal(t, &change, status.LastDisabled)
 }
 
-func TestDisableStickyFailingCategoriesThresholdReached(t *testing.T) {
-	categories := make(map[string]category)
-	categories["publishing"] = category{
-		services:         []string{"service1", "service2"},
-		name:             "service",
-		isEnabled:        true,
-		failureThreshold: 2,
+func TestEvaluateStickyCategoriesResetsOnSuccess(t *testing.T) {
+	controller, service := initializeMockController(nil)
+	service.categories = map[string]category{
+		"read": {name: "read", services: []string{"test-service-name"}, isSticky: true, isEnabled: true, failureThreshold: 2},
 	}
-	categories["test"] = category{
-		services:         []string{"test-service-name"},
-		name:             "test-service-name",
-		isSticky:         true,
-		isEnabled:        true,
-		failureThreshold: 2,
+	failing := fthealth.CheckResult{ID: "default/test-service-name", Name: "test-service-name", Ok: false}
+	healthy := fthealth.CheckResult{ID: "default/test-service-name", Name: "test-service-name", Ok: true}
+
+	for i := 0; i < 3; i++ {
+		controller.evaluateStickyCategories(context.TODO(), failing)
+		controller.evaluateStickyCategories(context.TODO(), healthy)
 	}
-	healthchecks := []fthealth.CheckResult{
-		{
-			ID:   "service1",
-			Name: "service1",
-			Ok:   true,
+	assert.Empty(t, service.updatedCategories, "A service that recovers between failures should not disable its category")
+}
+
+func TestEvaluateStickyCategoriesReEnablesCategory(t *testing.T) {
+	controller, service := initializeMockController(nil)
+	service.categories = map[string]category{
+		"read": {name: "read", services: []string{"test-service-name"}, isSticky: true, isEnabled: true, failureThreshold: 1, autoReEnableAfter: 2},
+	}
+	failing := fthealth.CheckResult{ID: "default/test-service-name", Name: "test-service-name", Ok: false}
+	healthy := fthealth.CheckResult{ID: "default/test-service-name", Name: "test-service-name", Ok: true}
+
+	controller.evaluateStickyCategories(context.TODO(), failing)
+	assert.False(t, service.categories["read"].isEnabled)
+
+	controller.evaluateStickyCategories(context.TODO(), healthy)
+	assert.False(t, service.categories["read"].isEnabled)
+	controller.evaluateStickyCategories(context.TODO(), healthy)
+	assert.True(t, service.categories["read"].isEnabled)
+}
+
+func TestFollowerDoesNotEvaluateStickyCategories(t *testing.T) {
+	controller, service := initializeMockController(nil)
+	controller.leader = newLeaderElector(leaderElectionConfig{enabled: true, identity: "replica-b"})
+	service.categories = map[string]category{
+		"read": {name: "read", services: []string{"test-service-name"}, isSticky: true, isEnabled: true, failureThreshold: 1},
+	}
+
+	controller.evaluateStickyCategories(context.TODO(), fthealth.CheckResult{ID: "default/test-service-name", Name: "test-service-name", Ok: false})
+	assert.Empty(t, service.updatedCategories)
+}
+
+func TestBuildServicesHealthResultDoesNotDisableStickyCategories(t *testing.T) {
+	controller, service := initializeMockController(nil)
+	defer controller.scheduler.stop()
+	service.categories = map[string]category{
+		"read": {name: "read", services: []string{"test-service-name"}, isSticky: true, isEnabled: true, failureThreshold: 1},
+	}
+	controller.results.set("test-service-name", fthealth.CheckResult{ID: "test-service-name", Name: "test-service-name", Ok: false}, checkBreakdown{})
+
+	_, categories, err := controller.buildServicesHealthResult(context.TODO(), []string{"read"}, true)
+	assert.NoError(t, err)
+	assert.True(t, categories["read"].isEnabled, "Requests should not count against the failure threshold")
+	assert.Empty(t, service.updatedCategories)
+}
+
+func TestScheduleServiceAddsMeasuredService(t *testing.T) {
+	controller, _ := initializeMockController(nil)
+	defer controller.scheduler.stop()
+	controller.scheduleService(context.TODO(), service{name: "test-service-name"})
+
+	assert.Eventually(t, isMeasured(controller, "test-service-name"), time.Second, time.Millisecond)
+	mService, _ := controller.scheduler.getMeasuredService("test-service-name")
+	assert.Equal(t, "test-service-name", mService.service.name)
+}
+
+func TestScheduleServiceKeepsUnchangedService(t *testing.T) {
+	controller, _ := initializeMockController(nil)
+	defer controller.scheduler.stop()
+	controller.scheduleService(context.TODO(), service{name: "test-service-name", appPort: 8080})
+	assert.Eventually(t, isMeasured(controller, "test-service-name"), time.Second, time.Millisecond)
+	first, _ := controller.scheduler.getMeasuredService("test-service-name")
+
+	controller.scheduleService(context.TODO(), service{name: "test-service-name", appPort: 8080})
+	controller.scheduleService(context.TODO(), service{name: "test-service-name", appPort: 8081})
+
+	assert.Eventually(t, func() bool {
+		mService, _ := controller.scheduler.getMeasuredService("test-service-name")
+		return mService.service.appPort == 8081
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, int32(8080), first.service.appPort)
+}
+
+func TestUnscheduleServiceRemovesMeasuredService(t *testing.T) {
+	controller, _ := initializeMockController(nil)
+	defer controller.scheduler.stop()
+	controller.scheduleService(context.TODO(), service{name: "test-service-name"})
+	assert.Eventually(t, isMeasured(controller, "test-service-name"), time.Second, time.Millisecond)
+
+	controller.unscheduleService("test-service-name")
+	controller.unscheduleService("test-service-name")
+
+	assert.Eventually(t, func() bool {
+		return !isMeasured(controller, "test-service-name")()
+	}, time.Second, time.Millisecond)
+}
+
+func TestWatchServiceEventsSchedulesAndUnschedulesServices(t *testing.T) {
+	controller, m := initializeMockController(nil)
+	defer controller.scheduler.stop()
+	m.serviceEvents = make(chan serviceEvent)
+	done := make(chan struct{})
+	go func() {
+		controller.watchServiceEvents()
+		close(done)
+	}()
+
+	m.serviceEvents <- serviceEvent{eventType: serviceUpserted, service: service{name: "test-service-name"}}
+	m.serviceEvents <- serviceEvent{eventType: serviceUpserted, service: service{name: "test-service-name-2"}}
+	m.serviceEvents <- serviceEvent{eventType: serviceRemoved, service: service{name: "test-service-name"}}
+	close(m.serviceEvents)
+	<-done
+
+	assert.Eventually(t, func() bool {
+		return !isMeasured(controller, "test-service-name")() && isMeasured(controller, "test-service-name-2")()
+	}, time.Second, time.Millisecond)
+}
+
+func TestGetRefreshPeriodForService(t *testing.T) {
+	categories := map[string]category{
+		"default": {
+			name:          "default",
+			refreshPeriod: 60 * time.Second,
 		},
-		{
-			ID:   "service2",
-			Name: "service2",
-			Ok:   true,
+		"publishing": {
+			name:          "publishing",
+			services:      []string{"service1"},
+			refreshPeriod: 30 * time.Second,
 		},
-		{
-			ID:   "test-service-name",
-			Name: "test-service-name",
-			Ok:   false,
+		"image-publish": {
+			name:          "image-publish",
+			services:      []string{"service2", "images/service3"},
+			refreshPeriod: 15 * time.Second,
 		},
 	}
 
-	controller, _ := initializeMockController(nil)
-	controller.disableStickyFailingCategories(context.TODO(), categories, healthchecks)
-	controller.disableStickyFailingCategories(context.TODO(), categories, healthchecks)
-	controller.disableStickyFailingCategories(context.TODO(), categories, healthchecks)
-	assert.False(t, categories["test"].isEnabled)
+	assert.Equal(t, 30*time.Second, getRefreshPeriodForService("service1", categories))
+	assert.Equal(t, 30*time.Second, getRefreshPeriodForService("publishing/service1", categories))
+	assert.Equal(t, 15*time.Second, getRefreshPeriodForService("images/service3", categories))
+	assert.Equal(t, 15*time.Second, getRefreshPeriodForService("unknown-service", categories))
+	assert.Equal(t, defaultRefreshPeriod, getRefreshPeriodForService("service1", nil))
+}
+
+func TestMatchesServiceEntry(t *testing.T) {
+	assert.True(t, matchesServiceEntry("service1", "publishing/service1"))
+	assert.True(t, matchesServiceEntry("service1", "service1"))
+	assert.True(t, matchesServiceEntry("publishing/service1", "publishing/service1"))
+	assert.False(t, matchesServiceEntry("publishing/service1", "default/service1"))
+	assert.False(t, matchesServiceEntry("service1", "publishing/service10"))
 }
 
 func TestGetMatchingCategoriesHappyFlow(t *testing.T) {
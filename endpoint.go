@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/Financial-Times/go-logger"
+	k8score "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// schemeAnnotation is the scheme the HTTP endpoints of the pods are served with, http or https
+	schemeAnnotation = "aggregate-healthcheck.ft.com/scheme"
+	// pathAnnotation replaces the path probed by the health, gtg and http probes
+	pathAnnotation = "aggregate-healthcheck.ft.com/path"
+	// headersAnnotation holds the headers sent with every request to the pods, as a JSON object
+	headersAnnotation = "aggregate-healthcheck.ft.com/headers"
+	// bearerTokenSecretAnnotation and caBundleSecretAnnotation refer to a key of a Secret of the namespace of the service, as "name/key"
+	bearerTokenSecretAnnotation = "aggregate-healthcheck.ft.com/bearer-token-secret"
+	caBundleSecretAnnotation    = "aggregate-healthcheck.ft.com/ca-bundle-secret"
+	// insecureSkipVerifyAnnotation turns off the verification of the certificates of the pods
+	insecureSkipVerifyAnnotation = "aggregate-healthcheck.ft.com/insecure-skip-verify"
+
+	// credentialsSecretsLabelSelector selects the Secrets the services can refer to; no other Secret is read
+	credentialsSecretsLabelSelector = "healthcheck-credentials-for=aggregate-healthcheck"
+	defaultBearerTokenKey           = "token"
+	defaultCABundleKey              = "ca.crt"
+
+	httpScheme  = "http"
+	httpsScheme = "https"
+)
+
+// secretRef refers to a key of a Secret of the namespace of the service.
+type secretRef struct {
+	name string
+	key  string
+}
+
+func (r secretRef) String() string {
+	return r.name + "/" + r.key
+}
+
+// parseSecretRef parses a Secret reference, either "name/key" or "name" for the default key.
+func parseSecretRef(value string, defaultKey string) (secretRef, error) {
+	name, key, found := strings.Cut(strings.TrimSpace(value), "/")
+	if !found {
+		key = defaultKey
+	}
+	if name == "" || key == "" {
+		return secretRef{}, fmt.Errorf("invalid secret reference %q, expected name/key", value)
+	}
+	return secretRef{name: name, key: key}, nil
+}
+
+// endpointConfig is how the HTTP endpoints of the pods of a service are reached.
+type endpointConfig struct {
+	scheme string
+	// path, if set, replaces the path of the probe
+	path               string
+	headers            map[string]string
+	bearerToken        *secretRef
+	caBundle           *secretRef
+	insecureSkipVerify bool
+}
+
+// getEndpointConfig returns the endpoint configuration set on the service by its annotations.
+// The invalid annotations are logged and ignored.
+func getEndpointConfig(k8sService *k8score.Service) endpointConfig {
+	annotations := k8sService.Annotations
+	config := endpointConfig{scheme: httpScheme, path: annotations[pathAnnotation]}
+
+	if value, ok := annotations[schemeAnnotation]; ok {
+		switch scheme := strings.ToLower(strings.TrimSpace(value)); scheme {
+		case httpScheme, httpsScheme:
+			config.scheme = scheme
+		default:
+			log.Warnf("Unknown scheme %q of service with name %s, using %s.", value, k8sService.Name, httpScheme)
+		}
+	}
+
+	if value, ok := annotations[headersAnnotation]; ok {
+		if err := json.Unmarshal([]byte(value), &config.headers); err != nil {
+			log.WithError(err).Warnf("Cannot parse the headers of service with name %s.", k8sService.Name)
+		}
+	}
+
+	if value, ok := annotations[bearerTokenSecretAnnotation]; ok {
+		ref, err := parseSecretRef(value, defaultBearerTokenKey)
+		if err != nil {
+			log.WithError(err).Warnf("Cannot parse the bearer token secret of service with name %s.", k8sService.Name)
+		} else {
+			config.bearerToken = &ref
+		}
+	}
+
+	if value, ok := annotations[caBundleSecretAnnotation]; ok {
+		ref, err := parseSecretRef(value, defaultCABundleKey)
+		if err != nil {
+			log.WithError(err).Warnf("Cannot parse the CA bundle secret of service with name %s.", k8sService.Name)
+		} else {
+			config.caBundle = &ref
+		}
+	}
+
+	if value, ok := annotations[insecureSkipVerifyAnnotation]; ok {
+		insecureSkipVerify, err := strconv.ParseBool(value)
+		if err != nil {
+			log.WithError(err).Warnf("Cannot parse the insecure-skip-verify annotation of service with name %s.", k8sService.Name)
+		}
+		config.insecureSkipVerify = insecureSkipVerify
+	}
+
+	return config
+}
+
+// httpEndpoint is an HTTP endpoint of the pods of a service, with the client of its scheme and the headers of its requests.
+type httpEndpoint struct {
+	client httpClient
+	scheme string
+	path   string
+	header http.Header
+}
+
+func (e httpEndpoint) newRequest(ctx context.Context, pod pod, port int32) (*http.Request, error) {
+	scheme := e.scheme
+	if scheme == "" {
+		scheme = httpScheme
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s://%s%s", scheme, podAddress(pod, port), e.path), nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range e.header {
+		req.Header[name] = values
+	}
+	return req, nil
+}
+
+// getHTTPEndpoint returns the endpoint of the pods of the service at the path, unless the service sets another path.
+// The secrets are read on every call, so the endpoint always sends the current bearer token and trusts the current CA bundle.
+func (hs *k8sHealthcheckService) getHTTPEndpoint(service service, path string) (httpEndpoint, error) {
+	config := service.endpoint
+	endpoint := httpEndpoint{client: hs.httpClient, scheme: config.scheme, path: path, header: make(http.Header)}
+	if config.path != "" {
+		endpoint.path = config.path
+	}
+	for name, value := range config.headers {
+		endpoint.header.Set(name, value)
+	}
+
+	if config.bearerToken != nil {
+		token, err := hs.getSecretValue(service.namespace, *config.bearerToken)
+		if err != nil {
+			return httpEndpoint{}, err
+		}
+		endpoint.header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	if config.scheme != httpsScheme {
+		return endpoint, nil
+	}
+
+	var caBundle []byte
+	if config.caBundle != nil {
+		var err error
+		if caBundle, err = hs.getSecretValue(service.namespace, *config.caBundle); err != nil {
+			return httpEndpoint{}, err
+		}
+	}
+	client, err := hs.tlsClients.get(caBundle, config.insecureSkipVerify)
+	if err != nil {
+		return httpEndpoint{}, fmt.Errorf("cannot build the HTTPS client of service with name %s: %w", service.key(), err)
+	}
+	endpoint.client = client
+	return endpoint, nil
+}
+
+// getSecretValue reads a key of a credentials Secret of the namespace, from the informer cache.
+func (hs *k8sHealthcheckService) getSecretValue(namespace string, ref secretRef) ([]byte, error) {
+	if namespace == "" {
+		namespace = k8score.NamespaceDefault
+	}
+
+	lister, ok := hs.secretListers[namespace]
+	if !ok {
+		if lister, ok = hs.secretListers[k8smeta.NamespaceAll]; !ok {
+			return nil, fmt.Errorf("cannot read secret %s: namespace %s is not monitored", ref, namespace)
+		}
+	}
+
+	k8sSecret, err := lister.Secrets(namespace).Get(ref.name)
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("cannot find secret %s in namespace %s, or it does not have the %s label", ref.name, namespace, credentialsSecretsLabelSelector)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read secret %s: %w", ref, err)
+	}
+
+	value, ok := k8sSecret.Data[ref.key]
+	if !ok {
+		return nil, fmt.Errorf("secret %s in namespace %s has no key %s", ref.name, namespace, ref.key)
+	}
+	return value, nil
+}
+
+// onCredentialsSecretUpdated handles an update of a credentials Secret, unless it is a resync of the informer
+// that leaves the Secret as it was, so the connections to the pods are not dropped every resync period.
+func (hs *k8sHealthcheckService) onCredentialsSecretUpdated(oldObj, newObj interface{}) {
+	oldSecret, oldOk := oldObj.(*k8score.Secret)
+	newSecret, newOk := newObj.(*k8score.Secret)
+	if oldOk && newOk && oldSecret.ResourceVersion == newSecret.ResourceVersion {
+		return
+	}
+	hs.onCredentialsSecretChanged(newObj)
+}
+
+// onCredentialsSecretChanged drops the HTTPS clients, so the ones trusting a CA bundle that has changed are not kept around.
+func (hs *k8sHealthcheckService) onCredentialsSecretChanged(obj interface{}) {
+	hs.tlsClients.reset()
+	if k8sSecret, ok := obj.(*k8score.Secret); ok {
+		log.Infof("Credentials secret %s has been updated.", newObjectKey(k8sSecret.Namespace, k8sSecret.Name))
+	}
+}
+
+// tlsClients builds the HTTPS clients of the services, one for each CA bundle and verification setting,
+// so that the connections to the pods are reused across checks. Every client shares the outbound requests limit.
+type tlsClients struct {
+	sync.Mutex
+	clients          map[string]httpClient
+	transports       map[string]*http.Transport
+	outboundRequests *limiter
+}
+
+func newTLSClients(outboundRequests *limiter) *tlsClients {
+	return &tlsClients{
+		clients:          make(map[string]httpClient),
+		transports:       make(map[string]*http.Transport),
+		outboundRequests: outboundRequests,
+	}
+}
+
+// get returns the client trusting the CA bundle, or the system roots when it is empty.
+func (c *tlsClients) get(caBundle []byte, insecureSkipVerify bool) (httpClient, error) {
+	key := fmt.Sprintf("%t/%x", insecureSkipVerify, sha256.Sum256(caBundle))
+
+	c.Lock()
+	defer c.Unlock()
+	if client, ok := c.clients[key]; ok {
+		return client, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// nolint:gosec
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+	if len(caBundle) != 0 {
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caBundle) {
+			return nil, errors.New("the CA bundle holds no PEM certificate")
+		}
+	}
+
+	client := getDefaultClient()
+	transport := client.Transport.(*http.Transport)
+	transport.TLSClientConfig = tlsConfig
+	c.clients[key] = newLimitedHTTPClient(client, c.outboundRequests)
+	c.transports[key] = transport
+	return c.clients[key], nil
+}
+
+// reset drops the clients and closes their idle connections, the requests in flight being left to complete.
+func (c *tlsClients) reset() {
+	c.Lock()
+	transports := c.transports
+	c.clients = make(map[string]httpClient)
+	c.transports = make(map[string]*http.Transport)
+	c.Unlock()
+
+	for _, transport := range transports {
+		transport.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	k8score "k8s.io/api/core/v1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseSecretRef(t *testing.T) {
+	ref, err := parseSecretRef("health-credentials/api-token", defaultBearerTokenKey)
+	assert.NoError(t, err)
+	assert.Equal(t, secretRef{name: "health-credentials", key: "api-token"}, ref)
+
+	ref, err = parseSecretRef(" health-credentials ", defaultBearerTokenKey)
+	assert.NoError(t, err)
+	assert.Equal(t, secretRef{name: "health-credentials", key: defaultBearerTokenKey}, ref)
+
+	for _, invalid := range []string{"", "/token", "health-credentials/"} {
+		_, err = parseSecretRef(invalid, defaultBearerTokenKey)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestGetEndpointConfig(t *testing.T) {
+	newK8sService := func(annotations map[string]string) *k8score.Service {
+		return &k8score.Service{ObjectMeta: k8smeta.ObjectMeta{Name: "service1", Annotations: annotations}}
+	}
+
+	assert.Equal(t, endpointConfig{scheme: httpScheme}, getEndpointConfig(newK8sService(nil)))
+	assert.Equal(t, endpointConfig{
+		scheme:             httpsScheme,
+		path:               "/internal/__health",
+		headers:            map[string]string{"X-Api-Key": "key"},
+		bearerToken:        &secretRef{name: "health-credentials", key: defaultBearerTokenKey},
+		caBundle:           &secretRef{name: "health-ca", key: "bundle.pem"},
+		insecureSkipVerify: true,
+	}, getEndpointConfig(newK8sService(map[string]string{
+		schemeAnnotation:             "HTTPS",
+		pathAnnotation:               "/internal/__health",
+		headersAnnotation:            `{"X-Api-Key": "key"}`,
+		bearerTokenSecretAnnotation:  "health-credentials",
+		caBundleSecretAnnotation:     "health-ca/bundle.pem",
+		insecureSkipVerifyAnnotation: "true",
+	})))
+	assert.Equal(t, endpointConfig{scheme: httpScheme}, getEndpointConfig(newK8sService(map[string]string{
+		schemeAnnotation:            "ftp",
+		headersAnnotation:           "X-Api-Key: key",
+		bearerTokenSecretAnnotation: "/token",
+	})), "Invalid annotations should be ignored")
+}
+
+func newCredentialsSecret(name string, data map[string][]byte) *k8score.Secret {
+	return &k8score.Secret{
+		ObjectMeta: k8smeta.ObjectMeta{
+			Name:      name,
+			Namespace: k8score.NamespaceDefault,
+			Labels:    map[string]string{"healthcheck-credentials-for": "aggregate-healthcheck"},
+		},
+		Data: data,
+	}
+}
+
+// tlsHealthServer is a local HTTPS stand-in of a pod that records the headers of the last request.
+type tlsHealthServer struct {
+	*httptest.Server
+	sync.Mutex
+	header http.Header
+}
+
+func newTLSHealthServer(t *testing.T) *tlsHealthServer {
+	server := &tlsHealthServer{}
+	server.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.Lock()
+		server.header = r.Header.Clone()
+		server.Unlock()
+		assert.Equal(t, "/internal/__health", r.URL.Path)
+		_, _ = w.Write([]byte(validFailingHealthCheckResponseBody))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (s *tlsHealthServer) lastHeader() http.Header {
+	s.Lock()
+	defer s.Unlock()
+	return s.header
+}
+
+func (s *tlsHealthServer) caBundle() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+}
+
+func (s *tlsHealthServer) pod(t *testing.T) (pod, int32) {
+	serverURL, err := url.Parse(s.URL)
+	require.NoError(t, err)
+	return listenerPod(t, serverURL.Host)
+}
+
+func TestHTTPSProbeWithBearerTokenAndCABundle(t *testing.T) {
+	server := newTLSHealthServer(t)
+	tokenSecret := newCredentialsSecret("health-credentials", map[string][]byte{"token": []byte("secret-token\n")})
+	caSecret := newCredentialsSecret("health-ca", map[string][]byte{"ca.crt": server.caBundle()})
+	hs := initializeMockService(t, &http.Client{Timeout: 5 * time.Second}, tokenSecret, caSecret)
+
+	srv := service{name: "service1", namespace: k8score.NamespaceDefault, endpoint: endpointConfig{
+		scheme:      httpsScheme,
+		path:        "/internal/__health",
+		headers:     map[string]string{"X-Api-Key": "key"},
+		bearerToken: &secretRef{name: "health-credentials", key: "token"},
+		caBundle:    &secretRef{name: "health-ca", key: "ca.crt"},
+	}}
+	pod, port := server.pod(t)
+
+	health, _, err := hs.newProber(srv).probeOnce(context.Background(), pod, port)
+	require.NoError(t, err)
+	assert.Len(t, health.Checks, 2)
+	assert.Equal(t, "Bearer secret-token", server.lastHeader().Get("Authorization"))
+	assert.Equal(t, "key", server.lastHeader().Get("X-Api-Key"))
+
+	tokenSecret.Data["token"] = []byte("rotated-token")
+	_, err = hs.k8sClient.CoreV1().Secrets(k8score.NamespaceDefault).Update(context.Background(), tokenSecret, k8smeta.UpdateOptions{})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		_, _, err := hs.newProber(srv).probeOnce(context.Background(), pod, port)
+		return err == nil && server.lastHeader().Get("Authorization") == "Bearer rotated-token"
+	}, 5*time.Second, 50*time.Millisecond, "The rotated token should be sent once the secret has changed")
+}
+
+func TestHTTPSProbeVerifiesCertificates(t *testing.T) {
+	server := newTLSHealthServer(t)
+	hs := initializeMockService(t, &http.Client{Timeout: 5 * time.Second})
+	pod, port := server.pod(t)
+	srv := service{name: "service1", endpoint: endpointConfig{scheme: httpsScheme, path: "/internal/__health"}}
+
+	_, _, err := hs.newProber(srv).probeOnce(context.Background(), pod, port)
+	assert.Error(t, err, "A certificate signed by an unknown authority should be rejected")
+
+	srv.endpoint.insecureSkipVerify = true
+	_, _, err = hs.newProber(srv).probeOnce(context.Background(), pod, port)
+	assert.NoError(t, err)
+}
+
+func TestProbeWithMissingSecretIsMisconfigured(t *testing.T) {
+	hs := initializeMockService(t, &http.Client{Timeout: 5 * time.Second})
+	srv := service{name: "service1", endpoint: endpointConfig{scheme: httpScheme, bearerToken: &secretRef{name: "missing", key: "token"}}}
+
+	probe := hs.probePod(context.Background(), pod{name: "pod1", ip: validIP}, srv)
+	assert.Error(t, probe.err)
+	assert.Equal(t, probeMisconfigured, getProbeFailure(probe.err))
+	assert.Len(t, probe.attempts, 1, "A misconfigured probe should not be retried")
+}
+
+func TestTLSClientsAreSharedAndReset(t *testing.T) {
+	clients := newTLSClients(newLimiter(1))
+
+	client, err := clients.get(nil, false)
+	require.NoError(t, err)
+	sameClient, err := clients.get(nil, false)
+	require.NoError(t, err)
+	assert.Same(t, client, sameClient)
+
+	insecureClient, err := clients.get(nil, true)
+	require.NoError(t, err)
+	assert.NotSame(t, client, insecureClient)
+
+	_, err = clients.get([]byte("not a certificate"), false)
+	assert.Error(t, err)
+
+	clients.reset()
+	newClient, err := clients.get(nil, false)
+	require.NoError(t, err)
+	assert.NotSame(t, client, newClient)
+}
+
+func TestTLSClientsResetClosesIdleConnections(t *testing.T) {
+	closed := make(chan struct{}, 1)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			closed <- struct{}{}
+		}
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	clients := newTLSClients(nil)
+	client, err := clients.get(nil, true)
+	require.NoError(t, err)
+	req, err := http.NewRequest("GET", server.URL, nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	clients.reset()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The idle connections of the dropped clients should be closed")
+	}
+}
+
+func TestCredentialsSecretResyncKeepsTLSClients(t *testing.T) {
+	hs := &k8sHealthcheckService{tlsClients: newTLSClients(nil)}
+	client, err := hs.tlsClients.get(nil, false)
+	require.NoError(t, err)
+
+	secret := newCredentialsSecret("health-ca", map[string][]byte{"ca.crt": []byte("ca")})
+	secret.ResourceVersion = "1"
+	hs.onCredentialsSecretUpdated(secret, secret.DeepCopy())
+	sameClient, err := hs.tlsClients.get(nil, false)
+	require.NoError(t, err)
+	assert.Same(t, client, sameClient, "A resync of an unchanged secret should keep the clients")
+
+	updated := secret.DeepCopy()
+	updated.ResourceVersion = "2"
+	hs.onCredentialsSecretUpdated(secret, updated)
+	newClient, err := hs.tlsClients.get(nil, false)
+	require.NoError(t, err)
+	assert.NotSame(t, client, newClient, "An update of a secret should drop the clients")
+}
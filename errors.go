@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// notFoundError is returned when the service or category a request refers to does not exist.
+type notFoundError struct {
+	msg string
+}
+
+func (e notFoundError) Error() string {
+	return e.msg
+}
+
+// conflictError is returned when a configMap could not be updated because it kept being changed concurrently.
+type conflictError struct {
+	msg string
+}
+
+func (e conflictError) Error() string {
+	return e.msg
+}
+
+// getStatusCodeForError maps the errors of the ack and category mutations to HTTP status codes.
+func getStatusCodeForError(err error) int {
+	var notFound notFoundError
+	var conflict conflictError
+	switch {
+	case errors.As(err, &notFound):
+		return http.StatusNotFound
+	case errors.As(err, &conflict):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -2,11 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
 	log "github.com/Financial-Times/go-logger"
@@ -21,13 +23,58 @@ type httpHandler struct {
 // IndividualHealthcheckParams struct used to populate HTML template with individual checks
 type IndividualHealthcheckParams struct {
 	Name                   string
+	Namespace              string
 	Status                 string
 	LastUpdated            string
 	MoreInfoPath           string
 	AddOrRemoveAckPath     string
 	AddOrRemoveAckPathName string
 	AckMessage             string
+	AckDetails             string
 	Output                 string
+	FailingPods            podFailures
+	// Version is the version of a pod, and VersionSkew the versions the pods of a service with a version skew run
+	Version     string
+	VersionSkew []versionPods
+	// Timeline shows the latest samples of the history of a service, whose full history is served by HistoryPath
+	Timeline    []timelineSlot
+	HistoryPath string
+}
+
+// timelineSlot is a sample of the history of a service on the services page, colored by its status.
+type timelineSlot struct {
+	Color string
+	Title string
+}
+
+func getTimeline(samples []healthSample) []timelineSlot {
+	timeline := make([]timelineSlot, 0, len(samples))
+	for _, sample := range samples {
+		timeline = append(timeline, timelineSlot{
+			Color: getStatusColor(sample.Status),
+			Title: fmt.Sprintf("%s: %s", sample.Time.Format(timeLayout), sample.Status),
+		})
+	}
+	return timeline
+}
+
+// getStatusColor returns the color a status is shown with on the HTML pages.
+func getStatusColor(status string) string {
+	switch status {
+	case "ok":
+		return "green"
+	case "degraded", "rolling out":
+		return "olive"
+	case "warning":
+		return "orange"
+	case "critical":
+		return "red"
+	}
+	return "blue"
+}
+
+func getServiceHistoryPath(pathPrefix string, serviceKey string) string {
+	return fmt.Sprintf("%s/__history?service-name=%s", pathPrefix, url.QueryEscape(serviceKey))
 }
 
 // AggregateHealthcheckParams struct used to populate HTML template with aggregate checks
@@ -37,21 +84,101 @@ type AggregateHealthcheckParams struct {
 	RefreshFromCachePath    string
 	RefreshWithoutCachePath string
 	AckCount                int
+	SilenceCount            int
+	DegradedCount           int
+	VersionSkewCount        int
+	SilencesPath            string
+	CategoriesPath          string
+	Replica                 string
 	IndividualHealthChecks  []IndividualHealthcheckParams
 }
 
+// SilencesPage struct used to populate HTML template for the silences page
+type SilencesPage struct {
+	PageTitle        string
+	HealthPath       string
+	AddSilencePath   string
+	Silences         []SilenceParams
+	SilenceDurations []AckDuration
+}
+
+// CategoriesPage struct used to populate HTML template for the categories page
+type CategoriesPage struct {
+	PageTitle  string
+	HealthPath string
+	Categories []CategoryParams
+}
+
+// CategoryParams struct used to populate HTML template with a category
+type CategoryParams struct {
+	Name                 string
+	Status               string
+	Sticky               bool
+	LastDisabled         string
+	DisabledService      string
+	DisabledOutput       string
+	LastEnabled          string
+	EnableOrDisablePath  string
+	EnableOrDisableLabel string
+}
+
+// SilenceParams struct used to populate HTML template with a silence
+type SilenceParams struct {
+	Comment           string
+	Author            string
+	Status            string
+	StartsAt          string
+	EndsAt            string
+	Services          string
+	Categories        string
+	Selector          string
+	RemoveSilencePath string
+}
+
 // AddAckForm struct used to populate HTML template for add acknowledge form
 type AddAckForm struct {
-	ServiceName string
-	AddAckPath  string
+	ServiceName  string
+	PodName      string
+	AddAckPath   string
+	AckDurations []AckDuration
+}
+
+// AckDuration is an option of the ack duration selector of the add acknowledge form
+type AckDuration struct {
+	Label string
+	Value string
 }
 
 var defaultCategories = []string{"default"}
 
+var ackDurations = []AckDuration{
+	{Label: "1 hour", Value: "1h"},
+	{Label: "4 hours", Value: "4h"},
+	{Label: "1 day", Value: "24h"},
+	{Label: "3 days", Value: "72h"},
+	{Label: "1 week", Value: "168h"},
+	{Label: "30 days", Value: "720h"},
+	{Label: "Never expires", Value: ""},
+}
+
+// silenceDurations are the options of the silence duration selector. Unlike acks, silences always end.
+var silenceDurations = []AckDuration{
+	{Label: "30 minutes", Value: "30m"},
+	{Label: "1 hour", Value: "1h"},
+	{Label: "2 hours", Value: "2h"},
+	{Label: "4 hours", Value: "4h"},
+	{Label: "8 hours", Value: "8h"},
+	{Label: "1 day", Value: "24h"},
+	{Label: "3 days", Value: "72h"},
+}
+
 const (
 	timeLayout              = "2006-01-02 15:04:05 MST"
 	healthcheckTemplateName = "html-templates/healthcheck-template.html"
 	addAckMsgTemplatePath   = "html-templates/add-ack-message-form-template.html"
+	silencesTemplatePath    = "html-templates/silences-template.html"
+	categoriesTemplatePath  = "html-templates/categories-template.html"
+	silenceStartLayout      = "2006-01-02T15:04"
 	healthcheckPath         = "/__health"
 	jsonContentType         = "application/json"
 )
@@ -62,6 +189,7 @@ func handleResponseWriterErr(err error) {
 	}
 }
 
+// updateStickyCategory enables or disables a category on behalf of the actor given in the request, and responds with the updated category.
 func (h *httpHandler) updateStickyCategory(w http.ResponseWriter, r *http.Request, isEnabled bool) {
 	categoryName := r.URL.Query().Get("category-name")
 	if categoryName == "" {
@@ -70,16 +198,26 @@ func (h *httpHandler) updateStickyCategory(w http.ResponseWriter, r *http.Reques
 		handleResponseWriterErr(err)
 		return
 	}
-	log.Infof("Updating category [%s] with isEnabled flag value of [%t]", categoryName, isEnabled)
-	err := h.controller.updateStickyCategory(r.Context(), categoryName, isEnabled)
+	change := categoryChange{
+		At:     time.Now().UTC(),
+		By:     r.URL.Query().Get("actor"),
+		Reason: r.URL.Query().Get("reason"),
+	}
+	log.Infof("Updating category [%s] with isEnabled flag value of [%t], by [%s]", categoryName, isEnabled, change.By)
+	status, err := h.controller.updateStickyCategory(r.Context(), categoryName, isEnabled, change)
 
 	if err != nil {
 		log.WithError(err).Errorf("Failed to update category with name %s.", categoryName)
-		w.WriteHeader(http.StatusInternalServerError)
-		_, err := w.Write([]byte("Failed to enable category."))
+		w.WriteHeader(getStatusCodeForError(err))
+		_, err := w.Write([]byte(fmt.Sprintf("Failed to update category %s.", categoryName)))
 		handleResponseWriterErr(err)
 		return
 	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		log.WithError(err).Error("Cannot encode category status")
+	}
 }
 
 func (h *httpHandler) handleDisableCategory(w http.ResponseWriter, r *http.Request) {
@@ -102,11 +240,24 @@ func (h *httpHandler) handleRemoveAck(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if podName := getPodNameFromURL(r.URL); podName != "" {
+		podKey := newObjectKey(r.URL.Query().Get("namespace"), podName)
+		log.Infof("Removing ack for pod %s of service with name %s", podKey, serviceName)
+		if err := h.controller.removePodAck(r.Context(), podKey, r.URL.Query().Get("check-name")); err != nil {
+			w.WriteHeader(getStatusCodeForError(err))
+			log.WithError(err).Errorf("Cannot remove ack for pod %s.", podKey)
+			return
+		}
+
+		http.Redirect(w, r, getPodsHealthWithoutCachePath(h.pathPrefix, serviceName), http.StatusMovedPermanently)
+		return
+	}
+
 	log.Infof("Removing ack for service with name %s", serviceName)
 	err := h.controller.removeAck(r.Context(), serviceName)
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(getStatusCodeForError(err))
 		log.WithError(err).Errorf("Cannot remove ack for service with name %s.", serviceName)
 		return
 	}
@@ -124,12 +275,36 @@ func (h *httpHandler) handleAddAck(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ackDuration, err := parseAckDuration(r.PostFormValue("ack-duration"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, err := w.Write([]byte("Provided ack duration is not valid."))
+		handleResponseWriterErr(err)
+		return
+	}
+
+	serviceAck := newAck(ackMessage, r.PostFormValue("ack-author"), r.PostFormValue("ack-ticket"), ackDuration, time.Now())
+
+	if podName := getPodNameFromURL(r.URL); podName != "" {
+		podKey := newObjectKey(r.URL.Query().Get("namespace"), podName)
+		log.Infof("Acking pod %s of service with name %s", podKey, serviceName)
+		if err = h.controller.addPodAck(r.Context(), podKey, r.FormValue("check-name"), serviceAck); err != nil {
+			w.WriteHeader(getStatusCodeForError(err))
+			log.WithError(err).Errorf("Cannot add acknowledge for pod %s.", podKey)
+			return
+		}
+
+		http.Redirect(w, r, getPodsHealthWithoutCachePath(h.pathPrefix, serviceName), http.StatusMovedPermanently)
+		return
+	}
+
 	log.Infof("Acking service with name %s", serviceName)
-	err := h.controller.addAck(r.Context(), serviceName, ackMessage)
+	err = h.controller.addAck(r.Context(), serviceName, serviceAck)
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(getStatusCodeForError(err))
 		log.WithError(err).Errorf("Cannot add acknowledge for service with name %s.", serviceName)
+		return
 	}
 
 	http.Redirect(w, r, fmt.Sprintf("%s?cache=false", h.pathPrefix), http.StatusMovedPermanently)
@@ -152,8 +327,13 @@ func (h *httpHandler) handleAddAckForm(w http.ResponseWriter, r *http.Request) {
 	}
 
 	addAckForm := AddAckForm{
-		ServiceName: serviceName,
-		AddAckPath:  fmt.Sprintf("%s/add-ack?service-name=%s", h.pathPrefix, serviceName),
+		ServiceName:  serviceName,
+		AddAckPath:   fmt.Sprintf("%s/add-ack?service-name=%s", h.pathPrefix, url.QueryEscape(serviceName)),
+		AckDurations: ackDurations,
+	}
+	if podName := getPodNameFromURL(r.URL); podName != "" {
+		addAckForm.PodName = podName
+		addAckForm.AddAckPath = fmt.Sprintf("%s&%s", addAckForm.AddAckPath, getPodAckParams(newObjectKey(r.URL.Query().Get("namespace"), podName)))
 	}
 
 	if err := htmlTemplate.Execute(w, addAckForm); err != nil {
@@ -165,6 +345,113 @@ func (h *httpHandler) handleAddAckForm(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (h *httpHandler) handleCategories(w http.ResponseWriter, r *http.Request) {
+	statuses, err := h.controller.getCategoryStatuses(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.WithError(err).Error("Cannot get categories")
+		_, err := w.Write([]byte("Cannot get categories"))
+		handleResponseWriterErr(err)
+		return
+	}
+
+	if r.Header.Get("Accept") == jsonContentType {
+		w.Header().Set("Content-Type", jsonContentType)
+		if err := json.NewEncoder(w).Encode(statuses); err != nil {
+			log.WithError(err).Error("Cannot encode categories")
+		}
+		return
+	}
+
+	w.Header().Add("Content-Type", "text/html")
+	htmlTemplate := parseHTMLTemplate(w, categoriesTemplatePath)
+	if htmlTemplate == nil {
+		return
+	}
+
+	if err := htmlTemplate.Execute(w, populateCategoriesPage(statuses, h.controller.getEnvironment(), h.pathPrefix)); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.WithError(err).Error("Cannot apply params to html template")
+		_, err := w.Write([]byte("Couldn't render template file for html response"))
+		handleResponseWriterErr(err)
+		return
+	}
+}
+
+func (h *httpHandler) handleSilences(w http.ResponseWriter, r *http.Request) {
+	silences, err := h.controller.getSilences(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.WithError(err).Error("Cannot get silences")
+		_, err := w.Write([]byte("Cannot get silences"))
+		handleResponseWriterErr(err)
+		return
+	}
+
+	if r.Header.Get("Accept") == jsonContentType {
+		if silences == nil {
+			silences = []silence{}
+		}
+		w.Header().Set("Content-Type", jsonContentType)
+		if err := json.NewEncoder(w).Encode(silences); err != nil {
+			log.WithError(err).Error("Cannot encode silences")
+		}
+		return
+	}
+
+	w.Header().Add("Content-Type", "text/html")
+	htmlTemplate := parseHTMLTemplate(w, silencesTemplatePath)
+	if htmlTemplate == nil {
+		return
+	}
+
+	if err := htmlTemplate.Execute(w, populateSilencesPage(silences, h.controller.getEnvironment(), h.pathPrefix, time.Now())); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.WithError(err).Error("Cannot apply params to html template")
+		_, err := w.Write([]byte("Couldn't render template file for html response"))
+		handleResponseWriterErr(err)
+		return
+	}
+}
+
+func (h *httpHandler) handleAddSilence(w http.ResponseWriter, r *http.Request) {
+	s, err := parseSilenceForm(r, time.Now())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, err := w.Write([]byte(fmt.Sprintf("Provided silence is not valid: %v", err)))
+		handleResponseWriterErr(err)
+		return
+	}
+
+	log.Infof("Adding silence %s from %s until %s", s.ID, s.StartsAt.Format(timeLayout), s.EndsAt.Format(timeLayout))
+	if err := h.controller.addSilence(r.Context(), s); err != nil {
+		w.WriteHeader(getStatusCodeForError(err))
+		log.WithError(err).Errorf("Cannot add silence %s.", s.ID)
+		return
+	}
+
+	http.Redirect(w, r, getSilencesPath(h.pathPrefix), http.StatusMovedPermanently)
+}
+
+func (h *httpHandler) handleRemoveSilence(w http.ResponseWriter, r *http.Request) {
+	silenceID := r.URL.Query().Get("silence-id")
+	if silenceID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		_, err := w.Write([]byte("Provided silence id is not valid."))
+		handleResponseWriterErr(err)
+		return
+	}
+
+	log.Infof("Removing silence %s", silenceID)
+	if err := h.controller.removeSilence(r.Context(), silenceID); err != nil {
+		w.WriteHeader(getStatusCodeForError(err))
+		log.WithError(err).Errorf("Cannot remove silence %s.", silenceID)
+		return
+	}
+
+	http.Redirect(w, r, getSilencesPath(h.pathPrefix), http.StatusMovedPermanently)
+}
+
 func (h *httpHandler) handleServicesHealthCheck(w http.ResponseWriter, r *http.Request) {
 	categories := parseCategories(r.URL)
 	useCache := useCache(r.URL)
@@ -190,14 +477,15 @@ func (h *httpHandler) handleServicesHealthCheck(w http.ResponseWriter, r *http.R
 
 	if r.Header.Get("Accept") == jsonContentType {
 		for i, serviceCheck := range healthResult.Checks {
-			serviceHealthcheckURL := getServiceHealthcheckURL(h.clusterURL, h.pathPrefix, serviceCheck.Name)
+			serviceHealthcheckURL := getServiceHealthcheckURL(h.clusterURL, h.pathPrefix, getCheckKey(serviceCheck))
 			healthResult.Checks[i].TechnicalSummary = fmt.Sprintf("%s Service healthcheck: %s", serviceCheck.TechnicalSummary, serviceHealthcheckURL)
 		}
 
-		buildHealthcheckJSONResponse(w, healthResult)
+		leader := h.controller.getLeaderStatus()
+		buildHealthcheckJSONResponse(w, healthResult.HealthResult, healthResult.breakdowns, nil, &leader, getCategoryStatuses(validCategories))
 	} else {
 		env := h.controller.getEnvironment()
-		buildServicesCheckHTMLResponse(w, healthResult, env, getCategoriesString(validCategories), h.pathPrefix)
+		buildServicesCheckHTMLResponse(w, healthResult, env, getCategoriesString(validCategories), h.pathPrefix, h.controller.getLeaderStatus())
 	}
 }
 
@@ -227,19 +515,87 @@ func (h *httpHandler) handlePodsHealthCheck(w http.ResponseWriter, r *http.Reque
 
 	if r.Header.Get("Accept") == jsonContentType {
 		for i, podCheck := range healthResult.Checks {
-			serviceHealthcheckURL := getIndividualPodHealthcheckURL(h.clusterURL, h.pathPrefix, podCheck.Name)
+			serviceHealthcheckURL := getIndividualPodHealthcheckURL(h.clusterURL, h.pathPrefix, getPodKeyFromCheck(podCheck))
 			healthResult.Checks[i].TechnicalSummary = fmt.Sprintf("%s Pod healthcheck: %s", podCheck.TechnicalSummary, serviceHealthcheckURL)
 		}
 
-		buildHealthcheckJSONResponse(w, healthResult)
+		buildHealthcheckJSONResponse(w, healthResult.HealthResult, nil, healthResult.versions, nil, nil)
 	} else {
 		env := h.controller.getEnvironment()
 		buildPodsCheckHTMLResponse(w, healthResult, env, serviceName, h.pathPrefix)
 	}
 }
 
+// handleServiceHistory responds with the history of a service in JSON, within the time range given by the from and to parameters,
+// or by the since parameter as the time range up to now, and only with the transitions of the given pod if the pod-name parameter is set.
+func (h *httpHandler) handleServiceHistory(w http.ResponseWriter, r *http.Request) {
+	serviceName := getServiceNameFromURL(r.URL)
+	if serviceName == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		_, err := w.Write([]byte("Couldn't get service name from url."))
+		handleResponseWriterErr(err)
+		return
+	}
+
+	filter, err := parseHistoryFilter(r.URL, time.Now())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, err = w.Write([]byte(err.Error()))
+		handleResponseWriterErr(err)
+		return
+	}
+
+	report, err := h.controller.getServiceHistory(serviceName, filter)
+	if err != nil {
+		log.WithError(err).Errorf("Cannot get the history of service with name %s", serviceName)
+		w.WriteHeader(getStatusCodeForError(err))
+		_, err = w.Write([]byte(fmt.Sprintf("Cannot get the history of service with name %s", serviceName)))
+		handleResponseWriterErr(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.WithError(err).Errorf("Cannot encode the history of service with name %s", serviceName)
+	}
+}
+
+// parseHistoryFilter parses the time range and the pod of a history request. The times are in RFC 3339, e.g. 2024-01-02T10:00:00Z,
+// and the since parameter is a duration, e.g. 6h.
+func parseHistoryFilter(requestURL *url.URL, now time.Time) (historyFilter, error) {
+	query := requestURL.Query()
+	filter := historyFilter{podName: query.Get("pod-name")}
+
+	var err error
+	if from := query.Get("from"); from != "" {
+		if filter.from, err = time.Parse(time.RFC3339, from); err != nil {
+			return historyFilter{}, fmt.Errorf("invalid from time %s, expected a time like 2024-01-02T10:00:00Z", from)
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		if filter.to, err = time.Parse(time.RFC3339, to); err != nil {
+			return historyFilter{}, fmt.Errorf("invalid to time %s, expected a time like 2024-01-02T10:00:00Z", to)
+		}
+	}
+	if since := query.Get("since"); since != "" {
+		if !filter.from.IsZero() {
+			return historyFilter{}, errors.New("the since and from parameters cannot be used together")
+		}
+		duration, err := time.ParseDuration(since)
+		if err != nil || duration <= 0 {
+			return historyFilter{}, fmt.Errorf("invalid since duration %s, expected a duration like 6h", since)
+		}
+		filter.from = now.Add(-duration)
+	}
+	if !filter.from.IsZero() && !filter.to.IsZero() && filter.to.Before(filter.from) {
+		return historyFilter{}, errors.New("the to time cannot be before the from time")
+	}
+	return filter, nil
+}
+
 func (h *httpHandler) handleIndividualPodHealthCheck(w http.ResponseWriter, r *http.Request) {
 	podName := getPodNameFromURL(r.URL)
+	namespace := r.URL.Query().Get("namespace")
 
 	if podName == "" {
 		w.WriteHeader(http.StatusBadRequest)
@@ -249,7 +605,7 @@ func (h *httpHandler) handleIndividualPodHealthCheck(w http.ResponseWriter, r *h
 	}
 
 	log.Infof("Retrieving individual pod health check for pod with name %s", podName)
-	podHealth, contentTypeHeader, err := h.controller.getIndividualPodHealth(r.Context(), podName)
+	podHealth, contentTypeHeader, err := h.controller.getIndividualPodHealth(r.Context(), newObjectKey(namespace, podName))
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -311,16 +667,80 @@ func getPodNameFromURL(url *url.URL) string {
 	return url.Query().Get("pod-name")
 }
 
+// parseAckDuration parses the duration of a new ack. An empty duration means that the ack never expires.
+func parseAckDuration(duration string) (time.Duration, error) {
+	if duration == "" {
+		return 0, nil
+	}
+
+	ackDuration, err := time.ParseDuration(duration)
+	if err != nil {
+		return 0, err
+	}
+	if ackDuration < 0 {
+		return 0, fmt.Errorf("ack duration %s is negative", duration)
+	}
+
+	return ackDuration, nil
+}
+
+// parseSilenceForm creates a silence from the add silence form. The silence starts now unless a start time is given, in UTC.
+func parseSilenceForm(r *http.Request, now time.Time) (silence, error) {
+	startsAt := now
+	if value := r.PostFormValue("starts-at"); value != "" {
+		var err error
+		startsAt, err = time.Parse(silenceStartLayout, value)
+		if err != nil {
+			return silence{}, fmt.Errorf("invalid start time %s", value)
+		}
+	}
+
+	duration, err := time.ParseDuration(r.PostFormValue("duration"))
+	if err != nil {
+		return silence{}, fmt.Errorf("invalid duration %s", r.PostFormValue("duration"))
+	}
+
+	return newSilence(
+		r.PostFormValue("comment"),
+		r.PostFormValue("author"),
+		startsAt,
+		duration,
+		splitFormList(r.PostFormValue("services")),
+		splitFormList(r.PostFormValue("categories")),
+		strings.TrimSpace(r.PostFormValue("selector")),
+	)
+}
+
+// splitFormList splits a comma separated form value, ignoring blank entries.
+func splitFormList(value string) []string {
+	var entries []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
 func useCache(theURL *url.URL) bool {
 	//use cache by default
 	return theURL.Query().Get("cache") != "false"
 }
 
-func buildHealthcheckJSONResponse(w http.ResponseWriter, healthResult fthealth.HealthResult) {
+// buildHealthcheckJSONResponse writes the health result as JSON, along with the breakdown of every service check or the version of every pod check,
+// the leadership of the replica and the status of the checked categories if they are given.
+func buildHealthcheckJSONResponse(w http.ResponseWriter, healthResult fthealth.HealthResult, breakdowns map[string]checkBreakdown, versions map[string]podVersion,
+	leader *leaderStatus, categories []categoryStatus) {
 
 	type CheckResultWithHeimdalAck struct {
 		fthealth.CheckResult
-		HeimdalAck string `json:"_acknowledged,omitempty"`
+		Namespace   string         `json:"namespace,omitempty"`
+		HeimdalAck  string         `json:"_acknowledged,omitempty"`
+		Silence     string         `json:"_silenced,omitempty"`
+		Quorum      *quorumStatus  `json:"_quorum,omitempty"`
+		FailingPods podFailures    `json:"_failingPods,omitempty"`
+		Versions    *versionStatus `json:"_versions,omitempty"`
+		Version     *podVersion    `json:"_version,omitempty"`
 	}
 
 	type HealthResult struct {
@@ -331,13 +751,36 @@ func buildHealthcheckJSONResponse(w http.ResponseWriter, healthResult fthealth.H
 		Checks        []CheckResultWithHeimdalAck `json:"checks"`
 		Ok            bool                        `json:"ok"`
 		Severity      uint8                       `json:"severity,omitempty"`
+		Replica       *leaderStatus               `json:"_replica,omitempty"`
+		Categories    []categoryStatus            `json:"_categories,omitempty"`
 	}
 
 	var newChecks []CheckResultWithHeimdalAck
 	for _, check := range healthResult.Checks {
+		namespace, _ := splitObjectKey(check.ID)
 		newCheck := CheckResultWithHeimdalAck{
 			CheckResult: check,
-			HeimdalAck:  check.Ack,
+			Namespace:   namespace,
+		}
+		if breakdown, ok := breakdowns[check.ID]; ok {
+			newCheck.Quorum = &breakdown.quorum
+			newCheck.FailingPods = breakdown.failingPods
+			if len(breakdown.versions.Versions) != 0 {
+				newCheck.Versions = &breakdown.versions
+			}
+		}
+		if version, ok := versions[check.ID]; ok {
+			newCheck.Version = &version
+		}
+		if check.Ack != "" {
+			checkAck := parseAck(check.Ack)
+			newCheck.Ack = checkAck.Message
+			// a silence is not an ack, so a silenced check is only marked as silenced
+			if checkAck.Silence != "" {
+				newCheck.Silence = checkAck.Silence
+			} else {
+				newCheck.HeimdalAck = checkAck.summary()
+			}
 		}
 		newChecks = append(newChecks, newCheck)
 	}
@@ -350,6 +793,8 @@ func buildHealthcheckJSONResponse(w http.ResponseWriter, healthResult fthealth.H
 		Ok:            healthResult.Ok,
 		Severity:      healthResult.Severity,
 		Checks:        newChecks,
+		Replica:       leader,
+		Categories:    categories,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -360,7 +805,7 @@ func buildHealthcheckJSONResponse(w http.ResponseWriter, healthResult fthealth.H
 	}
 }
 
-func buildServicesCheckHTMLResponse(w http.ResponseWriter, healthResult fthealth.HealthResult, environment string, categories string, pathPrefix string) {
+func buildServicesCheckHTMLResponse(w http.ResponseWriter, healthResult servicesHealth, environment string, categories string, pathPrefix string, leader leaderStatus) {
 	w.Header().Add("Content-Type", "text/html")
 	htmlTemplate := parseHTMLTemplate(w, healthcheckTemplateName)
 	if htmlTemplate == nil {
@@ -368,6 +813,7 @@ func buildServicesCheckHTMLResponse(w http.ResponseWriter, healthResult fthealth
 	}
 
 	aggregateHealthcheckParams := populateAggregateServiceChecks(healthResult, environment, categories, pathPrefix)
+	aggregateHealthcheckParams.Replica = describeReplica(leader)
 
 	if err := htmlTemplate.Execute(w, aggregateHealthcheckParams); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -378,7 +824,7 @@ func buildServicesCheckHTMLResponse(w http.ResponseWriter, healthResult fthealth
 	}
 }
 
-func buildPodsCheckHTMLResponse(w http.ResponseWriter, healthResult fthealth.HealthResult, environment string, serviceName string, pathPrefix string) {
+func buildPodsCheckHTMLResponse(w http.ResponseWriter, healthResult podsHealth, environment string, serviceName string, pathPrefix string) {
 	w.Header().Add("Content-Type", "text/html")
 	htmlTemplate := parseHTMLTemplate(w, healthcheckTemplateName)
 	if htmlTemplate == nil {
@@ -409,14 +855,19 @@ func parseHTMLTemplate(w http.ResponseWriter, templateName string) *template.Tem
 	return htmlTemplate
 }
 
-func populateAggregateServiceChecks(healthResult fthealth.HealthResult, environment string, categories string, pathPrefix string) *AggregateHealthcheckParams {
-	indiviualServiceChecks, ackCount := populateIndividualServiceChecks(healthResult.Checks, pathPrefix)
+func populateAggregateServiceChecks(healthResult servicesHealth, environment string, categories string, pathPrefix string) *AggregateHealthcheckParams {
+	indiviualServiceChecks, ackCount := populateIndividualServiceChecks(healthResult.Checks, healthResult.breakdowns, healthResult.timelines, pathPrefix)
 	aggregateChecks := &AggregateHealthcheckParams{
 		PageTitle:               buildPageTitle(environment, categories),
-		GeneralStatus:           getGeneralStatus(healthResult),
+		GeneralStatus:           getGeneralStatus(healthResult.HealthResult),
 		RefreshFromCachePath:    buildRefreshFromCachePath(categories, pathPrefix),
 		RefreshWithoutCachePath: buildRefreshWithoutCachePath(categories, pathPrefix),
 		AckCount:                ackCount,
+		SilenceCount:            countSilencedChecks(healthResult.Checks),
+		DegradedCount:           countDegradedChecks(healthResult.Checks, healthResult.breakdowns),
+		VersionSkewCount:        countVersionSkews(healthResult.breakdowns),
+		SilencesPath:            getSilencesPath(pathPrefix),
+		CategoriesPath:          getCategoriesPath(pathPrefix),
 		IndividualHealthChecks:  indiviualServiceChecks,
 	}
 
@@ -440,24 +891,39 @@ func buildRefreshWithoutCachePath(categories string, pathPrefix string) string {
 	return refreshWithoutCachePath
 }
 
-func populateIndividualServiceChecks(checks []fthealth.CheckResult, pathPrefix string) ([]IndividualHealthcheckParams, int) {
+func populateIndividualServiceChecks(checks []fthealth.CheckResult, breakdowns map[string]checkBreakdown, timelines map[string][]healthSample,
+	pathPrefix string) ([]IndividualHealthcheckParams, int) {
 	indiviualServiceChecks := make([]IndividualHealthcheckParams, len(checks))
 	ackCount := 0
 	for i, individualCheck := range checks {
-		if individualCheck.Ack != "" {
+		if isAcked(individualCheck) {
 			ackCount++
 		}
 
-		addOrRemoveAckPath, addOrRemoveAckPathName := buildAddOrRemoveAckPath(individualCheck.Name, pathPrefix, individualCheck.Ack)
+		serviceKey := getCheckKey(individualCheck)
+		namespace, _ := splitObjectKey(serviceKey)
+		breakdown := breakdowns[serviceKey]
+		addOrRemoveAckPath, addOrRemoveAckPathName := buildAddOrRemoveAckPath(serviceKey, pathPrefix, isAcked(individualCheck))
 		hc := IndividualHealthcheckParams{
 			Name:                   individualCheck.Name,
-			Status:                 getServiceStatusFromCheck(individualCheck),
+			Namespace:              namespace,
+			Status:                 getServiceStatusFromCheck(individualCheck, breakdown.quorum),
 			LastUpdated:            individualCheck.LastUpdated.Format(timeLayout),
-			MoreInfoPath:           fmt.Sprintf("%s/__pods-health?service-name=%s", pathPrefix, individualCheck.Name),
+			MoreInfoPath:           getServiceHealthcheckURL("", pathPrefix, serviceKey),
 			AddOrRemoveAckPath:     addOrRemoveAckPath,
 			AddOrRemoveAckPathName: addOrRemoveAckPathName,
-			AckMessage:             individualCheck.Ack,
 			Output:                 individualCheck.CheckOutput,
+			FailingPods:            breakdown.failingPods,
+			Timeline:               getTimeline(timelines[serviceKey]),
+			HistoryPath:            getServiceHistoryPath(pathPrefix, serviceKey),
+		}
+		if breakdown.versions.Skew {
+			hc.VersionSkew = breakdown.versions.Versions
+		}
+		if individualCheck.Ack != "" {
+			checkAck := parseAck(individualCheck.Ack)
+			hc.AckMessage = checkAck.Message
+			hc.AckDetails = checkAck.details()
 		}
 
 		indiviualServiceChecks[i] = hc
@@ -466,29 +932,67 @@ func populateIndividualServiceChecks(checks []fthealth.CheckResult, pathPrefix s
 	return indiviualServiceChecks, ackCount
 }
 
-func buildAddOrRemoveAckPath(serviceName string, pathPrefix string, ackMessage string) (string, string) {
-	if ackMessage == "" {
-		return fmt.Sprintf("%s/add-ack-form?service-name=%s", pathPrefix, serviceName), "Ack service"
+func buildAddOrRemoveAckPath(serviceName string, pathPrefix string, isAcked bool) (string, string) {
+	if !isAcked {
+		return fmt.Sprintf("%s/add-ack-form?service-name=%s", pathPrefix, url.QueryEscape(serviceName)), "Ack service"
 	}
 
-	return fmt.Sprintf("%s/rem-ack?service-name=%s", pathPrefix, serviceName), "Remove ack"
+	return fmt.Sprintf("%s/rem-ack?service-name=%s", pathPrefix, url.QueryEscape(serviceName)), "Remove ack"
+}
+
+// buildAddOrRemovePodAckPath links a pod to the form acking it or its checks, or to the removal of its ack.
+// The ack of a pod result may also be the ack of its service, which is only removed from the services page.
+func buildAddOrRemovePodAckPath(serviceName string, podKey string, pathPrefix string, isAcked bool) (string, string) {
+	podAckParams := getPodAckParams(podKey)
+	if !isAcked {
+		return fmt.Sprintf("%s/add-ack-form?service-name=%s&%s", pathPrefix, url.QueryEscape(serviceName), podAckParams), "Ack pod"
+	}
+
+	return fmt.Sprintf("%s/rem-ack?service-name=%s&%s", pathPrefix, url.QueryEscape(serviceName), podAckParams), "Remove ack"
+}
+
+// getPodAckParams returns the query parameters identifying a pod in the ack endpoints.
+func getPodAckParams(podKey string) string {
+	namespace, podName := splitObjectKey(podKey)
+	params := url.Values{}
+	params.Set("pod-name", podName)
+	if namespace != "" {
+		params.Set("namespace", namespace)
+	}
+	return params.Encode()
 }
 
-func populateIndividualPodChecks(checks []fthealth.CheckResult, pathPrefix string) ([]IndividualHealthcheckParams, int) {
+func getPodsHealthWithoutCachePath(pathPrefix string, serviceName string) string {
+	return fmt.Sprintf("%s/__pods-health?cache=false&service-name=%s", pathPrefix, url.QueryEscape(serviceName))
+}
+
+func populateIndividualPodChecks(checks []fthealth.CheckResult, versions map[string]podVersion, serviceName string, pathPrefix string) ([]IndividualHealthcheckParams, int) {
 	indiviualServiceChecks := make([]IndividualHealthcheckParams, len(checks))
 	ackCount := 0
 	for i, check := range checks {
-		if check.Ack != "" {
+		if isAcked(check) {
 			ackCount++
 		}
-		podName := extractPodName(check.Name)
+		podKey := getPodKeyFromCheck(check)
+		namespace, _ := splitObjectKey(podKey)
+		addOrRemoveAckPath, addOrRemoveAckPathName := buildAddOrRemovePodAckPath(serviceName, podKey, pathPrefix, isAcked(check))
 		hc := IndividualHealthcheckParams{
-			Name:         check.Name,
-			Status:       getServiceStatusFromCheck(check),
-			LastUpdated:  check.LastUpdated.Format(timeLayout),
-			MoreInfoPath: getIndividualPodHealthcheckURL("", pathPrefix, podName),
-			AckMessage:   check.Ack,
-			Output:       check.CheckOutput,
+			Name:                   check.Name,
+			Namespace:              namespace,
+			Status:                 getServiceStatusFromCheck(check, quorumStatus{}),
+			LastUpdated:            check.LastUpdated.Format(timeLayout),
+			MoreInfoPath:           getIndividualPodHealthcheckURL("", pathPrefix, podKey),
+			AddOrRemoveAckPath:     addOrRemoveAckPath,
+			AddOrRemoveAckPathName: addOrRemoveAckPathName,
+			Output:                 check.CheckOutput,
+		}
+		if version, ok := versions[check.ID]; ok {
+			hc.Version = version.String()
+		}
+		if check.Ack != "" {
+			checkAck := parseAck(check.Ack)
+			hc.AckMessage = checkAck.Message
+			hc.AckDetails = checkAck.details()
 		}
 
 		indiviualServiceChecks[i] = hc
@@ -497,8 +1001,29 @@ func populateIndividualPodChecks(checks []fthealth.CheckResult, pathPrefix strin
 	return indiviualServiceChecks, ackCount
 }
 
-func getIndividualPodHealthcheckURL(clusterURL, pathPrefix, podName string) string {
-	return fmt.Sprintf("%s%s/__pod-individual-health?pod-name=%s", clusterURL, pathPrefix, podName)
+func getIndividualPodHealthcheckURL(clusterURL, pathPrefix, podKey string) string {
+	namespace, podName := splitObjectKey(podKey)
+	if namespace == "" {
+		return fmt.Sprintf("%s%s/__pod-individual-health?pod-name=%s", clusterURL, pathPrefix, podName)
+	}
+	return fmt.Sprintf("%s%s/__pod-individual-health?pod-name=%s&namespace=%s", clusterURL, pathPrefix, podName, namespace)
+}
+
+// getCheckKey returns the namespace-qualified key of the service a check result belongs to.
+// Results without an ID fall back to the check name.
+func getCheckKey(check fthealth.CheckResult) string {
+	if check.ID != "" {
+		return check.ID
+	}
+	return check.Name
+}
+
+// getPodKeyFromCheck returns the namespace-qualified key of the pod a check result belongs to.
+func getPodKeyFromCheck(check fthealth.CheckResult) string {
+	if check.ID != "" {
+		return check.ID
+	}
+	return extractPodName(check.Name)
 }
 
 func extractPodName(checkName string) string {
@@ -511,30 +1036,122 @@ func extractPodName(checkName string) string {
 	return ""
 }
 
-func populateAggregatePodChecks(healthResult fthealth.HealthResult, environment string, serviceName string, pathPrefix string) *AggregateHealthcheckParams {
-	individualChecks, ackCount := populateIndividualPodChecks(healthResult.Checks, pathPrefix)
+func populateAggregatePodChecks(healthResult podsHealth, environment string, serviceName string, pathPrefix string) *AggregateHealthcheckParams {
+	individualChecks, ackCount := populateIndividualPodChecks(healthResult.Checks, healthResult.versions, serviceName, pathPrefix)
 	aggregateChecks := &AggregateHealthcheckParams{
 		PageTitle:               fmt.Sprintf("UPP %s cluster's pods of service %s", environment, serviceName),
-		GeneralStatus:           getGeneralStatus(healthResult),
+		GeneralStatus:           getGeneralStatus(healthResult.HealthResult),
 		RefreshFromCachePath:    getServiceHealthcheckURL("", pathPrefix, serviceName),
-		RefreshWithoutCachePath: fmt.Sprintf("%s/__pods-health?cache=false&service-name=%s", pathPrefix, serviceName),
+		RefreshWithoutCachePath: getPodsHealthWithoutCachePath(pathPrefix, serviceName),
 		IndividualHealthChecks:  individualChecks,
 		AckCount:                ackCount,
+		SilenceCount:            countSilencedChecks(healthResult.Checks),
+		SilencesPath:            getSilencesPath(pathPrefix),
 	}
 
 	return aggregateChecks
 }
 
+// describeReplica describes the replica serving the page, e.g. "Served by upp-aggregate-healthcheck-5d8f9-x2x4z (leader)".
+// It is empty when there is no leader election, as there is a single replica then.
+func describeReplica(leader leaderStatus) string {
+	if !leader.ElectionActive {
+		return ""
+	}
+	if leader.IsLeader {
+		return fmt.Sprintf("Served by %s (leader)", leader.Identity)
+	}
+	return fmt.Sprintf("Served by %s (follower)", leader.Identity)
+}
+
+func getCategoriesPath(pathPrefix string) string {
+	return pathPrefix + "/categories"
+}
+
+func populateCategoriesPage(statuses []categoryStatus, environment string, pathPrefix string) *CategoriesPage {
+	page := &CategoriesPage{
+		PageTitle:  fmt.Sprintf("UPP %s cluster's categories", environment),
+		HealthPath: pathPrefix,
+	}
+	for _, status := range statuses {
+		params := CategoryParams{
+			Name:                 status.Name,
+			Status:               "enabled",
+			Sticky:               status.Sticky,
+			EnableOrDisablePath:  fmt.Sprintf("%s/disable-category?category-name=%s", pathPrefix, url.QueryEscape(status.Name)),
+			EnableOrDisableLabel: "Disable",
+		}
+		if !status.Enabled {
+			params.Status = "disabled"
+			params.EnableOrDisablePath = fmt.Sprintf("%s/enable-category?category-name=%s", pathPrefix, url.QueryEscape(status.Name))
+			params.EnableOrDisableLabel = "Enable"
+		}
+		if status.LastDisabled != nil {
+			params.LastDisabled = status.LastDisabled.describe()
+			params.DisabledService = status.LastDisabled.Service
+			params.DisabledOutput = status.LastDisabled.Output
+		}
+		if status.LastEnabled != nil {
+			params.LastEnabled = status.LastEnabled.describe()
+		}
+		page.Categories = append(page.Categories, params)
+	}
+	return page
+}
+
+func getSilencesPath(pathPrefix string) string {
+	return pathPrefix + "/silences"
+}
+
+func populateSilencesPage(silences []silence, environment string, pathPrefix string, now time.Time) *SilencesPage {
+	page := &SilencesPage{
+		PageTitle:        fmt.Sprintf("UPP %s cluster's silences", environment),
+		HealthPath:       pathPrefix,
+		AddSilencePath:   pathPrefix + "/add-silence",
+		SilenceDurations: silenceDurations,
+	}
+	for _, s := range silences {
+		page.Silences = append(page.Silences, SilenceParams{
+			Comment:           s.Comment,
+			Author:            s.Author,
+			Status:            getSilenceStatus(s, now),
+			StartsAt:          s.StartsAt.Format(timeLayout),
+			EndsAt:            s.EndsAt.Format(timeLayout),
+			Services:          strings.Join(s.Services, ", "),
+			Categories:        strings.Join(s.Categories, ", "),
+			Selector:          s.Selector,
+			RemoveSilencePath: fmt.Sprintf("%s/rem-silence?silence-id=%s", pathPrefix, url.QueryEscape(s.ID)),
+		})
+	}
+	return page
+}
+
+func getSilenceStatus(s silence, now time.Time) string {
+	switch {
+	case s.isActive(now):
+		return "active"
+	case s.hasEnded(now):
+		return "ended"
+	default:
+		return "scheduled"
+	}
+}
+
 func getServiceHealthcheckURL(hostURL, pathPrefix, serviceName string) string {
-	return fmt.Sprintf("%s%s/__pods-health?service-name=%s", hostURL, pathPrefix, serviceName)
+	return fmt.Sprintf("%s%s/__pods-health?service-name=%s", hostURL, pathPrefix, url.QueryEscape(serviceName))
 }
 
 func buildPageTitle(environment string, categories string) string {
 	return fmt.Sprintf("UPP %s cluster's services from categories %s", environment, categories)
 }
 
-func getServiceStatusFromCheck(check fthealth.CheckResult) string {
-	status := getStatusFromCheck(check)
+// getServiceStatusFromCheck returns the status shown for a check: "ok", "degraded", "rolling out", "warning" or "critical",
+// followed by whether it has been silenced or acked.
+func getServiceStatusFromCheck(check fthealth.CheckResult, quorum quorumStatus) string {
+	status := getHealthStatus(check, quorum)
+	if isSilenced(check) {
+		return status + " silenced"
+	}
 	if check.Ack != "" {
 		return status + " acked"
 	}
@@ -542,6 +1159,59 @@ func getServiceStatusFromCheck(check fthealth.CheckResult) string {
 	return status
 }
 
+// countDegradedChecks counts the checks of the services that are ok, but have fewer healthy pods than they want.
+func countDegradedChecks(checks []fthealth.CheckResult, breakdowns map[string]checkBreakdown) int {
+	degradedCount := 0
+	for _, check := range checks {
+		if check.Ok && breakdowns[check.ID].quorum.Degraded {
+			degradedCount++
+		}
+	}
+	return degradedCount
+}
+
+// countVersionSkews counts the services whose pods run several versions while they are not rolling out.
+func countVersionSkews(breakdowns map[string]checkBreakdown) int {
+	skewCount := 0
+	for _, breakdown := range breakdowns {
+		if breakdown.versions.Skew {
+			skewCount++
+		}
+	}
+	return skewCount
+}
+
+// isAcked tells whether the check result has been acked, as opposed to silenced.
+func isAcked(check fthealth.CheckResult) bool {
+	return check.Ack != "" && !isSilenced(check)
+}
+
+func isSilenced(check fthealth.CheckResult) bool {
+	return check.Ack != "" && parseAck(check.Ack).isSilence()
+}
+
+func countSilencedChecks(checks []fthealth.CheckResult) int {
+	silenceCount := 0
+	for _, check := range checks {
+		if isSilenced(check) {
+			silenceCount++
+		}
+	}
+	return silenceCount
+}
+
+// getHealthStatus is the status of a service regardless of its ack and silences.
+func getHealthStatus(check fthealth.CheckResult, quorum quorumStatus) string {
+	status := getStatusFromCheck(check)
+	if check.Ok && quorum.Degraded {
+		status = "degraded"
+	}
+	if check.Ok && quorum.rollingOut() {
+		status = "rolling out"
+	}
+	return status
+}
+
 func getStatusFromCheck(check fthealth.CheckResult) string {
 	if check.Ok {
 		return "ok"
@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/Financial-Times/go-logger"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -23,18 +25,20 @@ const (
 	categoryWithChecks   = "catWithChecks"
 	brokenCategoryName   = "brokencat"
 	brokenServiceName    = "brokenServiceName"
+	conflictServiceName  = "conflictServiceName"
 	validPodName         = "validPod"
 	validServiceName     = "validServiceName"
 	brokenPodName        = "brokenPod"
+	nonExistingSilenceID = "nonExistingSilence"
 )
 
 func init() {
 	logger.InitLogger("upp-aggregate-healthcheck", "debug")
 }
 
-func (m *mockController) buildServicesHealthResult(_ context.Context, providedCategories []string, useCache bool) (fthealth.HealthResult, map[string]category, error) {
+func (m *mockController) buildServicesHealthResult(_ context.Context, providedCategories []string, useCache bool) (servicesHealth, map[string]category, error) {
 	if len(providedCategories) == 1 && providedCategories[0] == brokenCategoryName {
-		return fthealth.HealthResult{}, map[string]category{}, errors.New("Broken category")
+		return servicesHealth{}, map[string]category{}, errors.New("Broken category")
 	}
 
 	matchingCategories := map[string]category{}
@@ -76,24 +80,35 @@ func (m *mockController) buildServicesHealthResult(_ context.Context, providedCa
 		Severity:      1,
 	}
 
-	return health, matchingCategories, nil
+	return servicesHealth{HealthResult: health}, matchingCategories, nil
 }
 
 func (m *mockController) getMeasuredServices() map[string]measuredService {
 	return map[string]measuredService{}
 }
 
-func (m *mockController) runServiceChecksByServiceNames(context.Context, map[string]service, map[string]category) ([]fthealth.CheckResult, error) {
-	return []fthealth.CheckResult{}, nil
+func (m *mockController) getCachedResults() map[string]storedResult {
+	return map[string]storedResult{}
 }
 
-func (m *mockController) runServiceChecksFor(context.Context, map[string]category) ([]fthealth.CheckResult, error) {
-	return []fthealth.CheckResult{}, nil
+func (m *mockController) getServiceHistory(serviceName string, filter historyFilter) (historyReport, error) {
+	if serviceName != validServiceName {
+		return historyReport{}, notFoundError{msg: "cannot find service with name " + serviceName}
+	}
+	return newHealthHistory(historyLimits{transitions: 10, samples: 10}).report(serviceName, filter), nil
+}
+
+func (m *mockController) runServiceChecksByServiceNames(context.Context, map[string]service) ([]fthealth.CheckResult, map[string]checkBreakdown, error) {
+	return []fthealth.CheckResult{}, nil, nil
+}
+
+func (m *mockController) runServiceChecksFor(context.Context, map[string]category) ([]fthealth.CheckResult, map[string]checkBreakdown, error) {
+	return []fthealth.CheckResult{}, nil, nil
 }
 
-func (m *mockController) buildPodsHealthResult(_ context.Context, serviceName string) (fthealth.HealthResult, error) {
+func (m *mockController) buildPodsHealthResult(_ context.Context, serviceName string) (podsHealth, error) {
 	if serviceName == brokenServiceName {
-		return fthealth.HealthResult{}, errors.New("Broken pod")
+		return podsHealth{}, errors.New("Broken pod")
 	}
 
 	if serviceName == validServiceName {
@@ -106,32 +121,32 @@ func (m *mockController) buildPodsHealthResult(_ context.Context, serviceName st
 			},
 		}
 
-		return fthealth.HealthResult{
+		return podsHealth{HealthResult: fthealth.HealthResult{
 			Checks:        checks,
 			Description:   "test",
 			Name:          "cluster health",
 			SchemaVersion: 1,
 			Ok:            true,
 			Severity:      1,
-		}, nil
+		}}, nil
 	}
 
-	return fthealth.HealthResult{}, nil
+	return podsHealth{}, nil
 }
 
-func (m *mockController) runPodChecksFor(context.Context, string) ([]fthealth.CheckResult, error) {
-	return []fthealth.CheckResult{}, nil
+func (m *mockController) runPodChecksFor(context.Context, string) ([]fthealth.CheckResult, map[string]podVersion, error) {
+	return []fthealth.CheckResult{}, nil, nil
 }
 
-func (m *mockController) collectChecksFromCachesFor(context.Context, map[string]category) ([]fthealth.CheckResult, error) {
-	return []fthealth.CheckResult{}, nil
+func (m *mockController) collectChecksFromCachesFor(context.Context, map[string]category) ([]fthealth.CheckResult, map[string]checkBreakdown, error) {
+	return []fthealth.CheckResult{}, nil, nil
 }
 
-func (m *mockController) updateCachedHealth(context.Context, map[string]service, map[string]category) {
+func (m *mockController) scheduleService(context.Context, service) {
 
 }
 
-func (m *mockController) scheduleCheck(measuredService, time.Duration, *time.Timer) {
+func (m *mockController) unscheduleService(string) {
 
 }
 
@@ -142,40 +157,98 @@ func (m *mockController) getIndividualPodHealth(_ context.Context, podName strin
 	return []byte("test pod health"), "", nil
 }
 
-func (m *mockController) addAck(_ context.Context, serviceName string, message string) error {
+func (m *mockController) addAck(_ context.Context, serviceName string, _ ack) error {
 	if serviceName == brokenServiceName {
 		return errors.New("Broken service")
 	}
+	if serviceName == nonExistingServiceName {
+		return notFoundError{msg: "Cannot find service"}
+	}
+	if serviceName == conflictServiceName {
+		return conflictError{msg: "Acks configMap has been changed concurrently"}
+	}
 
 	return nil
 }
 
-func (m *mockController) updateStickyCategory(_ context.Context, categoryName string, isEnabled bool) error {
-	if categoryName == brokenCategoryName {
-		return errors.New("Broken category")
+func (m *mockController) addPodAck(_ context.Context, podKey string, _ string, _ ack) error {
+	if _, podName := splitObjectKey(podKey); podName == nonExistingPodName {
+		return notFoundError{msg: "Cannot find pod"}
 	}
 
 	return nil
 }
 
+func (m *mockController) removePodAck(_ context.Context, podKey string, _ string) error {
+	if _, podName := splitObjectKey(podKey); podName == nonExistingPodName {
+		return notFoundError{msg: "Cannot find pod"}
+	}
+
+	return nil
+}
+
+func (m *mockController) updateStickyCategory(_ context.Context, categoryName string, isEnabled bool, change categoryChange) (categoryStatus, error) {
+	if categoryName == brokenCategoryName {
+		return categoryStatus{}, errors.New("Broken category")
+	}
+	if categoryName == nonExistingCategoryName {
+		return categoryStatus{}, notFoundError{msg: "Cannot find category"}
+	}
+
+	status := categoryStatus{Name: categoryName, Enabled: isEnabled}
+	if isEnabled {
+		status.LastEnabled = &change
+	} else {
+		status.LastDisabled = &change
+	}
+	return status, nil
+}
+
+func (m *mockController) getCategoryStatuses(context.Context) ([]categoryStatus, error) {
+	return []categoryStatus{
+		{Name: "publishing", Enabled: true},
+		{Name: "read", Sticky: true, LastDisabled: &categoryChange{At: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC), By: stickyActor, Service: "default/api-policy-component", Failures: 3, Output: "0/2 pods available"}},
+	}, nil
+}
+
 func (m *mockController) removeAck(_ context.Context, serviceName string) error {
 	if serviceName == brokenServiceName {
 		return errors.New("Broken service")
 	}
+	if serviceName == nonExistingServiceName {
+		return notFoundError{msg: "Cannot find service"}
+	}
+	if serviceName == conflictServiceName {
+		return conflictError{msg: "Acks configMap has been changed concurrently"}
+	}
 
 	return nil
 }
 
-func (m *mockController) getEnvironment() string {
-	return ""
+func (m *mockController) getSilences(context.Context) ([]silence, error) {
+	return []silence{
+		{ID: "s1", Comment: "release", StartsAt: time.Now().Add(-time.Hour), EndsAt: time.Now().Add(time.Hour), Services: []string{validServiceName}},
+	}, nil
+}
+
+func (m *mockController) addSilence(context.Context, silence) error {
+	return nil
+}
+
+func (m *mockController) removeSilence(_ context.Context, silenceID string) error {
+	if silenceID == nonExistingSilenceID {
+		return notFoundError{msg: "Cannot find silence"}
+	}
+
+	return nil
 }
 
-func (m *mockController) getSeverityForService(context.Context, string, int32) uint8 {
-	return 1
+func (m *mockController) getLeaderStatus() leaderStatus {
+	return leaderStatus{Identity: "replica-a", ElectionActive: true, IsLeader: true}
 }
 
-func (m *mockController) getSeverityForPod(context.Context, string, int32) uint8 {
-	return 1
+func (m *mockController) getEnvironment() string {
+	return ""
 }
 
 func initializeTestHandler() *httpHandler {
@@ -222,6 +295,18 @@ func TestRemoveAckWithInternalError(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, respRecorder.Code)
 }
 
+func TestRemoveAckOfNonExistingService(t *testing.T) {
+	aggHealthCheckcHandler := initializeTestHandler()
+	req, err := http.NewRequest("GET", fmt.Sprintf("/rem-ack?service-name=%s", nonExistingServiceName), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	respRecorder := httptest.NewRecorder()
+	handler := http.HandlerFunc(aggHealthCheckcHandler.handleRemoveAck)
+	handler.ServeHTTP(respRecorder, req)
+	assert.Equal(t, http.StatusNotFound, respRecorder.Code)
+}
+
 func TestAddAckWithEmptyServiceName(t *testing.T) {
 	aggHealthCheckcHandler := initializeTestHandler()
 	req, err := http.NewRequest("GET", "", nil)
@@ -258,6 +343,30 @@ func TestAddAckWithBrokenService(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, respRecorder.Code)
 }
 
+func TestAddAckOfNonExistingService(t *testing.T) {
+	aggHealthCheckcHandler := initializeTestHandler()
+	req, err := http.NewRequest("GET", fmt.Sprintf("/add-ack?service-name=%s", nonExistingServiceName), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	respRecorder := httptest.NewRecorder()
+	handler := http.HandlerFunc(aggHealthCheckcHandler.handleAddAck)
+	handler.ServeHTTP(respRecorder, req)
+	assert.Equal(t, http.StatusNotFound, respRecorder.Code)
+}
+
+func TestAddAckWithConcurrentModification(t *testing.T) {
+	aggHealthCheckcHandler := initializeTestHandler()
+	req, err := http.NewRequest("GET", fmt.Sprintf("/add-ack?service-name=%s", conflictServiceName), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	respRecorder := httptest.NewRecorder()
+	handler := http.HandlerFunc(aggHealthCheckcHandler.handleAddAck)
+	handler.ServeHTTP(respRecorder, req)
+	assert.Equal(t, http.StatusConflict, respRecorder.Code)
+}
+
 func TestAddAckFromWithNonEmptyServiceName(t *testing.T) {
 	aggHealthCheckcHandler := initializeTestHandler()
 	req, err := http.NewRequest("GET", "/add-ack?service-name=testservice", nil)
@@ -306,6 +415,18 @@ func TestDisableCategoryWithIntrnalError(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, respRecorder.Code)
 }
 
+func TestDisableNonExistingCategory(t *testing.T) {
+	aggHealthCheckcHandler := initializeTestHandler()
+	req, err := http.NewRequest("GET", fmt.Sprintf("disable-category?category-name=%s", nonExistingCategoryName), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	respRecorder := httptest.NewRecorder()
+	handler := http.HandlerFunc(aggHealthCheckcHandler.handleDisableCategory)
+	handler.ServeHTTP(respRecorder, req)
+	assert.Equal(t, http.StatusNotFound, respRecorder.Code)
+}
+
 func TestDisableCategoryWithValidCategoryName(t *testing.T) {
 	aggHealthCheckcHandler := initializeTestHandler()
 	req, err := http.NewRequest("GET", "disable-category?category-name=testcat", nil)
@@ -523,3 +644,472 @@ func TestPodsHealthCheckHappyFlowJson(t *testing.T) {
 	handler.ServeHTTP(respRecorder, req)
 	assert.Equal(t, http.StatusOK, respRecorder.Code)
 }
+
+func TestHealthcheckJSONResponseIncludesNamespace(t *testing.T) {
+	respRecorder := httptest.NewRecorder()
+	buildHealthcheckJSONResponse(respRecorder, fthealth.HealthResult{
+		Checks: []fthealth.CheckResult{
+			{ID: "publishing/service1", Name: "service1", Ok: true},
+		},
+	}, nil, nil, nil, nil)
+
+	var body struct {
+		Checks []struct {
+			ID        string `json:"id"`
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"checks"`
+	}
+	assert.NoError(t, json.Unmarshal(respRecorder.Body.Bytes(), &body))
+	assert.Len(t, body.Checks, 1)
+	assert.Equal(t, "publishing/service1", body.Checks[0].ID)
+	assert.Equal(t, "service1", body.Checks[0].Name)
+	assert.Equal(t, "publishing", body.Checks[0].Namespace)
+}
+
+func TestHealthcheckJSONResponseIncludesFailingPods(t *testing.T) {
+	respRecorder := httptest.NewRecorder()
+	buildHealthcheckJSONResponse(respRecorder, fthealth.HealthResult{
+		Checks: []fthealth.CheckResult{
+			{ID: "default/service1", Name: "service1", Ok: false},
+			{ID: "default/service2", Name: "service2", Ok: true},
+		},
+	}, map[string]checkBreakdown{
+		"default/service1": {failingPods: podFailures{
+			{Pod: "service1-pod-a", Reason: failingChecksReason, FailingChecks: []failingCheck{{Name: "Kafka", Severity: 1}}},
+			{Pod: "service1-pod-b", Acked: true, Reason: "timeout", Error: "Error performing healthcheck request: i/o timeout"},
+		}},
+	}, nil, nil, nil)
+
+	var body struct {
+		Checks []struct {
+			FailingPods []struct {
+				Pod           string `json:"pod"`
+				Acked         bool   `json:"acked"`
+				Reason        string `json:"reason"`
+				Error         string `json:"error"`
+				FailingChecks []struct {
+					Name     string `json:"name"`
+					Severity uint8  `json:"severity"`
+				} `json:"failingChecks"`
+			} `json:"_failingPods"`
+		} `json:"checks"`
+	}
+	assert.NoError(t, json.Unmarshal(respRecorder.Body.Bytes(), &body))
+	assert.Len(t, body.Checks[0].FailingPods, 2)
+	assert.Equal(t, "failing checks", body.Checks[0].FailingPods[0].Reason)
+	assert.Equal(t, "Kafka", body.Checks[0].FailingPods[0].FailingChecks[0].Name)
+	assert.Equal(t, uint8(1), body.Checks[0].FailingPods[0].FailingChecks[0].Severity)
+	assert.True(t, body.Checks[0].FailingPods[1].Acked)
+	assert.Equal(t, "timeout", body.Checks[0].FailingPods[1].Reason)
+	assert.Equal(t, "Error performing healthcheck request: i/o timeout", body.Checks[0].FailingPods[1].Error)
+	assert.NotContains(t, respRecorder.Body.String(), `"_failingPods":null`)
+	assert.Empty(t, body.Checks[1].FailingPods)
+}
+
+func TestIndividualServiceChecksIncludeFailingPods(t *testing.T) {
+	failingPods := podFailures{{Pod: "service1-pod-a", Reason: "non-200 status", Error: "healthcheck endpoint returned non-200 status (503)"}}
+	checks, _ := populateIndividualServiceChecks([]fthealth.CheckResult{
+		{ID: "default/service1", Name: "service1", Ok: false},
+		{ID: "default/service2", Name: "service2", Ok: true},
+	}, map[string]checkBreakdown{"default/service1": {failingPods: failingPods}}, nil, "")
+
+	assert.Equal(t, failingPods, checks[0].FailingPods)
+	assert.Empty(t, checks[1].FailingPods)
+}
+
+func TestDegradedServicesAreShownApartFromHealthyOnes(t *testing.T) {
+	degraded := checkBreakdown{quorum: quorumStatus{Policy: "2", Healthy: 2, Desired: 3, Required: 2, Degraded: true}}
+	health := servicesHealth{
+		HealthResult: fthealth.HealthResult{Ok: true, Checks: []fthealth.CheckResult{
+			{ID: "default/service1", Name: "service1", Ok: true},
+			{ID: "default/service2", Name: "service2", Ok: true},
+		}},
+		breakdowns: map[string]checkBreakdown{"default/service1": degraded},
+	}
+
+	params := populateAggregateServiceChecks(health, "test", "default", "")
+	assert.Equal(t, 1, params.DegradedCount)
+	assert.Equal(t, "degraded", params.IndividualHealthChecks[0].Status)
+	assert.Equal(t, "ok", params.IndividualHealthChecks[1].Status)
+
+	respRecorder := httptest.NewRecorder()
+	buildHealthcheckJSONResponse(respRecorder, health.HealthResult, health.breakdowns, nil, nil, nil)
+	var body struct {
+		Checks []struct {
+			Quorum *quorumStatus `json:"_quorum"`
+		} `json:"checks"`
+	}
+	assert.NoError(t, json.Unmarshal(respRecorder.Body.Bytes(), &body))
+	assert.Equal(t, &degraded.quorum, body.Checks[0].Quorum)
+	assert.Nil(t, body.Checks[1].Quorum)
+}
+
+func TestIndividualChecksLinkToNamespacedServices(t *testing.T) {
+	checks, _ := populateIndividualServiceChecks([]fthealth.CheckResult{
+		{ID: "publishing/service1", Name: "service1", Ok: true},
+	}, nil, nil, "/prefix")
+
+	assert.Equal(t, "publishing", checks[0].Namespace)
+	assert.Equal(t, "/prefix/__pods-health?service-name=publishing%2Fservice1", checks[0].MoreInfoPath)
+	assert.Equal(t, "/prefix/add-ack-form?service-name=publishing%2Fservice1", checks[0].AddOrRemoveAckPath)
+
+	podChecks, _ := populateIndividualPodChecks([]fthealth.CheckResult{
+		{ID: "publishing/service1-pod-a", Name: "service1-pod-a", Ok: true},
+	}, nil, "publishing/service1", "/prefix")
+	assert.Equal(t, "/prefix/__pod-individual-health?pod-name=service1-pod-a&namespace=publishing", podChecks[0].MoreInfoPath)
+	assert.Equal(t, "/prefix/add-ack-form?service-name=publishing%2Fservice1&namespace=publishing&pod-name=service1-pod-a", podChecks[0].AddOrRemoveAckPath)
+	assert.Equal(t, "Ack pod", podChecks[0].AddOrRemoveAckPathName)
+}
+
+func TestIndividualPodChecksLinkToPodAckRemoval(t *testing.T) {
+	podChecks, ackCount := populateIndividualPodChecks([]fthealth.CheckResult{
+		{ID: "publishing/service1-pod-a", Name: "service1-pod-a", Ok: false, Ack: "bad node"},
+		{ID: "publishing/service1-pod-b", Name: "service1-pod-b", Ok: true},
+	}, nil, "publishing/service1", "/prefix")
+
+	assert.Equal(t, 1, ackCount)
+	assert.Equal(t, "bad node", podChecks[0].AckMessage)
+	assert.Equal(t, "/prefix/rem-ack?service-name=publishing%2Fservice1&namespace=publishing&pod-name=service1-pod-a", podChecks[0].AddOrRemoveAckPath)
+	assert.Equal(t, "Remove ack", podChecks[0].AddOrRemoveAckPathName)
+	assert.Equal(t, "Ack pod", podChecks[1].AddOrRemoveAckPathName)
+}
+
+func TestAddPodAckRedirectsToPodsPage(t *testing.T) {
+	aggHealthCheckcHandler := initializeTestHandler()
+	req, err := http.NewRequest("POST", "/add-ack?service-name=testservice&pod-name=testservice-pod-a&namespace=publishing", strings.NewReader("ack-msg=bad+node&check-name=kafka"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	respRecorder := httptest.NewRecorder()
+	handler := http.HandlerFunc(aggHealthCheckcHandler.handleAddAck)
+	handler.ServeHTTP(respRecorder, req)
+	assert.Equal(t, http.StatusMovedPermanently, respRecorder.Code)
+	assert.Equal(t, "/__pods-health?cache=false&service-name=testservice", respRecorder.Header().Get("Location"))
+}
+
+func TestAddPodAckOfNonExistingPod(t *testing.T) {
+	aggHealthCheckcHandler := initializeTestHandler()
+	req, err := http.NewRequest("POST", fmt.Sprintf("/add-ack?service-name=testservice&pod-name=%s", nonExistingPodName), strings.NewReader("ack-msg=bad+node"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	respRecorder := httptest.NewRecorder()
+	handler := http.HandlerFunc(aggHealthCheckcHandler.handleAddAck)
+	handler.ServeHTTP(respRecorder, req)
+	assert.Equal(t, http.StatusNotFound, respRecorder.Code)
+}
+
+func TestRemovePodAckRedirectsToPodsPage(t *testing.T) {
+	aggHealthCheckcHandler := initializeTestHandler()
+	req, err := http.NewRequest("GET", "/rem-ack?service-name=testservice&pod-name=testservice-pod-a&namespace=publishing", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	respRecorder := httptest.NewRecorder()
+	handler := http.HandlerFunc(aggHealthCheckcHandler.handleRemoveAck)
+	handler.ServeHTTP(respRecorder, req)
+	assert.Equal(t, http.StatusMovedPermanently, respRecorder.Code)
+	assert.Equal(t, "/__pods-health?cache=false&service-name=testservice", respRecorder.Header().Get("Location"))
+}
+
+func TestAddAckWithInvalidDuration(t *testing.T) {
+	aggHealthCheckcHandler := initializeTestHandler()
+	req, err := http.NewRequest("POST", "/add-ack?service-name=testservice", strings.NewReader("ack-msg=msg&ack-duration=tomorrow"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	respRecorder := httptest.NewRecorder()
+	handler := http.HandlerFunc(aggHealthCheckcHandler.handleAddAck)
+	handler.ServeHTTP(respRecorder, req)
+	assert.Equal(t, http.StatusBadRequest, respRecorder.Code)
+}
+
+func TestParseAckDuration(t *testing.T) {
+	d, err := parseAckDuration("")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), d)
+
+	d, err = parseAckDuration("24h")
+	assert.NoError(t, err)
+	assert.Equal(t, 24*time.Hour, d)
+
+	_, err = parseAckDuration("-1h")
+	assert.Error(t, err)
+}
+
+func TestHealthcheckJSONResponseRendersAckMetadata(t *testing.T) {
+	expiresAt := time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC)
+	ackValue, err := ack{Message: "known issue", Author: "jane.doe", CreatedAt: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC), ExpiresAt: &expiresAt, Ticket: "UPPSF-1"}.encode()
+	assert.NoError(t, err)
+
+	respRecorder := httptest.NewRecorder()
+	buildHealthcheckJSONResponse(respRecorder, fthealth.HealthResult{
+		Checks: []fthealth.CheckResult{
+			{ID: "service1", Name: "service1", Ack: ackValue},
+			{ID: "service2", Name: "service2", Ack: "legacy ack"},
+		},
+	}, nil, nil, nil, nil)
+
+	var body struct {
+		Checks []struct {
+			Ack          string `json:"ack"`
+			Acknowledged string `json:"_acknowledged"`
+		} `json:"checks"`
+	}
+	assert.NoError(t, json.Unmarshal(respRecorder.Body.Bytes(), &body))
+	assert.Equal(t, "known issue", body.Checks[0].Ack)
+	assert.Equal(t, "known issue (by jane.doe, since 2024-01-02 10:00:00 UTC, until 2024-01-03 10:00:00 UTC, ticket UPPSF-1)", body.Checks[0].Acknowledged)
+	assert.Equal(t, "legacy ack", body.Checks[1].Ack)
+	assert.Equal(t, "legacy ack", body.Checks[1].Acknowledged)
+}
+
+func TestSilencedChecksAreCountedApartFromAcks(t *testing.T) {
+	silenceAck := silence{ID: "s1", Comment: "release"}.ackValue()
+	params := populateAggregateServiceChecks(servicesHealth{HealthResult: fthealth.HealthResult{
+		Checks: []fthealth.CheckResult{
+			{ID: "service1", Name: "service1", Ack: silenceAck, Severity: 2},
+			{ID: "service2", Name: "service2", Ack: "known issue", Severity: 2},
+		},
+	}}, "test", "default", "/__health")
+
+	assert.Equal(t, 1, params.AckCount)
+	assert.Equal(t, 1, params.SilenceCount)
+	assert.Equal(t, "/__health/silences", params.SilencesPath)
+	assert.Equal(t, "warning silenced", params.IndividualHealthChecks[0].Status)
+	assert.Equal(t, "warning acked", params.IndividualHealthChecks[1].Status)
+}
+
+func TestSilencedChecksAreNotAcknowledgedInJSON(t *testing.T) {
+	respRecorder := httptest.NewRecorder()
+	buildHealthcheckJSONResponse(respRecorder, fthealth.HealthResult{
+		Checks: []fthealth.CheckResult{
+			{ID: "default/service1", Name: "service1", Ack: silence{ID: "s1", Comment: "release"}.ackValue(), Severity: 2},
+			{ID: "default/service2", Name: "service2", Ack: "known issue", Severity: 2},
+		},
+	}, nil, nil, nil, nil)
+
+	var body struct {
+		Checks []struct {
+			Acknowledged string `json:"_acknowledged"`
+			Silenced     string `json:"_silenced"`
+		} `json:"checks"`
+	}
+	assert.NoError(t, json.Unmarshal(respRecorder.Body.Bytes(), &body))
+	assert.Len(t, body.Checks, 2)
+	assert.Empty(t, body.Checks[0].Acknowledged, "A silenced check should not be acknowledged")
+	assert.Equal(t, "s1", body.Checks[0].Silenced)
+	assert.Equal(t, "known issue", body.Checks[1].Acknowledged)
+	assert.Empty(t, body.Checks[1].Silenced)
+}
+
+func TestSilencesPageHtmlResponse(t *testing.T) {
+	aggHealthCheckcHandler := initializeTestHandler()
+	req, err := http.NewRequest("GET", "/silences", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	respRecorder := httptest.NewRecorder()
+	handler := http.HandlerFunc(aggHealthCheckcHandler.handleSilences)
+	handler.ServeHTTP(respRecorder, req)
+	assert.Equal(t, http.StatusOK, respRecorder.Code)
+	assert.Contains(t, respRecorder.Body.String(), "/rem-silence?silence-id=s1")
+}
+
+func TestSilencesJSONResponse(t *testing.T) {
+	aggHealthCheckcHandler := initializeTestHandler()
+	req, err := http.NewRequest("GET", "/silences", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", jsonContentType)
+	respRecorder := httptest.NewRecorder()
+	handler := http.HandlerFunc(aggHealthCheckcHandler.handleSilences)
+	handler.ServeHTTP(respRecorder, req)
+	assert.Equal(t, http.StatusOK, respRecorder.Code)
+
+	var silences []silence
+	assert.NoError(t, json.Unmarshal(respRecorder.Body.Bytes(), &silences))
+	assert.Len(t, silences, 1)
+	assert.Equal(t, "s1", silences[0].ID)
+}
+
+func TestAddSilenceRedirectsToSilencesPage(t *testing.T) {
+	aggHealthCheckcHandler := initializeTestHandler()
+	req, err := http.NewRequest("POST", "/add-silence", strings.NewReader("comment=release&services=service1,+service2&duration=2h"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	respRecorder := httptest.NewRecorder()
+	handler := http.HandlerFunc(aggHealthCheckcHandler.handleAddSilence)
+	handler.ServeHTTP(respRecorder, req)
+	assert.Equal(t, http.StatusMovedPermanently, respRecorder.Code)
+	assert.Equal(t, "/silences", respRecorder.Header().Get("Location"))
+}
+
+func TestAddInvalidSilence(t *testing.T) {
+	tests := map[string]string{
+		"missing comment":  "services=service1&duration=2h",
+		"missing target":   "comment=release&duration=2h",
+		"invalid duration": "comment=release&services=service1&duration=soon",
+		"invalid start":    "comment=release&services=service1&duration=2h&starts-at=tomorrow",
+		"invalid selector": "comment=release&selector=team%3D%3D%3Dcontent&duration=2h",
+	}
+	for name, form := range tests {
+		t.Run(name, func(t *testing.T) {
+			aggHealthCheckcHandler := initializeTestHandler()
+			req, err := http.NewRequest("POST", "/add-silence", strings.NewReader(form))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			respRecorder := httptest.NewRecorder()
+			handler := http.HandlerFunc(aggHealthCheckcHandler.handleAddSilence)
+			handler.ServeHTTP(respRecorder, req)
+			assert.Equal(t, http.StatusBadRequest, respRecorder.Code)
+		})
+	}
+}
+
+func TestParseSilenceFormWithStart(t *testing.T) {
+	req, err := http.NewRequest("POST", "/add-silence", strings.NewReader("comment=release&categories=publishing,,read&duration=30m&starts-at=2024-01-02T22:00&author=jane.doe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	s, err := parseSilenceForm(req, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 1, 2, 22, 0, 0, 0, time.UTC), s.StartsAt)
+	assert.Equal(t, time.Date(2024, 1, 2, 22, 30, 0, 0, time.UTC), s.EndsAt)
+	assert.Equal(t, []string{"publishing", "read"}, s.Categories)
+	assert.Equal(t, "jane.doe", s.Author)
+}
+
+func TestRemoveSilence(t *testing.T) {
+	aggHealthCheckcHandler := initializeTestHandler()
+	req, err := http.NewRequest("GET", "/rem-silence?silence-id=s1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	respRecorder := httptest.NewRecorder()
+	handler := http.HandlerFunc(aggHealthCheckcHandler.handleRemoveSilence)
+	handler.ServeHTTP(respRecorder, req)
+	assert.Equal(t, http.StatusMovedPermanently, respRecorder.Code)
+	assert.Equal(t, "/silences", respRecorder.Header().Get("Location"))
+}
+
+func TestRemoveNonExistingSilence(t *testing.T) {
+	aggHealthCheckcHandler := initializeTestHandler()
+	req, err := http.NewRequest("GET", "/rem-silence?silence-id="+nonExistingSilenceID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	respRecorder := httptest.NewRecorder()
+	handler := http.HandlerFunc(aggHealthCheckcHandler.handleRemoveSilence)
+	handler.ServeHTTP(respRecorder, req)
+	assert.Equal(t, http.StatusNotFound, respRecorder.Code)
+}
+
+func TestServicesHealthJSONIncludesReplica(t *testing.T) {
+	aggHealthCheckcHandler := initializeTestHandler()
+	req, err := http.NewRequest("GET", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", jsonContentType)
+	respRecorder := httptest.NewRecorder()
+	handler := http.HandlerFunc(aggHealthCheckcHandler.handleServicesHealthCheck)
+	handler.ServeHTTP(respRecorder, req)
+
+	var body struct {
+		Replica leaderStatus `json:"_replica"`
+	}
+	assert.NoError(t, json.Unmarshal(respRecorder.Body.Bytes(), &body))
+	assert.Equal(t, leaderStatus{Identity: "replica-a", ElectionActive: true, IsLeader: true}, body.Replica)
+}
+
+func TestDescribeReplica(t *testing.T) {
+	assert.Empty(t, describeReplica(leaderStatus{Identity: "replica-a", IsLeader: true}))
+	assert.Equal(t, "Served by replica-a (leader)", describeReplica(leaderStatus{Identity: "replica-a", ElectionActive: true, IsLeader: true}))
+	assert.Equal(t, "Served by replica-b (follower)", describeReplica(leaderStatus{Identity: "replica-b", ElectionActive: true}))
+}
+
+func TestDisableCategoryRespondsWithTheChange(t *testing.T) {
+	aggHealthCheckcHandler := initializeTestHandler()
+	req, err := http.NewRequest("GET", "disable-category?category-name=read&actor=jane.doe&reason=failover", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	respRecorder := httptest.NewRecorder()
+	handler := http.HandlerFunc(aggHealthCheckcHandler.handleDisableCategory)
+	handler.ServeHTTP(respRecorder, req)
+	assert.Equal(t, http.StatusOK, respRecorder.Code)
+
+	var status categoryStatus
+	assert.NoError(t, json.Unmarshal(respRecorder.Body.Bytes(), &status))
+	assert.Equal(t, "read", status.Name)
+	assert.False(t, status.Enabled)
+	assert.Equal(t, "jane.doe", status.LastDisabled.By)
+	assert.Equal(t, "failover", status.LastDisabled.Reason)
+}
+
+func TestCategoriesPageHtmlResponse(t *testing.T) {
+	aggHealthCheckcHandler := initializeTestHandler()
+	req, err := http.NewRequest("GET", "/categories", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	respRecorder := httptest.NewRecorder()
+	handler := http.HandlerFunc(aggHealthCheckcHandler.handleCategories)
+	handler.ServeHTTP(respRecorder, req)
+	assert.Equal(t, http.StatusOK, respRecorder.Code)
+	assert.Contains(t, respRecorder.Body.String(), "/enable-category?category-name=read")
+	assert.Contains(t, respRecorder.Body.String(), "default/api-policy-component")
+}
+
+func TestCategoriesJSONResponse(t *testing.T) {
+	aggHealthCheckcHandler := initializeTestHandler()
+	req, err := http.NewRequest("GET", "/categories", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", jsonContentType)
+	respRecorder := httptest.NewRecorder()
+	handler := http.HandlerFunc(aggHealthCheckcHandler.handleCategories)
+	handler.ServeHTTP(respRecorder, req)
+	assert.Equal(t, http.StatusOK, respRecorder.Code)
+
+	var statuses []categoryStatus
+	assert.NoError(t, json.Unmarshal(respRecorder.Body.Bytes(), &statuses))
+	assert.Len(t, statuses, 2)
+	assert.Equal(t, 3, statuses[1].LastDisabled.Failures)
+}
+
+func TestPopulateCategoriesPage(t *testing.T) {
+	disabledAt := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	page := populateCategoriesPage([]categoryStatus{
+		{Name: "read", LastDisabled: &categoryChange{At: disabledAt, By: "jane.doe", Reason: "failover"}},
+	}, "test", "/__health")
+
+	assert.Equal(t, "disabled", page.Categories[0].Status)
+	assert.Equal(t, "by jane.doe at 2024-01-02 10:00:00 UTC: failover", page.Categories[0].LastDisabled)
+	assert.Equal(t, "/__health/enable-category?category-name=read", page.Categories[0].EnableOrDisablePath)
+}
+
+func TestServicesHealthJSONIncludesCategories(t *testing.T) {
+	respRecorder := httptest.NewRecorder()
+	buildHealthcheckJSONResponse(respRecorder, fthealth.HealthResult{}, nil, nil, nil, getCategoryStatuses(map[string]category{
+		"read": {name: "read", isSticky: true, lastDisabled: &categoryChange{By: stickyActor, Service: "default/service1"}},
+	}))
+
+	var body struct {
+		Categories []categoryStatus `json:"_categories"`
+	}
+	assert.NoError(t, json.Unmarshal(respRecorder.Body.Bytes(), &body))
+	assert.Equal(t, "default/service1", body.Categories[0].LastDisabled.Service)
+}
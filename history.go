@@ -0,0 +1,282 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
+	log "github.com/Financial-Times/go-logger"
+)
+
+const (
+	defaultHistoryTransitions  = 200
+	defaultHistorySamples      = 288
+	defaultHistorySamplePeriod = 5 * time.Minute
+	// timelineSamples is the number of the latest samples of a service shown in its timeline on the services page
+	timelineSamples = 48
+
+	// podGone is the state of a failing pod that is no longer probed, e.g. after it was replaced by a rollout
+	podGone = "gone"
+)
+
+// historyLimits bound the history kept for every service: at most the given number of transitions, those of the service
+// and of its pods together, and of samples, a sample of the result of the service being kept at most once per sample period.
+// A sample period of 0 keeps a sample of every result.
+type historyLimits struct {
+	transitions  int
+	samples      int
+	samplePeriod time.Duration
+}
+
+// validated returns the limits with the negative ones replaced by their defaults.
+func (l historyLimits) validated() historyLimits {
+	if l.transitions < 0 {
+		log.Warnf("Invalid number of history transitions %d, using the default %d.", l.transitions, defaultHistoryTransitions)
+		l.transitions = defaultHistoryTransitions
+	}
+	if l.samples < 0 {
+		log.Warnf("Invalid number of history samples %d, using the default %d.", l.samples, defaultHistorySamples)
+		l.samples = defaultHistorySamples
+	}
+	if l.samplePeriod < 0 {
+		log.Warnf("Invalid history sample period %s, using the default %s.", l.samplePeriod, defaultHistorySamplePeriod)
+		l.samplePeriod = defaultHistorySamplePeriod
+	}
+	return l
+}
+
+// ringBuffer keeps the latest items added to it, up to its capacity.
+type ringBuffer[T any] struct {
+	items []T
+	next  int
+	full  bool
+}
+
+// newRingBuffer creates a buffer of the given capacity; a buffer with no capacity keeps nothing.
+func newRingBuffer[T any](capacity int) *ringBuffer[T] {
+	if capacity < 0 {
+		capacity = 0
+	}
+	return &ringBuffer[T]{items: make([]T, capacity)}
+}
+
+func (b *ringBuffer[T]) add(item T) {
+	if len(b.items) == 0 {
+		return
+	}
+	b.items[b.next] = item
+	b.next = (b.next + 1) % len(b.items)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// all returns the items of the buffer, the oldest first.
+func (b *ringBuffer[T]) all() []T {
+	if !b.full {
+		return append([]T(nil), b.items[:b.next]...)
+	}
+	return append(append([]T(nil), b.items[b.next:]...), b.items[:b.next]...)
+}
+
+// healthTransition is a change of the status of a service, or of a pod when Pod is set.
+// From is empty for the first status of a service, or of a pod that starts failing as soon as it is probed.
+type healthTransition struct {
+	Time   time.Time `json:"time"`
+	Pod    string    `json:"pod,omitempty"`
+	From   string    `json:"from,omitempty"`
+	To     string    `json:"to"`
+	Output string    `json:"output,omitempty"`
+}
+
+// healthSample is the result of a check of a service, as it was at the given time.
+type healthSample struct {
+	Time        time.Time `json:"time"`
+	Status      string    `json:"status"`
+	Output      string    `json:"output,omitempty"`
+	FailingPods []string  `json:"failingPods,omitempty"`
+}
+
+// serviceHistory is the history of a service along with the latest status of the service and of its pods,
+// which the next result is compared against.
+type serviceHistory struct {
+	status      string
+	since       time.Time
+	podStatuses map[string]string
+	transitions *ringBuffer[healthTransition]
+	samples     *ringBuffer[healthSample]
+	lastSample  time.Time
+}
+
+// healthHistory keeps a bounded history of the results of the scheduled checks of every service.
+// It is written to along with the result store, so a removed service has no history either.
+type healthHistory struct {
+	sync.RWMutex
+	limits   historyLimits
+	services map[string]*serviceHistory
+}
+
+func newHealthHistory(limits historyLimits) *healthHistory {
+	return &healthHistory{
+		limits:   limits.validated(),
+		services: make(map[string]*serviceHistory),
+	}
+}
+
+// record adds the result of a check of the service to its history: a transition for every change of the status of the service
+// or of one of its pods, and a sample of the result if none was kept within the sample period.
+func (h *healthHistory) record(serviceKey string, checkResult fthealth.CheckResult, breakdown checkBreakdown, now time.Time) {
+	h.Lock()
+	defer h.Unlock()
+
+	history, ok := h.services[serviceKey]
+	if !ok {
+		history = &serviceHistory{
+			podStatuses: make(map[string]string),
+			transitions: newRingBuffer[healthTransition](h.limits.transitions),
+			samples:     newRingBuffer[healthSample](h.limits.samples),
+		}
+		h.services[serviceKey] = history
+	}
+
+	status := getHealthStatus(checkResult, breakdown.quorum)
+	if status != history.status {
+		history.transitions.add(healthTransition{Time: now, From: history.status, To: status, Output: checkResult.CheckOutput})
+		history.status = status
+		history.since = now
+	}
+	recordPodTransitions(history, breakdown.probedPods, breakdown.failingPods, now)
+
+	if history.lastSample.IsZero() || now.Sub(history.lastSample) >= h.limits.samplePeriod {
+		history.samples.add(healthSample{Time: now, Status: status, Output: checkResult.CheckOutput, FailingPods: breakdown.failingPods.names()})
+		history.lastSample = now
+	}
+}
+
+// recordPodTransitions records the changes of the status of the probed pods of the service. A pod is either ok or failing for the reason
+// of its failure. Pods that show up healthy and healthy pods that go away are the everyday business of rollouts and scaling,
+// so they are not recorded.
+func recordPodTransitions(history *serviceHistory, probedPods []string, failingPods podFailures, now time.Time) {
+	podStatuses := make(map[string]string, len(probedPods))
+	for _, podName := range probedPods {
+		podStatuses[podName] = "ok"
+	}
+	podErrors := make(map[string]string)
+	for _, failure := range failingPods {
+		podStatuses[failure.Pod] = failure.Reason
+		podErrors[failure.Pod] = failure.Error
+	}
+
+	podNames := make([]string, 0, len(podStatuses))
+	for podName := range podStatuses {
+		podNames = append(podNames, podName)
+	}
+	sort.Strings(podNames)
+	for _, podName := range podNames {
+		status, previous := podStatuses[podName], history.podStatuses[podName]
+		if status != previous && (previous != "" || status != "ok") {
+			history.transitions.add(healthTransition{Time: now, Pod: podName, From: previous, To: status, Output: podErrors[podName]})
+		}
+	}
+
+	gonePodNames := make([]string, 0)
+	for podName, previous := range history.podStatuses {
+		if _, ok := podStatuses[podName]; !ok && previous != "ok" {
+			gonePodNames = append(gonePodNames, podName)
+		}
+	}
+	sort.Strings(gonePodNames)
+	for _, podName := range gonePodNames {
+		history.transitions.add(healthTransition{Time: now, Pod: podName, From: history.podStatuses[podName], To: podGone})
+	}
+	history.podStatuses = podStatuses
+}
+
+// forget drops the history of a service that is no longer checked.
+func (h *healthHistory) forget(serviceKey string) {
+	h.Lock()
+	delete(h.services, serviceKey)
+	h.Unlock()
+}
+
+// historyReport is the history of a service within a time range, as served by the history endpoint.
+// Since is when the service got its current status, and Flaps the number of changes of its status within the range.
+type historyReport struct {
+	Service     string             `json:"service"`
+	Status      string             `json:"status,omitempty"`
+	Since       *time.Time         `json:"since,omitempty"`
+	From        *time.Time         `json:"from,omitempty"`
+	To          *time.Time         `json:"to,omitempty"`
+	Flaps       int                `json:"flaps"`
+	Transitions []healthTransition `json:"transitions"`
+	Samples     []healthSample     `json:"samples"`
+}
+
+// historyFilter selects the part of the history of a service that is reported: the transitions and samples within the time range,
+// either end being open when it is not set, and only the transitions of the given pod when it is set.
+type historyFilter struct {
+	from    time.Time
+	to      time.Time
+	podName string
+}
+
+func (f historyFilter) includes(t time.Time) bool {
+	return (f.from.IsZero() || !t.Before(f.from)) && (f.to.IsZero() || !t.After(f.to))
+}
+
+// report returns the history of the service selected by the filter. A service that has not been checked yet has an empty history.
+func (h *healthHistory) report(serviceKey string, filter historyFilter) historyReport {
+	report := historyReport{Service: serviceKey, Transitions: []healthTransition{}, Samples: []healthSample{}}
+	if !filter.from.IsZero() {
+		report.From = &filter.from
+	}
+	if !filter.to.IsZero() {
+		report.To = &filter.to
+	}
+
+	h.RLock()
+	defer h.RUnlock()
+	history, ok := h.services[serviceKey]
+	if !ok {
+		return report
+	}
+
+	report.Status = history.status
+	since := history.since
+	report.Since = &since
+	for _, transition := range history.transitions.all() {
+		if !filter.includes(transition.Time) || (filter.podName != "" && transition.Pod != filter.podName) {
+			continue
+		}
+		if transition.Pod == "" && transition.From != "" {
+			report.Flaps++
+		}
+		report.Transitions = append(report.Transitions, transition)
+	}
+	for _, sample := range history.samples.all() {
+		if filter.includes(sample.Time) {
+			report.Samples = append(report.Samples, sample)
+		}
+	}
+	return report
+}
+
+// timelines returns the latest samples of the given services, at most the given number of samples per service, the oldest first.
+func (h *healthHistory) timelines(serviceKeys []string, maxSamples int) map[string][]healthSample {
+	h.RLock()
+	defer h.RUnlock()
+	timelines := make(map[string][]healthSample, len(serviceKeys))
+	for _, serviceKey := range serviceKeys {
+		history, ok := h.services[serviceKey]
+		if !ok {
+			continue
+		}
+		samples := history.samples.all()
+		if len(samples) > maxSamples {
+			samples = samples[len(samples)-maxSamples:]
+		}
+		timelines[serviceKey] = samples
+	}
+	return timelines
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRingBufferKeepsTheLatestItems(t *testing.T) {
+	buffer := newRingBuffer[int](3)
+	assert.Empty(t, buffer.all())
+
+	buffer.add(1)
+	buffer.add(2)
+	assert.Equal(t, []int{1, 2}, buffer.all())
+
+	for i := 3; i <= 7; i++ {
+		buffer.add(i)
+	}
+	assert.Equal(t, []int{5, 6, 7}, buffer.all())
+
+	empty := newRingBuffer[int](0)
+	empty.add(1)
+	assert.Empty(t, empty.all())
+
+	negative := newRingBuffer[int](-1)
+	negative.add(1)
+	assert.Empty(t, negative.all(), "A buffer with a negative capacity should keep nothing")
+}
+
+func TestHistoryLimitsValidated(t *testing.T) {
+	assert.Equal(t, historyLimits{transitions: defaultHistoryTransitions, samples: defaultHistorySamples, samplePeriod: defaultHistorySamplePeriod},
+		historyLimits{transitions: -1, samples: -5, samplePeriod: -time.Second}.validated(), "Negative limits should fall back to their defaults")
+
+	limits := historyLimits{transitions: 0, samples: 10, samplePeriod: 0}
+	assert.Equal(t, limits, limits.validated(), "Zero limits should be kept as they are")
+}
+
+func TestHealthHistoryWithoutSamplePeriodSamplesEveryResult(t *testing.T) {
+	history := newHealthHistory(historyLimits{transitions: -1, samples: 10})
+	start := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		history.record("default/service1", fthealth.CheckResult{Ok: true}, checkBreakdown{}, start)
+	}
+
+	report := history.report("default/service1", historyFilter{})
+	assert.Len(t, report.Samples, 3)
+	assert.Len(t, report.Transitions, 1)
+}
+
+func newHistoryBreakdown(healthyPods []string, failingPods podFailures) checkBreakdown {
+	pods := append([]string(nil), healthyPods...)
+	for _, failure := range failingPods {
+		pods = append(pods, failure.Pod)
+	}
+	return checkBreakdown{failingPods: failingPods, probedPods: pods}
+}
+
+func TestHealthHistoryRecordsTransitions(t *testing.T) {
+	history := newHealthHistory(historyLimits{transitions: 10, samples: 10, samplePeriod: time.Minute})
+	start := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+	timeout := podFailure{Pod: "pod-b", Reason: string(probeTimeout), Error: "i/o timeout"}
+
+	history.record("default/service1", fthealth.CheckResult{Ok: true, CheckOutput: "2/2 pods available"},
+		newHistoryBreakdown([]string{"pod-a", "pod-b"}, nil), start)
+	history.record("default/service1", fthealth.CheckResult{Ok: false, Severity: 2, CheckOutput: "1/2 pods available - pod-b: timeout"},
+		newHistoryBreakdown([]string{"pod-a"}, podFailures{timeout}), start.Add(30*time.Second))
+	history.record("default/service1", fthealth.CheckResult{Ok: true, CheckOutput: "2/2 pods available"},
+		newHistoryBreakdown([]string{"pod-a", "pod-c"}, nil), start.Add(2*time.Minute))
+
+	report := history.report("default/service1", historyFilter{})
+	assert.Equal(t, "ok", report.Status)
+	assert.Equal(t, start.Add(2*time.Minute), *report.Since)
+	assert.Equal(t, 2, report.Flaps)
+	assert.Equal(t, []healthTransition{
+		{Time: start, To: "ok", Output: "2/2 pods available"},
+		{Time: start.Add(30 * time.Second), From: "ok", To: "warning", Output: "1/2 pods available - pod-b: timeout"},
+		{Time: start.Add(30 * time.Second), Pod: "pod-b", From: "ok", To: "timeout", Output: "i/o timeout"},
+		{Time: start.Add(2 * time.Minute), From: "warning", To: "ok", Output: "2/2 pods available"},
+		{Time: start.Add(2 * time.Minute), Pod: "pod-b", From: "timeout", To: podGone},
+	}, report.Transitions, "Healthy pods showing up or going away should not be recorded")
+
+	assert.Equal(t, []healthSample{
+		{Time: start, Status: "ok", Output: "2/2 pods available", FailingPods: []string{}},
+		{Time: start.Add(2 * time.Minute), Status: "ok", Output: "2/2 pods available", FailingPods: []string{}},
+	}, report.Samples, "A single sample should be kept per sample period")
+}
+
+func TestHealthHistoryRecordsTheProbedPods(t *testing.T) {
+	history := newHealthHistory(historyLimits{transitions: 10, samples: 10})
+	start := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+	failing := newHistoryBreakdown([]string{"pod-a"}, podFailures{{Pod: "pod-b", Reason: string(probeTimeout)}})
+	history.record("default/service1", fthealth.CheckResult{Ok: false}, failing, start)
+
+	recovered := checkBreakdown{probedPods: []string{"pod-a", "pod-b"}, versions: versionStatus{Versions: []versionPods{{Pods: []string{"pod-a"}}}}}
+	history.record("default/service1", fthealth.CheckResult{Ok: true}, recovered, start.Add(time.Minute))
+
+	report := history.report("default/service1", historyFilter{podName: "pod-b"})
+	assert.Equal(t, []healthTransition{
+		{Time: start, Pod: "pod-b", To: "timeout"},
+		{Time: start.Add(time.Minute), Pod: "pod-b", From: "timeout", To: "ok"},
+	}, report.Transitions, "The pods should be taken from the probed pods rather than from their versions")
+}
+
+func TestHealthHistoryIsBounded(t *testing.T) {
+	history := newHealthHistory(historyLimits{transitions: 3, samples: 2})
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		history.record("default/service1", fthealth.CheckResult{Ok: i%2 == 0}, checkBreakdown{}, start.Add(time.Duration(i)*time.Minute))
+	}
+
+	report := history.report("default/service1", historyFilter{})
+	assert.Len(t, report.Transitions, 3)
+	assert.Equal(t, start.Add(9*time.Minute), report.Transitions[2].Time)
+	assert.Len(t, report.Samples, 2)
+	assert.Equal(t, start.Add(9*time.Minute), report.Samples[1].Time)
+}
+
+func TestHealthHistoryReportFilters(t *testing.T) {
+	history := newHealthHistory(historyLimits{transitions: 10, samples: 10})
+	start := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+	failingPods := podFailures{{Pod: "pod-a", Reason: failingChecksReason}, {Pod: "pod-b", Reason: string(probeTimeout)}}
+	history.record("default/service1", fthealth.CheckResult{Ok: true}, newHistoryBreakdown([]string{"pod-a", "pod-b"}, nil), start)
+	history.record("default/service1", fthealth.CheckResult{Ok: false}, newHistoryBreakdown(nil, failingPods), start.Add(time.Hour))
+	history.record("default/service1", fthealth.CheckResult{Ok: true}, newHistoryBreakdown([]string{"pod-a", "pod-b"}, nil), start.Add(2*time.Hour))
+
+	report := history.report("default/service1", historyFilter{from: start.Add(30 * time.Minute), to: start.Add(90 * time.Minute)})
+	assert.Equal(t, start.Add(30*time.Minute), *report.From)
+	assert.Equal(t, start.Add(90*time.Minute), *report.To)
+	assert.Equal(t, 1, report.Flaps)
+	assert.Len(t, report.Transitions, 3)
+	assert.Len(t, report.Samples, 1)
+
+	report = history.report("default/service1", historyFilter{podName: "pod-b"})
+	assert.Equal(t, []healthTransition{
+		{Time: start.Add(time.Hour), Pod: "pod-b", From: "ok", To: "timeout"},
+		{Time: start.Add(2 * time.Hour), Pod: "pod-b", From: "timeout", To: "ok"},
+	}, report.Transitions)
+
+	report = history.report("default/service2", historyFilter{})
+	assert.Equal(t, historyReport{Service: "default/service2", Transitions: []healthTransition{}, Samples: []healthSample{}}, report,
+		"A service without a result should have an empty history")
+}
+
+func TestResultStoreRecordsHistory(t *testing.T) {
+	history := newHealthHistory(historyLimits{transitions: 10, samples: 10})
+	store := newResultStore(history)
+	store.set("default/service1", fthealth.CheckResult{Ok: true}, checkBreakdown{quorum: quorumStatus{Degraded: true}})
+
+	assert.Equal(t, "degraded", history.report("default/service1", historyFilter{}).Status)
+	assert.Len(t, history.timelines([]string{"default/service1", "default/service2"}, timelineSamples), 1)
+
+	store.delete("default/service1")
+	assert.Empty(t, history.report("default/service1", historyFilter{}).Transitions, "The history of a removed service should be dropped")
+}
+
+func TestHealthHistoryTimelines(t *testing.T) {
+	history := newHealthHistory(historyLimits{transitions: 10, samples: 10})
+	start := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		history.record("default/service1", fthealth.CheckResult{Ok: i != 3}, checkBreakdown{}, start.Add(time.Duration(i)*time.Minute))
+	}
+
+	timeline := getTimeline(history.timelines([]string{"default/service1"}, 3)["default/service1"])
+	assert.Equal(t, []timelineSlot{
+		{Color: "green", Title: "2024-05-02 10:02:00 UTC: ok"},
+		{Color: "red", Title: "2024-05-02 10:03:00 UTC: critical"},
+		{Color: "green", Title: "2024-05-02 10:04:00 UTC: ok"},
+	}, timeline)
+}
+
+func TestParseHistoryFilter(t *testing.T) {
+	now := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+	parse := func(query string) (historyFilter, error) {
+		requestURL, err := url.Parse("/__history?" + query)
+		require.NoError(t, err)
+		return parseHistoryFilter(requestURL, now)
+	}
+
+	filter, err := parse("service-name=service1&from=2024-05-01T10:00:00Z&to=2024-05-02T09:00:00Z&pod-name=pod-a")
+	assert.NoError(t, err)
+	assert.Equal(t, historyFilter{from: now.Add(-24 * time.Hour), to: now.Add(-time.Hour), podName: "pod-a"}, filter)
+
+	filter, err = parse("service-name=service1&since=6h")
+	assert.NoError(t, err)
+	assert.Equal(t, historyFilter{from: now.Add(-6 * time.Hour)}, filter)
+
+	for _, invalid := range []string{"from=yesterday", "to=2024-05-02", "since=-1h", "since=1h&from=2024-05-01T10:00:00Z",
+		"from=2024-05-02T09:00:00Z&to=2024-05-01T09:00:00Z"} {
+		_, err = parse(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestHandleServiceHistory(t *testing.T) {
+	aggHealthCheckHandler := initializeTestHandler()
+	handler := http.HandlerFunc(aggHealthCheckHandler.handleServiceHistory)
+
+	for _, test := range []struct {
+		query          string
+		expectedStatus int
+	}{
+		{"", http.StatusBadRequest},
+		{fmt.Sprintf("?service-name=%s&to=yesterday", validServiceName), http.StatusBadRequest},
+		{fmt.Sprintf("?service-name=%s", nonExistingServiceName), http.StatusNotFound},
+		{fmt.Sprintf("?service-name=%s&since=1h", validServiceName), http.StatusOK},
+	} {
+		req, err := http.NewRequest("GET", "/__history"+test.query, nil)
+		require.NoError(t, err)
+		respRecorder := httptest.NewRecorder()
+		handler.ServeHTTP(respRecorder, req)
+		assert.Equal(t, test.expectedStatus, respRecorder.Code, test.query)
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/__history?service-name=%s&since=1h", validServiceName), nil)
+	require.NoError(t, err)
+	respRecorder := httptest.NewRecorder()
+	handler.ServeHTTP(respRecorder, req)
+
+	var report historyReport
+	assert.NoError(t, json.Unmarshal(respRecorder.Body.Bytes(), &report))
+	assert.Equal(t, validServiceName, report.Service)
+	assert.NotNil(t, report.From)
+	assert.Equal(t, jsonContentType, respRecorder.Header().Get("Content-Type"))
+}
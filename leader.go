@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Financial-Times/go-logger"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	defaultLeaseName      = "upp-aggregate-healthcheck"
+	leaseDuration         = 15 * time.Second
+	leaseRenewDeadline    = 10 * time.Second
+	leaseRetryPeriod      = 2 * time.Second
+	leaderElectionBackoff = 5 * time.Second
+)
+
+type leaderElectionConfig struct {
+	enabled   bool
+	namespace string
+	leaseName string
+	identity  string
+}
+
+// leaderStatus describes the replica and its leadership, as exposed on the health pages.
+type leaderStatus struct {
+	Identity       string `json:"identity"`
+	ElectionActive bool   `json:"leaderElection"`
+	IsLeader       bool   `json:"leader"`
+}
+
+// leaderElector tells whether this replica is the leader, which is the only one allowed to perform state-changing
+// actions such as disabling sticky categories or reaping acks. All the replicas keep serving reads.
+// Without leader election every replica is the leader, as when a single replica is deployed.
+type leaderElector struct {
+	config  leaderElectionConfig
+	leading atomic.Bool
+}
+
+func newLeaderElector(config leaderElectionConfig) *leaderElector {
+	le := &leaderElector{config: config}
+	le.leading.Store(!config.enabled)
+	return le
+}
+
+func (le *leaderElector) isLeader() bool {
+	return le.leading.Load()
+}
+
+func (le *leaderElector) status() leaderStatus {
+	return leaderStatus{
+		Identity:       le.config.identity,
+		ElectionActive: le.config.enabled,
+		IsLeader:       le.isLeader(),
+	}
+}
+
+// run takes part in the election of the leader through a Lease until the context is done. A replica that loses the
+// leadership, e.g. because it could not renew the Lease in time, stands for election again.
+func (le *leaderElector) run(ctx context.Context, k8sClient kubernetes.Interface) {
+	if !le.config.enabled {
+		return
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: k8smeta.ObjectMeta{
+			Name:      le.config.leaseName,
+			Namespace: le.config.namespace,
+		},
+		Client:     k8sClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: le.config.identity},
+	}
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   leaseRenewDeadline,
+		RetryPeriod:     leaseRetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            le.config.leaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) {
+				log.Infof("Replica %s started leading", le.config.identity)
+				le.leading.Store(true)
+			},
+			OnStoppedLeading: func() {
+				log.Infof("Replica %s stopped leading", le.config.identity)
+				le.leading.Store(false)
+			},
+			OnNewLeader: func(identity string) {
+				if identity != le.config.identity {
+					log.Infof("Replica %s is the leader", identity)
+				}
+			},
+		},
+	})
+	if err != nil {
+		log.WithError(err).Error("Cannot set up leader election, this replica will never lead")
+		return
+	}
+
+	for {
+		elector.Run(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(leaderElectionBackoff):
+		}
+	}
+}
+
+// getReplicaIdentity returns the identity of the replica in the leader election: the pod name, or else the host name.
+func getReplicaIdentity(podName string) string {
+	if podName != "" {
+		return podName
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.WithError(err).Warn("Cannot get the host name, using the app name as leader election identity")
+		return defaultLeaseName
+	}
+	return hostname
+}
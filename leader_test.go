@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLeaderElectorWithoutElectionIsLeader(t *testing.T) {
+	le := newLeaderElector(leaderElectionConfig{identity: "replica-a"})
+	assert.True(t, le.isLeader())
+	assert.Equal(t, leaderStatus{Identity: "replica-a", IsLeader: true}, le.status())
+
+	// run returns straight away when there is no election
+	le.run(context.Background(), fake.NewSimpleClientset())
+	assert.True(t, le.isLeader())
+}
+
+func TestLeaderElectionElectsASingleLeader(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset()
+	config := leaderElectionConfig{enabled: true, namespace: "default", leaseName: defaultLeaseName}
+
+	config.identity = "replica-a"
+	first := newLeaderElector(config)
+	assert.False(t, first.isLeader(), "A replica should not lead before it is elected")
+	firstCtx, stopFirst := context.WithCancel(context.Background())
+	firstDone := make(chan struct{})
+	go func() {
+		first.run(firstCtx, k8sClient)
+		close(firstDone)
+	}()
+	assert.Eventually(t, first.isLeader, 5*time.Second, 10*time.Millisecond)
+
+	config.identity = "replica-b"
+	second := newLeaderElector(config)
+	secondCtx, stopSecond := context.WithCancel(context.Background())
+	defer stopSecond()
+	go second.run(secondCtx, k8sClient)
+	assert.Never(t, second.isLeader, 500*time.Millisecond, 10*time.Millisecond, "Only one replica should lead")
+
+	// the leader releases the Lease when it stops, so another replica takes over
+	stopFirst()
+	<-firstDone
+	assert.False(t, first.isLeader())
+	assert.Eventually(t, second.isLeader, 10*time.Second, 10*time.Millisecond)
+}
+
+func TestGetReplicaIdentity(t *testing.T) {
+	assert.Equal(t, "upp-aggregate-healthcheck-5d8f9-x2x4z", getReplicaIdentity("upp-aggregate-healthcheck-5d8f9-x2x4z"))
+	assert.NotEmpty(t, getReplicaIdentity(""))
+}
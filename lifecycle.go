@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/Financial-Times/go-logger"
+	k8score "k8s.io/api/core/v1"
+)
+
+const (
+	// startupGraceAnnotation replaces the startup grace period of the pods of a service, as a duration, e.g. "90s"
+	startupGraceAnnotation = "aggregate-healthcheck.ft.com/startup-grace"
+	defaultStartupGrace    = 60 // In seconds
+)
+
+// podLifecycle is where a pod is in its lifecycle, as seen by Kubernetes.
+type podLifecycle struct {
+	phase k8score.PodPhase
+	// terminating is set once the pod has been deleted, while its containers are stopping
+	terminating bool
+	// startTime is when the pod was started by its node, or when it was created if it has not been scheduled yet
+	startTime time.Time
+	// restarts is the number of restarts of all the containers of the pod
+	restarts int32
+	// waiting lists the containers of the pod that are waiting, in the order of the containers
+	waiting []containerWaiting
+}
+
+// containerWaiting is a container of a pod waiting for something, e.g. to be restarted after crashing.
+type containerWaiting struct {
+	container string
+	reason    string
+	restarts  int32
+}
+
+// The reasons containers wait for that will not resolve by themselves, by the kind of failure they are reported as.
+var containerWaitingFailures = map[string]probeFailure{
+	"CrashLoopBackOff":           probeCrashLoop,
+	"RunContainerError":          probeCrashLoop,
+	"ErrImagePull":               probeImagePull,
+	"ImagePullBackOff":           probeImagePull,
+	"InvalidImageName":           probeImagePull,
+	"CreateContainerConfigError": probeContainerError,
+	"CreateContainerError":       probeContainerError,
+}
+
+func getPodLifecycle(k8sPod k8score.Pod) podLifecycle {
+	lifecycle := podLifecycle{
+		phase:       k8sPod.Status.Phase,
+		terminating: k8sPod.DeletionTimestamp != nil,
+		startTime:   k8sPod.CreationTimestamp.Time,
+	}
+	if k8sPod.Status.StartTime != nil {
+		lifecycle.startTime = k8sPod.Status.StartTime.Time
+	}
+
+	statuses := append(append([]k8score.ContainerStatus{}, k8sPod.Status.InitContainerStatuses...), k8sPod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		lifecycle.restarts += status.RestartCount
+		if status.State.Waiting != nil && status.State.Waiting.Reason != "" {
+			lifecycle.waiting = append(lifecycle.waiting, containerWaiting{
+				container: status.Name,
+				reason:    status.State.Waiting.Reason,
+				restarts:  status.RestartCount,
+			})
+		}
+	}
+	return lifecycle
+}
+
+// isGone tells whether the pod is on its way out, terminating or finished, in which case it is no longer one of the pods of its service.
+func (l podLifecycle) isGone() bool {
+	return l.terminating || l.phase == k8score.PodSucceeded || l.phase == k8score.PodFailed
+}
+
+// isStarting tells whether the pod was started less than the grace period ago.
+func (l podLifecycle) isStarting(now time.Time, grace time.Duration) bool {
+	return !l.startTime.IsZero() && now.Sub(l.startTime) < grace
+}
+
+// containerFailure returns the failure of the first container of the pod that waits for a reason that will not resolve by itself,
+// e.g. a crash loop or an image that cannot be pulled.
+func (l podLifecycle) containerFailure() *probeError {
+	for _, waiting := range l.waiting {
+		if failure, ok := containerWaitingFailures[waiting.reason]; ok {
+			return &probeError{
+				failure: failure,
+				msg:     fmt.Sprintf("container %s is waiting: %s after %d restarts", waiting.container, waiting.reason, waiting.restarts),
+			}
+		}
+	}
+	return nil
+}
+
+// phaseFailure fails the pods that are not running, and so cannot be probed. The pods with an unknown phase are probed.
+func (l podLifecycle) phaseFailure() *probeError {
+	if l.phase == "" || l.phase == k8score.PodRunning {
+		return nil
+	}
+
+	msg := fmt.Sprintf("pod is %s", l.phase)
+	if len(l.waiting) != 0 {
+		reasons := make([]string, 0, len(l.waiting))
+		for _, waiting := range l.waiting {
+			reasons = append(reasons, fmt.Sprintf("%s: %s", waiting.container, waiting.reason))
+		}
+		msg = fmt.Sprintf("%s, waiting containers are %s", msg, strings.Join(reasons, ", "))
+	}
+	return &probeError{failure: probeNotRunning, msg: msg}
+}
+
+// getStartupGrace returns the startup grace period set on the service by its annotation, or nil for the default one.
+func getStartupGrace(k8sService *k8score.Service) *time.Duration {
+	value, ok := k8sService.Annotations[startupGraceAnnotation]
+	if !ok {
+		return nil
+	}
+
+	grace, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		log.WithError(err).Warnf("Cannot parse the startup grace period of service with name %s, using the default one.", k8sService.Name)
+		return nil
+	}
+	if grace < 0 {
+		log.Warnf("Negative startup grace period %v for service with name %s, using the default one.", grace, k8sService.Name)
+		return nil
+	}
+	return &grace
+}
+
+// startupGraceFor returns how long the pods of the service are given to become ready after they start, before they are probed.
+func (hs *k8sHealthcheckService) startupGraceFor(service service) time.Duration {
+	if service.startupGrace != nil {
+		return *service.startupGrace
+	}
+	return hs.startupGrace
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	k8score "k8s.io/api/core/v1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetPodLifecycle(t *testing.T) {
+	started := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	deleted := k8smeta.NewTime(started.Add(time.Hour))
+	k8sPod := k8score.Pod{
+		ObjectMeta: k8smeta.ObjectMeta{CreationTimestamp: k8smeta.NewTime(started.Add(-time.Minute)), DeletionTimestamp: &deleted},
+		Status: k8score.PodStatus{
+			Phase:     k8score.PodRunning,
+			StartTime: &k8smeta.Time{Time: started},
+			InitContainerStatuses: []k8score.ContainerStatus{
+				{Name: "init", RestartCount: 1},
+			},
+			ContainerStatuses: []k8score.ContainerStatus{
+				{Name: "app", RestartCount: 5, State: k8score.ContainerState{Waiting: &k8score.ContainerStateWaiting{Reason: "CrashLoopBackOff"}}},
+				{Name: "sidecar", State: k8score.ContainerState{Running: &k8score.ContainerStateRunning{}}},
+			},
+		},
+	}
+
+	assert.Equal(t, podLifecycle{
+		phase:       k8score.PodRunning,
+		terminating: true,
+		startTime:   started,
+		restarts:    6,
+		waiting:     []containerWaiting{{container: "app", reason: "CrashLoopBackOff", restarts: 5}},
+	}, getPodLifecycle(k8sPod))
+}
+
+func TestPodLifecycleFailures(t *testing.T) {
+	tests := []struct {
+		reason          string
+		expectedFailure probeFailure
+	}{
+		{reason: "CrashLoopBackOff", expectedFailure: probeCrashLoop},
+		{reason: "ImagePullBackOff", expectedFailure: probeImagePull},
+		{reason: "ErrImagePull", expectedFailure: probeImagePull},
+		{reason: "CreateContainerConfigError", expectedFailure: probeContainerError},
+	}
+	for _, test := range tests {
+		lifecycle := podLifecycle{phase: k8score.PodRunning, waiting: []containerWaiting{{container: "app", reason: test.reason, restarts: 2}}}
+		err := lifecycle.containerFailure()
+		require.NotNil(t, err, test.reason)
+		assert.Equal(t, test.expectedFailure, err.failure, test.reason)
+		assert.Equal(t, "container app is waiting: "+test.reason+" after 2 restarts", err.Error())
+	}
+
+	assert.Nil(t, podLifecycle{waiting: []containerWaiting{{container: "app", reason: "ContainerCreating"}}}.containerFailure())
+
+	pending := podLifecycle{phase: k8score.PodPending, waiting: []containerWaiting{{container: "app", reason: "ContainerCreating"}}}
+	assert.EqualError(t, pending.phaseFailure(), "pod is Pending, waiting containers are app: ContainerCreating")
+	assert.Nil(t, podLifecycle{phase: k8score.PodRunning}.phaseFailure())
+	assert.Nil(t, podLifecycle{}.phaseFailure(), "A pod of unknown phase should be probed")
+}
+
+func TestPodLifecycleIsGone(t *testing.T) {
+	assert.True(t, podLifecycle{phase: k8score.PodRunning, terminating: true}.isGone())
+	assert.True(t, podLifecycle{phase: k8score.PodFailed}.isGone())
+	assert.True(t, podLifecycle{phase: k8score.PodSucceeded}.isGone())
+	assert.False(t, podLifecycle{phase: k8score.PodPending}.isGone())
+}
+
+func TestGetStartupGrace(t *testing.T) {
+	newK8sService := func(annotations map[string]string) *k8score.Service {
+		return &k8score.Service{ObjectMeta: k8smeta.ObjectMeta{Name: "service1", Annotations: annotations}}
+	}
+
+	assert.Nil(t, getStartupGrace(newK8sService(nil)))
+	grace := 90 * time.Second
+	assert.Equal(t, &grace, getStartupGrace(newK8sService(map[string]string{startupGraceAnnotation: "90s"})))
+	assert.Nil(t, getStartupGrace(newK8sService(map[string]string{startupGraceAnnotation: "90"})))
+	assert.Nil(t, getStartupGrace(newK8sService(map[string]string{startupGraceAnnotation: "-30s"})), "A negative grace period should be ignored")
+
+	hs := &k8sHealthcheckService{startupGrace: time.Minute}
+	assert.Equal(t, time.Minute, hs.startupGraceFor(service{}))
+	assert.Equal(t, grace, hs.startupGraceFor(service{startupGrace: &grace}))
+}
+
+func TestProbePodOfPodsThatCannotBeProbed(t *testing.T) {
+	client := &mockHTTPClient{doFunc: func(req *http.Request) (*http.Response, error) {
+		t.Errorf("The pod should not be probed, but %s was requested", req.URL)
+		return nil, context.Canceled
+	}}
+	hs := &k8sHealthcheckService{httpClient: client, startupGrace: time.Minute}
+	now := time.Now()
+
+	crashLooping := pod{name: "pod1", lifecycle: podLifecycle{
+		phase:     k8score.PodRunning,
+		startTime: now,
+		restarts:  3,
+		waiting:   []containerWaiting{{container: "app", reason: "CrashLoopBackOff", restarts: 3}},
+	}}
+	probe := hs.probePod(context.Background(), crashLooping, service{})
+	assert.Equal(t, probeCrashLoop, getProbeFailure(probe.err), "A crash loop should be reported even within the startup grace period")
+
+	starting := pod{name: "pod1", lifecycle: podLifecycle{phase: k8score.PodPending, startTime: now.Add(-10 * time.Second)}}
+	probe = hs.probePod(context.Background(), starting, service{})
+	assert.True(t, probe.starting)
+	output, err := probe.status(now)
+	assert.NoError(t, err)
+	assert.Equal(t, "starting, not probed yet", output)
+
+	pending := pod{name: "pod1", lifecycle: podLifecycle{phase: k8score.PodPending, startTime: now.Add(-10 * time.Minute)}}
+	probe = hs.probePod(context.Background(), pending, service{})
+	assert.Equal(t, probeNotRunning, getProbeFailure(probe.err))
+
+	noGrace := time.Duration(0)
+	probe = hs.probePod(context.Background(), starting, service{startupGrace: &noGrace})
+	assert.False(t, probe.starting)
+	assert.Equal(t, probeNotRunning, getProbeFailure(probe.err))
+}
+
+func TestGetPodsForServiceExcludesGonePods(t *testing.T) {
+	deleted := k8smeta.Now()
+	newK8sPod := func(name string, phase k8score.PodPhase, deletionTimestamp *k8smeta.Time) *k8score.Pod {
+		return &k8score.Pod{
+			ObjectMeta: k8smeta.ObjectMeta{
+				Name:              name,
+				Namespace:         k8score.NamespaceDefault,
+				Labels:            map[string]string{"app": "service1"},
+				DeletionTimestamp: deletionTimestamp,
+				Finalizers:        []string{"test"},
+			},
+			Status: k8score.PodStatus{Phase: phase},
+		}
+	}
+	hs := initializeMockService(t, nil,
+		newK8sPod("running", k8score.PodRunning, nil),
+		newK8sPod("pending", k8score.PodPending, nil),
+		newK8sPod("terminating", k8score.PodRunning, &deleted),
+		newK8sPod("evicted", k8score.PodFailed, nil),
+	)
+
+	pods, err := hs.getPodsForService(context.Background(), service{name: "service1", namespace: k8score.NamespaceDefault})
+	assert.NoError(t, err)
+	require.Len(t, pods, 2)
+	assert.Equal(t, "pending", pods[0].name)
+	assert.Equal(t, "running", pods[1].name)
+}
+
+func TestCheckServiceHealthWithStartingPods(t *testing.T) {
+	workloads := map[string]workload{"default/service1": {desiredReplicas: 3}}
+	probe := newQuorumServiceProbe(nil, 2, 0)
+	probe.pods = append(probe.pods, podProbe{pod: pod{name: "new-pod"}, starting: true})
+
+	output, err := checkServiceHealth(probe, workloads, time.Now())
+	assert.NoError(t, err, "A starting pod should not make its service fail")
+	assert.Equal(t, "2/2 pods available, 1 starting, degraded", output)
+
+	probe = newQuorumServiceProbe(nil, 0, 2)
+	probe.service.isResilient = true
+	probe.pods = append(probe.pods, podProbe{pod: pod{name: "new-pod"}, starting: true})
+	assert.Equal(t, uint8(1), getSeverityForService(probe, time.Now()), "A starting pod should not count as a healthy pod of a resilient service")
+}
+
+func TestFailingPodsSummaryWithRestarts(t *testing.T) {
+	failures := podFailures{{Pod: "pod-a", Reason: string(probeCrashLoop), Restarts: 5}}
+	assert.Equal(t, "pod-a: crash loop after 5 restarts", failures.summary())
+}
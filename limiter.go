@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+const (
+	defaultPodChecksPerService = 8
+	defaultMaxPodChecks        = 64
+	defaultMaxOutboundRequests = 64
+)
+
+// checkLimits bounds the work done at the same time to check the pods. A limit of 0 or less disables it.
+type checkLimits struct {
+	// podChecksPerService is the number of pods of a service checked at the same time
+	podChecksPerService int
+	// podChecks is the number of pods checked at the same time across all services
+	podChecks int
+	// outboundRequests is the number of requests to the pods in flight at the same time, whether they are made
+	// by the scheduled checks, the uncached requests or the severity lookups
+	outboundRequests int
+}
+
+// limiter bounds the number of operations running at the same time. A nil limiter does not bound anything.
+type limiter struct {
+	slots chan struct{}
+}
+
+func newLimiter(size int) *limiter {
+	if size <= 0 {
+		return nil
+	}
+	return &limiter{slots: make(chan struct{}, size)}
+}
+
+// acquire waits for a free slot, unless the context is done first.
+func (l *limiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *limiter) release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
+
+// limitedHTTPClient holds a slot of the limiter for every request, from the moment it is sent until its response body is closed.
+type limitedHTTPClient struct {
+	client  httpClient
+	limiter *limiter
+}
+
+func newLimitedHTTPClient(client httpClient, l *limiter) httpClient {
+	if l == nil {
+		return client
+	}
+	return &limitedHTTPClient{client: client, limiter: l}
+}
+
+func (c *limitedHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	if err := c.limiter.acquire(req.Context()); err != nil {
+		return nil, fmt.Errorf("cannot send request to %s: %w", req.URL.Host, err)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		c.limiter.release()
+		return nil, err
+	}
+	if resp.Body == nil {
+		c.limiter.release()
+		return resp, nil
+	}
+
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: c.limiter.release}
+	return resp, nil
+}
+
+// releasingBody releases the slot of its request once, when it is closed.
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
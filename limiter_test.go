@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// concurrencyRecordingHTTPClient responds after a delay, and records the highest number of requests in flight at the same time.
+type concurrencyRecordingHTTPClient struct {
+	delay       time.Duration
+	inFlight    atomic.Int32
+	maxInFlight atomic.Int32
+	respond     func(req *http.Request) (*http.Response, error)
+}
+
+func (c *concurrencyRecordingHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	recordMax(&c.maxInFlight, c.inFlight.Add(1))
+	defer c.inFlight.Add(-1)
+
+	time.Sleep(c.delay)
+	return c.respond(req)
+}
+
+func recordMax(highest *atomic.Int32, current int32) {
+	for {
+		previous := highest.Load()
+		if current <= previous || highest.CompareAndSwap(previous, current) {
+			return
+		}
+	}
+}
+
+func newHealthyResponse(_ *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(bytes.NewBufferString(`{"checks":[{"name":"check","ok":true}]}`)),
+	}, nil
+}
+
+func TestLimiterBoundsConcurrentOperations(t *testing.T) {
+	l := newLimiter(2)
+	var inFlight, maxInFlight atomic.Int32
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, l.acquire(context.Background()))
+			defer l.release()
+
+			recordMax(&maxInFlight, inFlight.Add(1))
+			time.Sleep(5 * time.Millisecond)
+			inFlight.Add(-1)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(2), maxInFlight.Load())
+}
+
+func TestLimiterAcquireStopsWhenTheContextIsDone(t *testing.T) {
+	l := newLimiter(1)
+	assert.NoError(t, l.acquire(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, l.acquire(ctx), context.DeadlineExceeded)
+}
+
+func TestNilLimiterDoesNotBound(t *testing.T) {
+	l := newLimiter(0)
+	assert.Nil(t, l)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, l.acquire(context.Background()))
+	}
+	l.release()
+}
+
+func TestLimitedHTTPClientHoldsSlotUntilBodyIsClosed(t *testing.T) {
+	client := newLimitedHTTPClient(&mockHTTPClient{doFunc: newHealthyResponse}, newLimiter(1))
+	req := httptest.NewRequest(http.MethodGet, "http://10.2.3.4:8080/__health", nil)
+
+	resp, err := client.Do(req)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = client.Do(req.WithContext(ctx))
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "The slot should be held while the body is open")
+
+	assert.NoError(t, resp.Body.Close())
+	assert.NoError(t, resp.Body.Close(), "Closing the body twice should not release the slot twice")
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+}
+
+func TestLimitedHTTPClientReleasesSlotOnError(t *testing.T) {
+	client := newLimitedHTTPClient(&mockHTTPClient{doFunc: func(_ *http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	}}, newLimiter(1))
+	req := httptest.NewRequest(http.MethodGet, "http://10.2.3.4:8080/__health", nil)
+
+	for i := 0; i < 3; i++ {
+		_, err := client.Do(req)
+		assert.EqualError(t, err, "connection refused")
+	}
+}
+
+func TestProbePodsRunsInParallelWithinTheServiceLimit(t *testing.T) {
+	client := &concurrencyRecordingHTTPClient{delay: 20 * time.Millisecond, respond: func(req *http.Request) (*http.Response, error) {
+		if req.URL.Hostname() == "10.0.0.3" {
+			return nil, errors.New("connection refused")
+		}
+		return newHealthyResponse(req)
+	}}
+	hcService := &k8sHealthcheckService{httpClient: client, podChecksPerService: 3}
+	pods := []pod{{name: "pod-1", ip: "10.0.0.1"}, {name: "pod-2", ip: "10.0.0.2"}, {name: "pod-3", ip: "10.0.0.3"},
+		{name: "pod-4", ip: "10.0.0.4"}, {name: "pod-5", ip: "10.0.0.5"}, {name: "pod-6", ip: "10.0.0.6"}}
+
+	probes := hcService.probePods(context.Background(), pods, service{appPort: 8080})
+
+	assert.Len(t, probes, len(pods))
+	for i, probe := range probes {
+		assert.Equal(t, pods[i], probe.pod, "The probes should be in the order of the pods")
+		if pods[i].name == "pod-3" {
+			assert.Error(t, probe.err)
+		} else {
+			assert.NoError(t, probe.err, pods[i].name)
+		}
+	}
+	assert.Equal(t, int32(3), client.maxInFlight.Load())
+}
+
+func TestProbePodsIsBoundedByTheGlobalLimit(t *testing.T) {
+	client := &concurrencyRecordingHTTPClient{delay: 10 * time.Millisecond, respond: newHealthyResponse}
+	hcService := &k8sHealthcheckService{httpClient: client, podChecksPerService: 4, podChecks: newLimiter(3)}
+	pods := []pod{{name: "pod-1"}, {name: "pod-2"}, {name: "pod-3"}, {name: "pod-4"}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, probe := range hcService.probePods(context.Background(), pods, service{appPort: 8080}) {
+				assert.NoError(t, probe.err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, client.maxInFlight.Load(), int32(3))
+}
+
+func TestRunPodChecksForIsBoundedByTheServiceLimit(t *testing.T) {
+	objects := []runtime.Object{newTestService("service1", apiv1.NamespaceDefault)}
+	for i := 1; i <= 6; i++ {
+		p := newTestPod(fmt.Sprintf("service1-pod-%d", i), "service1")
+		p.Status = apiv1.PodStatus{
+			Phase:      apiv1.PodRunning,
+			PodIP:      fmt.Sprintf("10.0.0.%d", i),
+			Conditions: []apiv1.PodCondition{{Type: apiv1.PodReady, Status: apiv1.ConditionTrue}},
+		}
+		objects = append(objects, p)
+	}
+	hcService := initializeMockService(t, nil, objects...)
+	client := &concurrencyRecordingHTTPClient{delay: 20 * time.Millisecond, respond: newHealthyResponse}
+	hcService.httpClient = client
+	hcService.podChecksPerService = 2
+	controller := &healthCheckController{healthCheckService: hcService, results: newResultStore(nil)}
+
+	require.Eventually(t, func() bool {
+		s, err := hcService.getServiceByName("service1")
+		if err != nil {
+			return false
+		}
+		pods, err := hcService.getPodsForService(context.Background(), s)
+		return err == nil && len(pods) == 6
+	}, 5*time.Second, 10*time.Millisecond)
+
+	checks, _, err := controller.runPodChecksFor(context.Background(), "service1")
+	require.NoError(t, err)
+	assert.Len(t, checks, 6)
+	for _, check := range checks {
+		assert.True(t, check.Ok, check.Name)
+	}
+	assert.Equal(t, int32(2), client.maxInFlight.Load(), "The pods of a service should be checked within the limit of the scheduled checks")
+}
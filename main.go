@@ -68,17 +68,152 @@ func main() {
 	healthcheckCooldown := app.Int(cli.IntOpt{
 		Name:   "healthcheck-cooldown",
 		Value:  5,
-		Desc:   "Seconds to wait before retrying healthcheck",
+		Desc:   "Seconds to wait before the first retry of a health check; the wait doubles with every retry",
 		EnvVar: "HEALTHCHECK_COOLDOWN",
 	})
 
+	healthcheckMaxBackoff := app.Int(cli.IntOpt{
+		Name:   "healthcheck-max-backoff",
+		Value:  defaultHealthcheckMaxBackoff,
+		Desc:   "Maximum number of seconds to wait before retrying a health check",
+		EnvVar: "HEALTHCHECK_MAX_BACKOFF",
+	})
+
+	healthcheckJitter := app.Int(cli.IntOpt{
+		Name:   "healthcheck-backoff-jitter",
+		Value:  defaultHealthcheckJitter,
+		Desc:   "Percentage of the wait before retrying a health check that is randomly added or taken away",
+		EnvVar: "HEALTHCHECK_BACKOFF_JITTER",
+	})
+
+	healthcheckMaxElapsed := app.Int(cli.IntOpt{
+		Name:   "healthcheck-max-elapsed",
+		Value:  defaultHealthcheckMaxElapsed,
+		Desc:   "Maximum number of seconds spent on the attempts of a health check, after which it is not retried anymore (0 for no limit)",
+		EnvVar: "HEALTHCHECK_MAX_ELAPSED",
+	})
+
+	schedulerWorkers := app.Int(cli.IntOpt{
+		Name:   "scheduler-workers",
+		Value:  defaultSchedulerWorkers,
+		Desc:   "Maximum number of scheduled service checks running at the same time",
+		EnvVar: "SCHEDULER_WORKERS",
+	})
+
+	podChecksPerService := app.Int(cli.IntOpt{
+		Name:   "pod-checks-per-service",
+		Value:  defaultPodChecksPerService,
+		Desc:   "Maximum number of pods of a service checked at the same time (0 for no limit)",
+		EnvVar: "POD_CHECKS_PER_SERVICE",
+	})
+
+	maxPodChecks := app.Int(cli.IntOpt{
+		Name:   "max-pod-checks",
+		Value:  defaultMaxPodChecks,
+		Desc:   "Maximum number of pods checked at the same time across all services (0 for no limit)",
+		EnvVar: "MAX_POD_CHECKS",
+	})
+
+	maxOutboundRequests := app.Int(cli.IntOpt{
+		Name:   "max-outbound-requests",
+		Value:  defaultMaxOutboundRequests,
+		Desc:   "Maximum number of requests to the pods in flight at the same time, shared by the scheduled checks, the uncached requests and the severity lookups (0 for no limit)",
+		EnvVar: "MAX_OUTBOUND_REQUESTS",
+	})
+
+	podStartupGrace := app.Int(cli.IntOpt{
+		Name:   "pod-startup-grace",
+		Value:  defaultStartupGrace,
+		Desc:   "Number of seconds the pods are given to become ready after they start, before they are probed (0 to probe them straight away)",
+		EnvVar: "POD_STARTUP_GRACE",
+	})
+
+	historyTransitions := app.Int(cli.IntOpt{
+		Name:   "history-transitions",
+		Value:  defaultHistoryTransitions,
+		Desc:   "Maximum number of status changes of a service and its pods kept in its history",
+		EnvVar: "HISTORY_TRANSITIONS",
+	})
+
+	historySamples := app.Int(cli.IntOpt{
+		Name:   "history-samples",
+		Value:  defaultHistorySamples,
+		Desc:   "Maximum number of sampled results of a service kept in its history",
+		EnvVar: "HISTORY_SAMPLES",
+	})
+
+	historySamplePeriod := app.Int(cli.IntOpt{
+		Name:   "history-sample-period",
+		Value:  int(defaultHistorySamplePeriod / time.Second),
+		Desc:   "Number of seconds between the sampled results of a service kept in its history",
+		EnvVar: "HISTORY_SAMPLE_PERIOD",
+	})
+
+	namespaces := app.String(cli.StringOpt{
+		Name:   "namespaces",
+		Value:  "default",
+		Desc:   "Comma separated list of namespaces to monitor, or \"all\" for every namespace",
+		EnvVar: "NAMESPACES",
+	})
+
+	leaderElection := app.Bool(cli.BoolOpt{
+		Name:   "leader-election",
+		Value:  false,
+		Desc:   "Elect a leader among the replicas through a Lease; only the leader disables sticky categories and reaps acks",
+		EnvVar: "LEADER_ELECTION",
+	})
+
+	leaderElectionNamespace := app.String(cli.StringOpt{
+		Name:   "leader-election-namespace",
+		Value:  "default",
+		Desc:   "Namespace of the leader election Lease",
+		EnvVar: "LEADER_ELECTION_NAMESPACE",
+	})
+
+	leaseName := app.String(cli.StringOpt{
+		Name:   "leader-election-lease",
+		Value:  defaultLeaseName,
+		Desc:   "Name of the leader election Lease",
+		EnvVar: "LEADER_ELECTION_LEASE",
+	})
+
+	podName := app.String(cli.StringOpt{
+		Name:   "pod-name",
+		Value:  "",
+		Desc:   "Name of the pod, used as the identity of the replica in the leader election (the host name by default)",
+		EnvVar: "POD_NAME",
+	})
+
 	log.InitLogger(*appName, *logLevel)
 
 	app.Action = func() {
-		log.Infof("Starting app with params: [environment: %s], [pathPrefix: %s]", *environment, *pathPrefix)
+		log.Infof("Starting app with params: [environment: %s], [pathPrefix: %s], [namespaces: %s], [leaderElection: %t]", *environment, *pathPrefix, *namespaces, *leaderElection)
 
-		healthcheckCooldownDuration := time.Duration(*healthcheckCooldown) * time.Second
-		controller := initializeController(*environment, *maxHealthcheckAttempts, healthcheckCooldownDuration)
+		retries := retryPolicy{
+			maxRetries:     *maxHealthcheckAttempts,
+			initialBackoff: time.Duration(*healthcheckCooldown) * time.Second,
+			maxBackoff:     time.Duration(*healthcheckMaxBackoff) * time.Second,
+			jitter:         float64(*healthcheckJitter) / 100,
+			maxElapsed:     time.Duration(*healthcheckMaxElapsed) * time.Second,
+		}
+		leaderElectionConfig := leaderElectionConfig{
+			enabled:   *leaderElection,
+			namespace: *leaderElectionNamespace,
+			leaseName: *leaseName,
+			identity:  getReplicaIdentity(*podName),
+		}
+		limits := checkLimits{
+			podChecksPerService: *podChecksPerService,
+			podChecks:           *maxPodChecks,
+			outboundRequests:    *maxOutboundRequests,
+		}
+		history := historyLimits{
+			transitions:  *historyTransitions,
+			samples:      *historySamples,
+			samplePeriod: time.Duration(*historySamplePeriod) * time.Second,
+		}
+		controller := initializeController(*environment, retries, limits, time.Duration(*podStartupGrace)*time.Second, *schedulerWorkers, parseNamespaces(*namespaces),
+			leaderElectionConfig, history)
 		handler := &httpHandler{
 			controller: controller,
 			pathPrefix: *pathPrefix,
@@ -105,12 +240,17 @@ func listen(httpHandler *httpHandler, pathPrefix string, port int) {
 	s.HandleFunc("/add-ack", httpHandler.handleAddAck).Methods("POST")
 	s.HandleFunc("/enable-category", httpHandler.handleEnableCategory)
 	s.HandleFunc("/disable-category", httpHandler.handleDisableCategory)
+	s.HandleFunc("/categories", httpHandler.handleCategories)
 	s.HandleFunc("/rem-ack", httpHandler.handleRemoveAck)
 	s.HandleFunc("/add-ack-form", httpHandler.handleAddAckForm)
+	s.HandleFunc("/silences", httpHandler.handleSilences)
+	s.HandleFunc("/add-silence", httpHandler.handleAddSilence).Methods("POST")
+	s.HandleFunc("/rem-silence", httpHandler.handleRemoveSilence)
 	s.HandleFunc("", httpHandler.handleServicesHealthCheck)
 	s.HandleFunc("/", httpHandler.handleServicesHealthCheck)
 	s.HandleFunc("/__pods-health", httpHandler.handlePodsHealthCheck)
 	s.HandleFunc("/__pod-individual-health", httpHandler.handleIndividualPodHealthCheck)
+	s.HandleFunc("/__history", httpHandler.handleServiceHistory)
 	s.PathPrefix("/").Handler(http.StripPrefix("/", http.FileServer(http.Dir("resources/"))))
 
 	srv := &http.Server{
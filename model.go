@@ -1,15 +1,29 @@
 package main
 
 import (
+	"strings"
 	"sync"
 	"time"
+
+	k8score "k8s.io/api/core/v1"
 )
 
 type pod struct {
 	name        string
+	namespace   string
 	node        string
 	ip          string
 	serviceName string
+	// ready is the Ready condition of the pod, as seen by Kubernetes
+	ready bool
+	// containerPorts holds the named ports of the containers of the pod, which named target ports resolve to
+	containerPorts map[string]int32
+	// images are the images of the containers of the pod, which tell the version it runs
+	images    []containerImage
+	lifecycle podLifecycle
+	ack       string
+	// checkAcks holds the acks of single checks of the pod, keyed by the check name as it appears in the ack key.
+	checkAcks map[string]string
 }
 
 type category struct {
@@ -19,18 +33,56 @@ type category struct {
 	isSticky         bool
 	isEnabled        bool
 	failureThreshold int
-}
-
-type deployment struct {
-	desiredReplicas int32
+	// failureWindow, if set, makes the failures within the window count against the failure threshold,
+	// instead of the consecutive failures
+	failureWindow time.Duration
+	// autoReEnableAfter, if set, is the number of consecutive healthy runs of its services after which
+	// a category disabled for being sticky is enabled again
+	autoReEnableAfter int
+	lastDisabled      *categoryChange
+	lastEnabled       *categoryChange
 }
 
 type service struct {
-	name        string
-	ack         string
-	appPort     int32
+	name      string
+	namespace string
+	ack       string
+	appPort   int32
+	// appPortName is set instead of appPort when the app port targets a named container port
+	appPortName string
 	isResilient bool
 	isDaemon    bool
+	// quorum, if set, replaces the default of all pods having to be healthy, along with isResilient
+	quorum *quorumPolicy
+	// probeType is how the pods are probed, and grpcService the service asked for by the gRPC probe
+	probeType   probeType
+	grpcService string
+	// endpoint is how the HTTP endpoints of the pods are reached
+	endpoint endpointConfig
+	// healthEndpoints, if set, replaces the app port as the endpoints the health of the pods is combined from
+	healthEndpoints []healthEndpoint
+	// startupGrace, if set, replaces the default startup grace period of the pods
+	startupGrace *time.Duration
+	// buildInfoPath, if set, is the path of the endpoint the version of the pods is fetched from
+	buildInfoPath string
+	// selector selects the pods of the service, and workloadKind is set to cronJobWorkload for a monitored CronJob,
+	// which is checked by the time since its last successful run, at most maxTimeSinceSuccess if set, instead of by its pods
+	selector            map[string]string
+	workloadKind        workloadKind
+	maxTimeSinceSuccess time.Duration
+	labels              map[string]string
+}
+
+type serviceEventType int
+
+const (
+	serviceUpserted serviceEventType = iota
+	serviceRemoved
+)
+
+type serviceEvent struct {
+	eventType serviceEventType
+	service   service
 }
 
 type servicesMap struct {
@@ -39,7 +91,113 @@ type servicesMap struct {
 }
 
 type measuredService struct {
-	service            service
-	cachedHealth       *cachedHealth
-	cachedHealthMetric *cachedHealth
+	service       service
+	refreshPeriod time.Duration
+}
+
+// newObjectKey returns the namespace-qualified identity of a Kubernetes object, e.g. "publishing/api-policy-component".
+// Objects without a namespace are identified by their name only.
+func newObjectKey(namespace string, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "/" + name
+}
+
+// splitObjectKey is the inverse of newObjectKey.
+func splitObjectKey(key string) (namespace string, name string) {
+	if i := strings.Index(key, "/"); i >= 0 {
+		return key[:i], key[i+1:]
+	}
+	return "", key
+}
+
+func (s service) key() string {
+	return newObjectKey(s.namespace, s.name)
+}
+
+// ackKey is the key of the service's ack in the acks configMap. ConfigMap keys cannot contain slashes,
+// so services outside the default namespace are stored as "namespace.name" (neither may contain dots).
+func (s service) ackKey() string {
+	if s.namespace == "" || s.namespace == k8score.NamespaceDefault {
+		return s.name
+	}
+	return s.namespace + "." + s.name
+}
+
+func (p pod) key() string {
+	return newObjectKey(p.namespace, p.name)
+}
+
+// ackKey is the key of the pod's ack in the acks configMap, e.g. "pod.publishing.api-policy-component-7d9f8-x2x4z".
+// Pod ack keys have at least three dot separated parts, so they never clash with the "namespace.name" keys of services.
+func (p pod) ackKey() string {
+	return podAckKeyPrefix + p.namespaceOrDefault() + "." + p.name
+}
+
+// checkAckKey is the key of the ack of a single check of the pod's health endpoint,
+// e.g. "check.publishing.api-policy-component-7d9f8-x2x4z.Check-connectivity-to-Kafka".
+func (p pod) checkAckKey(checkName string) string {
+	return p.checkAckKeyPrefix() + ackKeySegment(checkName)
+}
+
+func (p pod) checkAckKeyPrefix() string {
+	return checkAckKeyPrefix + p.namespaceOrDefault() + "." + p.name + "."
+}
+
+// checkAck returns the raw ack of the check with the given name, if there is one.
+func (p pod) checkAck(checkName string) string {
+	return p.checkAcks[ackKeySegment(checkName)]
+}
+
+func (p pod) namespaceOrDefault() string {
+	if p.namespace == "" {
+		return k8score.NamespaceDefault
+	}
+	return p.namespace
+}
+
+// ackKeySegment turns a check name into the last part of a configMap key. ConfigMap keys may only contain
+// alphanumerics, '-', '_' and '.', and dots separate the parts of an ack key, so everything else becomes a dash.
+func ackKeySegment(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '-'
+	}, name)
+}
+
+// parsePodAckKey returns the pod a pod or check ack key belongs to. It reports false for the ack keys of services.
+func parsePodAckKey(key string) (namespace string, podName string, ok bool) {
+	var rest string
+	switch {
+	case strings.HasPrefix(key, podAckKeyPrefix):
+		rest = strings.TrimPrefix(key, podAckKeyPrefix)
+	case strings.HasPrefix(key, checkAckKeyPrefix):
+		rest = strings.TrimPrefix(key, checkAckKeyPrefix)
+		lastDot := strings.LastIndex(rest, ".")
+		if lastDot < 0 {
+			return "", "", false
+		}
+		rest = rest[:lastDot]
+	default:
+		return "", "", false
+	}
+
+	i := strings.Index(rest, ".")
+	if i <= 0 || i == len(rest)-1 {
+		return "", "", false
+	}
+	return rest[:i], rest[i+1:], true
+}
+
+// matchesServiceEntry tells whether a category services entry refers to the service identified by key.
+// Entries may be namespace-qualified ("namespace/service") or plain service names matching any namespace.
+func matchesServiceEntry(entry string, key string) bool {
+	if strings.Contains(entry, "/") {
+		return entry == key
+	}
+	_, name := splitObjectKey(key)
+	return entry == name
 }
@@ -2,25 +2,31 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"sort"
-	"sync"
+	"time"
 
 	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
 	log "github.com/Financial-Times/go-logger"
 )
 
-func (c *healthCheckController) buildPodsHealthResult(ctx context.Context, serviceName string) (fthealth.HealthResult, error) {
+// podsHealth is the health of the pods of a service, along with the version every pod runs, by pod key.
+type podsHealth struct {
+	fthealth.HealthResult
+	versions map[string]podVersion
+}
+
+func (c *healthCheckController) buildPodsHealthResult(ctx context.Context, serviceName string) (podsHealth, error) {
 	desc := fmt.Sprintf("Health of pods that are under service %s served without cache.", serviceName)
 
-	checkResults, err := c.runPodChecksFor(ctx, serviceName)
+	checkResults, versions, err := c.runPodChecksFor(ctx, serviceName)
 
 	if err != nil {
 		// nolint:staticcheck
-		return fthealth.HealthResult{}, fmt.Errorf("Cannot perform pod checks for service %s, error was: %s", serviceName, err.Error())
+		return podsHealth{}, fmt.Errorf("Cannot perform pod checks for service %s, error was: %s", serviceName, err.Error())
 	}
 
 	finalOk, finalSeverity := getFinalResult(checkResults, nil)
@@ -36,25 +42,29 @@ func (c *healthCheckController) buildPodsHealthResult(ctx context.Context, servi
 
 	sort.Sort(byNameComparator(health.Checks))
 
-	return health, nil
+	return podsHealth{HealthResult: health, versions: versions}, nil
 }
 
-func (c *healthCheckController) runPodChecksFor(ctx context.Context, serviceName string) ([]fthealth.CheckResult, error) {
+// runPodChecksFor checks every pod of the service, and returns the version of every pod as it was probed, by pod key.
+func (c *healthCheckController) runPodChecksFor(ctx context.Context, serviceName string) ([]fthealth.CheckResult, map[string]podVersion, error) {
 	serviceToBeChecked, err := c.healthCheckService.getServiceByName(serviceName)
 	if err != nil {
-		return []fthealth.CheckResult{}, err
+		return []fthealth.CheckResult{}, nil, err
 	}
 
-	pods, err := c.healthCheckService.getPodsForService(ctx, serviceName)
+	pods, err := c.healthCheckService.getPodsForService(ctx, serviceToBeChecked)
 	if err != nil {
 		// nolint:staticcheck
-		return []fthealth.CheckResult{}, fmt.Errorf("Cannot get pods for service %s, error was: %s", serviceName, err.Error())
+		return []fthealth.CheckResult{}, nil, fmt.Errorf("Cannot get pods for service %s, error was: %s", serviceName, err.Error())
 	}
 
+	// the pods are probed within the same limits as the scheduled checks
+	probes := c.healthCheckService.probePods(ctx, pods, serviceToBeChecked)
 	checks := make([]fthealth.Check, len(pods))
+	podAcks := make(map[string]string, len(pods))
 	for i, currentPod := range pods {
-		check := newPodHealthCheck(currentPod, serviceToBeChecked, c.healthCheckService)
-		checks[i] = check
+		checks[i] = newPodHealthCheck(serviceToBeChecked, probes[i])
+		podAcks[currentPod.key()] = currentPod.ack
 	}
 
 	healthChecks := fthealth.RunCheck(fthealth.HealthCheck{
@@ -64,64 +74,95 @@ func (c *healthCheckController) runPodChecksFor(ctx context.Context, serviceName
 		Checks:      checks,
 	}).Checks
 
-	wg := sync.WaitGroup{}
-	wg.Add(len(healthChecks))
-	for i := range healthChecks {
-		go func(i int, serviceToBeChecked service) {
-			defer wg.Done()
-			healthCheck := healthChecks[i]
-			if !healthCheck.Ok {
-				severity := c.getSeverityForPod(ctx, healthCheck.Name, serviceToBeChecked.appPort)
-				healthChecks[i].Severity = severity
-			}
-
-			if serviceToBeChecked.ack != "" {
-				healthChecks[i].Ack = serviceToBeChecked.ack
-			}
-		}(i, serviceToBeChecked)
-	}
-	wg.Wait()
-
-	return healthChecks, nil
+	now := time.Now()
+	versions := make(map[string]podVersion, len(pods))
+	for i, healthCheck := range healthChecks {
+		versions[healthCheck.ID] = probes[i].version()
+		// the checks are in the order of the pods, so the severity of a failing pod is computed from the probe its check was evaluated with
+		if !healthCheck.Ok {
+			healthChecks[i].Severity = computeSeverityByPods([]podProbe{probes[i]}, now)
+		}
+
+		// the ack of the pod itself takes precedence over the ack of its service
+		if ackValue := activeAck(podAcks[healthCheck.ID], now); ackValue != "" {
+			healthChecks[i].Ack = ackValue
+		} else if ackValue := activeAck(serviceToBeChecked.ack, now); ackValue != "" {
+			healthChecks[i].Ack = ackValue
+		}
+	}
+
+	categories, err := c.healthCheckService.getCategories(ctx)
+	if err != nil {
+		log.WithError(err).Warn("Cannot read categories, only silences targeting the service directly apply to its pods")
+	}
+	// the pods are silenced together with their service
+	c.silenceChecks(ctx, healthChecks, categories, func(fthealth.CheckResult) (service, bool) {
+		return serviceToBeChecked, true
+	})
+
+	return healthChecks, versions, nil
 }
 
-func (c *healthCheckController) getIndividualPodHealth(ctx context.Context, podName string) ([]byte, string, error) {
-	podToBeChecked, err := c.healthCheckService.getPodByName(ctx, podName)
+// getIndividualPodHealth proxies the health endpoint of a pod identified by its namespace-qualified key.
+// A pod name without a namespace is looked up in the default namespace.
+func (c *healthCheckController) getIndividualPodHealth(ctx context.Context, podKey string) ([]byte, string, error) {
+	namespace, podName := splitObjectKey(podKey)
+	podToBeChecked, err := c.healthCheckService.getPodByName(ctx, namespace, podName)
 	if err != nil {
 		return nil, "", errors.New("Error retrieving pod: " + err.Error())
 	}
 
-	srv, err := c.healthCheckService.getServiceByName(podToBeChecked.serviceName)
+	srv, err := c.healthCheckService.getServiceByName(newObjectKey(podToBeChecked.namespace, podToBeChecked.serviceName))
 
-	appPort := defaultAppPort
 	if err != nil {
 		log.Warnf("Cannot get srv with name %s. Using default app port [%d]", podToBeChecked.serviceName, defaultAppPort)
-	} else {
-		appPort = srv.appPort
+		srv = service{name: podToBeChecked.serviceName, namespace: podToBeChecked.namespace, appPort: defaultAppPort}
+	} else if !srv.probeType.servesHealthEndpoint() || len(srv.probedEndpoints()) > 1 {
+		// the pod has no single __health endpoint to show, so its health is shown as it is probed
+		probe := c.healthCheckService.probePod(ctx, podToBeChecked, srv)
+		if probe.err != nil {
+			return nil, "", errors.New("Error performing healthcheck: " + probe.err.Error())
+		}
+		body, err := json.Marshal(probe.health)
+		return body, jsonContentType, err
 	}
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("http://%s:%d/__health", podToBeChecked.ip, appPort), nil)
+	healthEndpoint := srv.probedEndpoints()[0]
+	if healthEndpoint.path != "" {
+		srv.endpoint.path = healthEndpoint.path
+	}
+	endpoint, err := c.healthCheckService.getHTTPEndpoint(srv, "/__health")
 	if err != nil {
-		return nil, "", errors.New("Error constructing healthcheck request: " + err.Error())
+		return nil, "", errors.New("Error configuring healthcheck request: " + err.Error())
 	}
 
-	resp, err := c.healthCheckService.getHTTPClient().Do(req)
+	port, err := healthEndpoint.port.resolve(podToBeChecked)
 	if err != nil {
-		return nil, "", errors.New("Error performing healthcheck: " + err.Error())
+		return nil, "", errors.New("Error resolving healthcheck port: " + err.Error())
 	}
 
-	if resp.StatusCode != 200 {
-		// nolint:staticcheck
-		return nil, "", fmt.Errorf("Healthcheck endpoint returned non-200 status (%v)", resp.Status)
+	req, err := endpoint.newRequest(ctx, podToBeChecked, port)
+	if err != nil {
+		return nil, "", errors.New("Error constructing healthcheck request: " + err.Error())
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	resp, err := endpoint.client.Do(req)
+	if err != nil {
+		return nil, "", errors.New("Error performing healthcheck: " + err.Error())
+	}
+	// the body is closed on every path, as the request holds an outbound request slot until then
 	defer func() {
 		if err = resp.Body.Close(); err != nil {
 			log.WithError(err).Error("Cannot close response body reader.")
 		}
 	}()
 
+	if resp.StatusCode != 200 {
+		// nolint:staticcheck
+		return nil, "", fmt.Errorf("Healthcheck endpoint returned non-200 status (%v)", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, "", errors.New("Error reading healthcheck response: " + err.Error())
 	}
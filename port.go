@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/Financial-Times/go-logger"
+	k8score "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// healthEndpointsAnnotation lists the endpoints the health of the pods of a service is combined from, see parseHealthEndpoints
+const healthEndpointsAnnotation = "aggregate-healthcheck.ft.com/health-endpoints"
+
+// portRef is a port of the pods of a service, either a number or the name of one of their container ports.
+type portRef struct {
+	number int32
+	name   string
+}
+
+// targetPortRef returns the port of the pods a port of a service forwards to, which is the port of the service when it has no target port.
+func targetPortRef(servicePort k8score.ServicePort) portRef {
+	switch {
+	case servicePort.TargetPort.Type == intstr.String:
+		return portRef{name: servicePort.TargetPort.StrVal}
+	case servicePort.TargetPort.IntVal != 0:
+		return portRef{number: servicePort.TargetPort.IntVal}
+	default:
+		return portRef{number: servicePort.Port}
+	}
+}
+
+// resolve returns the number of the port on the pod. Named ports are looked up in the container ports of the pod,
+// as the pods of a service may serve them on different numbers.
+func (r portRef) resolve(pod pod) (int32, error) {
+	if r.name == "" {
+		return r.number, nil
+	}
+	if number, ok := pod.containerPorts[r.name]; ok {
+		return number, nil
+	}
+	return 0, fmt.Errorf("pod %s has no container port named %s", pod.name, r.name)
+}
+
+func (r portRef) String() string {
+	if r.name != "" {
+		return r.name
+	}
+	return strconv.Itoa(int(r.number))
+}
+
+// healthEndpoint is one of the endpoints the health of the pods of a service is combined from.
+type healthEndpoint struct {
+	// name is the endpoint as it is listed in the annotation, which prefixes the checks of every endpoint but the first
+	name string
+	port portRef
+	// path, if set, replaces the path of the probe for this endpoint
+	path string
+}
+
+// parseHealthEndpoints parses the list of endpoints of a service, e.g. "app, admin/__health, 9090". Every endpoint is a port,
+// optionally followed by the path to probe. A port is the name of a port of the service, a port number, or the name of a container port.
+func parseHealthEndpoints(value string, servicePorts []k8score.ServicePort) ([]healthEndpoint, error) {
+	var endpoints []healthEndpoint
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		port, path, hasPath := strings.Cut(entry, "/")
+		if port == "" {
+			return nil, fmt.Errorf("health endpoint %q has no port", entry)
+		}
+		endpoint := healthEndpoint{name: port, port: portRef{name: port}}
+		if hasPath {
+			endpoint.path = "/" + path
+		}
+
+		if number, err := strconv.Atoi(port); err == nil {
+			if number <= 0 || number > 65535 {
+				return nil, fmt.Errorf("health endpoint %q has an invalid port number", entry)
+			}
+			endpoint.port = portRef{number: int32(number)}
+		}
+		for _, servicePort := range servicePorts {
+			if servicePort.Name == port {
+				endpoint.port = targetPortRef(servicePort)
+			}
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+
+	if len(endpoints) == 0 {
+		return nil, errors.New("no health endpoint is listed")
+	}
+	return endpoints, nil
+}
+
+// getHealthEndpoints returns the endpoints set on the service by its annotation, or nil for the app port alone.
+func getHealthEndpoints(k8sService *k8score.Service) []healthEndpoint {
+	value, ok := k8sService.Annotations[healthEndpointsAnnotation]
+	if !ok {
+		return nil
+	}
+
+	endpoints, err := parseHealthEndpoints(value, k8sService.Spec.Ports)
+	if err != nil {
+		log.WithError(err).Warnf("Cannot parse the health endpoints of service with name %s, using the app port.", k8sService.Name)
+		return nil
+	}
+	return endpoints
+}
+
+// probedEndpoints returns the endpoints the health of the pods of the service is combined from, the app port by default.
+// The readiness probe does not reach the pods, so it has a single endpoint without a port.
+func (s service) probedEndpoints() []healthEndpoint {
+	if s.probeType == readinessProbe {
+		return []healthEndpoint{{name: "app"}}
+	}
+	if len(s.healthEndpoints) == 0 {
+		return []healthEndpoint{{name: "app", port: portRef{number: s.appPort, name: s.appPortName}}}
+	}
+	return s.healthEndpoints
+}
+
+// getContainerPorts returns the named ports of the containers of the pod.
+func getContainerPorts(k8sPod k8score.Pod) map[string]int32 {
+	var ports map[string]int32
+	for _, container := range k8sPod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.Name == "" {
+				continue
+			}
+			if ports == nil {
+				ports = make(map[string]int32)
+			}
+			ports[port.Name] = port.ContainerPort
+		}
+	}
+	return ports
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	k8score "k8s.io/api/core/v1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestTargetPortRef(t *testing.T) {
+	assert.Equal(t, portRef{number: 8080}, targetPortRef(k8score.ServicePort{Port: 80, TargetPort: intstr.FromInt32(8080)}))
+	assert.Equal(t, portRef{name: "http"}, targetPortRef(k8score.ServicePort{Port: 80, TargetPort: intstr.FromString("http")}))
+	assert.Equal(t, portRef{number: 80}, targetPortRef(k8score.ServicePort{Port: 80}), "A port without a target port should target itself")
+}
+
+func TestPortRefResolve(t *testing.T) {
+	p := pod{name: "pod1", containerPorts: map[string]int32{"http": 8081}}
+
+	port, err := portRef{number: 8080}.resolve(p)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(8080), port)
+
+	port, err = portRef{name: "http"}.resolve(p)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(8081), port)
+
+	_, err = portRef{name: "admin"}.resolve(p)
+	assert.EqualError(t, err, "pod pod1 has no container port named admin")
+}
+
+func TestParseHealthEndpoints(t *testing.T) {
+	servicePorts := []k8score.ServicePort{
+		{Name: "app", Port: 8080, TargetPort: intstr.FromString("http")},
+		{Name: "admin", Port: 9000, TargetPort: intstr.FromInt32(9001)},
+	}
+
+	endpoints, err := parseHealthEndpoints("app, admin/internal/__health, 9090, metrics", servicePorts)
+	assert.NoError(t, err)
+	assert.Equal(t, []healthEndpoint{
+		{name: "app", port: portRef{name: "http"}},
+		{name: "admin", port: portRef{number: 9001}, path: "/internal/__health"},
+		{name: "9090", port: portRef{number: 9090}},
+		{name: "metrics", port: portRef{name: "metrics"}},
+	}, endpoints)
+
+	for _, invalid := range []string{"", " , ", "/__health", "0", "70000"} {
+		_, err = parseHealthEndpoints(invalid, servicePorts)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestPopulateServiceWithNamedAppPort(t *testing.T) {
+	k8sService := &k8score.Service{
+		ObjectMeta: k8smeta.ObjectMeta{Name: "service1", Annotations: map[string]string{healthEndpointsAnnotation: "app, admin"}},
+		Spec: k8score.ServiceSpec{Ports: []k8score.ServicePort{
+			{Name: "app", Port: 8080, TargetPort: intstr.FromString("http")},
+		}},
+	}
+
+	s := populateService(k8sService, nil)
+	assert.Equal(t, int32(0), s.appPort)
+	assert.Equal(t, "http", s.appPortName)
+	assert.Equal(t, []healthEndpoint{
+		{name: "app", port: portRef{name: "http"}},
+		{name: "admin", port: portRef{name: "admin"}},
+	}, s.probedEndpoints())
+}
+
+func TestPopulatePodWithContainerPorts(t *testing.T) {
+	k8sPod := k8score.Pod{Spec: k8score.PodSpec{Containers: []k8score.Container{
+		{Ports: []k8score.ContainerPort{{Name: "http", ContainerPort: 8080}, {ContainerPort: 8443}}},
+		{Ports: []k8score.ContainerPort{{Name: "admin", ContainerPort: 9000}}},
+	}}}
+
+	assert.Equal(t, map[string]int32{"http": 8080, "admin": 9000}, populatePod(k8sPod, nil).containerPorts)
+}
+
+func newHealthServer(t *testing.T, body string) (*httptest.Server, int32) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	_, port := listenerPod(t, serverURL.Host)
+	return server, port
+}
+
+func TestProbePodCombinesHealthEndpoints(t *testing.T) {
+	_, appPort := newHealthServer(t, `{"checks":[{"name":"Kafka","ok":true,"severity":1}]}`)
+	_, adminPort := newHealthServer(t, `{"checks":[{"name":"Disk","ok":false,"severity":2}]}`)
+	hs := initializeMockService(t, &http.Client{Timeout: 5 * time.Second})
+
+	p := pod{name: "pod1", ip: "127.0.0.1", containerPorts: map[string]int32{"http": appPort, "admin": adminPort}}
+	srv := service{name: "service1", healthEndpoints: []healthEndpoint{
+		{name: "app", port: portRef{name: "http"}},
+		{name: "admin", port: portRef{name: "admin"}},
+	}}
+
+	probe := hs.probePod(context.Background(), p, srv)
+	require.NoError(t, probe.err)
+	assert.Equal(t, []podCheck{
+		{Name: "Kafka", OK: true, Severity: 1},
+		{Name: "admin: Disk", OK: false, Severity: 2},
+	}, probe.health.Checks)
+}
+
+func TestProbePodFailsWithAnyHealthEndpoint(t *testing.T) {
+	_, appPort := newHealthServer(t, `{"checks":[{"name":"Kafka","ok":true,"severity":1}]}`)
+	_, adminPort := newHealthServer(t, "not json")
+	hs := initializeMockService(t, &http.Client{Timeout: 5 * time.Second})
+
+	p := pod{name: "pod1", ip: "127.0.0.1"}
+	srv := service{name: "service1", healthEndpoints: []healthEndpoint{
+		{name: "app", port: portRef{number: appPort}},
+		{name: "admin", port: portRef{number: adminPort}},
+	}}
+
+	probe := hs.probePod(context.Background(), p, srv)
+	require.Error(t, probe.err)
+	assert.Contains(t, probe.err.Error(), "admin endpoint: ")
+	assert.Equal(t, probeInvalidResponse, getProbeFailure(probe.err))
+
+	probe = hs.probePod(context.Background(), p, service{name: "service1", appPortName: "http"})
+	require.Error(t, probe.err)
+	assert.Equal(t, probeMisconfigured, getProbeFailure(probe.err), "A named port missing from the pod should not be probed")
+}
+
+func TestProbePodWithIPv6Address(t *testing.T) {
+	listener, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skip("IPv6 is not available: " + err.Error())
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"checks":[{"name":"Kafka","ok":true,"severity":1}]}`))
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	p, port := listenerPod(t, listener.Addr().String())
+	probe := initializeMockService(t, &http.Client{Timeout: 5 * time.Second}).probePod(context.Background(), p, service{name: "service1", appPort: port})
+	assert.NoError(t, probe.err)
+	assert.Len(t, probe.health.Checks, 1)
+}
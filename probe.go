@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Financial-Times/go-logger"
+)
+
+// podProbe is the outcome of fetching the health of a pod once in a check cycle. The status and the severity of the pod,
+// and those of its service, are all derived from it, so the severity always describes the status it comes with.
+type podProbe struct {
+	pod      pod
+	health   healthcheckResponse
+	attempts healthcheckAttempts
+	// latency is the time taken to fetch the health of the pod, retries included
+	latency time.Duration
+	err     error
+	// starting is set when the pod was not probed as it is not ready yet, but still within its startup grace period;
+	// a starting pod is neither healthy nor failing
+	starting bool
+	// buildVersion is the version of the pod from its build info endpoint, if its service fetches it
+	buildVersion string
+}
+
+// serviceProbe holds the probes of the pods of a service made in a check cycle.
+type serviceProbe struct {
+	service service
+	pods    []podProbe
+}
+
+func (p serviceProbe) countStarting() int {
+	starting := 0
+	for _, podProbe := range p.pods {
+		if podProbe.starting {
+			starting++
+		}
+	}
+	return starting
+}
+
+// probeService probes every pod of the service once.
+func (hs *k8sHealthcheckService) probeService(ctx context.Context, service service) (serviceProbe, error) {
+	// a monitored CronJob has no pods of its own to probe
+	if service.isCronJob() {
+		return serviceProbe{service: service}, nil
+	}
+
+	pods, err := hs.getPodsForService(ctx, service)
+	if err != nil {
+		return serviceProbe{service: service}, fmt.Errorf("cannot retrieve pods for service with name %s to perform healthcheck: %s", service.name, err.Error())
+	}
+
+	return serviceProbe{service: service, pods: hs.probePods(ctx, pods, service)}, nil
+}
+
+// probePods probes the pods in parallel, bounded by the number of pods checked at the same time
+// for a single service and across all services. The probes are returned in the order of the pods.
+func (hs *k8sHealthcheckService) probePods(ctx context.Context, pods []pod, service service) []podProbe {
+	probes := make([]podProbe, len(pods))
+	serviceChecks := newLimiter(hs.podChecksPerService)
+
+	var wg sync.WaitGroup
+	for i, p := range pods {
+		if err := serviceChecks.acquire(ctx); err != nil {
+			probes[i] = podProbe{pod: p, err: err}
+			continue
+		}
+		if err := hs.podChecks.acquire(ctx); err != nil {
+			serviceChecks.release()
+			probes[i] = podProbe{pod: p, err: err}
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer serviceChecks.release()
+			defer hs.podChecks.release()
+			probes[i] = hs.probePod(ctx, p, service)
+		}()
+	}
+	wg.Wait()
+
+	return probes
+}
+
+// probePod probes the pod the way its service is probed, see prober. The pods whose containers cannot start, the pods that are not ready
+// yet within their startup grace period and the pods that are not running are not probed, see podLifecycle.
+func (hs *k8sHealthcheckService) probePod(ctx context.Context, pod pod, service service) podProbe {
+	if err := pod.lifecycle.containerFailure(); err != nil {
+		return podProbe{pod: pod, err: err}
+	}
+	if !pod.ready && pod.lifecycle.isStarting(time.Now(), hs.startupGraceFor(service)) {
+		return podProbe{pod: pod, starting: true}
+	}
+	if err := pod.lifecycle.phaseFailure(); err != nil {
+		return podProbe{pod: pod, err: err}
+	}
+
+	start := time.Now()
+	health, attempts, err := hs.getHealthChecksForPod(ctx, pod, service)
+	latency := time.Since(start)
+	if err != nil {
+		log.WithError(err).Errorf("Cannot perform healthcheck for pod with name %s", pod.name)
+	} else {
+		log.Debugf("Fetched the health of pod %s in %s", pod.name, latency.Round(time.Millisecond))
+	}
+
+	probe := podProbe{pod: pod, health: health, attempts: attempts, latency: latency, err: err}
+	// the version of a pod that cannot be reached is not fetched, its images are enough to tell it
+	if service.buildInfoPath != "" && err == nil {
+		var buildErr error
+		if probe.buildVersion, buildErr = hs.getBuildVersion(ctx, pod, service); buildErr != nil {
+			log.WithError(buildErr).Warnf("Cannot fetch the build info of pod with name %s", pod.name)
+		}
+	}
+	return probe
+}
+
+// status fails if one of the checks of the pod is failing and has not been acked.
+// The output lists the failing checks that have been acked, and the outcome of every attempt when the health of the pod had to be fetched again.
+func (p podProbe) status(now time.Time) (string, error) {
+	if p.err != nil {
+		return "", fmt.Errorf("cannot perform healthcheck for pod: %s", p.describeFailure())
+	}
+	if p.starting {
+		return "starting, not probed yet", nil
+	}
+
+	var ackedChecks []string
+	for _, check := range p.health.Checks {
+		if check.OK {
+			continue
+		}
+		if checkAck := activeAck(p.pod.checkAck(check.Name), now); checkAck != "" {
+			ackedChecks = append(ackedChecks, fmt.Sprintf("%s (%s)", check.Name, parseAck(checkAck).Message))
+		}
+	}
+
+	if failingChecks := p.failingChecks(now); len(failingChecks) == 1 {
+		return "", errors.New(withAttempts(fmt.Sprintf("failing check is: %s", failingChecks[0].Name), p.attempts))
+	} else if len(failingChecks) > 1 {
+		names := make([]string, 0, len(failingChecks))
+		for _, check := range failingChecks {
+			names = append(names, check.Name)
+		}
+		return "", errors.New(withAttempts("failing checks are: "+strings.Join(names, ", "), p.attempts))
+	}
+
+	if len(ackedChecks) == 0 {
+		return withAttempts("", p.attempts), nil
+	}
+	return withAttempts("acked failing checks: "+strings.Join(ackedChecks, ", "), p.attempts), nil
+}
+
+// severity returns the severity of the most severe failing check of the pod that has not been acked, and whether there is one.
+func (p podProbe) severity(now time.Time) (uint8, bool, error) {
+	if p.err != nil {
+		return defaultSeverity, false, fmt.Errorf("cannot get severity for pod with name %s: %s", p.pod.name, p.err.Error())
+	}
+
+	finalSeverity := defaultSeverity
+	failingChecks := p.failingChecks(now)
+	for _, check := range failingChecks {
+		if check.Severity < finalSeverity {
+			finalSeverity = check.Severity
+		}
+	}
+
+	return finalSeverity, len(failingChecks) != 0, nil
+}
+
+// failingChecks lists the failing checks of the pod that have not been acked, in the order the pod reports them.
+func (p podProbe) failingChecks(now time.Time) []failingCheck {
+	var failingChecks []failingCheck
+	for _, check := range p.health.Checks {
+		if !check.OK && activeAck(p.pod.checkAck(check.Name), now) == "" {
+			failingChecks = append(failingChecks, failingCheck{Name: check.Name, Severity: check.Severity})
+		}
+	}
+	return failingChecks
+}
+
+// describeFailure lists the attempts of a failed probe, or its error when no attempt could be made.
+func (p podProbe) describeFailure() string {
+	if len(p.attempts) == 0 {
+		return p.err.Error()
+	}
+	return p.attempts.describe()
+}
+
+// withAttempts appends the outcome of every attempt to the output of a pod check, when the health of the pod had to be fetched again.
+func withAttempts(output string, attempts healthcheckAttempts) string {
+	if !attempts.retried() {
+		return output
+	}
+	if output == "" {
+		return "retried: " + attempts.describe()
+	}
+	return fmt.Sprintf("%s (retried: %s)", output, attempts.describe())
+}
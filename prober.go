@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/Financial-Times/go-logger"
+	k8score "k8s.io/api/core/v1"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// probeTypeAnnotation selects how the pods of a service are probed, see probeType
+	probeTypeAnnotation = "aggregate-healthcheck.ft.com/probe"
+	// grpcServiceAnnotation names the service whose health is asked to the gRPC Health Checking Protocol;
+	// the health of the whole server is asked when it is not set
+	grpcServiceAnnotation = "aggregate-healthcheck.ft.com/grpc-service"
+)
+
+// probeType is how the pods of a service are probed.
+type probeType string
+
+const (
+	// ftHealthProbe fetches the FT-style JSON __health endpoint, whose checks are reported one by one
+	ftHealthProbe probeType = "health"
+	// gtgProbe, httpProbe, tcpProbe, grpcProbe and readinessProbe report a single check, named after the probe
+	gtgProbe       probeType = "gtg"
+	httpProbe      probeType = "http"
+	tcpProbe       probeType = "tcp"
+	grpcProbe      probeType = "grpc"
+	readinessProbe probeType = "readiness"
+)
+
+// The names of the single check reported by the probes that do not list checks of their own. Acks of single checks use these names.
+const (
+	gtgCheckName       = "Good to go"
+	httpCheckName      = "HTTP status"
+	tcpCheckName       = "TCP connect"
+	grpcCheckName      = "gRPC health"
+	readinessCheckName = "Kubernetes readiness"
+)
+
+// servesHealthEndpoint tells whether the pods expose the FT __health endpoint, which they do unless another probe type is set.
+func (t probeType) servesHealthEndpoint() bool {
+	return t == "" || t == ftHealthProbe
+}
+
+func parseProbeType(value string) (probeType, error) {
+	switch t := probeType(value); t {
+	case ftHealthProbe, gtgProbe, httpProbe, tcpProbe, grpcProbe, readinessProbe:
+		return t, nil
+	default:
+		return "", fmt.Errorf("unknown probe type %q", value)
+	}
+}
+
+// getProbeType returns the probe type set on the service by its annotation, falling back to the FT __health endpoint.
+func getProbeType(k8sService *k8score.Service) probeType {
+	value, ok := k8sService.Annotations[probeTypeAnnotation]
+	if !ok {
+		return ftHealthProbe
+	}
+
+	t, err := parseProbeType(value)
+	if err != nil {
+		log.WithError(err).Warnf("Cannot parse the probe type of service with name %s, using %s.", k8sService.Name, ftHealthProbe)
+		return ftHealthProbe
+	}
+	return t
+}
+
+// prober fetches the health of a pod in a single attempt, and tells whether its failure is worth retrying.
+// The failures to reach the pod are probeErrors; a pod that answers that it is unhealthy has failing checks instead.
+type prober interface {
+	probeOnce(ctx context.Context, pod pod, port int32) (healthcheckResponse, bool, error)
+}
+
+// newProber returns the prober of the probe type of the service. Every prober takes a slot of the outbound requests while it probes a pod.
+// The HTTP probers reach the endpoint configured by the service, see getHTTPEndpoint.
+func (hs *k8sHealthcheckService) newProber(service service) prober {
+	switch service.probeType {
+	case tcpProbe:
+		return &tcpProber{timeout: podRequestTimeout, outboundRequests: hs.outboundRequests}
+	case grpcProbe:
+		return &grpcProber{service: service.grpcService, timeout: podRequestTimeout, outboundRequests: hs.outboundRequests}
+	case readinessProbe:
+		return readinessProber{}
+	}
+
+	path, checkName := "/__health", ""
+	switch service.probeType {
+	case gtgProbe:
+		path, checkName = "/__gtg", gtgCheckName
+	case httpProbe:
+		path, checkName = "/", httpCheckName
+	}
+
+	endpoint, err := hs.getHTTPEndpoint(service, path)
+	if err != nil {
+		return misconfiguredProber{err: err}
+	}
+	if checkName != "" {
+		return &httpStatusProber{endpoint: endpoint, checkName: checkName}
+	}
+	return &ftHealthProber{endpoint: endpoint}
+}
+
+// probeEndpoints probes every endpoint of the pod with its prober, and combines their health. The checks of every endpoint
+// but the first are prefixed with the name of their endpoint, so that they can be told, and acked, apart.
+// The pod fails to be probed as soon as one of its endpoints does.
+func probeEndpoints(ctx context.Context, pod pod, endpoints []healthEndpoint, probers []prober) (healthcheckResponse, bool, error) {
+	var combined healthcheckResponse
+	for i, endpoint := range endpoints {
+		port, err := endpoint.port.resolve(pod)
+		if err != nil {
+			return healthcheckResponse{}, false, &probeError{failure: probeMisconfigured, msg: "Error resolving healthcheck port: " + err.Error()}
+		}
+
+		health, retryable, err := probers[i].probeOnce(ctx, pod, port)
+		if err != nil {
+			if len(endpoints) > 1 {
+				err = &probeError{failure: getProbeFailure(err), msg: fmt.Sprintf("%s endpoint: %s", endpoint.name, err.Error())}
+			}
+			return healthcheckResponse{}, retryable, err
+		}
+
+		if i == 0 {
+			combined.Name = health.Name
+		}
+		for _, check := range health.Checks {
+			if i > 0 {
+				check.Name = fmt.Sprintf("%s: %s", endpoint.name, check.Name)
+			}
+			combined.Checks = append(combined.Checks, check)
+		}
+	}
+	return combined, false, nil
+}
+
+// podAddress joins the IP of the pod and the port, bracketing IPv6 addresses.
+func podAddress(pod pod, port int32) string {
+	return net.JoinHostPort(pod.ip, strconv.Itoa(int(port)))
+}
+
+// singleCheck is the health of a pod reported by a probe that does not list checks of its own.
+func singleCheck(name string, ok bool) healthcheckResponse {
+	return healthcheckResponse{Checks: []podCheck{{Name: name, OK: ok, Severity: defaultSeverity}}}
+}
+
+// transportFailure classifies the failure to reach a pod, which is retryable unless the caller has given up.
+func transportFailure(ctx context.Context, msg string, err error) (healthcheckResponse, bool, error) {
+	failure := probeRequestFailed
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		failure = probeTimeout
+	}
+	return healthcheckResponse{}, ctx.Err() == nil, &probeError{failure: failure, msg: msg + err.Error()}
+}
+
+// ftHealthProber fetches the FT-style JSON __health endpoint of the pod.
+type ftHealthProber struct {
+	endpoint httpEndpoint
+}
+
+func (p *ftHealthProber) probeOnce(ctx context.Context, pod pod, port int32) (healthcheckResponse, bool, error) {
+	req, err := p.endpoint.newRequest(ctx, pod, port)
+	if err != nil {
+		return healthcheckResponse{}, false, errors.New("Error constructing healthcheck request: " + err.Error())
+	}
+	req.Header.Set("Accept", "application/json")
+
+	return getHealthChecksForPodOnce(req, p.endpoint.client)
+}
+
+// httpStatusProber tells the health of the pod from the status of an HTTP endpoint: any status below 400 is healthy.
+type httpStatusProber struct {
+	endpoint  httpEndpoint
+	checkName string
+}
+
+func (p *httpStatusProber) probeOnce(ctx context.Context, pod pod, port int32) (healthcheckResponse, bool, error) {
+	req, err := p.endpoint.newRequest(ctx, pod, port)
+	if err != nil {
+		return healthcheckResponse{}, false, errors.New("Error constructing healthcheck request: " + err.Error())
+	}
+
+	resp, err := p.endpoint.client.Do(req)
+	if err != nil {
+		return transportFailure(ctx, "Error performing healthcheck request: ", err)
+	}
+	if err = resp.Body.Close(); err != nil {
+		log.WithError(err).Errorf("Cannot close response body reader.")
+	}
+
+	return singleCheck(p.checkName, resp.StatusCode < http.StatusBadRequest), false, nil
+}
+
+// misconfiguredProber fails every probe of a service whose endpoint cannot be set up, without retrying.
+type misconfiguredProber struct {
+	err error
+}
+
+func (p misconfiguredProber) probeOnce(context.Context, pod, int32) (healthcheckResponse, bool, error) {
+	return healthcheckResponse{}, false, &probeError{failure: probeMisconfigured, msg: "Error configuring healthcheck request: " + p.err.Error()}
+}
+
+// tcpProber tells the health of the pod from whether a TCP connection can be opened to it within the timeout.
+type tcpProber struct {
+	timeout          time.Duration
+	outboundRequests *limiter
+}
+
+func (p *tcpProber) probeOnce(ctx context.Context, pod pod, port int32) (healthcheckResponse, bool, error) {
+	if err := p.outboundRequests.acquire(ctx); err != nil {
+		return transportFailure(ctx, "Error connecting to pod: ", err)
+	}
+	defer p.outboundRequests.release()
+
+	// the attempt is bounded like the HTTP requests to the pods, whatever the deadline of the check
+	attemptCtx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	dialer := net.Dialer{Timeout: p.timeout}
+	conn, err := dialer.DialContext(attemptCtx, "tcp", podAddress(pod, port))
+	if err != nil {
+		return transportFailure(ctx, "Error connecting to pod: ", err)
+	}
+	if err = conn.Close(); err != nil {
+		log.WithError(err).Errorf("Cannot close connection to pod %s.", pod.name)
+	}
+
+	return singleCheck(tcpCheckName, true), false, nil
+}
+
+// grpcProber asks the health of the pod with the gRPC Health Checking Protocol, which has to answer within the timeout.
+type grpcProber struct {
+	service          string
+	timeout          time.Duration
+	outboundRequests *limiter
+}
+
+func (p *grpcProber) probeOnce(ctx context.Context, pod pod, port int32) (healthcheckResponse, bool, error) {
+	if err := p.outboundRequests.acquire(ctx); err != nil {
+		return transportFailure(ctx, "Error performing gRPC health check: ", err)
+	}
+	defer p.outboundRequests.release()
+
+	conn, err := grpc.NewClient(podAddress(pod, port), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return healthcheckResponse{}, false, errors.New("Error constructing gRPC health check: " + err.Error())
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.WithError(err).Errorf("Cannot close gRPC connection to pod %s.", pod.name)
+		}
+	}()
+
+	// the attempt is bounded like the HTTP requests to the pods, whatever the deadline of the check
+	attemptCtx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	resp, err := healthpb.NewHealthClient(conn).Check(attemptCtx, &healthpb.HealthCheckRequest{Service: p.service})
+	switch status.Code(err) {
+	case codes.OK:
+		return singleCheck(grpcCheckName, resp.GetStatus() == healthpb.HealthCheckResponse_SERVING), false, nil
+	case codes.NotFound:
+		// the server does not know the service, which is reported as not serving
+		return singleCheck(grpcCheckName, false), false, nil
+	case codes.Unavailable:
+		return transportFailure(ctx, "Error performing gRPC health check: ", err)
+	case codes.DeadlineExceeded:
+		return healthcheckResponse{}, ctx.Err() == nil, &probeError{failure: probeTimeout, msg: "Error performing gRPC health check: " + err.Error()}
+	default:
+		return healthcheckResponse{}, false, &probeError{failure: probeInvalidResponse, msg: "Error performing gRPC health check: " + err.Error()}
+	}
+}
+
+// readinessProber tells the health of the pod from its Ready condition, as seen by Kubernetes, without reaching the pod.
+type readinessProber struct{}
+
+func (readinessProber) probeOnce(_ context.Context, pod pod, _ int32) (healthcheckResponse, bool, error) {
+	return singleCheck(readinessCheckName, pod.ready), false, nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	k8score "k8s.io/api/core/v1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseProbeType(t *testing.T) {
+	for _, value := range []string{"health", "gtg", "http", "tcp", "grpc", "readiness"} {
+		probeType, err := parseProbeType(value)
+		assert.NoError(t, err, value)
+		assert.Equal(t, value, string(probeType))
+	}
+
+	_, err := parseProbeType("ping")
+	assert.Error(t, err)
+}
+
+func TestGetProbeType(t *testing.T) {
+	newK8sService := func(annotations map[string]string) *k8score.Service {
+		return &k8score.Service{ObjectMeta: k8smeta.ObjectMeta{Name: "service1", Annotations: annotations}}
+	}
+
+	assert.Equal(t, ftHealthProbe, getProbeType(newK8sService(nil)))
+	assert.Equal(t, grpcProbe, getProbeType(newK8sService(map[string]string{probeTypeAnnotation: "grpc"})))
+	assert.Equal(t, ftHealthProbe, getProbeType(newK8sService(map[string]string{probeTypeAnnotation: "ping"})),
+		"An unknown probe type should fall back to the __health endpoint")
+}
+
+func TestPodAddressBracketsIPv6(t *testing.T) {
+	assert.Equal(t, "10.2.3.4:8080", podAddress(pod{ip: "10.2.3.4"}, 8080))
+	assert.Equal(t, "[fd00::1]:8080", podAddress(pod{ip: "fd00::1"}, 8080))
+}
+
+// listenerPod returns a pod and the port that reach the address the local stand-in listens on.
+func listenerPod(t *testing.T, address string) (pod, int32) {
+	host, port, err := net.SplitHostPort(address)
+	require.NoError(t, err)
+	portNumber, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	return pod{name: "pod1", ip: host}, int32(portNumber)
+}
+
+func newProberTestService(t *testing.T) *k8sHealthcheckService {
+	return initializeMockService(t, &http.Client{Timeout: 5 * time.Second})
+}
+
+func TestFTHealthProber(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/__health", r.URL.Path)
+		_, _ = w.Write([]byte(validFailingHealthCheckResponseBody))
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+	pod, port := listenerPod(t, serverURL.Host)
+
+	health, _, err := newProberTestService(t).newProber(service{}).probeOnce(context.Background(), pod, port)
+	assert.NoError(t, err)
+	assert.Len(t, health.Checks, 2)
+}
+
+func TestHTTPStatusProbers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/__gtg":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+	pod, port := listenerPod(t, serverURL.Host)
+	hs := newProberTestService(t)
+
+	health, _, err := hs.newProber(service{probeType: gtgProbe}).probeOnce(context.Background(), pod, port)
+	assert.NoError(t, err, "A pod that answers should not be a transport failure")
+	assert.Equal(t, singleCheck(gtgCheckName, false), health)
+
+	health, _, err = hs.newProber(service{probeType: httpProbe}).probeOnce(context.Background(), pod, port)
+	assert.NoError(t, err)
+	assert.Equal(t, singleCheck(httpCheckName, true), health)
+}
+
+func TestTCPProber(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	pod, port := listenerPod(t, listener.Addr().String())
+	prober := newProberTestService(t).newProber(service{probeType: tcpProbe})
+
+	health, _, err := prober.probeOnce(context.Background(), pod, port)
+	assert.NoError(t, err)
+	assert.Equal(t, singleCheck(tcpCheckName, true), health)
+
+	require.NoError(t, listener.Close())
+	_, retryable, err := prober.probeOnce(context.Background(), pod, port)
+	assert.Error(t, err)
+	assert.True(t, retryable)
+	assert.Equal(t, probeRequestFailed, getProbeFailure(err))
+}
+
+func TestGRPCProber(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("content", healthpb.HealthCheckResponse_SERVING)
+	healthServer.SetServingStatus("search", healthpb.HealthCheckResponse_NOT_SERVING)
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	pod, port := listenerPod(t, listener.Addr().String())
+	hs := newProberTestService(t)
+	tests := []struct {
+		grpcService string
+		expectedOK  bool
+	}{
+		{grpcService: "", expectedOK: true},
+		{grpcService: "content", expectedOK: true},
+		{grpcService: "search", expectedOK: false},
+		{grpcService: "unknown", expectedOK: false},
+	}
+	for _, test := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		health, _, err := hs.newProber(service{probeType: grpcProbe, grpcService: test.grpcService}).probeOnce(ctx, pod, port)
+		cancel()
+		assert.NoError(t, err, test.grpcService)
+		assert.Equal(t, singleCheck(grpcCheckName, test.expectedOK), health, test.grpcService)
+	}
+}
+
+func TestGRPCProberOfUnreachablePod(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	pod, port := listenerPod(t, listener.Addr().String())
+	require.NoError(t, listener.Close())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, retryable, err := newProberTestService(t).newProber(service{probeType: grpcProbe}).probeOnce(ctx, pod, port)
+	assert.Error(t, err)
+	assert.True(t, retryable)
+	assert.Equal(t, probeRequestFailed, getProbeFailure(err))
+}
+
+func TestGRPCProberOfSilentPod(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	// the connections are accepted, but never answered
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	pod, port := listenerPod(t, listener.Addr().String())
+
+	prober := &grpcProber{timeout: 200 * time.Millisecond}
+	_, retryable, err := prober.probeOnce(context.Background(), pod, port)
+	assert.Error(t, err)
+	assert.True(t, retryable, "An attempt that timed out should be retried while the check has time left")
+	assert.Equal(t, probeTimeout, getProbeFailure(err))
+}
+
+func TestReadinessProber(t *testing.T) {
+	prober := newProberTestService(t).newProber(service{probeType: readinessProbe})
+
+	health, _, err := prober.probeOnce(context.Background(), pod{name: "pod1", ready: true}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, singleCheck(readinessCheckName, true), health)
+
+	health, _, err = prober.probeOnce(context.Background(), pod{name: "pod1"}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, singleCheck(readinessCheckName, false), health)
+}
@@ -11,6 +11,8 @@ type prometheusFeeder struct {
 	environment string
 	ticker      *time.Ticker
 	controller  controller
+	// recorded holds the labels of the service series recorded in the last pass, by service key
+	recorded map[string]prom.Labels
 }
 
 func newPrometheusFeeder(environment string, controller controller) *prometheusFeeder {
@@ -19,33 +21,72 @@ func newPrometheusFeeder(environment string, controller controller) *prometheusF
 		environment: environment,
 		ticker:      ticker,
 		controller:  controller,
+		recorded:    make(map[string]prom.Labels),
 	}
 }
 
 func (p prometheusFeeder) feed() {
 	ignitePilotLight(p.environment)
 	serviceStatus := initServiceStatusMetrics()
+	serviceDegraded := initServiceDegradedMetrics()
+	serviceVersionSkew := initServiceVersionSkewMetrics()
+	leader := initLeaderMetric()
 
 	for range p.ticker.C {
-		p.recordMetrics(serviceStatus)
+		p.recordMetrics(serviceStatus, serviceDegraded, serviceVersionSkew)
+		p.recordLeaderMetric(leader)
 	}
 }
 
-func (p prometheusFeeder) recordMetrics(serviceStatus *prom.GaugeVec) {
-	for _, service := range p.controller.getMeasuredServices() {
-		select {
-		case checkResult := <-service.cachedHealthMetric.toReadFromCache:
-			name := strings.Replace(checkResult.Name, ".", "-", -1)
-			checkStatus := inverseBoolToFloat64(checkResult.Ok)
-			serviceStatus.
-				With(prom.Labels{"environment": p.environment, "service": name}).
-				Set(checkStatus)
-		default:
+// recordMetrics sets the series of every service with a cached result, and deletes the series of the services
+// that no longer have one, e.g. because they were removed, so they do not keep reporting their last status.
+func (p prometheusFeeder) recordMetrics(serviceStatus *prom.GaugeVec, serviceDegraded *prom.GaugeVec, serviceVersionSkew *prom.GaugeVec) {
+	recorded := make(map[string]prom.Labels)
+	for _, result := range p.controller.getCachedResults() {
+		name := strings.Replace(result.checkResult.Name, ".", "-", -1)
+		namespace, _ := splitObjectKey(result.checkResult.ID)
+		labels := prom.Labels{"environment": p.environment, "namespace": namespace, "service": name}
+		serviceStatus.With(labels).Set(inverseBoolToFloat64(result.checkResult.Ok))
+		serviceDegraded.With(labels).Set(boolToFloat64(result.checkResult.Ok && result.breakdown.quorum.Degraded))
+		serviceVersionSkew.With(labels).Set(boolToFloat64(result.breakdown.versions.Skew))
+		recorded[newObjectKey(namespace, name)] = labels
+	}
+
+	for key, labels := range p.recorded {
+		if _, ok := recorded[key]; ok {
 			continue
 		}
+		serviceStatus.Delete(labels)
+		serviceDegraded.Delete(labels)
+		serviceVersionSkew.Delete(labels)
+		delete(p.recorded, key)
+	}
+	for key, labels := range recorded {
+		p.recorded[key] = labels
 	}
 }
 
+func (p prometheusFeeder) recordLeaderMetric(leader *prom.GaugeVec) {
+	status := p.controller.getLeaderStatus()
+	leader.With(prom.Labels{"environment": p.environment, "identity": status.Identity}).Set(boolToFloat64(status.IsLeader))
+}
+
+func initLeaderMetric() *prom.GaugeVec {
+	leader := prom.NewGaugeVec(
+		prom.GaugeOpts{
+			Namespace: "upp",
+			Subsystem: "health",
+			Name:      "leader",
+			Help:      "Leadership of the replica: 1 - leader; 0 - follower",
+		},
+		[]string{
+			"environment",
+			"identity",
+		})
+	prom.MustRegister(leader)
+	return leader
+}
+
 func initServiceStatusMetrics() *prom.GaugeVec {
 	serviceStatus := prom.NewGaugeVec(
 		prom.GaugeOpts{
@@ -56,12 +97,30 @@ func initServiceStatusMetrics() *prom.GaugeVec {
 		},
 		[]string{
 			"environment",
+			"namespace",
 			"service",
 		})
 	prom.MustRegister(serviceStatus)
 	return serviceStatus
 }
 
+func initServiceDegradedMetrics() *prom.GaugeVec {
+	serviceDegraded := prom.NewGaugeVec(
+		prom.GaugeOpts{
+			Namespace: "upp",
+			Subsystem: "health",
+			Name:      "servicedegraded",
+			Help:      "Degradation of a healthy service: 1 - fewer healthy pods than desired, but above its quorum; 0 - otherwise",
+		},
+		[]string{
+			"environment",
+			"namespace",
+			"service",
+		})
+	prom.MustRegister(serviceDegraded)
+	return serviceDegraded
+}
+
 func ignitePilotLight(environment string) {
 	pilotLight := prom.NewGaugeVec(
 		prom.GaugeOpts{
@@ -77,9 +136,33 @@ func ignitePilotLight(environment string) {
 	pilotLight.With(prom.Labels{"environment": environment}).Set(1)
 }
 
+func boolToFloat64(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
 func inverseBoolToFloat64(b bool) float64 {
 	if b {
 		return 0
 	}
 	return 1
 }
+
+func initServiceVersionSkewMetrics() *prom.GaugeVec {
+	serviceVersionSkew := prom.NewGaugeVec(
+		prom.GaugeOpts{
+			Namespace: "upp",
+			Subsystem: "health",
+			Name:      "serviceversionskew",
+			Help:      "Version skew of a service: 1 - its pods run several versions while it is not rolling out; 0 - otherwise",
+		},
+		[]string{
+			"environment",
+			"namespace",
+			"service",
+		})
+	prom.MustRegister(serviceVersionSkew)
+	return serviceVersionSkew
+}
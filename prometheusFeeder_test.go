@@ -3,7 +3,9 @@ package main
 import (
 	"testing"
 
+	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
 	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -27,6 +29,7 @@ func TestInitServiceStatusMetrics(t *testing.T) {
 		},
 		[]string{
 			"environment",
+			"namespace",
 			"service",
 		})
 
@@ -54,6 +57,65 @@ func TestIgnitePilotLight(t *testing.T) {
 	assert.True(t, ok, "Expecting an 'AlreadyRegisteredError'.")
 }
 
+func TestRecordMetricsFromCachedResults(t *testing.T) {
+	controller := &healthCheckController{results: newResultStore(nil)}
+	controller.results.set("default/service.one", fthealth.CheckResult{ID: "default/service.one", Name: "service.one", Ok: true}, checkBreakdown{})
+	controller.results.set("default/service-two", fthealth.CheckResult{ID: "default/service-two", Name: "service-two", Ok: false}, checkBreakdown{})
+	controller.results.set("publishing/service-two", fthealth.CheckResult{ID: "publishing/service-two", Name: "service-two", Ok: true},
+		checkBreakdown{quorum: quorumStatus{Policy: "1", Healthy: 1, Desired: 2, Required: 1, Degraded: true}, versions: versionStatus{Skew: true}})
+
+	serviceStatus := prom.NewGaugeVec(prom.GaugeOpts{Name: "test_servicestatus"}, []string{"environment", "namespace", "service"})
+	serviceDegraded := prom.NewGaugeVec(prom.GaugeOpts{Name: "test_servicedegraded"}, []string{"environment", "namespace", "service"})
+	serviceVersionSkew := prom.NewGaugeVec(prom.GaugeOpts{Name: "test_serviceversionskew"}, []string{"environment", "namespace", "service"})
+	feeder := newPrometheusFeeder(ENV, controller)
+	feeder.recordMetrics(serviceStatus, serviceDegraded, serviceVersionSkew)
+
+	assert.Equal(t, 3, testutil.CollectAndCount(serviceStatus))
+	assert.Equal(t, float64(0), testutil.ToFloat64(serviceStatus.With(prom.Labels{"environment": ENV, "namespace": "default", "service": "service-one"})))
+	assert.Equal(t, float64(1), testutil.ToFloat64(serviceStatus.With(prom.Labels{"environment": ENV, "namespace": "default", "service": "service-two"})))
+	assert.Equal(t, float64(0), testutil.ToFloat64(serviceStatus.With(prom.Labels{"environment": ENV, "namespace": "publishing", "service": "service-two"})))
+
+	assert.Equal(t, 3, testutil.CollectAndCount(serviceDegraded))
+	assert.Equal(t, float64(0), testutil.ToFloat64(serviceDegraded.With(prom.Labels{"environment": ENV, "namespace": "default", "service": "service-one"})))
+	assert.Equal(t, float64(1), testutil.ToFloat64(serviceDegraded.With(prom.Labels{"environment": ENV, "namespace": "publishing", "service": "service-two"})))
+
+	assert.Equal(t, 3, testutil.CollectAndCount(serviceVersionSkew))
+	assert.Equal(t, float64(0), testutil.ToFloat64(serviceVersionSkew.With(prom.Labels{"environment": ENV, "namespace": "default", "service": "service-two"})))
+	assert.Equal(t, float64(1), testutil.ToFloat64(serviceVersionSkew.With(prom.Labels{"environment": ENV, "namespace": "publishing", "service": "service-two"})))
+}
+
+func TestRecordMetricsDeletesRemovedServices(t *testing.T) {
+	controller := &healthCheckController{results: newResultStore(nil)}
+	controller.results.set("default/service1", fthealth.CheckResult{ID: "default/service1", Name: "service1", Ok: false}, checkBreakdown{})
+	controller.results.set("default/service2", fthealth.CheckResult{ID: "default/service2", Name: "service2", Ok: true}, checkBreakdown{})
+
+	serviceStatus := prom.NewGaugeVec(prom.GaugeOpts{Name: "test_servicestatus"}, []string{"environment", "namespace", "service"})
+	serviceDegraded := prom.NewGaugeVec(prom.GaugeOpts{Name: "test_servicedegraded"}, []string{"environment", "namespace", "service"})
+	serviceVersionSkew := prom.NewGaugeVec(prom.GaugeOpts{Name: "test_serviceversionskew"}, []string{"environment", "namespace", "service"})
+	feeder := newPrometheusFeeder(ENV, controller)
+	feeder.recordMetrics(serviceStatus, serviceDegraded, serviceVersionSkew)
+	assert.Equal(t, 2, testutil.CollectAndCount(serviceStatus))
+
+	controller.results.delete("default/service1")
+	feeder.recordMetrics(serviceStatus, serviceDegraded, serviceVersionSkew)
+
+	removedLabels := prom.Labels{"environment": ENV, "namespace": "default", "service": "service1"}
+	for _, gauge := range []*prom.GaugeVec{serviceStatus, serviceDegraded, serviceVersionSkew} {
+		assert.Equal(t, 1, testutil.CollectAndCount(gauge))
+		assert.False(t, gauge.Delete(removedLabels), "The series of a removed service should be gone")
+	}
+	assert.Equal(t, float64(0), testutil.ToFloat64(serviceStatus.With(prom.Labels{"environment": ENV, "namespace": "default", "service": "service2"})))
+}
+
+func TestRecordLeaderMetric(t *testing.T) {
+	controller := &healthCheckController{leader: newLeaderElector(leaderElectionConfig{identity: "replica-a"})}
+	leader := prom.NewGaugeVec(prom.GaugeOpts{Name: "test_leader"}, []string{"environment", "identity"})
+	feeder := newPrometheusFeeder(ENV, controller)
+	feeder.recordLeaderMetric(leader)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(leader.With(prom.Labels{"environment": ENV, "identity": "replica-a"})))
+}
+
 func TestInverseBoolToFloat64(t *testing.T) {
 	one := inverseBoolToFloat64(false)
 	assert.Equal(t, float64(1), one)
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/Financial-Times/go-logger"
+	k8score "k8s.io/api/core/v1"
+)
+
+const (
+	// quorumAnnotation sets the quorum of a service as "all", a number of pods, e.g. "2", or a percentage of the desired pods, e.g. "50%".
+	quorumAnnotation = "aggregate-healthcheck.ft.com/min-healthy"
+	// minHealthyPodsLabel and minHealthyPercentLabel set the quorum as labels, which cannot hold a percent sign
+	minHealthyPodsLabel    = "minHealthyPods"
+	minHealthyPercentLabel = "minHealthyPercent"
+	allPodsQuorum          = "all"
+)
+
+type quorumKind int
+
+const (
+	quorumAll quorumKind = iota
+	quorumPods
+	quorumPercent
+)
+
+// quorumPolicy tells how many pods of a service must be healthy for the service to be ok.
+type quorumPolicy struct {
+	kind  quorumKind
+	value int
+}
+
+func (q quorumPolicy) String() string {
+	switch q.kind {
+	case quorumPods:
+		return strconv.Itoa(q.value)
+	case quorumPercent:
+		return fmt.Sprintf("%d%%", q.value)
+	default:
+		return allPodsQuorum
+	}
+}
+
+// required returns the number of healthy pods needed by a service that wants desired pods and runs total pods.
+// Requiring all pods means every running pod, and at least one when the service wants some, so a service
+// that is scaling up is not failed for the pods it does not run yet.
+func (q quorumPolicy) required(desired int, total int) int {
+	switch q.kind {
+	case quorumPods:
+		return min(q.value, desired)
+	case quorumPercent:
+		return (desired*q.value + 99) / 100
+	default:
+		if total == 0 && desired > 0 {
+			return 1
+		}
+		return total
+	}
+}
+
+// parseQuorumPolicy parses "all", a number of pods or a percentage of the desired pods.
+func parseQuorumPolicy(value string) (quorumPolicy, error) {
+	value = strings.TrimSpace(value)
+	if value == allPodsQuorum {
+		return quorumPolicy{kind: quorumAll}, nil
+	}
+
+	if percent, isPercent := strings.CutSuffix(value, "%"); isPercent {
+		p, err := strconv.Atoi(percent)
+		if err != nil || p < 1 || p > 100 {
+			return quorumPolicy{}, fmt.Errorf("the percentage of healthy pods must be between 1%% and 100%%, got %q", value)
+		}
+		return quorumPolicy{kind: quorumPercent, value: p}, nil
+	}
+
+	pods, err := strconv.Atoi(value)
+	if err != nil || pods < 1 {
+		return quorumPolicy{}, fmt.Errorf("the number of healthy pods must be %q or a positive number, got %q", allPodsQuorum, value)
+	}
+	return quorumPolicy{kind: quorumPods, value: pods}, nil
+}
+
+// getQuorumPolicy returns the quorum set on the service by its annotation, or else by its labels.
+// It returns nil when the service does not set a quorum, or sets an invalid one.
+func getQuorumPolicy(k8sService *k8score.Service) *quorumPolicy {
+	value, ok := k8sService.Annotations[quorumAnnotation]
+	if !ok {
+		value, ok = k8sService.Labels[minHealthyPodsLabel]
+	}
+	if !ok {
+		if value, ok = k8sService.Labels[minHealthyPercentLabel]; ok {
+			value += "%"
+		}
+	}
+	if !ok {
+		return nil
+	}
+
+	policy, err := parseQuorumPolicy(value)
+	if err != nil {
+		log.WithError(err).Warnf("Cannot parse the quorum of service with name %s, all its pods have to be healthy.", k8sService.Name)
+		return nil
+	}
+	return &policy
+}
+
+// quorumStatus is the number of healthy pods of a service, against the number of pods it wants and the number it needs to be ok.
+// The unavailable pods that have been acked count as healthy, as they do not make their service fail.
+type quorumStatus struct {
+	Policy   string `json:"policy"`
+	Healthy  int    `json:"healthy"`
+	Desired  int    `json:"desired"`
+	Required int    `json:"required"`
+	// Degraded is set when the service has enough healthy pods to be ok, but fewer than it wants, some failing ones,
+	// or fewer ready pods than its workload wants
+	Degraded bool `json:"degraded"`
+	// Workload is the workload backing the service, if any
+	Workload *workloadStatus `json:"workload,omitempty"`
+}
+
+func (q quorumStatus) met() bool {
+	return q.Healthy >= q.Required
+}
+
+// rollingOut reports whether the workload of the service is rolling out, and its rollout is not stuck.
+func (q quorumStatus) rollingOut() bool {
+	return q.Workload != nil && q.Workload.Rollout == string(rolloutInProgress)
+}
+
+// rolloutStuck reports whether the workload of the service has exceeded its progress deadline.
+func (q quorumStatus) rolloutStuck() bool {
+	return q.Workload != nil && q.Workload.Rollout == string(rolloutStuck)
+}
+
+// evaluateQuorum counts the healthy pods of the service against its quorum. The desired pods are those of the workload
+// backing the service, or else the running pods, and the service is degraded when its workload reports fewer ready pods.
+// The starting pods are not counted, as if they did not run yet.
+func evaluateQuorum(probe serviceProbe, workloads map[string]workload, failingPods podFailures) quorumStatus {
+	policy := quorumPolicy{kind: quorumAll}
+	if probe.service.quorum != nil {
+		policy = *probe.service.quorum
+	}
+
+	total := len(probe.pods) - probe.countStarting()
+	unavailable := len(failingPods) - failingPods.countAcked()
+	desired := total
+	ready := total
+	w, exists := workloads[probe.service.key()]
+	if exists {
+		desired = int(w.desiredReplicas)
+		ready = int(w.readyReplicas)
+	}
+
+	status := quorumStatus{
+		Policy:   policy.String(),
+		Healthy:  total - unavailable,
+		Desired:  desired,
+		Required: policy.required(desired, total),
+	}
+	if exists {
+		status.Workload = &workloadStatus{Kind: string(w.kind), Name: w.name, Desired: desired, Ready: ready, Rollout: string(w.rollout), Message: w.rolloutMessage}
+	}
+	// a service rolling out is ok, even below its quorum, until its rollout is stuck
+	status.Degraded = (status.met() || status.rollingOut()) && (status.Healthy < status.Desired || unavailable != 0 || ready < desired)
+	return status
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	k8score "k8s.io/api/core/v1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseQuorumPolicy(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected quorumPolicy
+	}{
+		{value: "all", expected: quorumPolicy{kind: quorumAll}},
+		{value: "2", expected: quorumPolicy{kind: quorumPods, value: 2}},
+		{value: " 50% ", expected: quorumPolicy{kind: quorumPercent, value: 50}},
+		{value: "100%", expected: quorumPolicy{kind: quorumPercent, value: 100}},
+	}
+	for _, test := range tests {
+		policy, err := parseQuorumPolicy(test.value)
+		assert.NoError(t, err, test.value)
+		assert.Equal(t, test.expected, policy, test.value)
+	}
+
+	for _, invalid := range []string{"", "none", "0", "-1", "0%", "101%", "half%"} {
+		_, err := parseQuorumPolicy(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestQuorumPolicyRequired(t *testing.T) {
+	all := quorumPolicy{kind: quorumAll}
+	assert.Equal(t, 3, all.required(3, 3))
+	assert.Equal(t, 2, all.required(3, 2), "Pods that do not run yet should not be required")
+	assert.Equal(t, 4, all.required(3, 4), "Every running pod should be required")
+	assert.Equal(t, 1, all.required(3, 0))
+	assert.Equal(t, 0, all.required(0, 0))
+
+	pods := quorumPolicy{kind: quorumPods, value: 2}
+	assert.Equal(t, 2, pods.required(3, 3))
+	assert.Equal(t, 1, pods.required(1, 1), "More pods than desired should not be required")
+
+	percent := quorumPolicy{kind: quorumPercent, value: 50}
+	assert.Equal(t, 2, percent.required(3, 3), "The required pods should be rounded up")
+	assert.Equal(t, 2, percent.required(4, 1))
+	assert.Equal(t, 0, percent.required(0, 0))
+}
+
+func TestGetQuorumPolicy(t *testing.T) {
+	newK8sService := func(labels map[string]string, annotations map[string]string) *k8score.Service {
+		return &k8score.Service{ObjectMeta: k8smeta.ObjectMeta{Name: "service1", Labels: labels, Annotations: annotations}}
+	}
+
+	assert.Nil(t, getQuorumPolicy(newK8sService(nil, nil)))
+	assert.Equal(t, &quorumPolicy{kind: quorumPods, value: 2}, getQuorumPolicy(newK8sService(map[string]string{minHealthyPodsLabel: "2"}, nil)))
+	assert.Equal(t, &quorumPolicy{kind: quorumAll}, getQuorumPolicy(newK8sService(map[string]string{minHealthyPodsLabel: "all"}, nil)))
+	assert.Equal(t, &quorumPolicy{kind: quorumPercent, value: 60}, getQuorumPolicy(newK8sService(map[string]string{minHealthyPercentLabel: "60"}, nil)))
+	assert.Equal(t, &quorumPolicy{kind: quorumPercent, value: 75},
+		getQuorumPolicy(newK8sService(map[string]string{minHealthyPodsLabel: "2"}, map[string]string{quorumAnnotation: "75%"})),
+		"The annotation should take precedence over the labels")
+	assert.Nil(t, getQuorumPolicy(newK8sService(map[string]string{minHealthyPodsLabel: "many"}, nil)))
+}
+
+func newQuorumServiceProbe(quorum *quorumPolicy, healthy int, failing int) serviceProbe {
+	probe := serviceProbe{service: service{name: "service1", namespace: "default", quorum: quorum}}
+	for i := 0; i < healthy; i++ {
+		probe.pods = append(probe.pods, podProbe{pod: pod{name: "ok-pod"}, health: healthcheckResponse{Checks: []podCheck{{Name: "check", OK: true}}}})
+	}
+	for i := 0; i < failing; i++ {
+		probe.pods = append(probe.pods, newFailingPodProbe(pod{name: "notok-pod"}, 1))
+	}
+	return probe
+}
+
+func TestCheckServiceHealthWithQuorum(t *testing.T) {
+	workloads := map[string]workload{"default/service1": {desiredReplicas: 3, readyReplicas: 3}}
+	twoPods := &quorumPolicy{kind: quorumPods, value: 2}
+
+	output, err := checkServiceHealth(newQuorumServiceProbe(twoPods, 2, 1), workloads, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, "2/3 pods available, 2 required, degraded - notok-pod: failing checks check (severity 1)", output)
+
+	_, err = checkServiceHealth(newQuorumServiceProbe(twoPods, 1, 2), workloads, time.Now())
+	assert.EqualError(t, err, "1/3 pods available, 2 required - notok-pod: failing checks check (severity 1); notok-pod: failing checks check (severity 1)")
+
+	output, err = checkServiceHealth(newQuorumServiceProbe(twoPods, 3, 0), workloads, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, "3/3 pods available, 2 required", output)
+}
+
+func TestCheckServiceHealthRequiresAllPodsByDefault(t *testing.T) {
+	workloads := map[string]workload{"default/service1": {desiredReplicas: 3}}
+
+	_, err := checkServiceHealth(newQuorumServiceProbe(nil, 2, 1), workloads, time.Now())
+	assert.Error(t, err)
+
+	output, err := checkServiceHealth(newQuorumServiceProbe(nil, 2, 0), workloads, time.Now())
+	assert.NoError(t, err, "A service that does not run all its pods yet should be ok")
+	assert.Equal(t, "2/2 pods available, degraded", output)
+
+	_, err = checkServiceHealth(newQuorumServiceProbe(nil, 0, 0), workloads, time.Now())
+	assert.Error(t, err, "A service without pods should fail when it wants some")
+}
+
+func TestGetSeverityForServiceWithQuorum(t *testing.T) {
+	probe := newQuorumServiceProbe(&quorumPolicy{kind: quorumPods, value: 2}, 1, 2)
+	probe.service.isResilient = true
+
+	assert.Equal(t, uint8(1), getSeverityForService(probe, time.Now()),
+		"A resilient service below its quorum should take the severity of its failing pods")
+}
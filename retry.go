@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHealthcheckMaxBackoff = 30 // In seconds
+	defaultHealthcheckMaxElapsed = 45 // In seconds
+	defaultHealthcheckJitter     = 20 // In percent of the backoff
+	backoffMultiplier            = 2
+)
+
+// retryPolicy tells when and how soon the health of a pod is fetched again after a failed attempt.
+type retryPolicy struct {
+	// maxRetries is the number of attempts made after the first one
+	maxRetries int
+	// initialBackoff is the wait before the first retry; it doubles with every retry, up to maxBackoff
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	// jitter is the fraction of the backoff randomly added or taken away, so the pods of a service are not retried in lockstep
+	jitter float64
+	// maxElapsed bounds the time spent on all the attempts: no retry is made that would start after it. 0 means no bound
+	maxElapsed time.Duration
+}
+
+// backoff returns the wait before the given retry, counted from 1. random is a number in [0, 1) that picks the jitter.
+func (p retryPolicy) backoff(retry int, random float64) time.Duration {
+	backoff := p.initialBackoff
+	for i := 1; i < retry && (p.maxBackoff <= 0 || backoff < p.maxBackoff); i++ {
+		backoff *= backoffMultiplier
+	}
+	if p.maxBackoff > 0 && backoff > p.maxBackoff {
+		backoff = p.maxBackoff
+	}
+
+	return backoff + time.Duration(float64(backoff)*p.jitter*(2*random-1))
+}
+
+// healthcheckAttempt is the outcome of an attempt to fetch the health of a pod.
+type healthcheckAttempt struct {
+	err      error
+	duration time.Duration
+}
+
+type healthcheckAttempts []healthcheckAttempt
+
+// describe lists the outcome of every attempt, e.g. "attempt 1 failed after 12s: timeout; attempt 2 succeeded after 15ms".
+func (a healthcheckAttempts) describe() string {
+	outcomes := make([]string, 0, len(a))
+	for i, attempt := range a {
+		duration := attempt.duration.Round(time.Millisecond)
+		if attempt.err != nil {
+			outcomes = append(outcomes, fmt.Sprintf("attempt %d failed after %s: %s", i+1, duration, attempt.err.Error()))
+		} else {
+			outcomes = append(outcomes, fmt.Sprintf("attempt %d succeeded after %s", i+1, duration))
+		}
+	}
+	return strings.Join(outcomes, "; ")
+}
+
+// retried tells whether more than one attempt was made, in which case the outcome of every attempt is worth reporting.
+func (a healthcheckAttempts) retried() bool {
+	return len(a) > 1
+}
+
+// sleepContext waits for the given duration, unless the context is done first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
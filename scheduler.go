@@ -0,0 +1,294 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
+	log "github.com/Financial-Times/go-logger"
+)
+
+const defaultSchedulerWorkers = 10
+
+// checkFunc runs a single check for a measured service, along with its breakdown, and reports whether it produced a result.
+// The context is cancelled when the service is unscheduled, rescheduled or the scheduler is stopped.
+type checkFunc func(context.Context, measuredService) (fthealth.CheckResult, checkBreakdown, bool)
+
+// checkScheduler runs the recurring service checks. The schedule is owned by a single goroutine (run),
+// everything else talks to it through channels, and the checks themselves are executed by a bounded pool of workers.
+// Results are written to the result store by the owner goroutine only, so a removed service never gets a late result.
+type checkScheduler struct {
+	check    checkFunc
+	workers  int
+	results  *resultStore
+	requests chan scheduleRequest
+	stopping chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+
+	// measured is a read-only view of the scheduled services, kept for the HTTP handlers and the metrics feeder.
+	measuredLock sync.RWMutex
+	measured     map[string]measuredService
+}
+
+type scheduleRequest struct {
+	service       service
+	refreshPeriod time.Duration
+	remove        bool
+}
+
+type scheduleEntry struct {
+	mService   measuredService
+	generation uint64
+	ctx        context.Context
+	cancel     context.CancelFunc
+}
+
+type scheduledRun struct {
+	serviceKey string
+	generation uint64
+	nextRun    time.Time
+}
+
+type checkJob struct {
+	run      scheduledRun
+	ctx      context.Context
+	mService measuredService
+}
+
+type checkOutcome struct {
+	run         scheduledRun
+	checkResult fthealth.CheckResult
+	breakdown   checkBreakdown
+	hasResult   bool
+}
+
+// runQueue is a priority queue of scheduled runs ordered by their next run time.
+type runQueue []scheduledRun
+
+func (q runQueue) Len() int {
+	return len(q)
+}
+
+func (q runQueue) Less(i, j int) bool {
+	return q[i].nextRun.Before(q[j].nextRun)
+}
+
+func (q runQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *runQueue) Push(x interface{}) {
+	*q = append(*q, x.(scheduledRun))
+}
+
+func (q *runQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	*q = old[:n-1]
+	return item
+}
+
+func newCheckScheduler(check checkFunc, workers int, results *resultStore) *checkScheduler {
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &checkScheduler{
+		check:    check,
+		workers:  workers,
+		results:  results,
+		requests: make(chan scheduleRequest),
+		stopping: make(chan struct{}),
+		stopped:  make(chan struct{}),
+		measured: make(map[string]measuredService),
+	}
+}
+
+func (s *checkScheduler) start() {
+	go s.run()
+}
+
+// stop cancels all running checks and waits for the workers to exit.
+func (s *checkScheduler) stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopping)
+	})
+	<-s.stopped
+}
+
+// schedule starts the recurring check of a service, or restarts it if the service or its refresh period changed.
+func (s *checkScheduler) schedule(service service, refreshPeriod time.Duration) {
+	s.send(scheduleRequest{service: service, refreshPeriod: refreshPeriod})
+}
+
+// unschedule stops the recurring check of the service with the given namespace-qualified key.
+func (s *checkScheduler) unschedule(serviceKey string) {
+	namespace, name := splitObjectKey(serviceKey)
+	s.send(scheduleRequest{service: service{name: name, namespace: namespace}, remove: true})
+}
+
+func (s *checkScheduler) send(req scheduleRequest) {
+	select {
+	case s.requests <- req:
+	case <-s.stopping:
+		log.Warnf("Check scheduler is stopped, ignoring schedule request for service %s", req.service.key())
+	}
+}
+
+func (s *checkScheduler) getMeasuredService(serviceKey string) (measuredService, bool) {
+	s.measuredLock.RLock()
+	defer s.measuredLock.RUnlock()
+	mService, ok := s.measured[serviceKey]
+	return mService, ok
+}
+
+func (s *checkScheduler) getMeasuredServices() map[string]measuredService {
+	s.measuredLock.RLock()
+	defer s.measuredLock.RUnlock()
+	measured := make(map[string]measuredService, len(s.measured))
+	for key, mService := range s.measured {
+		measured[key] = mService
+	}
+	return measured
+}
+
+func (s *checkScheduler) setMeasuredService(mService measuredService) {
+	s.measuredLock.Lock()
+	s.measured[mService.service.key()] = mService
+	s.measuredLock.Unlock()
+}
+
+func (s *checkScheduler) deleteMeasuredService(serviceKey string) {
+	s.measuredLock.Lock()
+	delete(s.measured, serviceKey)
+	s.measuredLock.Unlock()
+}
+
+//nolint:gocognit
+func (s *checkScheduler) run() {
+	entries := make(map[string]*scheduleEntry)
+	queue := &runQueue{}
+	var ready []checkJob
+	var generation uint64
+
+	jobs := make(chan checkJob)
+	completed := make(chan checkOutcome)
+	wg := sync.WaitGroup{}
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.work(jobs, completed)
+		}()
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		if queue.Len() > 0 {
+			timer.Reset(time.Until((*queue)[0].nextRun))
+		} else {
+			timer.Stop()
+		}
+
+		var jobsChannel chan checkJob
+		var nextJob checkJob
+		if len(ready) > 0 {
+			jobsChannel = jobs
+			nextJob = ready[0]
+		}
+
+		select {
+		case req := <-s.requests:
+			key := req.service.key()
+			entry, exists := entries[key]
+			if req.remove {
+				if exists {
+					entry.cancel()
+					delete(entries, key)
+					s.deleteMeasuredService(key)
+					s.results.delete(key)
+				}
+				continue
+			}
+
+			refreshPeriod := req.refreshPeriod
+			if refreshPeriod <= 0 {
+				refreshPeriod = defaultRefreshPeriod
+			}
+			if exists && entry.mService.refreshPeriod == refreshPeriod && reflect.DeepEqual(entry.mService.service, req.service) {
+				continue
+			}
+			if exists {
+				entry.cancel()
+			}
+
+			generation++
+			ctx, cancel := context.WithCancel(context.Background())
+			newEntry := &scheduleEntry{
+				mService:   measuredService{service: req.service, refreshPeriod: refreshPeriod},
+				generation: generation,
+				ctx:        ctx,
+				cancel:     cancel,
+			}
+			entries[key] = newEntry
+			s.setMeasuredService(newEntry.mService)
+			heap.Push(queue, scheduledRun{serviceKey: key, generation: generation, nextRun: time.Now()})
+
+		case <-timer.C:
+			now := time.Now()
+			for queue.Len() > 0 && !(*queue)[0].nextRun.After(now) {
+				run := heap.Pop(queue).(scheduledRun)
+				entry, ok := entries[run.serviceKey]
+				if !ok || entry.generation != run.generation {
+					continue
+				}
+				ready = append(ready, checkJob{run: run, ctx: entry.ctx, mService: entry.mService})
+			}
+
+		case jobsChannel <- nextJob:
+			ready = ready[1:]
+
+		case outcome := <-completed:
+			run := outcome.run
+			entry, ok := entries[run.serviceKey]
+			if !ok || entry.generation != run.generation {
+				continue
+			}
+			if outcome.hasResult {
+				s.results.set(run.serviceKey, outcome.checkResult, outcome.breakdown)
+			}
+			run.nextRun = time.Now().Add(entry.mService.refreshPeriod)
+			heap.Push(queue, run)
+
+		case <-s.stopping:
+			for _, entry := range entries {
+				entry.cancel()
+			}
+			close(jobs)
+			wg.Wait()
+			close(s.stopped)
+			return
+		}
+	}
+}
+
+func (s *checkScheduler) work(jobs <-chan checkJob, completed chan<- checkOutcome) {
+	for job := range jobs {
+		outcome := checkOutcome{run: job.run}
+		if job.ctx.Err() == nil {
+			outcome.checkResult, outcome.breakdown, outcome.hasResult = s.check(job.ctx, job.mService)
+		}
+
+		select {
+		case completed <- outcome:
+		case <-s.stopping:
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
+	"github.com/stretchr/testify/assert"
+)
+
+type checkRecorder struct {
+	sync.Mutex
+	runs map[string]int
+}
+
+func newCheckRecorder() *checkRecorder {
+	return &checkRecorder{runs: make(map[string]int)}
+}
+
+func (r *checkRecorder) check(_ context.Context, mService measuredService) (fthealth.CheckResult, checkBreakdown, bool) {
+	r.Lock()
+	r.runs[mService.service.name]++
+	r.Unlock()
+	return fthealth.CheckResult{Name: mService.service.name, Ok: true}, checkBreakdown{}, true
+}
+
+func (r *checkRecorder) count(serviceName string) int {
+	r.Lock()
+	defer r.Unlock()
+	return r.runs[serviceName]
+}
+
+func TestSchedulerRunsCheckImmediatelyAndPeriodically(t *testing.T) {
+	recorder := newCheckRecorder()
+	scheduler := newCheckScheduler(recorder.check, 2, newResultStore(nil))
+	scheduler.start()
+	defer scheduler.stop()
+
+	scheduler.schedule(service{name: "service1"}, 10*time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		return recorder.count("service1") >= 3
+	}, time.Second, time.Millisecond)
+	result, ok := scheduler.results.get("service1")
+	assert.True(t, ok)
+	assert.Equal(t, "service1", result.checkResult.Name)
+}
+
+func TestSchedulerUnscheduleStopsChecks(t *testing.T) {
+	recorder := newCheckRecorder()
+	scheduler := newCheckScheduler(recorder.check, 2, newResultStore(nil))
+	scheduler.start()
+	defer scheduler.stop()
+
+	scheduler.schedule(service{name: "service1"}, 5*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return recorder.count("service1") >= 1
+	}, time.Second, time.Millisecond)
+
+	scheduler.unschedule("service1")
+	assert.Eventually(t, func() bool {
+		_, ok := scheduler.getMeasuredService("service1")
+		return !ok
+	}, time.Second, time.Millisecond)
+	_, hasResult := scheduler.results.get("service1")
+	assert.False(t, hasResult)
+
+	// at most one run may have been in flight when the service was unscheduled
+	countAfterUnschedule := recorder.count("service1")
+	time.Sleep(50 * time.Millisecond)
+	assert.LessOrEqual(t, recorder.count("service1"), countAfterUnschedule+1)
+}
+
+func TestSchedulerRescheduleCancelsRunningCheck(t *testing.T) {
+	started := make(chan struct{}, 1)
+	cancelled := make(chan struct{})
+	check := func(ctx context.Context, mService measuredService) (fthealth.CheckResult, checkBreakdown, bool) {
+		if mService.service.appPort != 8080 {
+			return fthealth.CheckResult{}, checkBreakdown{}, false
+		}
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-ctx.Done()
+		close(cancelled)
+		return fthealth.CheckResult{Name: mService.service.name}, checkBreakdown{}, true
+	}
+
+	scheduler := newCheckScheduler(check, 2, newResultStore(nil))
+	scheduler.start()
+	defer scheduler.stop()
+
+	scheduler.schedule(service{name: "service1", appPort: 8080}, time.Hour)
+	<-started
+	scheduler.schedule(service{name: "service1", appPort: 8081}, time.Hour)
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		assert.Fail(t, "Expected the running check to be cancelled by the reschedule")
+	}
+
+	assert.Eventually(t, func() bool {
+		mService, ok := scheduler.getMeasuredService("service1")
+		return ok && mService.service.appPort == 8081
+	}, time.Second, time.Millisecond)
+	// the cancelled check of the previous definition must not store its result
+	time.Sleep(20 * time.Millisecond)
+	_, ok := scheduler.results.get("service1")
+	assert.False(t, ok)
+}
+
+func TestSchedulerIgnoresUnchangedSchedule(t *testing.T) {
+	recorder := newCheckRecorder()
+	scheduler := newCheckScheduler(recorder.check, 2, newResultStore(nil))
+	scheduler.start()
+	defer scheduler.stop()
+
+	scheduler.schedule(service{name: "service1"}, time.Hour)
+	assert.Eventually(t, func() bool {
+		return recorder.count("service1") == 1
+	}, time.Second, time.Millisecond)
+
+	scheduler.schedule(service{name: "service1"}, time.Hour)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 1, recorder.count("service1"))
+
+	scheduler.schedule(service{name: "service1"}, 2*time.Hour)
+	assert.Eventually(t, func() bool {
+		return recorder.count("service1") == 2
+	}, time.Second, time.Millisecond)
+}
+
+func TestSchedulerBoundsConcurrentChecks(t *testing.T) {
+	var running, maxRunning int32
+	check := func(_ context.Context, _ measuredService) (fthealth.CheckResult, checkBreakdown, bool) {
+		current := atomic.AddInt32(&running, 1)
+		for {
+			observed := atomic.LoadInt32(&maxRunning)
+			if current <= observed || atomic.CompareAndSwapInt32(&maxRunning, observed, current) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return fthealth.CheckResult{}, checkBreakdown{}, false
+	}
+
+	scheduler := newCheckScheduler(check, 3, newResultStore(nil))
+	scheduler.start()
+	defer scheduler.stop()
+
+	for i := 0; i < 20; i++ {
+		scheduler.schedule(service{name: fmt.Sprintf("service%d", i)}, time.Millisecond)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	assert.LessOrEqual(t, atomic.LoadInt32(&maxRunning), int32(3))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&maxRunning))
+}
+
+func TestSchedulerStopCancelsRunningChecks(t *testing.T) {
+	started := make(chan struct{})
+	check := func(ctx context.Context, _ measuredService) (fthealth.CheckResult, checkBreakdown, bool) {
+		close(started)
+		<-ctx.Done()
+		return fthealth.CheckResult{}, checkBreakdown{}, false
+	}
+
+	scheduler := newCheckScheduler(check, 1, newResultStore(nil))
+	scheduler.start()
+	scheduler.schedule(service{name: "service1"}, time.Hour)
+	<-started
+
+	stopped := make(chan struct{})
+	go func() {
+		scheduler.stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		assert.Fail(t, "Expected the scheduler to stop")
+	}
+
+	// requests after stop must not block
+	scheduler.schedule(service{name: "service2"}, time.Hour)
+	scheduler.unschedule("service1")
+}
+
+func TestSchedulerConcurrentServiceChurnAndReads(t *testing.T) {
+	recorder := newCheckRecorder()
+	scheduler := newCheckScheduler(recorder.check, 4, newResultStore(nil))
+	scheduler.start()
+	defer scheduler.stop()
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				name := fmt.Sprintf("service%d", (w*50+i)%10)
+				scheduler.schedule(service{name: name, appPort: int32(8080 + i%2)}, time.Millisecond)
+				if i%3 == 0 {
+					scheduler.unschedule(name)
+				}
+			}
+		}(w)
+	}
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				for name, mService := range scheduler.getMeasuredServices() {
+					assert.Equal(t, name, mService.service.name)
+				}
+				scheduler.getMeasuredService("service1")
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < 10; i++ {
+		scheduler.unschedule(fmt.Sprintf("service%d", i))
+	}
+	assert.Eventually(t, func() bool {
+		return len(scheduler.getMeasuredServices()) == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestControllerConcurrentScheduleAndCachedReads(t *testing.T) {
+	controller, _ := initializeMockController(nil)
+	defer controller.scheduler.stop()
+	categories := map[string]category{"default": {name: "default"}}
+
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			controller.scheduleService(context.TODO(), service{name: "test-service-name", appPort: int32(8080 + i%2)})
+			controller.unscheduleService("test-service-name-2")
+			controller.scheduleService(context.TODO(), service{name: "test-service-name-2"})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			_, _, err := controller.collectChecksFromCachesFor(context.TODO(), categories)
+			assert.NoError(t, err)
+			controller.getMeasuredServices()
+		}
+	}()
+	wg.Wait()
+}
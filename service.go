@@ -5,59 +5,105 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	log "github.com/Financial-Times/go-logger"
+	batchv1 "k8s.io/api/batch/v1"
 	k8score "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
 )
 
 type httpClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 type k8sHealthcheckService struct {
-	k8sClient        kubernetes.Interface
-	httpClient       httpClient
-	services         servicesMap
-	acks             map[string]string
-	maxCheckAttempts int
-	checkCooldown    time.Duration
+	k8sClient  kubernetes.Interface
+	httpClient httpClient
+	services   servicesMap
+	retries    retryPolicy
+	// startupGrace is how long the pods are given to become ready after they start, before they are probed
+	startupGrace time.Duration
+	// podChecksPerService bounds the pods of a service checked at the same time, and podChecks the pods checked across all services
+	podChecksPerService int
+	podChecks           *limiter
+	// outboundRequests bounds the requests to the pods in flight, whether they go through httpClient or are made by the other probes
+	outboundRequests  *limiter
+	serviceEvents     chan serviceEvent
+	informerFactories []informers.SharedInformerFactory
+	// workloadListers holds the listers of every monitored namespace,
+	// or a single entry for k8smeta.NamespaceAll when all namespaces are monitored.
+	workloadListers map[string]workloadListers
+	configMapLister corelisters.ConfigMapLister
+	// secretListers holds the listers of the credentials Secrets, keyed like workloadListers
+	secretListers map[string]corelisters.SecretLister
+	tlsClients    *tlsClients
+}
+
+type workloadListers struct {
+	podLister         corelisters.PodLister
+	deploymentLister  appslisters.DeploymentLister
+	statefulSetLister appslisters.StatefulSetLister
+	daemonSetLister   appslisters.DaemonSetLister
+	replicaSetLister  appslisters.ReplicaSetLister
+	jobLister         batchlisters.JobLister
+	cronJobLister     batchlisters.CronJobLister
 }
 
 type healthcheckService interface {
 	getCategories(context.Context) (map[string]category, error)
-	updateCategory(context.Context, string, bool) error
-	getDeployments(context.Context) (map[string]deployment, error)
+	updateCategory(context.Context, string, bool, categoryChange) (category, error)
+	getWorkloads(context.Context, []service) (map[string]workload, error)
 	getServiceByName(serviceName string) (service, error)
 	getServicesMapByNames([]string) map[string]service
 	isServicePresent(string) bool
-	getPodsForService(context.Context, string) ([]pod, error)
-	getPodByName(context.Context, string) (pod, error)
-	checkServiceHealth(context.Context, service, map[string]deployment) (string, error)
-	checkPodHealth(pod, int32) error
-	getIndividualPodSeverity(pod, int32) (uint8, bool, error)
-	getHealthChecksForPod(pod, int32) (healthcheckResponse, error)
+	getPodsForService(context.Context, service) ([]pod, error)
+	getPodByName(context.Context, string, string) (pod, error)
+	probeService(context.Context, service) (serviceProbe, error)
+	probePods(context.Context, []pod, service) []podProbe
+	probePod(context.Context, pod, service) podProbe
 	addAck(context.Context, string, string) error
 	removeAck(context.Context, string) error
-	getHTTPClient() httpClient
+	removeExpiredAcks(context.Context, time.Time) ([]string, error)
+	getSilences(context.Context) ([]silence, error)
+	addSilence(context.Context, silence) error
+	removeSilence(context.Context, string) error
+	removeEndedSilences(context.Context, time.Time) ([]string, error)
+	getHTTPEndpoint(service, string) (httpEndpoint, error)
+	getServiceEvents() <-chan serviceEvent
 	RLockServices()
 	RUnlockServices()
 }
 
 const (
+	allNamespaces                     = "all"
+	configNamespace                   = k8score.NamespaceDefault
 	defaultRefreshRate                = 60
 	defaultRetryTimeoutAfterError     = 5 //In seconds
 	defaultFailureThreshold           = 3
 	defaultSeverity                   = uint8(2)
 	defaultResiliency                 = true
 	ackMessagesConfigMapName          = "healthcheck.ack.messages"
+	podAckKeyPrefix                   = "pod."
+	checkAckKeyPrefix                 = "check."
 	ackMessagesConfigMapLabelSelector = "healthcheck-acknowledgements-for=aggregate-healthcheck"
+	categoriesConfigMapLabelSelector  = "healthcheck-categories-for=aggregate-healthcheck"
+	servicesLabelSelector             = "hasHealthcheck=true"
 	defaultAppPort                    = int32(8080)
+	serviceEventsBufferSize           = 256
+	informerResyncPeriod              = 5 * time.Minute
 )
 
 func (hs *k8sHealthcheckService) RLockServices() {
@@ -70,95 +116,143 @@ func (hs *k8sHealthcheckService) RUnlockServices() {
 
 func (hs *k8sHealthcheckService) updateAcksForServices(acksMap map[string]string) {
 	hs.services.Lock()
-	for serviceName, service := range hs.services.m {
-		if ackMsg, found := acksMap[serviceName]; found {
+	for serviceKey, service := range hs.services.m {
+		if ackMsg, found := acksMap[service.ackKey()]; found {
 			service.ack = ackMsg
 		} else {
 			service.ack = ""
 		}
-		hs.services.m[serviceName] = service
+		hs.services.m[serviceKey] = service
 	}
 	hs.services.Unlock()
 }
 
-func (hs *k8sHealthcheckService) watchAcks() {
-	for {
-		watcher, err := hs.k8sClient.CoreV1().ConfigMaps(k8score.NamespaceDefault).Watch(context.Background(), k8smeta.ListOptions{LabelSelector: ackMessagesConfigMapLabelSelector})
+func (hs *k8sHealthcheckService) onAcksConfigMapChanged(_ interface{}) {
+	acks := hs.getAcks()
+	hs.updateAcksForServices(acks)
+	log.Infof("Acks configMap has been updated: %s", acks)
+}
 
-		if err != nil {
-			log.WithError(err).Errorf("Error while starting to watch acks configMap with label selector %s", ackMessagesConfigMapLabelSelector)
-			log.Infof("Reconnecting after %d seconds...", defaultRetryTimeoutAfterError*time.Second)
-			time.Sleep(defaultRetryTimeoutAfterError * time.Second)
+func (hs *k8sHealthcheckService) getAcks() map[string]string {
+	acks := make(map[string]string)
+	k8sAckConfigMaps, err := hs.configMapLister.ConfigMaps(configNamespace).List(mustParseSelector(ackMessagesConfigMapLabelSelector))
+	if err != nil {
+		log.WithError(err).Errorf("Cannot list acks configMaps with label selector %s", ackMessagesConfigMapLabelSelector)
+		return acks
+	}
 
-			continue
+	for _, k8sAckConfigMap := range k8sAckConfigMaps {
+		for serviceName, ackMsg := range k8sAckConfigMap.Data {
+			acks[serviceName] = ackMsg
 		}
+	}
 
-		log.Info("Started watching acks configMap")
-		resultChannel := watcher.ResultChan()
-		for msg := range resultChannel {
-			switch msg.Type {
-			case watch.Added, watch.Modified:
-				k8sConfigMap := msg.Object.(*k8score.ConfigMap)
-				hs.updateAcksForServices(k8sConfigMap.Data)
-				hs.acks = k8sConfigMap.Data
-				log.Infof("Acks configMap has been updated: %s", k8sConfigMap.Data)
-			case watch.Deleted:
-				hs.acks = make(map[string]string)
-				log.Error("Acks configMap has been deleted. From now on the acks will no longer be available.")
-			default:
-				log.Error("Error received on watch acks configMap. Channel may be full")
-			}
-		}
+	return acks
+}
 
-		log.Info("Acks configMap watching terminated. Reconnecting...")
+func (hs *k8sHealthcheckService) onServiceAddedOrUpdated(obj interface{}) {
+	k8sService, ok := obj.(*k8score.Service)
+	if !ok {
+		return
 	}
-}
 
-func (hs *k8sHealthcheckService) watchServices() {
-	for {
-		watcher, err := hs.k8sClient.CoreV1().Services(k8score.NamespaceDefault).Watch(context.Background(), k8smeta.ListOptions{LabelSelector: "hasHealthcheck=true"})
-		if err != nil {
-			log.WithError(err).Error("Error while starting to watch services")
-			log.Infof("Reconnecting after %d seconds...", defaultRetryTimeoutAfterError*time.Second)
-			time.Sleep(defaultRetryTimeoutAfterError * time.Second)
+	// the service may have been relabelled so that it is no longer monitored
+	if !mustParseSelector(servicesLabelSelector).Matches(labels.Set(k8sService.Labels)) {
+		hs.removeService(newObjectKey(k8sService.Namespace, k8sService.Name), false)
+		return
+	}
 
-			continue
+	hs.upsertService(populateService(k8sService, hs.getAcks()))
+}
+
+func (hs *k8sHealthcheckService) onServiceDeleted(obj interface{}) {
+	k8sService, ok := obj.(*k8score.Service)
+	if !ok {
+		tombstone, isTombstone := obj.(cache.DeletedFinalStateUnknown)
+		if !isTombstone {
+			return
+		}
+		if k8sService, ok = tombstone.Obj.(*k8score.Service); !ok {
+			return
 		}
+	}
 
-		log.Info("Started watching services")
-		resultChannel := watcher.ResultChan()
-		for msg := range resultChannel {
-			switch msg.Type {
-			case watch.Added, watch.Modified:
-				k8sService := msg.Object.(*k8score.Service)
-				s := populateService(k8sService, hs.acks)
-
-				hs.services.Lock()
-				hs.services.m[s.name] = s
-				hs.services.Unlock()
-
-				log.Infof("Service with name %s added or updated.", s.name)
-			case watch.Deleted:
-				k8sService := msg.Object.(*k8score.Service)
-				hs.services.Lock()
-				delete(hs.services.m, k8sService.Name)
-				hs.services.Unlock()
-				log.Infof("Service with name %s has been removed", k8sService.Name)
-			default:
-				log.Error("Error received on watch services. Channel may be full")
-			}
+	hs.removeService(newObjectKey(k8sService.Namespace, k8sService.Name), false)
+}
+
+func (hs *k8sHealthcheckService) onCronJobAddedOrUpdated(obj interface{}) {
+	k8sCronJob, ok := obj.(*batchv1.CronJob)
+	if !ok {
+		return
+	}
+
+	// the CronJob may have been relabelled so that it is no longer monitored
+	if !mustParseSelector(servicesLabelSelector).Matches(labels.Set(k8sCronJob.Labels)) {
+		hs.removeService(newObjectKey(k8sCronJob.Namespace, k8sCronJob.Name), true)
+		return
+	}
+
+	hs.upsertService(populateCronJobService(k8sCronJob, hs.getAcks()))
+}
+
+func (hs *k8sHealthcheckService) onCronJobDeleted(obj interface{}) {
+	k8sCronJob, ok := obj.(*batchv1.CronJob)
+	if !ok {
+		tombstone, isTombstone := obj.(cache.DeletedFinalStateUnknown)
+		if !isTombstone {
+			return
+		}
+		if k8sCronJob, ok = tombstone.Obj.(*batchv1.CronJob); !ok {
+			return
 		}
+	}
+
+	hs.removeService(newObjectKey(k8sCronJob.Namespace, k8sCronJob.Name), true)
+}
+
+// upsertService adds or updates a monitored service. A Service and a monitored CronJob with the same name
+// in the same namespace share their key, in which case the one monitored first is kept.
+func (hs *k8sHealthcheckService) upsertService(s service) {
+	hs.services.Lock()
+	if existing, found := hs.services.m[s.key()]; found && existing.isCronJob() != s.isCronJob() {
+		hs.services.Unlock()
+		log.Warnf("Service %s is already monitored, ignoring the other object with the same name.", s.key())
+		return
+	}
+	hs.services.m[s.key()] = s
+	hs.services.Unlock()
 
-		log.Info("Services watching terminated. Reconnecting...")
+	log.Infof("Service %s added or updated.", s.key())
+	hs.serviceEvents <- serviceEvent{eventType: serviceUpserted, service: s}
+}
+
+// removeService removes a monitored service, as long as it is a monitored CronJob when isCronJob is set, or a Service otherwise.
+func (hs *k8sHealthcheckService) removeService(serviceKey string, isCronJob bool) {
+	hs.services.Lock()
+	s, found := hs.services.m[serviceKey]
+	found = found && s.isCronJob() == isCronJob
+	if found {
+		delete(hs.services.m, serviceKey)
+	}
+	hs.services.Unlock()
+
+	if !found {
+		return
 	}
+
+	log.Infof("Service %s has been removed", serviceKey)
+	hs.serviceEvents <- serviceEvent{eventType: serviceRemoved, service: s}
 }
 
+// podRequestTimeout bounds a single request to a pod: services should respond within 10s
+const podRequestTimeout = 12 * time.Second
+
 func getDefaultClient() *http.Client {
 	return &http.Client{
-		Timeout: 12 * time.Second, // services should respond within 10s
+		Timeout: podRequestTimeout,
 		Transport: &http.Transport{
 			DialContext: (&net.Dialer{
-				Timeout:   12 * time.Second,
+				Timeout:   podRequestTimeout,
 				KeepAlive: 90 * time.Second, // health check runs every 60s so good to reuse connection
 				DualStack: true,
 			}).DialContext,
@@ -171,7 +265,7 @@ func getDefaultClient() *http.Client {
 	}
 }
 
-func initializeHealthCheckService(maxCheckAttempts int, checkCooldown time.Duration) *k8sHealthcheckService {
+func initializeHealthCheckService(retries retryPolicy, limits checkLimits, startupGrace time.Duration, namespaces []string) *k8sHealthcheckService {
 	client := getDefaultClient()
 
 	// creates the in-cluster config
@@ -186,187 +280,535 @@ func initializeHealthCheckService(maxCheckAttempts int, checkCooldown time.Durat
 		panic(fmt.Sprintf("Failed to create k8s client: %v", err.Error()))
 	}
 
-	services := make(map[string]service)
+	k8sService, err := newK8sHealthcheckService(k8sClient, client, retries, limits, startupGrace, namespaces)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to set up k8s informers: %v", err.Error()))
+	}
+
+	if err = k8sService.startInformers(make(chan struct{})); err != nil {
+		panic(fmt.Sprintf("Failed to start k8s informers: %v", err.Error()))
+	}
+
+	return k8sService
+}
+
+// parseNamespaces parses the value of the namespaces option: a comma separated list of namespaces, or "all".
+// The result is empty when all namespaces are monitored.
+func parseNamespaces(namespacesOption string) []string {
+	var namespaces []string
+	for _, namespace := range strings.Split(namespacesOption, ",") {
+		namespace = strings.TrimSpace(namespace)
+		if namespace == allNamespaces {
+			return nil
+		}
+		if namespace != "" && !isStringInSlice(namespace, namespaces) {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+
+	if len(namespaces) == 0 {
+		return []string{k8score.NamespaceDefault}
+	}
+	return namespaces
+}
+
+// newK8sHealthcheckService wires the shared informers the service reads the cluster state from.
+// Lookups go through the informer listers, so the number of API server calls does not depend on
+// how many services are monitored or how often they are checked.
+// Workloads are watched in the given namespaces (all of them if none is given), while the
+// acks and categories configMaps are always read from the config namespace.
+// All the requests to the pods go through the given client, bounded by the outbound requests limit.
+func newK8sHealthcheckService(k8sClient kubernetes.Interface, client httpClient, retries retryPolicy, limits checkLimits, startupGrace time.Duration,
+	namespaces []string) (*k8sHealthcheckService, error) {
+	if len(namespaces) == 0 {
+		namespaces = []string{k8smeta.NamespaceAll}
+	}
 
+	outboundRequests := newLimiter(limits.outboundRequests)
 	k8sService := &k8sHealthcheckService{
-		httpClient:       client,
-		k8sClient:        k8sClient,
-		services:         servicesMap{m: services},
-		maxCheckAttempts: maxCheckAttempts,
-		checkCooldown:    checkCooldown,
+		httpClient:          newLimitedHTTPClient(client, outboundRequests),
+		outboundRequests:    outboundRequests,
+		k8sClient:           k8sClient,
+		services:            servicesMap{m: make(map[string]service)},
+		retries:             retries,
+		startupGrace:        startupGrace,
+		podChecksPerService: limits.podChecksPerService,
+		podChecks:           newLimiter(limits.podChecks),
+		serviceEvents:       make(chan serviceEvent, serviceEventsBufferSize),
+		workloadListers:     make(map[string]workloadListers),
+		secretListers:       make(map[string]corelisters.SecretLister),
+		tlsClients:          newTLSClients(outboundRequests),
+	}
+
+	var configFactory informers.SharedInformerFactory
+	for _, namespace := range namespaces {
+		factory := informers.NewSharedInformerFactoryWithOptions(k8sClient, informerResyncPeriod, informers.WithNamespace(namespace))
+		k8sService.informerFactories = append(k8sService.informerFactories, factory)
+		k8sService.workloadListers[namespace] = workloadListers{
+			podLister:         factory.Core().V1().Pods().Lister(),
+			deploymentLister:  factory.Apps().V1().Deployments().Lister(),
+			statefulSetLister: factory.Apps().V1().StatefulSets().Lister(),
+			daemonSetLister:   factory.Apps().V1().DaemonSets().Lister(),
+			replicaSetLister:  factory.Apps().V1().ReplicaSets().Lister(),
+			jobLister:         factory.Batch().V1().Jobs().Lister(),
+			cronJobLister:     factory.Batch().V1().CronJobs().Lister(),
+		}
+
+		_, err := factory.Core().V1().Services().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: k8sService.onServiceAddedOrUpdated,
+			UpdateFunc: func(_, newObj interface{}) {
+				k8sService.onServiceAddedOrUpdated(newObj)
+			},
+			DeleteFunc: k8sService.onServiceDeleted,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cannot register services event handler: %v", err)
+		}
+
+		_, err = factory.Batch().V1().CronJobs().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: k8sService.onCronJobAddedOrUpdated,
+			UpdateFunc: func(_, newObj interface{}) {
+				k8sService.onCronJobAddedOrUpdated(newObj)
+			},
+			DeleteFunc: k8sService.onCronJobDeleted,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cannot register CronJobs event handler: %v", err)
+		}
+
+		if namespace == k8smeta.NamespaceAll || namespace == configNamespace {
+			configFactory = factory
+		}
+
+		// only the labelled Secrets are watched, through a factory of their own
+		secretFactory := informers.NewSharedInformerFactoryWithOptions(k8sClient, informerResyncPeriod, informers.WithNamespace(namespace),
+			informers.WithTweakListOptions(func(options *k8smeta.ListOptions) {
+				options.LabelSelector = credentialsSecretsLabelSelector
+			}))
+		k8sService.informerFactories = append(k8sService.informerFactories, secretFactory)
+		k8sService.secretListers[namespace] = secretFactory.Core().V1().Secrets().Lister()
+
+		_, err = secretFactory.Core().V1().Secrets().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    k8sService.onCredentialsSecretChanged,
+			UpdateFunc: k8sService.onCredentialsSecretUpdated,
+			DeleteFunc: k8sService.onCredentialsSecretChanged,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cannot register credentials secrets event handler: %v", err)
+		}
 	}
 
-	go k8sService.watchAcks()
-	go k8sService.watchServices()
+	if configFactory == nil {
+		configFactory = informers.NewSharedInformerFactoryWithOptions(k8sClient, informerResyncPeriod, informers.WithNamespace(configNamespace))
+		k8sService.informerFactories = append(k8sService.informerFactories, configFactory)
+	}
+	k8sService.configMapLister = configFactory.Core().V1().ConfigMaps().Lister()
 
-	return k8sService
+	_, err := configFactory.Core().V1().ConfigMaps().Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: func(obj interface{}) bool {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			k8sConfigMap, ok := obj.(*k8score.ConfigMap)
+			return ok && k8sConfigMap.Namespace == configNamespace && mustParseSelector(ackMessagesConfigMapLabelSelector).Matches(labels.Set(k8sConfigMap.Labels))
+		},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc: k8sService.onAcksConfigMapChanged,
+			UpdateFunc: func(_, newObj interface{}) {
+				k8sService.onAcksConfigMapChanged(newObj)
+			},
+			DeleteFunc: k8sService.onAcksConfigMapChanged,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot register acks event handler: %v", err)
+	}
+
+	return k8sService, nil
 }
 
-func (hs *k8sHealthcheckService) updateCategory(ctx context.Context, categoryName string, isEnabled bool) error {
-	categoryConfigMapName := fmt.Sprintf("category.%s", categoryName)
-	k8sCategory, err := hs.k8sClient.CoreV1().ConfigMaps(k8score.NamespaceDefault).Get(ctx, categoryConfigMapName, k8smeta.GetOptions{})
+func (hs *k8sHealthcheckService) getWorkloadListers(namespace string) (workloadListers, error) {
+	if listers, ok := hs.workloadListers[namespace]; ok {
+		return listers, nil
+	}
+	if listers, ok := hs.workloadListers[k8smeta.NamespaceAll]; ok {
+		return listers, nil
+	}
+	return workloadListers{}, fmt.Errorf("namespace %s is not monitored", namespace)
+}
+
+// startInformers starts the shared informers and waits until their caches are filled.
+func (hs *k8sHealthcheckService) startInformers(stopCh <-chan struct{}) error {
+	for _, factory := range hs.informerFactories {
+		factory.Start(stopCh)
+	}
+	for _, factory := range hs.informerFactories {
+		for informerType, synced := range factory.WaitForCacheSync(stopCh) {
+			if !synced {
+				return fmt.Errorf("cache of informer for %v has not been synced", informerType)
+			}
+		}
+	}
 
+	log.Info("Kubernetes informer caches have been synced")
+	return nil
+}
+
+// updateCategory enables or disables a category and records the change next to the category.enabled flag.
+// It returns the updated category.
+func (hs *k8sHealthcheckService) updateCategory(ctx context.Context, categoryName string, isEnabled bool, change categoryChange) (category, error) {
+	changeValue, err := change.encode()
 	if err != nil {
-		return fmt.Errorf("cannot retrieve configMap for category with name %s: %s", categoryName, err.Error())
+		return category{}, err
 	}
 
-	k8sCategory.Data["category.enabled"] = strconv.FormatBool(isEnabled)
-	_, err = hs.k8sClient.CoreV1().ConfigMaps(k8score.NamespaceDefault).Update(ctx, k8sCategory, k8smeta.UpdateOptions{})
+	changeKey := lastDisabledCategoryKey
+	if isEnabled {
+		changeKey = lastEnabledCategoryKey
+	}
+
+	var updatedCategory category
+	categoryConfigMapName := fmt.Sprintf("category.%s", categoryName)
+	err = hs.updateConfigMap(ctx, categoryConfigMapName, func(k8sCategory *k8score.ConfigMap) bool {
+		if k8sCategory.Data == nil {
+			k8sCategory.Data = make(map[string]string)
+		}
+		k8sCategory.Data["category.enabled"] = strconv.FormatBool(isEnabled)
+		k8sCategory.Data[changeKey] = changeValue
+		updatedCategory = populateCategory(k8sCategory.Data)
+		return true
+	})
 
+	if apierrors.IsNotFound(err) {
+		return category{}, notFoundError{msg: fmt.Sprintf("cannot find configMap for category with name %s", categoryName)}
+	}
 	if err != nil {
-		return fmt.Errorf("cannot update configMap for category with name %s: %s", categoryName, err.Error())
+		return category{}, fmt.Errorf("cannot update configMap for category with name %s: %w", categoryName, err)
 	}
 
-	return nil
+	return updatedCategory, nil
 }
 
 func (hs *k8sHealthcheckService) removeAck(ctx context.Context, serviceName string) error {
 	log.Infof("Removing ack for service with name %s ", serviceName)
-	k8sAcksConfigMap, err := getAcksConfigMap(ctx, hs.k8sClient)
+	err := hs.updateConfigMap(ctx, ackMessagesConfigMapName, func(k8sAcksConfigMap *k8score.ConfigMap) bool {
+		if _, found := k8sAcksConfigMap.Data[serviceName]; !found {
+			return false
+		}
+		delete(k8sAcksConfigMap.Data, serviceName)
+		return true
+	})
 
 	if err != nil {
-		return fmt.Errorf("failed to retrieve the current list of acks: %s", err.Error())
+		return fmt.Errorf("failed to remove the ack for service %s: %w", serviceName, err)
 	}
 
-	delete(k8sAcksConfigMap.Data, serviceName)
+	return nil
+}
+
+// removeExpiredAcks removes the acks that expired before now from the acks configMap and returns the keys of the removed acks.
+// The acks of pods that no longer exist are removed as well, as pods never come back under the same name.
+func (hs *k8sHealthcheckService) removeExpiredAcks(ctx context.Context, now time.Time) ([]string, error) {
+	var expiredAcks []string
+	err := hs.updateConfigMap(ctx, ackMessagesConfigMapName, func(k8sAcksConfigMap *k8score.ConfigMap) bool {
+		// the configMap is read again on every retry
+		expiredAcks = nil
+		for ackKey, ackValue := range k8sAcksConfigMap.Data {
+			if parseAck(ackValue).isExpired(now) || hs.isAckedPodGone(ackKey) {
+				expiredAcks = append(expiredAcks, ackKey)
+				delete(k8sAcksConfigMap.Data, ackKey)
+			}
+		}
+		return len(expiredAcks) > 0
+	})
 
-	if k8sAcksConfigMap.Data[serviceName] != "" {
-		return fmt.Errorf("the ack for service %s has not been removed from configmap", serviceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove the expired acks: %w", err)
 	}
 
-	k8sAcksConfigMap2, err := hs.k8sClient.CoreV1().ConfigMaps(k8score.NamespaceDefault).Update(ctx, &k8sAcksConfigMap, k8smeta.UpdateOptions{})
+	sort.Strings(expiredAcks)
+	return expiredAcks, nil
+}
 
-	if k8sAcksConfigMap2.Data[serviceName] != "" {
-		return fmt.Errorf("the ack for service %s has not been removed from configmap. This check has been performed on the retrieved service", serviceName)
+// isAckedPodGone tells whether the ack key belongs to a pod that does not exist anymore.
+// Pods of namespaces that are not monitored are never considered gone.
+func (hs *k8sHealthcheckService) isAckedPodGone(ackKey string) bool {
+	namespace, podName, ok := parsePodAckKey(ackKey)
+	if !ok {
+		return false
 	}
 
+	listers, err := hs.getWorkloadListers(namespace)
 	if err != nil {
-		return fmt.Errorf("failed to remove the ack for service %s", serviceName)
+		return false
 	}
 
-	return nil
+	_, err = listers.podLister.Pods(namespace).Get(podName)
+	return apierrors.IsNotFound(err)
 }
 
 func (hs *k8sHealthcheckService) addAck(ctx context.Context, serviceName, ackMessage string) error {
-	k8sAcksConfigMap, err := getAcksConfigMap(ctx, hs.k8sClient)
+	err := hs.updateConfigMap(ctx, ackMessagesConfigMapName, func(k8sAcksConfigMap *k8score.ConfigMap) bool {
+		if k8sAcksConfigMap.Data == nil {
+			k8sAcksConfigMap.Data = make(map[string]string)
+		}
+		k8sAcksConfigMap.Data[serviceName] = ackMessage
+		return true
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to update the acks config map for service %s and ack message [%s]: %w", serviceName, ackMessage, err)
+	}
+
+	return nil
+}
 
+// getSilences returns the silences of the silences configMaps, ordered by their start.
+func (hs *k8sHealthcheckService) getSilences(_ context.Context) ([]silence, error) {
+	k8sSilencesConfigMaps, err := hs.configMapLister.ConfigMaps(configNamespace).List(mustParseSelector(silencesConfigMapLabelSelector))
 	if err != nil {
-		return fmt.Errorf("failed to retrieve the current list of acks: %s", err.Error())
+		return nil, fmt.Errorf("failed to get the silences from kubernetes: %v", err.Error())
 	}
 
-	if k8sAcksConfigMap.Data == nil {
-		k8sAcksConfigMap.Data = make(map[string]string)
+	var silences []silence
+	for _, k8sSilencesConfigMap := range k8sSilencesConfigMaps {
+		for silenceID, value := range k8sSilencesConfigMap.Data {
+			s, err := parseSilence(value)
+			if err != nil {
+				log.WithError(err).Warnf("Ignoring invalid silence %s", silenceID)
+				continue
+			}
+			silences = append(silences, s)
+		}
 	}
 
-	k8sAcksConfigMap.Data[serviceName] = ackMessage
+	sortSilences(silences)
+	return silences, nil
+}
+
+func (hs *k8sHealthcheckService) addSilence(ctx context.Context, s silence) error {
+	value, err := s.encode()
+	if err != nil {
+		return err
+	}
 
-	_, err = hs.k8sClient.CoreV1().ConfigMaps(k8score.NamespaceDefault).Update(ctx, &k8sAcksConfigMap, k8smeta.UpdateOptions{})
+	err = hs.updateConfigMap(ctx, silencesConfigMapName, func(k8sSilencesConfigMap *k8score.ConfigMap) bool {
+		if k8sSilencesConfigMap.Data == nil {
+			k8sSilencesConfigMap.Data = make(map[string]string)
+		}
+		k8sSilencesConfigMap.Data[s.ID] = value
+		return true
+	})
 
 	if err != nil {
-		return fmt.Errorf("failed to update the acks config map for service %s and ack message [%s]: %v", serviceName, ackMessage, err)
+		return fmt.Errorf("failed to add silence %s: %w", s.ID, err)
 	}
 
 	return nil
 }
 
-func (hs *k8sHealthcheckService) getDeployments(ctx context.Context) (deployments map[string]deployment, err error) {
-	deploymentList, err := hs.k8sClient.AppsV1().Deployments(k8score.NamespaceDefault).List(ctx, k8smeta.ListOptions{})
+func (hs *k8sHealthcheckService) removeSilence(ctx context.Context, silenceID string) error {
+	found := false
+	err := hs.updateConfigMap(ctx, silencesConfigMapName, func(k8sSilencesConfigMap *k8score.ConfigMap) bool {
+		_, found = k8sSilencesConfigMap.Data[silenceID]
+		delete(k8sSilencesConfigMap.Data, silenceID)
+		return found
+	})
+
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve deployments: %v", err.Error())
+		return fmt.Errorf("failed to remove silence %s: %w", silenceID, err)
 	}
+	if !found {
+		return notFoundError{msg: fmt.Sprintf("cannot find silence with ID %s", silenceID)}
+	}
+
+	return nil
+}
 
-	deployments = make(map[string]deployment)
-	for _, d := range deploymentList.Items {
-		deployments[d.GetName()] = deployment{
-			desiredReplicas: *d.Spec.Replicas,
+// removeEndedSilences removes the silences that ended before now from the silences configMap and returns their IDs.
+func (hs *k8sHealthcheckService) removeEndedSilences(ctx context.Context, now time.Time) ([]string, error) {
+	var endedSilences []string
+	err := hs.updateConfigMap(ctx, silencesConfigMapName, func(k8sSilencesConfigMap *k8score.ConfigMap) bool {
+		// the configMap is read again on every retry
+		endedSilences = nil
+		for silenceID, value := range k8sSilencesConfigMap.Data {
+			if s, err := parseSilence(value); err == nil && s.hasEnded(now) {
+				endedSilences = append(endedSilences, silenceID)
+				delete(k8sSilencesConfigMap.Data, silenceID)
+			}
 		}
-	}
+		return len(endedSilences) > 0
+	})
 
-	dl, err := hs.k8sClient.AppsV1().StatefulSets(k8score.NamespaceDefault).List(ctx, k8smeta.ListOptions{})
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve StatefulSet: %v", err.Error())
+		return nil, fmt.Errorf("failed to remove the ended silences: %w", err)
 	}
 
-	for _, d := range dl.Items {
-		deployments[d.Spec.ServiceName] = deployment{
-			desiredReplicas: *d.Spec.Replicas,
+	sort.Strings(endedSilences)
+	return endedSilences, nil
+}
+
+// updateConfigMap applies mutate to the latest version of a configMap of the config namespace and updates it.
+// The update is sent with the resourceVersion it was read at, so a concurrent change makes it fail with a conflict,
+// in which case the configMap is read and mutated again. mutate reports whether the configMap needs to be updated.
+func (hs *k8sHealthcheckService) updateConfigMap(ctx context.Context, configMapName string, mutate func(*k8score.ConfigMap) bool) error {
+	configMaps := hs.k8sClient.CoreV1().ConfigMaps(configNamespace)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		k8sConfigMap, err := configMaps.Get(ctx, configMapName, k8smeta.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		if !mutate(k8sConfigMap) {
+			return nil
 		}
+
+		_, err = configMaps.Update(ctx, k8sConfigMap, k8smeta.UpdateOptions{})
+		return err
+	})
+
+	if apierrors.IsConflict(err) {
+		return conflictError{msg: fmt.Sprintf("configMap %s has been changed concurrently too many times: %v", configMapName, err)}
 	}
-	return deployments, nil
+
+	return err
 }
 
-func (hs *k8sHealthcheckService) getPodByName(ctx context.Context, podName string) (pod, error) {
-	k8sPod, err := hs.k8sClient.CoreV1().Pods(k8score.NamespaceDefault).Get(ctx, podName, k8smeta.GetOptions{})
+func (hs *k8sHealthcheckService) getPodByName(_ context.Context, namespace string, podName string) (pod, error) {
+	if namespace == "" {
+		namespace = k8score.NamespaceDefault
+	}
+
+	listers, err := hs.getWorkloadListers(namespace)
+	if err != nil {
+		return pod{}, fmt.Errorf("failed to get the pod with name %s from k8s cluster: %v", podName, err.Error())
+	}
+
+	k8sPod, err := listers.podLister.Pods(namespace).Get(podName)
+	if apierrors.IsNotFound(err) {
+		return pod{}, notFoundError{msg: fmt.Sprintf("cannot find pod with name %s in namespace %s", podName, namespace)}
+	}
 	if err != nil {
 		return pod{}, fmt.Errorf("failed to get the pod with name %s from k8s cluster: %v", podName, err.Error())
 	}
 
-	p := populatePod(*k8sPod)
+	p := populatePod(*k8sPod, hs.getAcks())
 	return p, nil
 }
 
 func (hs *k8sHealthcheckService) isServicePresent(serviceName string) bool {
 	hs.services.RLock()
-	_, ok := hs.services.m[serviceName]
-	hs.services.RUnlock()
-	return ok
+	defer hs.services.RUnlock()
+	return len(hs.resolveServiceKeys(serviceName)) > 0
 }
 
+// getServiceByName looks a service up by its namespace-qualified key or by its plain name.
+// A plain name resolves to the service in the default namespace, or to the only service with that name.
 func (hs *k8sHealthcheckService) getServiceByName(serviceName string) (service, error) {
 	hs.services.RLock()
 	defer hs.services.RUnlock()
 
-	if srv, ok := hs.services.m[serviceName]; ok {
+	keys := hs.resolveServiceKeys(serviceName)
+	switch len(keys) {
+	case 0:
+		return service{}, notFoundError{msg: fmt.Sprintf("cannot find service with name %s", serviceName)}
+	case 1:
+		return hs.services.m[keys[0]], nil
+	}
+
+	if srv, ok := hs.services.m[newObjectKey(k8score.NamespaceDefault, serviceName)]; ok {
 		return srv, nil
 	}
 
-	return service{}, fmt.Errorf("cannot find service with name %s", serviceName)
+	return service{}, fmt.Errorf("service name %s is ambiguous, it matches services %s", serviceName, strings.Join(keys, ", "))
 }
+
 func (hs *k8sHealthcheckService) getServicesMapByNames(serviceNames []string) map[string]service {
+	services := make(map[string]service)
+	hs.services.RLock()
+	defer hs.services.RUnlock()
+
 	//if the list of service names is empty, it means that we are in the default category so we take all the services that have healthcheck
 	if len(serviceNames) == 0 {
-		hs.services.RLock()
-		defer hs.services.RUnlock()
-		return hs.services.m
+		for key, srv := range hs.services.m {
+			services[key] = srv
+		}
+		return services
 	}
 
-	services := make(map[string]service)
-	hs.services.RLock()
 	for _, serviceName := range serviceNames {
-		if srv, ok := hs.services.m[serviceName]; ok {
-			services[serviceName] = srv
-		} else {
+		keys := hs.resolveServiceKeys(serviceName)
+		if len(keys) == 0 {
 			log.Errorf("Service with name [%s] not found.", serviceName)
 		}
+		for _, key := range keys {
+			services[key] = hs.services.m[key]
+		}
 	}
 
-	hs.services.RUnlock()
 	return services
 }
 
-func (hs *k8sHealthcheckService) getPodsForService(ctx context.Context, serviceName string) ([]pod, error) {
-	k8sPods, err := hs.k8sClient.CoreV1().Pods(k8score.NamespaceDefault).List(ctx, k8smeta.ListOptions{LabelSelector: fmt.Sprintf("app=%s", serviceName)})
+// resolveServiceKeys returns the keys of the services a category entry or a request parameter refers to.
+// The caller must hold the services lock.
+func (hs *k8sHealthcheckService) resolveServiceKeys(serviceName string) []string {
+	if _, ok := hs.services.m[serviceName]; ok {
+		return []string{serviceName}
+	}
+
+	var keys []string
+	for key := range hs.services.m {
+		if matchesServiceEntry(serviceName, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// getPodsForService returns the pods selected by a service, looked up in the service's namespace.
+func (hs *k8sHealthcheckService) getPodsForService(_ context.Context, s service) ([]pod, error) {
+	namespace := s.namespace
+	if namespace == "" {
+		namespace = k8score.NamespaceDefault
+	}
+
+	listers, err := hs.getWorkloadListers(namespace)
+	if err != nil {
+		return []pod{}, fmt.Errorf("failed to get the list of pods from k8s cluster: %v", err.Error())
+	}
+
+	k8sPods, err := listers.podLister.Pods(namespace).List(s.podSelector())
 	if err != nil {
 		return []pod{}, fmt.Errorf("failed to get the list of pods from k8s cluster: %v", err.Error())
 	}
 
-	pods := make([]pod, len(k8sPods.Items))
-	for i, k8sPod := range k8sPods.Items {
-		p := populatePod(k8sPod)
-		pods[i] = p
+	acks := hs.getAcks()
+	pods := make([]pod, 0, len(k8sPods))
+	for _, k8sPod := range k8sPods {
+		p := populatePod(*k8sPod, acks)
+		// the terminating and finished pods have been replaced, or are about to be
+		if p.lifecycle.isGone() {
+			continue
+		}
+		pods = append(pods, p)
 	}
 
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].name < pods[j].name
+	})
+
 	return pods, nil
 }
 
-func (hs *k8sHealthcheckService) getCategories(ctx context.Context) (map[string]category, error) {
+func (hs *k8sHealthcheckService) getCategories(_ context.Context) (map[string]category, error) {
 	categories := make(map[string]category)
-	k8sCategories, err := hs.k8sClient.CoreV1().ConfigMaps(k8score.NamespaceDefault).List(ctx, k8smeta.ListOptions{LabelSelector: "healthcheck-categories-for=aggregate-healthcheck"})
+	k8sCategories, err := hs.configMapLister.ConfigMaps(configNamespace).List(mustParseSelector(categoriesConfigMapLabelSelector))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get the categories from kubernetes: %v", err.Error())
 	}
 
-	for _, k8sCategory := range k8sCategories.Items {
+	for _, k8sCategory := range k8sCategories {
 		c := populateCategory(k8sCategory.Data)
 		categories[c.name] = c
 	}
@@ -374,8 +816,9 @@ func (hs *k8sHealthcheckService) getCategories(ctx context.Context) (map[string]
 	return categories, nil
 }
 
-func (hs *k8sHealthcheckService) getHTTPClient() httpClient {
-	return hs.httpClient
+// getServiceEvents returns the stream of service additions, updates and removals observed by watchServices.
+func (hs *k8sHealthcheckService) getServiceEvents() <-chan serviceEvent {
+	return hs.serviceEvents
 }
 
 func populateCategory(k8sCatData map[string]string) category {
@@ -401,25 +844,72 @@ func populateCategory(k8sCatData map[string]string) category {
 		failureThreshold = defaultFailureThreshold
 	}
 
+	failureWindowSeconds, err := strconv.ParseInt(k8sCatData["category.failureWindow"], 10, 64)
+	if err != nil || failureWindowSeconds < 0 {
+		failureWindowSeconds = 0
+	}
+
+	autoReEnableAfter, err := strconv.Atoi(k8sCatData["category.autoReEnableAfter"])
+	if err != nil || autoReEnableAfter < 0 {
+		autoReEnableAfter = 0
+	}
+
 	refreshRatePeriod := time.Duration(refreshRateSeconds * int64(time.Second))
 	categories := strings.ReplaceAll(k8sCatData["category.services"], " ", "")
 	return category{
-		name:             categoryName,
-		services:         strings.Split(categories, ","),
-		refreshPeriod:    refreshRatePeriod,
-		isSticky:         isSticky,
-		isEnabled:        isEnabled,
-		failureThreshold: failureThreshold,
+		name:              categoryName,
+		services:          strings.Split(categories, ","),
+		refreshPeriod:     refreshRatePeriod,
+		isSticky:          isSticky,
+		isEnabled:         isEnabled,
+		failureThreshold:  failureThreshold,
+		failureWindow:     time.Duration(failureWindowSeconds) * time.Second,
+		autoReEnableAfter: autoReEnableAfter,
+		lastDisabled:      parseCategoryChange(categoryName, k8sCatData[lastDisabledCategoryKey]),
+		lastEnabled:       parseCategoryChange(categoryName, k8sCatData[lastEnabledCategoryKey]),
 	}
 }
 
-func populatePod(k8sPod k8score.Pod) pod {
-	return pod{
+func populatePod(k8sPod k8score.Pod, acks map[string]string) pod {
+	p := pod{
 		name:        k8sPod.Name,
+		namespace:   k8sPod.Namespace,
 		node:        k8sPod.Spec.NodeName,
 		ip:          k8sPod.Status.PodIP,
 		serviceName: k8sPod.Labels["app"],
+		ready:       isPodReady(k8sPod),
+		// named target ports resolve to the ports of the pod itself
+		containerPorts: getContainerPorts(k8sPod),
+		images:         getContainerImages(k8sPod),
+		lifecycle:      getPodLifecycle(k8sPod),
+	}
+	p.ack = acks[p.ackKey()]
+
+	prefix := p.checkAckKeyPrefix()
+	for key, ackValue := range acks {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		checkName := strings.TrimPrefix(key, prefix)
+		// the check acks of a pod whose name continues with a dot share the prefix, but not the number of parts
+		if checkName == "" || strings.Contains(checkName, ".") {
+			continue
+		}
+		if p.checkAcks == nil {
+			p.checkAcks = make(map[string]string)
+		}
+		p.checkAcks[checkName] = ackValue
+	}
+	return p
+}
+
+func isPodReady(k8sPod k8score.Pod) bool {
+	for _, condition := range k8sPod.Status.Conditions {
+		if condition.Type == k8score.PodReady {
+			return condition.Status == k8score.ConditionTrue
+		}
 	}
+	return false
 }
 
 func populateService(k8sService *k8score.Service, acks map[string]string) service {
@@ -442,32 +932,43 @@ func populateService(k8sService *k8score.Service, acks map[string]string) servic
 		}
 	}
 
-	return service{
-		name:        serviceName,
-		appPort:     getAppPortForService(k8sService),
-		isDaemon:    isDaemon,
-		isResilient: isResilient,
-		ack:         acks[serviceName],
-	}
+	appPort := getAppPortForService(k8sService)
+	s := service{
+		name:            serviceName,
+		namespace:       k8sService.Namespace,
+		appPort:         appPort.number,
+		appPortName:     appPort.name,
+		healthEndpoints: getHealthEndpoints(k8sService),
+		startupGrace:    getStartupGrace(k8sService),
+		buildInfoPath:   getBuildInfoPath(k8sService),
+		isDaemon:        isDaemon,
+		isResilient:     isResilient,
+		quorum:          getQuorumPolicy(k8sService),
+		probeType:       getProbeType(k8sService),
+		grpcService:     k8sService.Annotations[grpcServiceAnnotation],
+		endpoint:        getEndpointConfig(k8sService),
+		selector:        k8sService.Spec.Selector,
+		labels:          k8sService.Labels,
+	}
+	s.ack = acks[s.ackKey()]
+	return s
 }
 
-func getAppPortForService(k8sService *k8score.Service) int32 {
-	servicePorts := k8sService.Spec.Ports
-	for _, port := range servicePorts {
+// getAppPortForService returns the port of the pods the app port of the service forwards to, which may be a named container port.
+func getAppPortForService(k8sService *k8score.Service) portRef {
+	for _, port := range k8sService.Spec.Ports {
 		if port.Name == "app" {
-			return port.TargetPort.IntVal
+			return targetPortRef(port)
 		}
 	}
 
-	return defaultAppPort
+	return portRef{number: defaultAppPort}
 }
 
-func getAcksConfigMap(ctx context.Context, k8sClient kubernetes.Interface) (k8score.ConfigMap, error) {
-	k8sAckConfigMap, err := k8sClient.CoreV1().ConfigMaps(k8score.NamespaceDefault).Get(ctx, ackMessagesConfigMapName, k8smeta.GetOptions{})
-
+func mustParseSelector(selector string) labels.Selector {
+	parsedSelector, err := labels.Parse(selector)
 	if err != nil {
-		return k8score.ConfigMap{}, fmt.Errorf("cannot find configMap with name %s: %s", ackMessagesConfigMapName, err.Error())
+		panic(fmt.Sprintf("Invalid label selector %s: %v", selector, err))
 	}
-
-	return *k8sAckConfigMap, nil
+	return parsedSelector
 }
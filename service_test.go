@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -15,8 +19,9 @@ import (
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/kubernetes/fake"
-	core "k8s.io/client-go/testing"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func init() {
@@ -113,13 +118,23 @@ func initializeMockServiceWithK8sServices() *k8sHealthcheckService {
 	}
 }
 
-func initializeMockService(httpClient *http.Client) *k8sHealthcheckService {
-	mockK8sClient := fake.NewSimpleClientset()
+func initializeMockService(t *testing.T, httpClient *http.Client, objects ...runtime.Object) *k8sHealthcheckService {
+	return initializeMockServiceInNamespaces(t, httpClient, []string{apiv1.NamespaceDefault}, objects...)
+}
 
-	return &k8sHealthcheckService{
-		k8sClient:  mockK8sClient,
-		httpClient: httpClient,
-	}
+func initializeMockServiceInNamespaces(t *testing.T, httpClient *http.Client, namespaces []string, objects ...runtime.Object) *k8sHealthcheckService {
+	mockK8sClient := fake.NewSimpleClientset(objects...)
+
+	hcService, err := newK8sHealthcheckService(mockK8sClient, httpClient, retryPolicy{}, checkLimits{}, 0, namespaces)
+	assert.NoError(t, err)
+
+	stopCh := make(chan struct{})
+	t.Cleanup(func() {
+		close(stopCh)
+	})
+	assert.NoError(t, hcService.startInformers(stopCh))
+
+	return hcService
 }
 
 func initializeMockHTTPClient(responseStatusCode int, responseBody string) *http.Client {
@@ -146,77 +161,274 @@ func (t *mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 }
 
 func TestGetHealthChecksForPodInternalServerErr(t *testing.T) {
-	service := initializeMockService(initializeMockHTTPClient(http.StatusInternalServerError, ""))
-	_, err := service.getHealthChecksForPod(pod{name: "test", ip: validIP}, 8080)
+	hcService := initializeMockService(t, initializeMockHTTPClient(http.StatusInternalServerError, ""))
+	_, _, err := hcService.getHealthChecksForPod(context.TODO(), pod{name: "test", ip: validIP}, service{appPort: 8080})
 	assert.NotNil(t, err)
 }
 
 func TestGetHealthChecksForPodHealthAvailable(t *testing.T) {
-	service := initializeMockService(initializeMockHTTPClient(http.StatusOK, validFailingHealthCheckResponseBody))
-	healthCheckResponse, err := service.getHealthChecksForPod(pod{name: "test", ip: validIP}, 8080)
+	hcService := initializeMockService(t, initializeMockHTTPClient(http.StatusOK, validFailingHealthCheckResponseBody))
+	healthCheckResponse, _, err := hcService.getHealthChecksForPod(context.TODO(), pod{name: "test", ip: validIP}, service{appPort: 8080})
 	assert.Nil(t, err)
 	assert.Equal(t, 2, len(healthCheckResponse.Checks))
 }
 
-func TestGetIndividualPodSeverityErrorWhilePodHealthCheck(t *testing.T) {
-	service := initializeMockService(initializeMockHTTPClient(http.StatusInternalServerError, ""))
-	severity, _, err := service.getIndividualPodSeverity(pod{name: "test", ip: validIP}, 8080)
+func TestPodProbeSeverityErrorWhilePodHealthCheck(t *testing.T) {
+	hcService := initializeMockService(t, initializeMockHTTPClient(http.StatusInternalServerError, ""))
+	severity, _, err := hcService.probePod(context.TODO(), pod{name: "test", ip: validIP}, service{appPort: 8080}).severity(time.Now())
 	assert.NotNil(t, err)
 	assert.Equal(t, defaultSeverity, severity)
 }
 
-func TestGetIndividualPodSeverityValidPodHealth(t *testing.T) {
-	service := initializeMockService(initializeMockHTTPClient(http.StatusOK, validFailingHealthCheckResponseBody))
-	severity, checkFailed, err := service.getIndividualPodSeverity(pod{name: "test", ip: validIP}, 8080)
+func TestPodProbeSeverityValidPodHealth(t *testing.T) {
+	hcService := initializeMockService(t, initializeMockHTTPClient(http.StatusOK, validFailingHealthCheckResponseBody))
+	severity, checkFailed, err := hcService.probePod(context.TODO(), pod{name: "test", ip: validIP}, service{appPort: 8080}).severity(time.Now())
 	assert.Nil(t, err)
 	assert.True(t, checkFailed)
 	assert.Equal(t, validSeverity, severity)
 }
 
-func TestGetIndividualPodSeverityValidPodHealth_Severity2(t *testing.T) {
-	service := initializeMockService(initializeMockHTTPClient(http.StatusOK, validFailingHealthCheckResponseBodyWithSeverity2))
-	severity, checkFailed, err := service.getIndividualPodSeverity(pod{name: "test", ip: validIP}, 8080)
+func TestPodProbeSeverityValidPodHealth_Severity2(t *testing.T) {
+	hcService := initializeMockService(t, initializeMockHTTPClient(http.StatusOK, validFailingHealthCheckResponseBodyWithSeverity2))
+	severity, checkFailed, err := hcService.probePod(context.TODO(), pod{name: "test", ip: validIP}, service{appPort: 8080}).severity(time.Now())
 	assert.Nil(t, err)
 	assert.True(t, checkFailed)
 	assert.Equal(t, uint8(2), severity)
 }
 
-func TestCheckPodHealthFailingChecks(t *testing.T) {
-	service := initializeMockService(initializeMockHTTPClient(http.StatusOK, validFailingHealthCheckResponseBody))
-	err := service.checkPodHealth(pod{name: "test", ip: validIP}, 8080)
+func TestPodProbeStatusFailingChecks(t *testing.T) {
+	hcService := initializeMockService(t, initializeMockHTTPClient(http.StatusOK, validFailingHealthCheckResponseBody))
+	_, err := hcService.probePod(context.TODO(), pod{name: "test", ip: validIP}, service{appPort: 8080}).status(time.Now())
 	assert.NotNil(t, err)
 }
 
-func TestCheckPodHealthWithInvalidUrl(t *testing.T) {
-	service := initializeMockService(nil)
-	err := service.checkPodHealth(pod{name: "test", ip: "%s"}, 8080)
+func TestPodProbeStatusWithInvalidUrl(t *testing.T) {
+	hcService := initializeMockService(t, nil)
+	_, err := hcService.probePod(context.TODO(), pod{name: "test", ip: "%s"}, service{appPort: 8080}).status(time.Now())
 	assert.NotNil(t, err)
 }
 
-func TestCheckPodHealthPassingChecks(t *testing.T) {
-	service := initializeMockService(initializeMockHTTPClient(http.StatusOK, validPassingHealthCheckResponseBody))
-	err := service.checkPodHealth(pod{name: "test", ip: validIP}, 8080)
+func TestPodProbeStatusPassingChecks(t *testing.T) {
+	hcService := initializeMockService(t, initializeMockHTTPClient(http.StatusOK, validPassingHealthCheckResponseBody))
+	_, err := hcService.probePod(context.TODO(), pod{name: "test", ip: validIP}, service{appPort: 8080}).status(time.Now())
 	assert.Nil(t, err)
 }
 
 func TestGetCategories(t *testing.T) {
-	service := initializeMockService(nil)
+	service := initializeMockService(t, nil)
 	_, err := service.getCategories(context.TODO())
 	assert.Nil(t, err)
 }
 
 func TestUpdateCategoryInvalidConfigMap(t *testing.T) {
-	service := initializeMockService(nil)
-	err := service.updateCategory(context.TODO(), "validCategoryName", true)
+	service := initializeMockService(t, nil)
+	_, err := service.updateCategory(context.TODO(), "validCategoryName", true, categoryChange{})
 	assert.NotNil(t, err)
 }
 
 func TestAddAckConfigMapNotFound(t *testing.T) {
-	service := initializeMockService(nil)
+	service := initializeMockService(t, nil)
 	err := service.addAck(context.TODO(), "invalidServiceName", "ack message")
 	assert.NotNil(t, err)
 }
 
+func TestRemoveExpiredAcks(t *testing.T) {
+	now := time.Now()
+	expired, err := newAck("old issue", "jane.doe", "", time.Minute, now.Add(-time.Hour)).encode()
+	assert.NoError(t, err)
+	active, err := newAck("known issue", "jane.doe", "", time.Hour, now).encode()
+	assert.NoError(t, err)
+
+	hcService := initializeMockService(t, nil, &apiv1.ConfigMap{
+		ObjectMeta: k8smeta.ObjectMeta{
+			Name:      ackMessagesConfigMapName,
+			Namespace: apiv1.NamespaceDefault,
+		},
+		Data: map[string]string{
+			"service1": expired,
+			"service2": active,
+			"service3": "plain ack",
+		},
+	})
+
+	removed, err := hcService.removeExpiredAcks(context.TODO(), now)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"service1"}, removed)
+
+	k8sAcksConfigMap, err := hcService.k8sClient.CoreV1().ConfigMaps(apiv1.NamespaceDefault).Get(context.TODO(), ackMessagesConfigMapName, k8smeta.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"service2": active, "service3": "plain ack"}, k8sAcksConfigMap.Data)
+
+	removed, err = hcService.removeExpiredAcks(context.TODO(), now)
+	assert.NoError(t, err)
+	assert.Empty(t, removed)
+}
+
+func TestRemoveExpiredAcksConfigMapNotFound(t *testing.T) {
+	hcService := initializeMockService(t, nil)
+	_, err := hcService.removeExpiredAcks(context.TODO(), time.Now())
+	assert.Error(t, err)
+}
+
+var configMapsResource = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+func newAcksConfigMap(acks map[string]string) *apiv1.ConfigMap {
+	return &apiv1.ConfigMap{
+		ObjectMeta: k8smeta.ObjectMeta{
+			Name:            ackMessagesConfigMapName,
+			Namespace:       apiv1.NamespaceDefault,
+			ResourceVersion: "1",
+		},
+		Data: acks,
+	}
+}
+
+// enforceResourceVersions makes the fake clientset reject configMap updates based on a stale resourceVersion,
+// like the API server does.
+func enforceResourceVersions(hcService *k8sHealthcheckService) {
+	fakeClient := hcService.k8sClient.(*fake.Clientset)
+	lock := sync.Mutex{}
+	fakeClient.PrependReactor("update", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		k8sConfigMap := action.(k8stesting.UpdateAction).GetObject().(*apiv1.ConfigMap).DeepCopy()
+		current, err := fakeClient.Tracker().Get(configMapsResource, k8sConfigMap.Namespace, k8sConfigMap.Name)
+		if err != nil {
+			return true, nil, err
+		}
+		if current.(*apiv1.ConfigMap).ResourceVersion != k8sConfigMap.ResourceVersion {
+			return true, nil, apierrors.NewConflict(configMapsResource.GroupResource(), k8sConfigMap.Name, errors.New("the object has been modified"))
+		}
+
+		resourceVersion, _ := strconv.Atoi(k8sConfigMap.ResourceVersion)
+		k8sConfigMap.ResourceVersion = strconv.Itoa(resourceVersion + 1)
+		return true, k8sConfigMap, fakeClient.Tracker().Update(configMapsResource, k8sConfigMap, k8sConfigMap.Namespace)
+	})
+}
+
+func getStoredAcks(t *testing.T, hcService *k8sHealthcheckService) map[string]string {
+	k8sAcksConfigMap, err := hcService.k8sClient.CoreV1().ConfigMaps(apiv1.NamespaceDefault).Get(context.TODO(), ackMessagesConfigMapName, k8smeta.GetOptions{})
+	assert.NoError(t, err)
+	return k8sAcksConfigMap.Data
+}
+
+func TestConcurrentAckMutationsAreNotLost(t *testing.T) {
+	hcService := initializeMockService(t, nil, newAcksConfigMap(map[string]string{"service-to-remove": "old ack"}))
+	enforceResourceVersions(hcService)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, hcService.addAck(context.TODO(), fmt.Sprintf("service%d", i), ackMsg))
+		}(i)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, hcService.removeAck(context.TODO(), "service-to-remove"))
+	}()
+	wg.Wait()
+
+	acks := getStoredAcks(t, hcService)
+	assert.Len(t, acks, 10)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, ackMsg, acks[fmt.Sprintf("service%d", i)])
+	}
+}
+
+func TestAddAckRetriesAfterConcurrentWrite(t *testing.T) {
+	hcService := initializeMockService(t, nil, newAcksConfigMap(map[string]string{}))
+	enforceResourceVersions(hcService)
+	fakeClient := hcService.k8sClient.(*fake.Clientset)
+
+	// another writer acks a service between our read and our update
+	concurrentWriteDone := false
+	fakeClient.PrependReactor("update", "configmaps", func(_ k8stesting.Action) (bool, runtime.Object, error) {
+		if !concurrentWriteDone {
+			concurrentWriteDone = true
+			concurrentWrite := newAcksConfigMap(map[string]string{"service1": "concurrent ack"})
+			concurrentWrite.ResourceVersion = "2"
+			assert.NoError(t, fakeClient.Tracker().Update(configMapsResource, concurrentWrite, apiv1.NamespaceDefault))
+		}
+		return false, nil, nil
+	})
+
+	assert.NoError(t, hcService.addAck(context.TODO(), "service2", ackMsg))
+	assert.Equal(t, map[string]string{"service1": "concurrent ack", "service2": ackMsg}, getStoredAcks(t, hcService))
+}
+
+func TestAddAckReturnsConflictErrorWhenRetriesAreExhausted(t *testing.T) {
+	hcService := initializeMockService(t, nil, newAcksConfigMap(map[string]string{}))
+	fakeClient := hcService.k8sClient.(*fake.Clientset)
+	fakeClient.PrependReactor("update", "configmaps", func(_ k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewConflict(configMapsResource.GroupResource(), ackMessagesConfigMapName, errors.New("the object has been modified"))
+	})
+
+	err := hcService.addAck(context.TODO(), "service1", ackMsg)
+	assert.Error(t, err)
+	assert.ErrorAs(t, err, &conflictError{})
+	assert.Equal(t, http.StatusConflict, getStatusCodeForError(err))
+}
+
+func TestRemoveAckUpdateError(t *testing.T) {
+	hcService := initializeMockService(t, nil, newAcksConfigMap(map[string]string{"service1": ackMsg}))
+	fakeClient := hcService.k8sClient.(*fake.Clientset)
+	fakeClient.PrependReactor("update", "configmaps", func(_ k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("API server is unavailable")
+	})
+
+	err := hcService.removeAck(context.TODO(), "service1")
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusInternalServerError, getStatusCodeForError(err))
+}
+
+func TestRemoveAckOfServiceWithoutAck(t *testing.T) {
+	hcService := initializeMockService(t, nil, newAcksConfigMap(map[string]string{"service1": ackMsg}))
+
+	assert.NoError(t, hcService.removeAck(context.TODO(), "service2"))
+	assert.Equal(t, map[string]string{"service1": ackMsg}, getStoredAcks(t, hcService))
+}
+
+func TestUpdateCategoryNotFound(t *testing.T) {
+	hcService := initializeMockService(t, nil)
+
+	_, err := hcService.updateCategory(context.TODO(), "missing", false, categoryChange{})
+	assert.ErrorAs(t, err, &notFoundError{})
+	assert.Equal(t, http.StatusNotFound, getStatusCodeForError(err))
+}
+
+func TestUpdateCategoryRetriesOnConflict(t *testing.T) {
+	hcService := initializeMockService(t, nil, &apiv1.ConfigMap{
+		ObjectMeta: k8smeta.ObjectMeta{
+			Name:            "category.publishing",
+			Namespace:       apiv1.NamespaceDefault,
+			ResourceVersion: "1",
+		},
+		Data: map[string]string{"category.name": "publishing", "category.enabled": "true"},
+	})
+	enforceResourceVersions(hcService)
+	fakeClient := hcService.k8sClient.(*fake.Clientset)
+
+	conflicts := 0
+	fakeClient.PrependReactor("update", "configmaps", func(_ k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts < 2 {
+			conflicts++
+			return true, nil, apierrors.NewConflict(configMapsResource.GroupResource(), "category.publishing", errors.New("the object has been modified"))
+		}
+		return false, nil, nil
+	})
+
+	_, err := hcService.updateCategory(context.TODO(), "publishing", false, categoryChange{})
+	assert.NoError(t, err)
+	k8sCategory, err := hcService.k8sClient.CoreV1().ConfigMaps(apiv1.NamespaceDefault).Get(context.TODO(), "category.publishing", k8smeta.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "false", k8sCategory.Data["category.enabled"])
+	assert.Equal(t, 2, conflicts)
+}
+
 func TestUpdateAcksForServicesEmptyAckList(t *testing.T) {
 	hcService := initializeMockServiceWithK8sServices()
 	acks := make(map[string]string)
@@ -227,11 +439,9 @@ func TestUpdateAcksForServicesEmptyAckList(t *testing.T) {
 	assert.Equal(t, hcService.services.m[validK8sServiceName].ack, ackMsg)
 }
 
-func TestGetDeploymentsReturnsDeployments(t *testing.T) {
-	service := initializeMockService(nil)
+func TestGetWorkloadsReturnsDeployments(t *testing.T) {
 	var replicas int32 = 1
-	_, err := service.k8sClient.AppsV1().Deployments(apiv1.NamespaceDefault).Create(
-		context.TODO(),
+	hcService := initializeMockService(t, nil,
 		&appsv1.Deployment{
 			ObjectMeta: k8smeta.ObjectMeta{
 				Name:      "deployment1",
@@ -241,11 +451,6 @@ func TestGetDeploymentsReturnsDeployments(t *testing.T) {
 				Replicas: &replicas,
 			},
 		},
-		k8smeta.CreateOptions{})
-	assert.Nil(t, err)
-
-	_, err = service.k8sClient.AppsV1().Deployments(apiv1.NamespaceDefault).Create(
-		context.TODO(),
 		&appsv1.Deployment{
 			ObjectMeta: k8smeta.ObjectMeta{
 				Name:      "deployment2",
@@ -254,22 +459,23 @@ func TestGetDeploymentsReturnsDeployments(t *testing.T) {
 			Spec: appsv1.DeploymentSpec{
 				Replicas: &replicas,
 			},
-		}, k8smeta.CreateOptions{})
-	assert.Nil(t, err)
+		})
 
-	deployments, err := service.getDeployments(context.TODO())
+	workloads, err := hcService.getWorkloads(context.TODO(), []service{
+		{name: "deployment1", namespace: apiv1.NamespaceDefault},
+		{name: "deployment2", namespace: apiv1.NamespaceDefault},
+		{name: "deployment3", namespace: apiv1.NamespaceDefault},
+	})
 
 	assert.Nil(t, err)
-	assert.Equal(t, 2, len(deployments))
-	assertDeploymentsHas(t, deployments, "deployment1")
-	assertDeploymentsHas(t, deployments, "deployment2")
+	assert.Equal(t, 2, len(workloads), "A service without a workload should be left out")
+	assertWorkloadsHas(t, workloads, "default/deployment1")
+	assertWorkloadsHas(t, workloads, "default/deployment2")
 }
 
-func TestGetDeploymentsReturnsDeploymentsAndStatefulSets(t *testing.T) {
-	service := initializeMockService(nil)
+func TestGetWorkloadsReturnsDeploymentsStatefulSetsAndDaemonSets(t *testing.T) {
 	var replicas int32 = 1
-	_, err := service.k8sClient.AppsV1().Deployments(apiv1.NamespaceDefault).Create(
-		context.TODO(),
+	hcService := initializeMockService(t, nil,
 		&appsv1.Deployment{
 			ObjectMeta: k8smeta.ObjectMeta{
 				Name:      "deployment1",
@@ -278,11 +484,7 @@ func TestGetDeploymentsReturnsDeploymentsAndStatefulSets(t *testing.T) {
 			Spec: appsv1.DeploymentSpec{
 				Replicas: &replicas,
 			},
-		}, k8smeta.CreateOptions{})
-	assert.Nil(t, err)
-
-	_, err = service.k8sClient.AppsV1().Deployments(apiv1.NamespaceDefault).Create(
-		context.TODO(),
+		},
 		&appsv1.Deployment{
 			ObjectMeta: k8smeta.ObjectMeta{
 				Name:      "deployment2",
@@ -291,11 +493,7 @@ func TestGetDeploymentsReturnsDeploymentsAndStatefulSets(t *testing.T) {
 			Spec: appsv1.DeploymentSpec{
 				Replicas: &replicas,
 			},
-		}, k8smeta.CreateOptions{})
-	assert.Nil(t, err)
-
-	_, err = service.k8sClient.AppsV1().StatefulSets(apiv1.NamespaceDefault).Create(
-		context.TODO(),
+		},
 		&appsv1.StatefulSet{
 			ObjectMeta: k8smeta.ObjectMeta{
 				Name:      "deployment3",
@@ -305,51 +503,624 @@ func TestGetDeploymentsReturnsDeploymentsAndStatefulSets(t *testing.T) {
 				ServiceName: "special-stateful-service",
 				Replicas:    &replicas,
 			},
-		}, k8smeta.CreateOptions{})
-	assert.Nil(t, err)
+		},
+		&appsv1.DaemonSet{
+			ObjectMeta: k8smeta.ObjectMeta{
+				Name:      "daemon1",
+				Namespace: apiv1.NamespaceDefault,
+			},
+			Status: appsv1.DaemonSetStatus{
+				DesiredNumberScheduled: 3,
+			},
+		})
 
-	deployments, err := service.getDeployments(context.TODO())
+	workloads, err := hcService.getWorkloads(context.TODO(), []service{
+		{name: "deployment1", namespace: apiv1.NamespaceDefault},
+		{name: "deployment2", namespace: apiv1.NamespaceDefault},
+		{name: "special-stateful-service", namespace: apiv1.NamespaceDefault},
+		{name: "daemon1", namespace: apiv1.NamespaceDefault},
+	})
 
 	assert.Nil(t, err)
-	assert.Equal(t, 3, len(deployments))
-	assertDeploymentsHas(t, deployments, "deployment1")
-	assertDeploymentsHas(t, deployments, "deployment2")
-	assertDeploymentsHas(t, deployments, "special-stateful-service")
+	assert.Equal(t, 4, len(workloads))
+	assertWorkloadsHas(t, workloads, "default/deployment1")
+	assertWorkloadsHas(t, workloads, "default/deployment2")
+	assertWorkloadsHas(t, workloads, "default/special-stateful-service")
+	assertWorkloadsHas(t, workloads, "default/daemon1")
+	assert.Equal(t, int32(3), workloads["default/daemon1"].desiredReplicas)
+	assert.Equal(t, daemonSetWorkload, workloads["default/daemon1"].kind)
+	assert.Equal(t, workload{kind: statefulSetWorkload, name: "deployment3", desiredReplicas: 1}, workloads["default/special-stateful-service"])
 }
 
-func assertDeploymentsHas(t *testing.T, deployments map[string]deployment, key string) {
-	_, present := deployments[key]
-	assert.True(t, present, "Expected deployments to have %s", key)
+func assertWorkloadsHas(t *testing.T, workloads map[string]workload, key string) {
+	_, present := workloads[key]
+	assert.True(t, present, "Expected workloads to have %s", key)
 }
 
-func TestGetDeploymentsReturnsErrorForDeployments(t *testing.T) {
-	service := initializeMockService(nil)
-	mock := &fake.Clientset{}
-	service.k8sClient = mock
-	mock.AddReactor("list", "deployments", func(action core.Action) (bool, runtime.Object, error) {
-		return true, nil, apierrors.NewNotFound(action.GetResource().GroupResource(), action.GetResource().Resource)
+func TestGetWorkloadsDefaultsUnsetReplicas(t *testing.T) {
+	hcService := initializeMockService(t, nil, &appsv1.Deployment{
+		ObjectMeta: k8smeta.ObjectMeta{
+			Name:      "deployment1",
+			Namespace: apiv1.NamespaceDefault,
+		},
 	})
-	deployments, err := service.getDeployments(context.TODO())
+
+	workloads, err := hcService.getWorkloads(context.TODO(), []service{{name: "deployment1", namespace: apiv1.NamespaceDefault}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), workloads["default/deployment1"].desiredReplicas)
+}
+
+func TestGetPodsForServiceFromLister(t *testing.T) {
+	hcService := initializeMockService(t, nil,
+		newTestPod("service1-pod-b", "service1"),
+		newTestPod("service1-pod-a", "service1"),
+		newTestPod("service2-pod-a", "service2"))
+
+	pods, err := hcService.getPodsForService(context.TODO(), service{name: "service1", namespace: apiv1.NamespaceDefault})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(pods))
+	assert.Equal(t, "service1-pod-a", pods[0].name)
+	assert.Equal(t, "service1-pod-b", pods[1].name)
+
+	p, err := hcService.getPodByName(context.TODO(), apiv1.NamespaceDefault, "service2-pod-a")
+	assert.NoError(t, err)
+	assert.Equal(t, "service2", p.serviceName)
+
+	_, err = hcService.getPodByName(context.TODO(), apiv1.NamespaceDefault, "missing-pod")
 	assert.Error(t, err)
-	assert.Nil(t, deployments)
 }
 
-func TestGetDeploymentsReturnsErrorForStatefulSets(t *testing.T) {
-	service := initializeMockService(nil)
-	mock := &fake.Clientset{}
-	service.k8sClient = mock
-	mock.AddReactor("list", "deployments", func(action core.Action) (bool, runtime.Object, error) {
-		return true, &appsv1.DeploymentList{}, nil
-	})
-	mock.AddReactor("list", "statefulsets", func(action core.Action) (bool, runtime.Object, error) {
-		return true, nil, apierrors.NewNotFound(action.GetResource().GroupResource(), action.GetResource().Resource)
-	})
-	deployments, err := service.getDeployments(context.TODO())
+const namedFailingChecksResponseBody = `{
+  "schemaVersion": 1,
+  "name": "service1",
+  "checks": [
+    {"name": "Check connectivity to Kafka", "ok": false, "severity": 1},
+    {"name": "Check connectivity to Neo4j", "ok": true, "severity": 2}
+  ]
+}`
+
+func newLabelledAcksConfigMap(acks map[string]string) *apiv1.ConfigMap {
+	k8sAcksConfigMap := newAcksConfigMap(acks)
+	k8sAcksConfigMap.Labels = map[string]string{"healthcheck-acknowledgements-for": "aggregate-healthcheck"}
+	return k8sAcksConfigMap
+}
+
+func TestPodsCarryTheirAcks(t *testing.T) {
+	ackedPod := pod{name: "service1-pod-a", namespace: apiv1.NamespaceDefault}
+	dottedPod := pod{name: "service1-pod-a.b", namespace: apiv1.NamespaceDefault}
+	hcService := initializeMockService(t, nil,
+		newTestPod("service1-pod-a", "service1"),
+		newTestPod("service1-pod-b", "service1"),
+		newLabelledAcksConfigMap(map[string]string{
+			"service1":                           "service ack",
+			ackedPod.ackKey():                    "pod ack",
+			ackedPod.checkAckKey("kafka"):        "check ack",
+			dottedPod.checkAckKey("other check"): "other pod check ack",
+		}))
+
+	pods, err := hcService.getPodsForService(context.TODO(), service{name: "service1", namespace: apiv1.NamespaceDefault})
+	assert.NoError(t, err)
+	assert.Equal(t, "pod ack", pods[0].ack)
+	assert.Equal(t, map[string]string{"kafka": "check ack"}, pods[0].checkAcks)
+	assert.Equal(t, "check ack", pods[0].checkAck("kafka"))
+	assert.Empty(t, pods[1].ack)
+	assert.Empty(t, pods[1].checkAcks)
+
+	p, err := hcService.getPodByName(context.TODO(), apiv1.NamespaceDefault, "service1-pod-a")
+	assert.NoError(t, err)
+	assert.Equal(t, "pod ack", p.ack)
+}
+
+func TestGetPodByNameNotFound(t *testing.T) {
+	hcService := initializeMockService(t, nil)
+	_, err := hcService.getPodByName(context.TODO(), apiv1.NamespaceDefault, "missing-pod")
+	assert.ErrorAs(t, err, &notFoundError{})
+}
+
+func TestPodProbeStatusIgnoresAckedChecks(t *testing.T) {
+	hcService := initializeMockService(t, initializeMockHTTPClient(http.StatusOK, namedFailingChecksResponseBody))
+	p := pod{name: "service1-pod-a", ip: validIP}
+
+	_, err := hcService.probePod(context.TODO(), p, service{appPort: 8080}).status(time.Now())
+	assert.EqualError(t, err, "failing check is: Check connectivity to Kafka")
+
+	p.checkAcks = map[string]string{ackKeySegment("Check connectivity to Kafka"): "broker maintenance"}
+	output, err := hcService.probePod(context.TODO(), p, service{appPort: 8080}).status(time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, "acked failing checks: Check connectivity to Kafka (broker maintenance)", output)
+
+	severity, checkFailed, err := hcService.probePod(context.TODO(), p, service{appPort: 8080}).severity(time.Now())
+	assert.NoError(t, err)
+	assert.False(t, checkFailed)
+	assert.Equal(t, defaultSeverity, severity)
+}
+
+func TestPodProbeStatusDoesNotIgnoreExpiredCheckAcks(t *testing.T) {
+	hcService := initializeMockService(t, initializeMockHTTPClient(http.StatusOK, namedFailingChecksResponseBody))
+	expired, err := newAck("broker maintenance", "", "", time.Minute, time.Now().Add(-time.Hour)).encode()
+	assert.NoError(t, err)
+	p := pod{name: "service1-pod-a", ip: validIP, checkAcks: map[string]string{ackKeySegment("Check connectivity to Kafka"): expired}}
+
+	_, err = hcService.probePod(context.TODO(), p, service{appPort: 8080}).status(time.Now())
 	assert.Error(t, err)
-	assert.Nil(t, deployments)
+}
+
+func TestCheckServiceHealthIgnoresAckedPods(t *testing.T) {
+	ackedPod := pod{name: "service1-pod-a", namespace: apiv1.NamespaceDefault}
+	hcService := initializeMockService(t, initializeMockHTTPClient(http.StatusOK, namedFailingChecksResponseBody),
+		newTestPod("service1-pod-a", "service1"),
+		newTestPod("service1-pod-b", "service1"),
+		newLabelledAcksConfigMap(map[string]string{ackedPod.ackKey(): "bad node"}))
+	s := service{name: "service1", namespace: apiv1.NamespaceDefault, isDaemon: true}
+
+	// the other pod is failing too, and it has not been acked
+	probe, err := hcService.probeService(context.TODO(), s)
+	assert.NoError(t, err)
+	_, err = checkServiceHealth(probe, nil, time.Now())
+	assert.EqualError(t, err, "0/2 pods available, 1 unavailable acked - "+
+		"service1-pod-a (acked): failing checks Check connectivity to Kafka (severity 1); service1-pod-b: failing checks Check connectivity to Kafka (severity 1)")
+
+	otherPod := pod{name: "service1-pod-b", namespace: apiv1.NamespaceDefault}
+	_, err = hcService.k8sClient.CoreV1().ConfigMaps(apiv1.NamespaceDefault).Update(context.TODO(),
+		newLabelledAcksConfigMap(map[string]string{ackedPod.ackKey(): "bad node", otherPod.checkAckKey("Check connectivity to Kafka"): "broker maintenance"}),
+		k8smeta.UpdateOptions{})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		probe, err := hcService.probeService(context.TODO(), s)
+		if err != nil {
+			return false
+		}
+		output, err := checkServiceHealth(probe, nil, time.Now())
+		return err == nil && output == "1/2 pods available, 1 unavailable acked - service1-pod-a (acked): failing checks Check connectivity to Kafka (severity 1)"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestRemoveExpiredAcksRemovesAcksOfDeletedPods(t *testing.T) {
+	existingPod := pod{name: "service1-pod-a", namespace: apiv1.NamespaceDefault}
+	deletedPod := pod{name: "service1-pod-gone", namespace: apiv1.NamespaceDefault}
+	unmonitoredPod := pod{name: "service1-pod-a", namespace: "unmonitored"}
+	hcService := initializeMockService(t, nil,
+		newTestPod("service1-pod-a", "service1"),
+		newAcksConfigMap(map[string]string{
+			"service1":                          ackMsg,
+			existingPod.ackKey():                ackMsg,
+			existingPod.checkAckKey("kafka"):    ackMsg,
+			deletedPod.ackKey():                 ackMsg,
+			deletedPod.checkAckKey("kafka"):     ackMsg,
+			unmonitoredPod.checkAckKey("kafka"): ackMsg,
+		}))
+
+	removed, err := hcService.removeExpiredAcks(context.TODO(), time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{deletedPod.checkAckKey("kafka"), deletedPod.ackKey()}, removed)
+	assert.Equal(t, map[string]string{
+		"service1":                          ackMsg,
+		existingPod.ackKey():                ackMsg,
+		existingPod.checkAckKey("kafka"):    ackMsg,
+		unmonitoredPod.checkAckKey("kafka"): ackMsg,
+	}, getStoredAcks(t, hcService))
+}
+
+func TestLookupsDoNotCallTheAPIServer(t *testing.T) {
+	hcService := initializeMockService(t, nil,
+		newTestPod("service1-pod-a", "service1"),
+		&apiv1.ConfigMap{
+			ObjectMeta: k8smeta.ObjectMeta{
+				Name:      "category.default",
+				Namespace: apiv1.NamespaceDefault,
+				Labels:    map[string]string{"healthcheck-categories-for": "aggregate-healthcheck"},
+			},
+			Data: map[string]string{"category.name": "default"},
+		})
+	fakeClient := hcService.k8sClient.(*fake.Clientset)
+	actionsAfterSync := len(fakeClient.Actions())
+
+	for i := 0; i < 10; i++ {
+		_, err := hcService.getWorkloads(context.TODO(), []service{{name: "service1", namespace: apiv1.NamespaceDefault}})
+		assert.NoError(t, err)
+		_, err = hcService.getPodsForService(context.TODO(), service{name: "service1", namespace: apiv1.NamespaceDefault})
+		assert.NoError(t, err)
+		_, err = hcService.getPodByName(context.TODO(), apiv1.NamespaceDefault, "service1-pod-a")
+		assert.NoError(t, err)
+		categories, err := hcService.getCategories(context.TODO())
+		assert.NoError(t, err)
+		assert.Contains(t, categories, "default")
+	}
+
+	assert.Equal(t, actionsAfterSync, len(fakeClient.Actions()))
+}
+
+func newTestPod(name string, app string) *apiv1.Pod {
+	return &apiv1.Pod{
+		ObjectMeta: k8smeta.ObjectMeta{
+			Name:      name,
+			Namespace: apiv1.NamespaceDefault,
+			Labels:    map[string]string{"app": app},
+		},
+		Status: apiv1.PodStatus{
+			PodIP: validIP,
+		},
+	}
+}
+
+func waitForWatch(t *testing.T, hcService *k8sHealthcheckService, resource string) {
+	fakeClient := hcService.k8sClient.(*fake.Clientset)
+	assert.Eventually(t, func() bool {
+		for _, action := range fakeClient.Actions() {
+			if action.GetVerb() == "watch" && action.GetResource().Resource == resource {
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestServiceInformerPublishesServiceEvents(t *testing.T) {
+	hcService := initializeMockService(t, nil)
+	waitForWatch(t, hcService, "services")
+
+	k8sService := &apiv1.Service{
+		ObjectMeta: k8smeta.ObjectMeta{
+			Name:      "service1",
+			Namespace: apiv1.NamespaceDefault,
+			Labels:    map[string]string{"hasHealthcheck": "true"},
+		},
+	}
+	_, err := hcService.k8sClient.CoreV1().Services(apiv1.NamespaceDefault).Create(context.TODO(), k8sService, k8smeta.CreateOptions{})
+	assert.NoError(t, err)
+
+	event := <-hcService.serviceEvents
+	assert.Equal(t, serviceUpserted, event.eventType)
+	assert.Equal(t, "service1", event.service.name)
+	assert.True(t, hcService.isServicePresent("service1"))
+
+	err = hcService.k8sClient.CoreV1().Services(apiv1.NamespaceDefault).Delete(context.TODO(), "service1", k8smeta.DeleteOptions{})
+	assert.NoError(t, err)
+
+	event = <-hcService.serviceEvents
+	assert.Equal(t, serviceRemoved, event.eventType)
+	assert.Equal(t, "service1", event.service.name)
+	assert.False(t, hcService.isServicePresent("service1"))
+}
+
+func TestServiceInformerIgnoresServicesWithoutHealthcheck(t *testing.T) {
+	monitored := &apiv1.Service{
+		ObjectMeta: k8smeta.ObjectMeta{
+			Name:      "service1",
+			Namespace: apiv1.NamespaceDefault,
+			Labels:    map[string]string{"hasHealthcheck": "true"},
+		},
+	}
+	notMonitored := &apiv1.Service{
+		ObjectMeta: k8smeta.ObjectMeta{
+			Name:      "service2",
+			Namespace: apiv1.NamespaceDefault,
+		},
+	}
+	hcService := initializeMockService(t, nil, monitored, notMonitored)
+
+	event := <-hcService.serviceEvents
+	assert.Equal(t, "service1", event.service.name)
+	assert.False(t, hcService.isServicePresent("service2"))
+	waitForWatch(t, hcService, "services")
+
+	relabelled := monitored.DeepCopy()
+	relabelled.Labels = map[string]string{"hasHealthcheck": "false"}
+	_, err := hcService.k8sClient.CoreV1().Services(apiv1.NamespaceDefault).Update(context.TODO(), relabelled, k8smeta.UpdateOptions{})
+	assert.NoError(t, err)
+
+	event = <-hcService.serviceEvents
+	assert.Equal(t, serviceRemoved, event.eventType)
+	assert.Equal(t, "service1", event.service.name)
+	assert.False(t, hcService.isServicePresent("service1"))
+}
+
+func TestAcksInformerUpdatesServiceAcks(t *testing.T) {
+	hcService := initializeMockService(t, nil,
+		&apiv1.Service{
+			ObjectMeta: k8smeta.ObjectMeta{
+				Name:      "service1",
+				Namespace: apiv1.NamespaceDefault,
+				Labels:    map[string]string{"hasHealthcheck": "true"},
+			},
+		},
+		&apiv1.ConfigMap{
+			ObjectMeta: k8smeta.ObjectMeta{
+				Name:      ackMessagesConfigMapName,
+				Namespace: apiv1.NamespaceDefault,
+				Labels:    map[string]string{"healthcheck-acknowledgements-for": "aggregate-healthcheck"},
+			},
+			Data: map[string]string{"service1": ackMsg},
+		})
+	<-hcService.serviceEvents
+
+	assert.Eventually(t, func() bool {
+		s, err := hcService.getServiceByName("service1")
+		return err == nil && s.ack == ackMsg
+	}, 5*time.Second, 10*time.Millisecond)
+
+	waitForWatch(t, hcService, "configmaps")
+	err := hcService.k8sClient.CoreV1().ConfigMaps(apiv1.NamespaceDefault).Delete(context.TODO(), ackMessagesConfigMapName, k8smeta.DeleteOptions{})
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		s, err := hcService.getServiceByName("service1")
+		return err == nil && s.ack == ""
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestParseNamespaces(t *testing.T) {
+	assert.Equal(t, []string{"default"}, parseNamespaces(""))
+	assert.Equal(t, []string{"default"}, parseNamespaces("default"))
+	assert.Equal(t, []string{"default", "publishing"}, parseNamespaces("default, publishing,default"))
+	assert.Empty(t, parseNamespaces("all"))
+	assert.Empty(t, parseNamespaces("publishing,all"))
+}
+
+func newTestService(name string, namespace string) *apiv1.Service {
+	return &apiv1.Service{
+		ObjectMeta: k8smeta.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{"hasHealthcheck": "true"},
+		},
+	}
+}
+
+func newTestPodInNamespace(name string, app string, namespace string) *apiv1.Pod {
+	p := newTestPod(name, app)
+	p.Namespace = namespace
+	return p
+}
+
+func drainServiceEvents(t *testing.T, hcService *k8sHealthcheckService, count int) {
+	for i := 0; i < count; i++ {
+		select {
+		case <-hcService.serviceEvents:
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "Expected a service event")
+			return
+		}
+	}
+}
+
+func TestMonitorsServicesInMultipleNamespaces(t *testing.T) {
+	var replicas int32 = 2
+	hcService := initializeMockServiceInNamespaces(t, nil, []string{"default", "publishing"},
+		newTestService("service1", "default"),
+		newTestService("service1", "publishing"),
+		newTestService("service2", "publishing"),
+		newTestService("service3", "not-monitored"),
+		newTestPodInNamespace("service1-pod-a", "service1", "default"),
+		newTestPodInNamespace("service1-pod-b", "service1", "publishing"),
+		&appsv1.Deployment{
+			ObjectMeta: k8smeta.ObjectMeta{Name: "service1", Namespace: "publishing"},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		})
+	drainServiceEvents(t, hcService, 3)
+
+	assert.True(t, hcService.isServicePresent("default/service1"))
+	assert.True(t, hcService.isServicePresent("publishing/service1"))
+	assert.True(t, hcService.isServicePresent("service2"))
+	assert.False(t, hcService.isServicePresent("service3"))
+
+	s, err := hcService.getServiceByName("service1")
+	assert.NoError(t, err)
+	assert.Equal(t, "default", s.namespace, "A plain name should prefer the default namespace")
+
+	s, err = hcService.getServiceByName("service2")
+	assert.NoError(t, err)
+	assert.Equal(t, "publishing/service2", s.key())
+
+	services := hcService.getServicesMapByNames([]string{"service1"})
+	assert.Len(t, services, 2)
+	services = hcService.getServicesMapByNames([]string{"publishing/service1"})
+	assert.Len(t, services, 1)
+	assert.Contains(t, services, "publishing/service1")
+	assert.Len(t, hcService.getServicesMapByNames(nil), 3)
+
+	pods, err := hcService.getPodsForService(context.TODO(), service{name: "service1", namespace: "publishing"})
+	assert.NoError(t, err)
+	assert.Len(t, pods, 1)
+	assert.Equal(t, "service1-pod-b", pods[0].name)
+	assert.Equal(t, "publishing", pods[0].namespace)
+
+	_, err = hcService.getPodByName(context.TODO(), "publishing", "service1-pod-a")
+	assert.Error(t, err, "Pods should be looked up in the given namespace only")
+	_, err = hcService.getPodByName(context.TODO(), "not-monitored", "service1-pod-a")
+	assert.Error(t, err)
+
+	workloads, err := hcService.getWorkloads(context.TODO(), []service{{name: "service1", namespace: "default"}, {name: "service1", namespace: "publishing"}})
+	assert.NoError(t, err)
+	assertWorkloadsHas(t, workloads, "publishing/service1")
+	assert.NotContains(t, workloads, "default/service1")
+}
+
+func TestGetServiceByNameAmbiguousName(t *testing.T) {
+	hcService := initializeMockServiceInNamespaces(t, nil, []string{"publishing", "images"},
+		newTestService("service1", "publishing"),
+		newTestService("service1", "images"))
+	drainServiceEvents(t, hcService, 2)
+
+	_, err := hcService.getServiceByName("service1")
+	assert.Error(t, err)
+
+	s, err := hcService.getServiceByName("images/service1")
+	assert.NoError(t, err)
+	assert.Equal(t, "images", s.namespace)
+}
+
+func TestMonitorsAllNamespaces(t *testing.T) {
+	hcService := initializeMockServiceInNamespaces(t, nil, nil,
+		newTestService("service1", "publishing"),
+		newTestService("service2", "images"),
+		&apiv1.ConfigMap{
+			ObjectMeta: k8smeta.ObjectMeta{
+				Name:      ackMessagesConfigMapName,
+				Namespace: apiv1.NamespaceDefault,
+				Labels:    map[string]string{"healthcheck-acknowledgements-for": "aggregate-healthcheck"},
+			},
+			Data: map[string]string{"publishing.service1": ackMsg, "service2": ackMsg},
+		},
+		&apiv1.ConfigMap{
+			ObjectMeta: k8smeta.ObjectMeta{
+				Name:      "category.default",
+				Namespace: "publishing",
+				Labels:    map[string]string{"healthcheck-categories-for": "aggregate-healthcheck"},
+			},
+			Data: map[string]string{"category.name": "default"},
+		},
+		newTestPodInNamespace("service2-pod-a", "service2", "images"))
+	drainServiceEvents(t, hcService, 2)
+
+	assert.Eventually(t, func() bool {
+		s, err := hcService.getServiceByName("publishing/service1")
+		return err == nil && s.ack == ackMsg
+	}, 5*time.Second, 10*time.Millisecond)
+	s, err := hcService.getServiceByName("images/service2")
+	assert.NoError(t, err)
+	assert.Empty(t, s.ack, "Acks of services outside the default namespace are keyed by namespace")
+
+	pods, err := hcService.getPodsForService(context.TODO(), s)
+	assert.NoError(t, err)
+	assert.Len(t, pods, 1)
+
+	categories, err := hcService.getCategories(context.TODO())
+	assert.NoError(t, err)
+	assert.Empty(t, categories, "Categories are only read from the config namespace")
 }
 
 func TestGetDefaultClient(t *testing.T) {
 	hc := getDefaultClient()
 	assert.Equal(t, hc.Timeout, 12*time.Second, "Expected time out to be 12 seconds")
 }
+
+func newSilencesConfigMap(silences ...silence) *apiv1.ConfigMap {
+	data := make(map[string]string)
+	for _, s := range silences {
+		value, _ := s.encode()
+		data[s.ID] = value
+	}
+	return &apiv1.ConfigMap{
+		ObjectMeta: k8smeta.ObjectMeta{
+			Name:            silencesConfigMapName,
+			Namespace:       apiv1.NamespaceDefault,
+			ResourceVersion: "1",
+			Labels:          map[string]string{"healthcheck-silences-for": "aggregate-healthcheck"},
+		},
+		Data: data,
+	}
+}
+
+func getStoredSilences(t *testing.T, hcService *k8sHealthcheckService) map[string]string {
+	k8sSilencesConfigMap, err := hcService.k8sClient.CoreV1().ConfigMaps(apiv1.NamespaceDefault).Get(context.TODO(), silencesConfigMapName, k8smeta.GetOptions{})
+	assert.NoError(t, err)
+	return k8sSilencesConfigMap.Data
+}
+
+func TestGetSilences(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	later := silence{ID: "b", Comment: "later", StartsAt: now.Add(time.Hour), EndsAt: now.Add(2 * time.Hour), Services: []string{"service1"}}
+	sooner := silence{ID: "a", Comment: "sooner", StartsAt: now, EndsAt: now.Add(time.Hour), Selector: "team=content"}
+	silencesConfigMap := newSilencesConfigMap(later, sooner)
+	silencesConfigMap.Data["invalid"] = "not a silence"
+	hcService := initializeMockService(t, nil, silencesConfigMap)
+
+	silences, err := hcService.getSilences(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, []silence{sooner, later}, silences)
+}
+
+func TestAddAndRemoveSilenceInConfigMap(t *testing.T) {
+	hcService := initializeMockService(t, nil, newSilencesConfigMap())
+	s, err := newSilence("release", "jane.doe", time.Now(), time.Hour, []string{"service1"}, nil, "")
+	assert.NoError(t, err)
+
+	assert.NoError(t, hcService.addSilence(context.TODO(), s))
+	stored, err := parseSilence(getStoredSilences(t, hcService)[s.ID])
+	assert.NoError(t, err)
+	assert.Equal(t, s.Comment, stored.Comment)
+
+	assert.NoError(t, hcService.removeSilence(context.TODO(), s.ID))
+	assert.Empty(t, getStoredSilences(t, hcService))
+
+	err = hcService.removeSilence(context.TODO(), s.ID)
+	assert.Equal(t, http.StatusNotFound, getStatusCodeForError(err))
+}
+
+func TestAddSilenceConfigMapNotFound(t *testing.T) {
+	hcService := initializeMockService(t, nil)
+	err := hcService.addSilence(context.TODO(), silence{ID: "a"})
+	assert.Error(t, err)
+}
+
+func TestRemoveEndedSilences(t *testing.T) {
+	now := time.Now()
+	ended := silence{ID: "ended", Comment: "ended", StartsAt: now.Add(-2 * time.Hour), EndsAt: now.Add(-time.Hour)}
+	active := silence{ID: "active", Comment: "active", StartsAt: now.Add(-time.Hour), EndsAt: now.Add(time.Hour)}
+	hcService := initializeMockService(t, nil, newSilencesConfigMap(ended, active))
+
+	removed, err := hcService.removeEndedSilences(context.TODO(), now)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ended"}, removed)
+	assert.Contains(t, getStoredSilences(t, hcService), "active")
+	assert.NotContains(t, getStoredSilences(t, hcService), "ended")
+}
+
+func TestPopulateCategoryStickyOptions(t *testing.T) {
+	c := populateCategory(map[string]string{
+		"category.name":              "read",
+		"category.services":          "service1, service2",
+		"category.issticky":          "true",
+		"category.failureThreshold":  "5",
+		"category.failureWindow":     "600",
+		"category.autoReEnableAfter": "3",
+	})
+	assert.Equal(t, []string{"service1", "service2"}, c.services)
+	assert.Equal(t, 5, c.failureThreshold)
+	assert.Equal(t, 10*time.Minute, c.failureWindow)
+	assert.Equal(t, 3, c.autoReEnableAfter)
+
+	c = populateCategory(map[string]string{"category.name": "read", "category.failureWindow": "-1"})
+	assert.Equal(t, defaultFailureThreshold, c.failureThreshold)
+	assert.Zero(t, c.failureWindow)
+	assert.Zero(t, c.autoReEnableAfter)
+}
+
+func TestUpdateCategoryRecordsTheChange(t *testing.T) {
+	hcService := initializeMockService(t, nil, &apiv1.ConfigMap{
+		ObjectMeta: k8smeta.ObjectMeta{
+			Name:      "category.publishing",
+			Namespace: apiv1.NamespaceDefault,
+		},
+		Data: map[string]string{"category.name": "publishing", "category.enabled": "true", "category.issticky": "true"},
+	})
+	disabledAt := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	disabled := categoryChange{At: disabledAt, By: stickyActor, Service: "default/service1", Failures: 3, Output: "0/2 pods available"}
+
+	updatedCategory, err := hcService.updateCategory(context.TODO(), "publishing", false, disabled)
+	assert.NoError(t, err)
+	assert.False(t, updatedCategory.isEnabled)
+	assert.Equal(t, &disabled, updatedCategory.lastDisabled)
+	assert.Nil(t, updatedCategory.lastEnabled)
+	assert.True(t, updatedCategory.isDisabledBySticky())
+
+	enabled := categoryChange{At: disabledAt.Add(time.Hour), By: "jane.doe", Reason: "service fixed"}
+	updatedCategory, err = hcService.updateCategory(context.TODO(), "publishing", true, enabled)
+	assert.NoError(t, err)
+	assert.True(t, updatedCategory.isEnabled)
+	assert.Equal(t, &disabled, updatedCategory.lastDisabled, "The last disable should be kept after the category is enabled")
+	assert.Equal(t, &enabled, updatedCategory.lastEnabled)
+
+	k8sCategory, err := hcService.k8sClient.CoreV1().ConfigMaps(apiv1.NamespaceDefault).Get(context.TODO(), "category.publishing", k8smeta.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, &enabled, parseCategoryChange("publishing", k8sCategory.Data[lastEnabledCategoryKey]))
+}
+
+func TestPopulateCategoryIgnoresInvalidChanges(t *testing.T) {
+	c := populateCategory(map[string]string{"category.name": "read", lastDisabledCategoryKey: "not json"})
+	assert.Nil(t, c.lastDisabled)
+	assert.Nil(t, c.lastEnabled)
+}
@@ -1,34 +1,28 @@
 package main
 
 import (
-	"context"
+	"time"
 
 	log "github.com/Financial-Times/go-logger"
 )
 
-func (c *healthCheckController) getSeverityForService(ctx context.Context, serviceName string, appPort int32) uint8 {
-	pods, err := c.healthCheckService.getPodsForService(ctx, serviceName)
-	if err != nil {
-		log.WithError(err).Warnf("Cannot get pods for service with name %s in order to get severity level, using default severity: %d.", serviceName, defaultSeverity)
-		return defaultSeverity
-	}
-
-	var isResilient bool
-	service, err := c.healthCheckService.getServiceByName(serviceName)
-	if err != nil {
-		log.WithError(err).Warnf("Cannot get service with name %s in order to get resiliency, using default resiliency: %t.", serviceName, defaultResiliency)
-		isResilient = defaultResiliency
-	} else {
-		isResilient = service.isResilient
-	}
+// getSeverityForService computes the severity of a failing service from the probes its check was evaluated with.
+// A service with a quorum fails only when it has too few healthy pods, so it takes the severity of its most severe failing pod,
+// whether it is resilient or not.
+func getSeverityForService(probe serviceProbe, now time.Time) uint8 {
+	probes := withoutAckedPods(probe.pods, now)
 
-	if !isResilient {
-		return c.computeSeverityByPods(pods, appPort)
+	if !probe.service.isResilient || probe.service.quorum != nil {
+		return computeSeverityByPods(probes, now)
 	}
 
 	finalSeverity := defaultSeverity
-	for _, pod := range pods {
-		individualPodSeverity, checkFailed, err := c.healthCheckService.getIndividualPodSeverity(pod, appPort)
+	for _, podProbe := range probes {
+		// a starting pod is not healthy yet
+		if podProbe.starting {
+			continue
+		}
+		individualPodSeverity, checkFailed, err := podProbe.severity(now)
 
 		if err != nil {
 			log.WithError(err).Error("Cannot get individual pod severity, skipping pod.")
@@ -46,21 +40,10 @@ func (c *healthCheckController) getSeverityForService(ctx context.Context, servi
 	return finalSeverity
 }
 
-func (c *healthCheckController) getSeverityForPod(ctx context.Context, podName string, appPort int32) uint8 {
-	podToBeChecked, err := c.healthCheckService.getPodByName(ctx, podName)
-
-	if err != nil {
-		log.WithError(err).Errorf("Cannot get pod by name: %s in order to get severity level, using default severity: %d.", podName, defaultSeverity)
-		return defaultSeverity
-	}
-
-	return c.computeSeverityByPods([]pod{podToBeChecked}, appPort)
-}
-
-func (c *healthCheckController) computeSeverityByPods(pods []pod, appPort int32) uint8 {
+func computeSeverityByPods(probes []podProbe, now time.Time) uint8 {
 	finalSeverity := defaultSeverity
-	for _, pod := range pods {
-		individualPodSeverity, _, err := c.healthCheckService.getIndividualPodSeverity(pod, appPort)
+	for _, podProbe := range probes {
+		individualPodSeverity, _, err := podProbe.severity(now)
 
 		if err != nil {
 			log.WithError(err).Warn("Cannot get individual pod severity, skipping pod.")
@@ -74,3 +57,14 @@ func (c *healthCheckController) computeSeverityByPods(pods []pod, appPort int32)
 
 	return finalSeverity
 }
+
+// withoutAckedPods leaves out the pods that have been acked, as they do not contribute to the health of their service.
+func withoutAckedPods(probes []podProbe, now time.Time) []podProbe {
+	var unacked []podProbe
+	for _, p := range probes {
+		if activeAck(p.pod.ack, now) == "" {
+			unacked = append(unacked, p)
+		}
+	}
+	return unacked
+}
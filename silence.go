@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	silencesConfigMapName          = "healthcheck.silences"
+	silencesConfigMapLabelSelector = "healthcheck-silences-for=aggregate-healthcheck"
+)
+
+// silence is a time-bounded maintenance window. While it is active, the failing checks of the services it targets
+// are treated like acked checks. A silence targets services by name, all the services of categories, and the services
+// whose labels match a label selector. It is stored as a JSON value in the silences configMap, keyed by its ID.
+type silence struct {
+	ID         string    `json:"id"`
+	Comment    string    `json:"comment"`
+	Author     string    `json:"author,omitempty"`
+	StartsAt   time.Time `json:"startsAt"`
+	EndsAt     time.Time `json:"endsAt"`
+	Services   []string  `json:"services,omitempty"`
+	Categories []string  `json:"categories,omitempty"`
+	Selector   string    `json:"selector,omitempty"`
+}
+
+// newSilence creates a silence with a random ID. The silence must have a comment, a positive duration and at least one target.
+func newSilence(comment string, author string, startsAt time.Time, duration time.Duration, services []string, categories []string, selector string) (silence, error) {
+	if comment == "" {
+		return silence{}, errors.New("a silence must have a comment")
+	}
+	if duration <= 0 {
+		return silence{}, fmt.Errorf("silence duration %v must be positive", duration)
+	}
+	if len(services) == 0 && len(categories) == 0 && selector == "" {
+		return silence{}, errors.New("a silence must target services, categories or a label selector")
+	}
+	if _, err := labels.Parse(selector); err != nil {
+		return silence{}, fmt.Errorf("invalid label selector %s: %v", selector, err)
+	}
+
+	id, err := newSilenceID()
+	if err != nil {
+		return silence{}, err
+	}
+
+	return silence{
+		ID:         id,
+		Comment:    comment,
+		Author:     author,
+		StartsAt:   startsAt.UTC(),
+		EndsAt:     startsAt.UTC().Add(duration),
+		Services:   services,
+		Categories: categories,
+		Selector:   selector,
+	}, nil
+}
+
+// newSilenceID returns a random ID, which is also a valid configMap key.
+func newSilenceID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("cannot generate silence ID: %v", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+func parseSilence(value string) (silence, error) {
+	var s silence
+	if err := json.Unmarshal([]byte(value), &s); err != nil {
+		return silence{}, fmt.Errorf("cannot parse silence [%s]: %v", value, err)
+	}
+	return s, nil
+}
+
+func (s silence) encode() (string, error) {
+	value, err := json.Marshal(s)
+	if err != nil {
+		return "", fmt.Errorf("cannot encode silence %s: %v", s.ID, err)
+	}
+	return string(value), nil
+}
+
+func (s silence) isActive(now time.Time) bool {
+	return !now.Before(s.StartsAt) && now.Before(s.EndsAt)
+}
+
+func (s silence) hasEnded(now time.Time) bool {
+	return !now.Before(s.EndsAt)
+}
+
+// matches tells whether the silence targets the service, given the categories the service may belong to.
+func (s silence) matches(srv service, categories map[string]category) bool {
+	for _, serviceEntry := range s.Services {
+		if matchesServiceEntry(serviceEntry, srv.key()) {
+			return true
+		}
+	}
+
+	for _, categoryName := range s.Categories {
+		if c, ok := categories[categoryName]; ok && categoryContainsService(c, srv.key()) {
+			return true
+		}
+	}
+
+	if s.Selector != "" {
+		selector, err := labels.Parse(s.Selector)
+		if err == nil && selector.Matches(labels.Set(srv.labels)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ackValue is the ack attached to the check results silenced by the silence.
+func (s silence) ackValue() string {
+	endsAt := s.EndsAt
+	value, err := ack{
+		Message:   s.Comment,
+		Author:    s.Author,
+		CreatedAt: s.StartsAt,
+		ExpiresAt: &endsAt,
+		Silence:   s.ID,
+	}.encode()
+	if err != nil {
+		return s.Comment
+	}
+	return value
+}
+
+// categoryContainsService tells whether the service belongs to the category. The default category contains every service.
+func categoryContainsService(c category, serviceKey string) bool {
+	if c.name == "default" {
+		return true
+	}
+	for _, serviceEntry := range c.services {
+		if matchesServiceEntry(serviceEntry, serviceKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// findActiveSilence returns the first of the silences that is active and targets the service.
+func findActiveSilence(silences []silence, srv service, categories map[string]category, now time.Time) (silence, bool) {
+	for _, s := range silences {
+		if s.isActive(now) && s.matches(srv, categories) {
+			return s, true
+		}
+	}
+	return silence{}, false
+}
+
+// applySilences attaches the silences to the check results of the services they target, the way acks are attached.
+// Checks that have been acked keep their ack. serviceForCheck returns the service a check result belongs to.
+func applySilences(checkResults []fthealth.CheckResult, silences []silence, categories map[string]category, serviceForCheck func(fthealth.CheckResult) (service, bool), now time.Time) {
+	if len(silences) == 0 {
+		return
+	}
+
+	for i, checkResult := range checkResults {
+		if checkResult.Ack != "" {
+			continue
+		}
+		srv, ok := serviceForCheck(checkResult)
+		if !ok {
+			continue
+		}
+		if s, found := findActiveSilence(silences, srv, categories, now); found {
+			checkResults[i].Ack = s.ackValue()
+		}
+	}
+}
+
+func sortSilences(silences []silence) {
+	sort.Slice(silences, func(i, j int) bool {
+		if !silences[i].StartsAt.Equal(silences[j].StartsAt) {
+			return silences[i].StartsAt.Before(silences[j].StartsAt)
+		}
+		return silences[i].ID < silences[j].ID
+	})
+}
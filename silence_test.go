@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSilence(t *testing.T) {
+	startsAt := time.Date(2024, 1, 2, 22, 0, 0, 0, time.UTC)
+	s, err := newSilence("release", "jane.doe", startsAt, 2*time.Hour, []string{"service1"}, nil, "")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, s.ID)
+	assert.Equal(t, startsAt, s.StartsAt)
+	assert.Equal(t, startsAt.Add(2*time.Hour), s.EndsAt)
+
+	other, err := newSilence("release", "jane.doe", startsAt, 2*time.Hour, []string{"service1"}, nil, "")
+	assert.NoError(t, err)
+	assert.NotEqual(t, s.ID, other.ID)
+}
+
+func TestNewSilenceValidation(t *testing.T) {
+	now := time.Now()
+	_, err := newSilence("", "", now, time.Hour, []string{"service1"}, nil, "")
+	assert.Error(t, err, "A silence without comment should be rejected")
+
+	_, err = newSilence("release", "", now, 0, []string{"service1"}, nil, "")
+	assert.Error(t, err, "A silence without duration should be rejected")
+
+	_, err = newSilence("release", "", now, time.Hour, nil, nil, "")
+	assert.Error(t, err, "A silence without target should be rejected")
+
+	_, err = newSilence("release", "", now, time.Hour, nil, nil, "team===content")
+	assert.Error(t, err, "A silence with an invalid selector should be rejected")
+}
+
+func TestSilenceIsActive(t *testing.T) {
+	now := time.Now()
+	s := silence{StartsAt: now, EndsAt: now.Add(time.Hour)}
+
+	assert.False(t, s.isActive(now.Add(-time.Minute)))
+	assert.False(t, s.hasEnded(now.Add(-time.Minute)))
+	assert.True(t, s.isActive(now))
+	assert.False(t, s.isActive(now.Add(time.Hour)))
+	assert.True(t, s.hasEnded(now.Add(time.Hour)))
+}
+
+func TestSilenceMatches(t *testing.T) {
+	categories := map[string]category{
+		"default":    {name: "default"},
+		"publishing": {name: "publishing", services: []string{"content-ingester"}},
+	}
+	ingester := service{name: "content-ingester", namespace: "default"}
+	labelledService := service{name: "api-policy-component", namespace: "read", labels: map[string]string{"team": "content"}}
+
+	assert.True(t, silence{Services: []string{"content-ingester"}}.matches(ingester, categories))
+	assert.True(t, silence{Services: []string{"read/api-policy-component"}}.matches(labelledService, categories))
+	assert.False(t, silence{Services: []string{"publishing/api-policy-component"}}.matches(labelledService, categories))
+	assert.True(t, silence{Categories: []string{"publishing"}}.matches(ingester, categories))
+	assert.False(t, silence{Categories: []string{"publishing"}}.matches(labelledService, categories))
+	assert.True(t, silence{Categories: []string{"default"}}.matches(labelledService, categories))
+	assert.False(t, silence{Categories: []string{"unknown"}}.matches(ingester, categories))
+	assert.True(t, silence{Selector: "team=content"}.matches(labelledService, categories))
+	assert.False(t, silence{Selector: "team=content"}.matches(ingester, categories))
+}
+
+func TestApplySilences(t *testing.T) {
+	now := time.Now()
+	services := map[string]service{
+		"service1": {name: "service1", namespace: "default"},
+		"service2": {name: "service2", namespace: "default"},
+		"service3": {name: "service3", namespace: "default"},
+	}
+	silences := []silence{
+		{ID: "ended", Comment: "ended", StartsAt: now.Add(-2 * time.Hour), EndsAt: now.Add(-time.Hour), Services: []string{"service3"}},
+		{ID: "active", Comment: "release", StartsAt: now.Add(-time.Hour), EndsAt: now.Add(time.Hour), Services: []string{"service1", "service2", "service3"}},
+		{ID: "scheduled", Comment: "later", StartsAt: now.Add(time.Hour), EndsAt: now.Add(2 * time.Hour), Services: []string{"service4"}},
+	}
+	checkResults := []fthealth.CheckResult{
+		{ID: "service1", Ok: false},
+		{ID: "service2", Ok: false, Ack: "known issue"},
+		{ID: "service3", Ok: true},
+		{ID: "service4", Ok: false},
+	}
+
+	applySilences(checkResults, silences, map[string]category{}, func(checkResult fthealth.CheckResult) (service, bool) {
+		srv, ok := services[checkResult.ID]
+		return srv, ok
+	}, now)
+
+	assert.Equal(t, "active", parseAck(checkResults[0].Ack).Silence)
+	assert.Equal(t, "release", parseAck(checkResults[0].Ack).Message)
+	assert.Equal(t, "known issue", checkResults[1].Ack, "An acked check should keep its ack")
+	assert.Equal(t, "active", parseAck(checkResults[2].Ack).Silence)
+	assert.Empty(t, checkResults[3].Ack, "A check should not be silenced by a scheduled silence")
+
+	finalOk, _ := getFinalResult(checkResults[:3], map[string]category{"default": {name: "default", isEnabled: true}})
+	assert.True(t, finalOk, "Silenced checks should not fail the cluster health")
+}
+
+func TestSortSilences(t *testing.T) {
+	now := time.Now()
+	silences := []silence{
+		{ID: "c", StartsAt: now.Add(time.Hour)},
+		{ID: "b", StartsAt: now},
+		{ID: "a", StartsAt: now},
+	}
+	sortSilences(silences)
+	assert.Equal(t, "a", silences[0].ID)
+	assert.Equal(t, "b", silences[1].ID)
+	assert.Equal(t, "c", silences[2].ID)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type stickyAction int
+
+const (
+	stickyNoAction stickyAction = iota
+	stickyDisable
+	stickyReEnable
+)
+
+// stickyKey identifies the runs of a service within a sticky category, so a service shared by two sticky categories
+// is counted separately for each of them.
+type stickyKey struct {
+	category string
+	service  string
+}
+
+type stickyRuns struct {
+	consecutiveFailures  int
+	consecutiveSuccesses int
+	// failures holds the times of the failed runs within the failure window of the category, if it has one
+	failures []time.Time
+}
+
+// stickyTracker counts the scheduled check runs of the services of sticky categories, and tells when a category has to be
+// disabled or re-enabled. It is fed by the scheduler workers, so it is safe for concurrent use.
+type stickyTracker struct {
+	sync.Mutex
+	runs map[stickyKey]*stickyRuns
+	// removed holds the services that are not checked anymore, which are not waited for to re-enable their categories
+	removed map[string]bool
+}
+
+func newStickyTracker() *stickyTracker {
+	return &stickyTracker{
+		runs:    make(map[stickyKey]*stickyRuns),
+		removed: make(map[string]bool),
+	}
+}
+
+// record records a check run of the service for the sticky category. A category is disabled once the service has failed
+// failureThreshold runs in a row or, if the category has a failure window, failureThreshold runs within the window.
+// A category disabled for being sticky is re-enabled once all of its services have been healthy for autoReEnableAfter runs in a row;
+// a category disabled through the endpoints stays disabled until it is enabled again the same way.
+// The failures of the service counted against the threshold are returned along with the action.
+func (t *stickyTracker) record(c category, serviceKey string, ok bool, now time.Time) (stickyAction, int) {
+	t.Lock()
+	defer t.Unlock()
+
+	delete(t.removed, serviceKey)
+	key := stickyKey{category: c.name, service: serviceKey}
+	r, found := t.runs[key]
+	if !found {
+		r = &stickyRuns{}
+		t.runs[key] = r
+	}
+
+	if ok {
+		r.consecutiveFailures = 0
+		r.consecutiveSuccesses++
+	} else {
+		r.consecutiveFailures++
+		r.consecutiveSuccesses = 0
+		if c.failureWindow > 0 {
+			r.failures = append(r.failures, now)
+		}
+	}
+	r.failures = withinWindow(r.failures, now, c.failureWindow)
+
+	failures := r.failureCount(c)
+	if c.isEnabled {
+		if !ok && failures >= c.failureThreshold {
+			return stickyDisable, failures
+		}
+		return stickyNoAction, failures
+	}
+
+	if ok && c.autoReEnableAfter > 0 && c.isDisabledBySticky() && t.isCategoryHealthy(c, c.autoReEnableAfter) {
+		return stickyReEnable, failures
+	}
+	return stickyNoAction, failures
+}
+
+// categoryUpdated resets the runs of a category disabled or re-enabled by the tracker,
+// so its healthy runs, or its failures, are counted from now on.
+func (t *stickyTracker) categoryUpdated(categoryName string) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.resetCategory(categoryName)
+}
+
+// forgetService drops the runs of a service that is not checked anymore, so it does not hold back the re-enabling of its categories.
+func (t *stickyTracker) forgetService(serviceKey string) {
+	t.Lock()
+	defer t.Unlock()
+
+	for key := range t.runs {
+		if key.service == serviceKey {
+			delete(t.runs, key)
+		}
+	}
+	t.removed[serviceKey] = true
+}
+
+func (t *stickyTracker) resetCategory(categoryName string) {
+	for key := range t.runs {
+		if key.category == categoryName {
+			delete(t.runs, key)
+		}
+	}
+}
+
+// isCategoryHealthy tells whether every service of the category has been healthy for the given number of runs in a row.
+// The runs of a category are reset when it is disabled, so every service listed by the category has to be checked again,
+// unless it is not checked anymore.
+func (t *stickyTracker) isCategoryHealthy(c category, healthyRuns int) bool {
+	for key, r := range t.runs {
+		if key.category == c.name && r.consecutiveSuccesses < healthyRuns {
+			return false
+		}
+	}
+
+	for _, serviceEntry := range c.services {
+		if serviceEntry != "" && !t.hasRunsFor(c.name, serviceEntry) && !t.isRemoved(serviceEntry) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *stickyTracker) hasRunsFor(categoryName string, serviceEntry string) bool {
+	for key := range t.runs {
+		if key.category == categoryName && matchesServiceEntry(serviceEntry, key.service) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *stickyTracker) isRemoved(serviceEntry string) bool {
+	for serviceKey := range t.removed {
+		if matchesServiceEntry(serviceEntry, serviceKey) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *stickyRuns) failureCount(c category) int {
+	if c.failureWindow > 0 {
+		return len(r.failures)
+	}
+	return r.consecutiveFailures
+}
+
+// withinWindow drops the failures older than the window. Without a window no failure times are kept.
+func withinWindow(failures []time.Time, now time.Time, window time.Duration) []time.Time {
+	if window <= 0 {
+		return nil
+	}
+
+	start := now.Add(-window)
+	kept := failures[:0]
+	for _, failure := range failures {
+		if failure.After(start) {
+			kept = append(kept, failure)
+		}
+	}
+	return kept
+}
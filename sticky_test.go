@@ -0,0 +1,146 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStickyTrackerDisablesAfterConsecutiveFailures(t *testing.T) {
+	tracker := newStickyTracker()
+	c := category{name: "read", isSticky: true, isEnabled: true, failureThreshold: 3}
+	now := time.Now()
+
+	action, failures := tracker.record(c, "default/service1", false, now)
+	assert.Equal(t, stickyNoAction, action)
+	assert.Equal(t, 1, failures)
+	tracker.record(c, "default/service1", false, now)
+
+	// a healthy run resets the count
+	action, failures = tracker.record(c, "default/service1", true, now)
+	assert.Equal(t, stickyNoAction, action)
+	assert.Equal(t, 0, failures)
+
+	tracker.record(c, "default/service1", false, now)
+	tracker.record(c, "default/service1", false, now)
+	action, failures = tracker.record(c, "default/service1", false, now)
+	assert.Equal(t, stickyDisable, action)
+	assert.Equal(t, 3, failures)
+}
+
+func TestStickyTrackerCountsServicesPerCategory(t *testing.T) {
+	tracker := newStickyTracker()
+	read := category{name: "read", isSticky: true, isEnabled: true, failureThreshold: 2}
+	publishing := category{name: "publishing", isSticky: true, isEnabled: true, failureThreshold: 2}
+	now := time.Now()
+
+	_, failures := tracker.record(read, "default/service1", false, now)
+	assert.Equal(t, 1, failures)
+	_, failures = tracker.record(publishing, "default/service1", false, now)
+	assert.Equal(t, 1, failures, "A service shared by two categories should be counted once for each of them")
+	_, failures = tracker.record(read, "default/service2", false, now)
+	assert.Equal(t, 1, failures, "Services of the same category should be counted separately")
+}
+
+func TestStickyTrackerDisablesAfterFailuresWithinWindow(t *testing.T) {
+	tracker := newStickyTracker()
+	c := category{name: "read", isSticky: true, isEnabled: true, failureThreshold: 3, failureWindow: 10 * time.Minute}
+	start := time.Now()
+
+	tracker.record(c, "default/service1", false, start)
+	tracker.record(c, "default/service1", true, start.Add(time.Minute))
+	action, failures := tracker.record(c, "default/service1", false, start.Add(2*time.Minute))
+	assert.Equal(t, stickyNoAction, action)
+	assert.Equal(t, 2, failures, "Healthy runs should not reset the failures within the window")
+
+	// the first failure is out of the window by now
+	action, failures = tracker.record(c, "default/service1", false, start.Add(11*time.Minute))
+	assert.Equal(t, stickyNoAction, action)
+	assert.Equal(t, 2, failures)
+
+	action, _ = tracker.record(c, "default/service1", false, start.Add(11*time.Minute+30*time.Second))
+	assert.Equal(t, stickyDisable, action)
+}
+
+func TestStickyTrackerReEnablesCategoriesItDisabled(t *testing.T) {
+	tracker := newStickyTracker()
+	// the services of a category without any are read as a single empty entry
+	c := category{name: "read", services: []string{""}, isSticky: true, isEnabled: true, failureThreshold: 1, autoReEnableAfter: 2}
+	now := time.Now()
+
+	action, _ := tracker.record(c, "default/service1", false, now)
+	assert.Equal(t, stickyDisable, action)
+	tracker.categoryUpdated(c.name)
+	c.isEnabled = false
+	c.lastDisabled = &categoryChange{By: stickyActor}
+
+	tracker.record(c, "default/service1", true, now)
+	tracker.record(c, "default/service2", true, now)
+	tracker.record(c, "default/service2", true, now)
+	action, _ = tracker.record(c, "default/service1", false, now)
+	assert.Equal(t, stickyNoAction, action)
+
+	action, _ = tracker.record(c, "default/service1", true, now)
+	assert.Equal(t, stickyNoAction, action)
+	action, _ = tracker.record(c, "default/service1", true, now)
+	assert.Equal(t, stickyReEnable, action, "The category should be re-enabled once all its services are healthy for 2 runs")
+
+	tracker.categoryUpdated(c.name)
+	c.isEnabled = true
+	action, failures := tracker.record(c, "default/service1", true, now)
+	assert.Equal(t, stickyNoAction, action)
+	assert.Equal(t, 0, failures)
+}
+
+func TestStickyTrackerWaitsForEveryServiceToReEnable(t *testing.T) {
+	tracker := newStickyTracker()
+	c := category{name: "read", services: []string{"service1", "publishing/service2"}, isSticky: true, isEnabled: true,
+		failureThreshold: 1, autoReEnableAfter: 2}
+	start := time.Now()
+
+	action, _ := tracker.record(c, "default/service1", false, start)
+	assert.Equal(t, stickyDisable, action)
+	tracker.categoryUpdated(c.name)
+	c.isEnabled = false
+	c.lastDisabled = &categoryChange{By: stickyActor}
+
+	// service1 is checked every minute, service2 every 5 minutes
+	for i := 1; i <= 4; i++ {
+		action, _ = tracker.record(c, "default/service1", true, start.Add(time.Duration(i)*time.Minute))
+		assert.Equal(t, stickyNoAction, action, "The category should wait for service2 to be checked again")
+	}
+	action, _ = tracker.record(c, "publishing/service2", true, start.Add(5*time.Minute))
+	assert.Equal(t, stickyNoAction, action)
+	action, _ = tracker.record(c, "publishing/service2", true, start.Add(10*time.Minute))
+	assert.Equal(t, stickyReEnable, action, "The category should be re-enabled once every service listed is healthy for 2 runs")
+}
+
+func TestStickyTrackerDoesNotReEnableManuallyDisabledCategories(t *testing.T) {
+	tracker := newStickyTracker()
+	c := category{name: "read", isSticky: true, isEnabled: false, failureThreshold: 1, autoReEnableAfter: 1}
+
+	action, _ := tracker.record(c, "default/service1", true, time.Now())
+	assert.Equal(t, stickyNoAction, action, "A category disabled before it was tracked should not be re-enabled")
+
+	c.lastDisabled = &categoryChange{By: "jane.doe", Reason: "failover"}
+	action, _ = tracker.record(c, "default/service1", true, time.Now())
+	assert.Equal(t, stickyNoAction, action, "A category disabled through the endpoint should not be re-enabled")
+}
+
+func TestStickyTrackerForgetsRemovedServices(t *testing.T) {
+	tracker := newStickyTracker()
+	c := category{name: "read", isSticky: true, isEnabled: true, failureThreshold: 1, autoReEnableAfter: 1}
+	now := time.Now()
+
+	tracker.record(c, "default/service1", false, now)
+	tracker.categoryUpdated(c.name)
+	c.isEnabled = false
+	c.lastDisabled = &categoryChange{By: stickyActor}
+	tracker.record(c, "default/service1", false, now)
+
+	tracker.forgetService("default/service1")
+	c.services = []string{"service1", "service2"}
+	action, _ := tracker.record(c, "default/service2", true, now)
+	assert.Equal(t, stickyReEnable, action, "A removed service should not hold back the re-enabling of its category")
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	log "github.com/Financial-Times/go-logger"
+	k8score "k8s.io/api/core/v1"
+)
+
+const (
+	// buildInfoAnnotation makes the version of the pods of the service be fetched from their build info endpoint:
+	// "true" for the __build-info endpoint, or the path of the endpoint, e.g. "/internal/__build-info"
+	buildInfoAnnotation  = "aggregate-healthcheck.ft.com/build-info"
+	defaultBuildInfoPath = "/__build-info"
+)
+
+// containerImage is the image a container of a pod runs.
+type containerImage struct {
+	container string
+	image     string
+}
+
+// getContainerImages returns the images of the containers of the pod, in the order of its spec.
+func getContainerImages(k8sPod k8score.Pod) []containerImage {
+	images := make([]containerImage, 0, len(k8sPod.Spec.Containers))
+	for _, container := range k8sPod.Spec.Containers {
+		images = append(images, containerImage{container: container.Name, image: container.Image})
+	}
+	return images
+}
+
+// imageVersion describes the images of the pod, e.g. "coco/api-policy-component:v1.2.3", or
+// "app=coco/api-policy-component:v1.2.3, proxy=envoyproxy/envoy:v1.30" for a pod of several containers.
+func (p pod) imageVersion() string {
+	if len(p.images) == 1 {
+		return p.images[0].image
+	}
+	images := make([]string, 0, len(p.images))
+	for _, image := range p.images {
+		images = append(images, image.container+"="+image.image)
+	}
+	return strings.Join(images, ", ")
+}
+
+// getBuildInfoPath returns the path of the build info endpoint set on the service by its annotation,
+// or an empty path when the version of the pods is not fetched.
+func getBuildInfoPath(k8sService *k8score.Service) string {
+	value := strings.TrimSpace(k8sService.Annotations[buildInfoAnnotation])
+	switch {
+	case value == "" || value == "false":
+		return ""
+	case value == "true":
+		return defaultBuildInfoPath
+	case strings.HasPrefix(value, "/"):
+		return value
+	}
+	log.Warnf("Cannot parse the build info endpoint of service with name %s, the versions of its pods are not fetched.", k8sService.Name)
+	return ""
+}
+
+// buildInfoResponse is the response of the build info endpoint of a pod.
+type buildInfoResponse struct {
+	Version  string `json:"version"`
+	Revision string `json:"revision"`
+}
+
+// getBuildVersion fetches the version of the pod from its build info endpoint, on the port its health is fetched from
+// and reached the same way. It falls back to the revision when the build info has no version.
+func (hs *k8sHealthcheckService) getBuildVersion(ctx context.Context, pod pod, service service) (string, error) {
+	port, err := service.probedEndpoints()[0].port.resolve(pod)
+	if err != nil {
+		return "", err
+	}
+
+	service.endpoint.path = service.buildInfoPath
+	endpoint, err := hs.getHTTPEndpoint(service, defaultBuildInfoPath)
+	if err != nil {
+		return "", err
+	}
+	req, err := endpoint.newRequest(ctx, pod, port)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := endpoint.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err = resp.Body.Close(); err != nil {
+			log.WithError(err).Errorf("Cannot close response body reader.")
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("build info endpoint returned non-200 status (%v)", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var buildInfo buildInfoResponse
+	if err = json.Unmarshal(body, &buildInfo); err != nil {
+		return "", fmt.Errorf("cannot parse build info response: %w", err)
+	}
+	if buildInfo.Version != "" {
+		return buildInfo.Version, nil
+	}
+	return buildInfo.Revision, nil
+}
+
+// podVersion is the version a pod runs: its images, and the version from its build info when it is fetched.
+type podVersion struct {
+	Image string `json:"image"`
+	Build string `json:"build,omitempty"`
+}
+
+func (v podVersion) String() string {
+	if v.Build == "" {
+		return v.Image
+	}
+	return fmt.Sprintf("%s (build %s)", v.Image, v.Build)
+}
+
+func (p podProbe) version() podVersion {
+	return podVersion{Image: p.pod.imageVersion(), Build: p.buildVersion}
+}
+
+// versionPods lists the pods running a version.
+type versionPods struct {
+	podVersion
+	Pods []string `json:"pods"`
+}
+
+// versionStatus lists the versions the pods of a service run. The service has a version skew when its pods run several images,
+// or several build versions, while its workload is not rolling out.
+type versionStatus struct {
+	Versions []versionPods `json:"versions"`
+	Skew     bool          `json:"skew"`
+}
+
+// getVersions groups the pods of the service by the version they run, the version of the most pods first.
+// The pods whose build version could not be fetched do not make a version skew on their own.
+func getVersions(probe serviceProbe, quorum quorumStatus) versionStatus {
+	var status versionStatus
+	images := make(map[string]bool)
+	builds := make(map[string]bool)
+	for _, podProbe := range probe.pods {
+		version := podProbe.version()
+		images[version.Image] = true
+		if version.Build != "" {
+			builds[version.Build] = true
+		}
+
+		i := 0
+		for i < len(status.Versions) && status.Versions[i].podVersion != version {
+			i++
+		}
+		if i == len(status.Versions) {
+			status.Versions = append(status.Versions, versionPods{podVersion: version})
+		}
+		status.Versions[i].Pods = append(status.Versions[i].Pods, podProbe.pod.name)
+	}
+
+	sort.SliceStable(status.Versions, func(i, j int) bool {
+		return len(status.Versions[i].Pods) > len(status.Versions[j].Pods)
+	})
+	rollingOut := quorum.Workload != nil && quorum.Workload.Rollout != ""
+	status.Skew = !rollingOut && (len(images) > 1 || len(builds) > 1)
+	return status
+}
+
+// summary describes the versions of a service with a version skew in a line,
+// e.g. "version skew: coco/api:v2 on 2 pods, coco/api:v1 on 1 pod".
+func (v versionStatus) summary() string {
+	versions := make([]string, 0, len(v.Versions))
+	for _, version := range v.Versions {
+		pods := "pods"
+		if len(version.Pods) == 1 {
+			pods = "pod"
+		}
+		versions = append(versions, fmt.Sprintf("%s on %d %s", version.podVersion, len(version.Pods), pods))
+	}
+	return "version skew: " + strings.Join(versions, ", ")
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	k8score "k8s.io/api/core/v1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetBuildInfoPath(t *testing.T) {
+	newK8sService := func(value string) *k8score.Service {
+		return &k8score.Service{ObjectMeta: k8smeta.ObjectMeta{Name: "service1", Annotations: map[string]string{buildInfoAnnotation: value}}}
+	}
+
+	assert.Equal(t, "", getBuildInfoPath(&k8score.Service{}))
+	assert.Equal(t, "", getBuildInfoPath(newK8sService("false")))
+	assert.Equal(t, defaultBuildInfoPath, getBuildInfoPath(newK8sService("true")))
+	assert.Equal(t, "/internal/__build-info", getBuildInfoPath(newK8sService(" /internal/__build-info ")))
+	assert.Equal(t, "", getBuildInfoPath(newK8sService("internal")), "An invalid path should be ignored")
+}
+
+func TestPopulatePodWithImages(t *testing.T) {
+	k8sPod := k8score.Pod{Spec: k8score.PodSpec{Containers: []k8score.Container{
+		{Name: "app", Image: "coco/api-policy-component:v1.2.3"},
+		{Name: "proxy", Image: "envoyproxy/envoy:v1.30"},
+	}}}
+
+	p := populatePod(k8sPod, nil)
+	assert.Equal(t, []containerImage{{container: "app", image: "coco/api-policy-component:v1.2.3"}, {container: "proxy", image: "envoyproxy/envoy:v1.30"}}, p.images)
+	assert.Equal(t, "app=coco/api-policy-component:v1.2.3, proxy=envoyproxy/envoy:v1.30", p.imageVersion())
+
+	p.images = p.images[:1]
+	assert.Equal(t, "coco/api-policy-component:v1.2.3", p.imageVersion())
+}
+
+func newVersionedPodProbe(name string, image string, build string) podProbe {
+	return podProbe{pod: pod{name: name, images: []containerImage{{container: "app", image: image}}}, buildVersion: build}
+}
+
+func TestGetVersions(t *testing.T) {
+	probe := serviceProbe{pods: []podProbe{
+		newVersionedPodProbe("pod-a", "coco/api:v1", ""),
+		newVersionedPodProbe("pod-b", "coco/api:v2", ""),
+		newVersionedPodProbe("pod-c", "coco/api:v2", ""),
+	}}
+
+	versions := getVersions(probe, quorumStatus{})
+	assert.Equal(t, versionStatus{
+		Versions: []versionPods{
+			{podVersion: podVersion{Image: "coco/api:v2"}, Pods: []string{"pod-b", "pod-c"}},
+			{podVersion: podVersion{Image: "coco/api:v1"}, Pods: []string{"pod-a"}},
+		},
+		Skew: true,
+	}, versions)
+	assert.Equal(t, "version skew: coco/api:v2 on 2 pods, coco/api:v1 on 1 pod", versions.summary())
+
+	rollingOut := quorumStatus{Workload: &workloadStatus{Kind: string(deploymentWorkload), Name: "api", Rollout: string(rolloutInProgress)}}
+	assert.False(t, getVersions(probe, rollingOut).Skew, "A service rolling out is expected to run several versions")
+}
+
+func TestGetVersionsComparesBuildVersions(t *testing.T) {
+	probe := serviceProbe{pods: []podProbe{
+		newVersionedPodProbe("pod-a", "coco/api:latest", "1.0.0"),
+		newVersionedPodProbe("pod-b", "coco/api:latest", "1.1.0"),
+	}}
+	versions := getVersions(probe, quorumStatus{})
+	assert.True(t, versions.Skew)
+	assert.Equal(t, "version skew: coco/api:latest (build 1.0.0) on 1 pod, coco/api:latest (build 1.1.0) on 1 pod", versions.summary())
+
+	probe.pods[1].buildVersion = ""
+	assert.False(t, getVersions(probe, quorumStatus{}).Skew, "A pod whose build version could not be fetched should not make a skew")
+}
+
+func TestCheckServiceHealthWarnsOfVersionSkew(t *testing.T) {
+	s := service{name: "api", namespace: k8score.NamespaceDefault}
+	healthy := healthcheckResponse{Checks: []podCheck{{Name: "Kafka", OK: true, Severity: 1}}}
+	probe := serviceProbe{service: s, pods: []podProbe{
+		newVersionedPodProbe("pod-a", "coco/api:v1", ""),
+		newVersionedPodProbe("pod-b", "coco/api:v2", ""),
+	}}
+	for i := range probe.pods {
+		probe.pods[i].health = healthy
+	}
+	workloads := map[string]workload{s.key(): {kind: deploymentWorkload, name: "api", desiredReplicas: 2, readyReplicas: 2}}
+
+	output, err := checkServiceHealth(probe, workloads, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, "2/2 pods available - version skew: coco/api:v1 on 1 pod, coco/api:v2 on 1 pod", output)
+}
+
+func TestProbePodFetchesBuildVersion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/internal/__build-info":
+			_, _ = w.Write([]byte(`{"version":"1.2.3","revision":"a1b2c3d"}`))
+		case "/__health":
+			_, _ = w.Write([]byte(`{"checks":[{"name":"Kafka","ok":true,"severity":1}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	_, port := listenerPod(t, serverURL.Host)
+	hs := initializeMockService(t, &http.Client{Timeout: 5 * time.Second})
+
+	p := pod{name: "pod1", ip: "127.0.0.1", images: []containerImage{{container: "app", image: "coco/api:v1"}}}
+	srv := service{name: "service1", appPort: port, buildInfoPath: "/internal/__build-info"}
+
+	probe := hs.probePod(context.Background(), p, srv)
+	require.NoError(t, probe.err)
+	assert.Equal(t, podVersion{Image: "coco/api:v1", Build: "1.2.3"}, probe.version())
+
+	srv.buildInfoPath = "/missing"
+	probe = hs.probePod(context.Background(), p, srv)
+	require.NoError(t, probe.err, "A pod without build info should still be healthy")
+	assert.Equal(t, podVersion{Image: "coco/api:v1"}, probe.version())
+}
+
+func TestHealthcheckJSONResponseIncludesVersions(t *testing.T) {
+	skew := versionStatus{Versions: []versionPods{
+		{podVersion: podVersion{Image: "coco/api:v2"}, Pods: []string{"pod-b"}},
+		{podVersion: podVersion{Image: "coco/api:v1"}, Pods: []string{"pod-a"}},
+	}, Skew: true}
+	respRecorder := httptest.NewRecorder()
+	buildHealthcheckJSONResponse(respRecorder, fthealth.HealthResult{
+		Checks: []fthealth.CheckResult{{ID: "default/service1", Name: "service1", Ok: true}},
+	}, map[string]checkBreakdown{"default/service1": {versions: skew}}, nil, nil, nil)
+
+	var body struct {
+		Checks []struct {
+			Versions *versionStatus `json:"_versions"`
+		} `json:"checks"`
+	}
+	assert.NoError(t, json.Unmarshal(respRecorder.Body.Bytes(), &body))
+	assert.Equal(t, &skew, body.Checks[0].Versions)
+
+	respRecorder = httptest.NewRecorder()
+	buildHealthcheckJSONResponse(respRecorder, fthealth.HealthResult{
+		Checks: []fthealth.CheckResult{{ID: "default/pod-a", Name: "pod-a", Ok: true}},
+	}, nil, map[string]podVersion{"default/pod-a": {Image: "coco/api:v1", Build: "1.0.0"}}, nil, nil)
+
+	var podsBody struct {
+		Checks []struct {
+			Version *podVersion `json:"_version"`
+		} `json:"checks"`
+	}
+	assert.NoError(t, json.Unmarshal(respRecorder.Body.Bytes(), &podsBody))
+	assert.Equal(t, &podVersion{Image: "coco/api:v1", Build: "1.0.0"}, podsBody.Checks[0].Version)
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/Financial-Times/go-logger"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	k8score "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// maxTimeSinceSuccessAnnotation is how long ago the last run of a monitored CronJob may have succeeded, as a duration, e.g. "90m"
+	maxTimeSinceSuccessAnnotation = "aggregate-healthcheck.ft.com/max-time-since-success"
+	defaultMaxTimeSinceSuccess    = 24 * time.Hour
+	// deploymentProgressDeadlineExceeded is the reason of the Progressing condition of a Deployment whose rollout is stuck
+	deploymentProgressDeadlineExceeded = "ProgressDeadlineExceeded"
+	// deploymentNewReplicaSetAvailable is the reason of the Progressing condition of a Deployment whose rollout has completed
+	deploymentNewReplicaSetAvailable = "NewReplicaSetAvailable"
+)
+
+// workloadKind is the kind of the controller running the pods of a service.
+type workloadKind string
+
+const (
+	deploymentWorkload  workloadKind = "Deployment"
+	statefulSetWorkload workloadKind = "StatefulSet"
+	daemonSetWorkload   workloadKind = "DaemonSet"
+	cronJobWorkload     workloadKind = "CronJob"
+)
+
+// rolloutState is the progress of the rollout of a Deployment, empty when it is complete.
+type rolloutState string
+
+const (
+	rolloutInProgress rolloutState = "rolling out"
+	rolloutStuck      rolloutState = "rollout stuck"
+)
+
+// workload is the controller running the pods of a service, as it reports them.
+type workload struct {
+	kind workloadKind
+	name string
+	// desiredReplicas is the number of pods the workload wants, the number of nodes a DaemonSet is scheduled on
+	desiredReplicas int32
+	readyReplicas   int32
+	// rollout tells whether a Deployment is rolling out, and rolloutMessage how far the rollout is, or why it is stuck
+	rollout        rolloutState
+	rolloutMessage string
+	// created is when the workload was created, and lastSuccess when a run of a CronJob last succeeded
+	created     time.Time
+	lastSuccess time.Time
+	suspended   bool
+}
+
+// workloadStatus is the workload backing a service, as reported in the breakdown of its check.
+type workloadStatus struct {
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Desired int    `json:"desired"`
+	Ready   int    `json:"ready"`
+	// Rollout is "rolling out" or "rollout stuck" while a Deployment is not fully rolled out, with the progress or the reason in Message
+	Rollout string `json:"rollout,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// isCronJob reports whether the service is a monitored CronJob rather than a Service.
+func (s service) isCronJob() bool {
+	return s.workloadKind == cronJobWorkload
+}
+
+// podSelector returns the selector of the pods of the service, which defaults to the pods labelled with its name.
+func (s service) podSelector() labels.Selector {
+	if len(s.selector) == 0 {
+		return labels.SelectorFromSet(labels.Set{"app": s.name})
+	}
+	return labels.SelectorFromSet(s.selector)
+}
+
+func (w workload) String() string {
+	return fmt.Sprintf("%s/%s", w.kind, w.name)
+}
+
+func newDeploymentWorkload(d *appsv1.Deployment) workload {
+	w := workload{kind: deploymentWorkload, name: d.Name, desiredReplicas: getDesiredReplicas(d.Spec.Replicas), readyReplicas: d.Status.ReadyReplicas}
+	w.rollout, w.rolloutMessage = getDeploymentRollout(d)
+	return w
+}
+
+// getDeploymentRollout tells whether the Deployment is rolling out, the way kubectl rollout status does: until the controller has observed
+// its latest spec, and then until all its pods are updated and available and its old pods are gone. A rollout that has exceeded its
+// progress deadline is stuck, with the message of the Progressing condition. A paused Deployment is not rolling out.
+// Updated pods that are unavailable only make a rollout while the Progressing condition tells that the rollout has not completed:
+// once it has, an unavailable pod, e.g. a crash looping one, is a failure of the service.
+func getDeploymentRollout(d *appsv1.Deployment) (rolloutState, string) {
+	if d.Spec.Paused {
+		return "", ""
+	}
+	if d.Status.ObservedGeneration < d.Generation {
+		return rolloutInProgress, "waiting for the new spec to be observed"
+	}
+	progressingReason := ""
+	for _, condition := range d.Status.Conditions {
+		if condition.Type != appsv1.DeploymentProgressing {
+			continue
+		}
+		if condition.Reason == deploymentProgressDeadlineExceeded {
+			return rolloutStuck, condition.Message
+		}
+		progressingReason = condition.Reason
+	}
+
+	desired := getDesiredReplicas(d.Spec.Replicas)
+	switch {
+	case d.Status.UpdatedReplicas < desired:
+		return rolloutInProgress, fmt.Sprintf("%d of %d pods updated", d.Status.UpdatedReplicas, desired)
+	case d.Status.Replicas > d.Status.UpdatedReplicas:
+		return rolloutInProgress, fmt.Sprintf("%d old pods terminating", d.Status.Replicas-d.Status.UpdatedReplicas)
+	case d.Status.AvailableReplicas < d.Status.UpdatedReplicas && progressingReason != "" && progressingReason != deploymentNewReplicaSetAvailable:
+		return rolloutInProgress, fmt.Sprintf("%d of %d updated pods available", d.Status.AvailableReplicas, d.Status.UpdatedReplicas)
+	}
+	return "", ""
+}
+
+func newStatefulSetWorkload(s *appsv1.StatefulSet) workload {
+	return workload{kind: statefulSetWorkload, name: s.Name, desiredReplicas: getDesiredReplicas(s.Spec.Replicas), readyReplicas: s.Status.ReadyReplicas}
+}
+
+func newDaemonSetWorkload(d *appsv1.DaemonSet) workload {
+	return workload{kind: daemonSetWorkload, name: d.Name, desiredReplicas: d.Status.DesiredNumberScheduled, readyReplicas: d.Status.NumberReady}
+}
+
+// getDesiredReplicas returns the replica count of a workload, which Kubernetes defaults to 1 when unset.
+func getDesiredReplicas(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
+
+// getWorkloads resolves the services to the workloads running their pods, keyed by the namespace-qualified name of the service.
+// The services without a workload are left out.
+func (hs *k8sHealthcheckService) getWorkloads(_ context.Context, services []service) (map[string]workload, error) {
+	workloads := make(map[string]workload)
+	for _, s := range services {
+		w, found, err := hs.resolveWorkload(s)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve the workload of service %s: %w", s.key(), err)
+		}
+		if found {
+			workloads[s.key()] = w
+		}
+	}
+	return workloads, nil
+}
+
+// resolveWorkload finds the workload of the service: the CronJob of a monitored CronJob, or else the controller owning the pods of
+// the service, or else the workload whose pod template matches the selector of the service, or else the workload named after
+// the service (a StatefulSet by its service name).
+func (hs *k8sHealthcheckService) resolveWorkload(s service) (workload, bool, error) {
+	namespace := s.namespace
+	if namespace == "" {
+		namespace = k8score.NamespaceDefault
+	}
+	listers, err := hs.getWorkloadListers(namespace)
+	if err != nil {
+		return workload{}, false, err
+	}
+
+	if s.isCronJob() {
+		return listers.resolveCronJob(namespace, s.name)
+	}
+
+	w, found, err := listers.resolveByOwner(namespace, s.podSelector())
+	if err != nil || found {
+		return w, found, err
+	}
+	return listers.resolveBySelector(namespace, s)
+}
+
+// resolveByOwner follows the controller owner references of the pods of the service up to their workload.
+func (l workloadListers) resolveByOwner(namespace string, selector labels.Selector) (workload, bool, error) {
+	k8sPods, err := l.podLister.Pods(namespace).List(selector)
+	if err != nil {
+		return workload{}, false, err
+	}
+
+	for _, k8sPod := range k8sPods {
+		owner := k8smeta.GetControllerOf(k8sPod)
+		if owner == nil {
+			continue
+		}
+
+		switch owner.Kind {
+		case "ReplicaSet":
+			replicaSet, err := l.replicaSetLister.ReplicaSets(namespace).Get(owner.Name)
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return workload{}, false, err
+			}
+			if owner = k8smeta.GetControllerOf(replicaSet); owner == nil || owner.Kind != string(deploymentWorkload) {
+				continue
+			}
+			d, err := l.deploymentLister.Deployments(namespace).Get(owner.Name)
+			if err == nil {
+				return newDeploymentWorkload(d), true, nil
+			}
+		case string(statefulSetWorkload):
+			s, err := l.statefulSetLister.StatefulSets(namespace).Get(owner.Name)
+			if err == nil {
+				return newStatefulSetWorkload(s), true, nil
+			}
+		case string(daemonSetWorkload):
+			d, err := l.daemonSetLister.DaemonSets(namespace).Get(owner.Name)
+			if err == nil {
+				return newDaemonSetWorkload(d), true, nil
+			}
+		}
+	}
+	return workload{}, false, nil
+}
+
+// resolveBySelector finds the workload whose pod template matches the selector of the service, or else the one named after the service.
+// When several workloads match, the one named after the service is preferred, a StatefulSet being named after it by its service name.
+func (l workloadListers) resolveBySelector(namespace string, s service) (workload, bool, error) {
+	selector := s.podSelector()
+	var named, matching []workload
+	add := func(w workload, isNamed bool, template map[string]string) {
+		if isNamed {
+			named = append(named, w)
+		} else if selector.Matches(labels.Set(template)) {
+			matching = append(matching, w)
+		}
+	}
+
+	deployments, err := l.deploymentLister.Deployments(namespace).List(labels.Everything())
+	if err != nil {
+		return workload{}, false, fmt.Errorf("failed to retrieve deployments: %v", err.Error())
+	}
+	for _, d := range deployments {
+		add(newDeploymentWorkload(d), d.Name == s.name, d.Spec.Template.Labels)
+	}
+
+	statefulSets, err := l.statefulSetLister.StatefulSets(namespace).List(labels.Everything())
+	if err != nil {
+		return workload{}, false, fmt.Errorf("failed to retrieve StatefulSet: %v", err.Error())
+	}
+	for _, set := range statefulSets {
+		add(newStatefulSetWorkload(set), set.Spec.ServiceName == s.name, set.Spec.Template.Labels)
+	}
+
+	daemonSets, err := l.daemonSetLister.DaemonSets(namespace).List(labels.Everything())
+	if err != nil {
+		return workload{}, false, fmt.Errorf("failed to retrieve DaemonSets: %v", err.Error())
+	}
+	for _, d := range daemonSets {
+		add(newDaemonSetWorkload(d), d.Name == s.name, d.Spec.Template.Labels)
+	}
+
+	candidates := append(named, matching...)
+	if len(candidates) == 0 {
+		return workload{}, false, nil
+	}
+	if len(candidates) > 1 {
+		log.Warnf("Service %s matches several workloads, using %s.", s.key(), candidates[0])
+	}
+	return candidates[0], true, nil
+}
+
+// resolveCronJob returns the CronJob of a monitored CronJob. Its last successful run is the one reported by the CronJob,
+// or else the last successful Job it owns.
+func (l workloadListers) resolveCronJob(namespace string, name string) (workload, bool, error) {
+	cronJob, err := l.cronJobLister.CronJobs(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		return workload{}, false, nil
+	}
+	if err != nil {
+		return workload{}, false, err
+	}
+
+	w := workload{
+		kind:      cronJobWorkload,
+		name:      cronJob.Name,
+		created:   cronJob.CreationTimestamp.Time,
+		suspended: cronJob.Spec.Suspend != nil && *cronJob.Spec.Suspend,
+	}
+	if cronJob.Status.LastSuccessfulTime != nil {
+		w.lastSuccess = cronJob.Status.LastSuccessfulTime.Time
+	}
+
+	jobs, err := l.jobLister.Jobs(namespace).List(labels.Everything())
+	if err != nil {
+		return workload{}, false, fmt.Errorf("failed to retrieve Jobs: %v", err.Error())
+	}
+	for _, job := range jobs {
+		if owner := k8smeta.GetControllerOf(job); owner == nil || owner.Kind != string(cronJobWorkload) || owner.UID != cronJob.UID {
+			continue
+		}
+		if completed := getJobCompletionTime(job); completed.After(w.lastSuccess) {
+			w.lastSuccess = completed
+		}
+	}
+	return w, true, nil
+}
+
+// getJobCompletionTime returns when the Job succeeded, or the zero time if it did not.
+func getJobCompletionTime(job *batchv1.Job) time.Time {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobComplete && condition.Status == k8score.ConditionTrue {
+			if job.Status.CompletionTime != nil {
+				return job.Status.CompletionTime.Time
+			}
+			return condition.LastTransitionTime.Time
+		}
+	}
+	return time.Time{}
+}
+
+// checkCronJobHealth evaluates a monitored CronJob: it is ok as long as its last run succeeded within its maximum time since success.
+// A CronJob that has not succeeded yet is given that time from its creation, and a suspended CronJob is always ok.
+func checkCronJobHealth(s service, workloads map[string]workload, now time.Time) (string, error) {
+	w, exists := workloads[s.key()]
+	if !exists {
+		return "", fmt.Errorf("cannot find CronJob for service with name %s", s.key())
+	}
+	if w.suspended {
+		return "CronJob is suspended", nil
+	}
+
+	maxTimeSinceSuccess := s.maxTimeSinceSuccess
+	if maxTimeSinceSuccess == 0 {
+		maxTimeSinceSuccess = defaultMaxTimeSinceSuccess
+	}
+
+	if w.lastSuccess.IsZero() {
+		if now.Sub(w.created) < maxTimeSinceSuccess {
+			return "no successful run yet", nil
+		}
+		return "", fmt.Errorf("no successful run since the CronJob was created %s ago, at most %s allowed", formatAge(now.Sub(w.created)), maxTimeSinceSuccess)
+	}
+
+	age := now.Sub(w.lastSuccess)
+	if age > maxTimeSinceSuccess {
+		return "", fmt.Errorf("last successful run was %s ago, at most %s allowed", formatAge(age), maxTimeSinceSuccess)
+	}
+	return fmt.Sprintf("last successful run was %s ago", formatAge(age)), nil
+}
+
+func formatAge(age time.Duration) string {
+	return age.Truncate(time.Minute).String()
+}
+
+// getMaxTimeSinceSuccess returns the maximum time since the last successful run set on the CronJob by its annotation,
+// or 0 for the default one.
+func getMaxTimeSinceSuccess(k8sCronJob *batchv1.CronJob) time.Duration {
+	value, ok := k8sCronJob.Annotations[maxTimeSinceSuccessAnnotation]
+	if !ok {
+		return 0
+	}
+
+	maxTimeSinceSuccess, err := time.ParseDuration(strings.TrimSpace(value))
+	if err == nil && maxTimeSinceSuccess <= 0 {
+		err = errors.New("the duration should be positive")
+	}
+	if err != nil {
+		log.WithError(err).Warnf("Cannot parse the maximum time since success of CronJob with name %s, using %s.", k8sCronJob.Name, defaultMaxTimeSinceSuccess)
+		return 0
+	}
+	return maxTimeSinceSuccess
+}
+
+// populateCronJobService returns the service a monitored CronJob is checked as. It has no pods of its own to probe.
+func populateCronJobService(k8sCronJob *batchv1.CronJob, acks map[string]string) service {
+	s := service{
+		name:                k8sCronJob.Name,
+		namespace:           k8sCronJob.Namespace,
+		isResilient:         true,
+		workloadKind:        cronJobWorkload,
+		maxTimeSinceSuccess: getMaxTimeSinceSuccess(k8sCronJob),
+		labels:              k8sCronJob.Labels,
+	}
+	s.ack = acks[s.ackKey()]
+	return s
+}
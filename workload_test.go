@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	k8score "k8s.io/api/core/v1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newControllerRef(kind string, name string, uid types.UID) []k8smeta.OwnerReference {
+	isController := true
+	return []k8smeta.OwnerReference{{Kind: kind, Name: name, UID: uid, Controller: &isController}}
+}
+
+func TestGetWorkloadsFollowsOwnerReferences(t *testing.T) {
+	replicas := int32(3)
+	k8sPod := newTestPod("api-policy-7d9f8-x2x4z", "api-policy-v2")
+	k8sPod.OwnerReferences = newControllerRef("ReplicaSet", "api-policy-7d9f8", "")
+	hcService := initializeMockService(t, nil,
+		k8sPod,
+		&appsv1.ReplicaSet{ObjectMeta: k8smeta.ObjectMeta{
+			Name:            "api-policy-7d9f8",
+			Namespace:       k8score.NamespaceDefault,
+			OwnerReferences: newControllerRef("Deployment", "api-policy-component", ""),
+		}},
+		&appsv1.Deployment{
+			ObjectMeta: k8smeta.ObjectMeta{Name: "api-policy-component", Namespace: k8score.NamespaceDefault},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+			Status:     appsv1.DeploymentStatus{Replicas: 3, UpdatedReplicas: 3, ReadyReplicas: 2, AvailableReplicas: 2},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: k8smeta.ObjectMeta{Name: "api-policy", Namespace: k8score.NamespaceDefault},
+		})
+
+	s := service{name: "api-policy", namespace: k8score.NamespaceDefault, selector: map[string]string{"app": "api-policy-v2"}}
+	workloads, err := hcService.getWorkloads(context.TODO(), []service{s})
+	require.NoError(t, err)
+	assert.Equal(t, workload{
+		kind:            deploymentWorkload,
+		name:            "api-policy-component",
+		desiredReplicas: 3,
+		readyReplicas:   2,
+	}, workloads[s.key()],
+		"The owner of the pods should take precedence over the workload named after the service")
+}
+
+func TestGetWorkloadsMatchesPodTemplates(t *testing.T) {
+	hcService := initializeMockService(t, nil,
+		&appsv1.DaemonSet{
+			ObjectMeta: k8smeta.ObjectMeta{Name: "node-exporter-ds", Namespace: k8score.NamespaceDefault},
+			Spec: appsv1.DaemonSetSpec{Template: k8score.PodTemplateSpec{
+				ObjectMeta: k8smeta.ObjectMeta{Labels: map[string]string{"app": "node-exporter", "tier": "monitoring"}},
+			}},
+			Status: appsv1.DaemonSetStatus{DesiredNumberScheduled: 5, NumberReady: 4},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: k8smeta.ObjectMeta{Name: "exporter-proxy", Namespace: k8score.NamespaceDefault},
+			Spec: appsv1.DeploymentSpec{Template: k8score.PodTemplateSpec{
+				ObjectMeta: k8smeta.ObjectMeta{Labels: map[string]string{"app": "exporter-proxy"}},
+			}},
+		})
+
+	s := service{name: "node-exporter", namespace: k8score.NamespaceDefault, selector: map[string]string{"app": "node-exporter"}}
+	workloads, err := hcService.getWorkloads(context.TODO(), []service{s, {name: "unknown", namespace: k8score.NamespaceDefault}})
+	require.NoError(t, err)
+	assert.Len(t, workloads, 1)
+	assert.Equal(t, workload{kind: daemonSetWorkload, name: "node-exporter-ds", desiredReplicas: 5, readyReplicas: 4}, workloads[s.key()])
+}
+
+func TestEvaluateQuorumWithReadyReplicas(t *testing.T) {
+	workloads := map[string]workload{"default/service1": {kind: statefulSetWorkload, name: "service1", desiredReplicas: 3, readyReplicas: 2}}
+
+	status := evaluateQuorum(newQuorumServiceProbe(nil, 3, 0), workloads, nil)
+	assert.True(t, status.met())
+	assert.True(t, status.Degraded, "A service whose workload has fewer ready pods than it wants should be degraded")
+	assert.Equal(t, &workloadStatus{Kind: "StatefulSet", Name: "service1", Desired: 3, Ready: 2}, status.Workload)
+
+	assert.Nil(t, evaluateQuorum(newQuorumServiceProbe(nil, 3, 0), nil, nil).Workload)
+}
+
+func TestGetWorkloadsOfCronJobs(t *testing.T) {
+	created := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	lastSuccessful := k8smeta.NewTime(created.Add(time.Hour))
+	completed := k8smeta.NewTime(created.Add(2 * time.Hour))
+	suspend := true
+	hcService := initializeMockService(t, nil,
+		&batchv1.CronJob{ObjectMeta: k8smeta.ObjectMeta{
+			Name:              "content-cleanup",
+			Namespace:         k8score.NamespaceDefault,
+			UID:               "cleanup-uid",
+			CreationTimestamp: k8smeta.NewTime(created),
+		}, Status: batchv1.CronJobStatus{LastSuccessfulTime: &lastSuccessful}},
+		&batchv1.Job{
+			ObjectMeta: k8smeta.ObjectMeta{Name: "content-cleanup-1", Namespace: k8score.NamespaceDefault, OwnerReferences: newControllerRef("CronJob", "content-cleanup", "cleanup-uid")},
+			Status: batchv1.JobStatus{
+				CompletionTime: &completed,
+				Conditions:     []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: k8score.ConditionTrue}},
+			},
+		},
+		&batchv1.Job{
+			ObjectMeta: k8smeta.ObjectMeta{Name: "content-cleanup-2", Namespace: k8score.NamespaceDefault, OwnerReferences: newControllerRef("CronJob", "content-cleanup", "cleanup-uid")},
+			Status:     batchv1.JobStatus{Conditions: []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: k8score.ConditionTrue}}},
+		},
+		&batchv1.CronJob{
+			ObjectMeta: k8smeta.ObjectMeta{Name: "reindexer", Namespace: k8score.NamespaceDefault},
+			Spec:       batchv1.CronJobSpec{Suspend: &suspend},
+		})
+
+	cleanup := service{name: "content-cleanup", namespace: k8score.NamespaceDefault, workloadKind: cronJobWorkload}
+	reindexer := service{name: "reindexer", namespace: k8score.NamespaceDefault, workloadKind: cronJobWorkload}
+	workloads, err := hcService.getWorkloads(context.TODO(), []service{cleanup, reindexer})
+	require.NoError(t, err)
+	assert.Equal(t, workload{kind: cronJobWorkload, name: "content-cleanup", created: created, lastSuccess: completed.Time}, workloads[cleanup.key()],
+		"The last successful Job should be used when it completed after the last successful time of the CronJob")
+	assert.True(t, workloads[reindexer.key()].suspended)
+}
+
+func TestCheckCronJobHealth(t *testing.T) {
+	now := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+	s := service{name: "content-cleanup", namespace: k8score.NamespaceDefault, workloadKind: cronJobWorkload, maxTimeSinceSuccess: 2 * time.Hour}
+	newWorkloads := func(w workload) map[string]workload {
+		w.kind = cronJobWorkload
+		return map[string]workload{s.key(): w}
+	}
+
+	output, err := checkServiceHealth(serviceProbe{service: s}, newWorkloads(workload{lastSuccess: now.Add(-90 * time.Minute)}), now)
+	assert.NoError(t, err)
+	assert.Equal(t, "last successful run was 1h30m0s ago", output)
+
+	_, err = checkServiceHealth(serviceProbe{service: s}, newWorkloads(workload{lastSuccess: now.Add(-3 * time.Hour)}), now)
+	assert.EqualError(t, err, "last successful run was 3h0m0s ago, at most 2h0m0s allowed")
+
+	output, err = checkServiceHealth(serviceProbe{service: s}, newWorkloads(workload{created: now.Add(-time.Hour)}), now)
+	assert.NoError(t, err, "A new CronJob should be given the maximum time since success to succeed")
+	assert.Equal(t, "no successful run yet", output)
+
+	_, err = checkServiceHealth(serviceProbe{service: s}, newWorkloads(workload{created: now.Add(-3 * time.Hour)}), now)
+	assert.EqualError(t, err, "no successful run since the CronJob was created 3h0m0s ago, at most 2h0m0s allowed")
+
+	output, err = checkServiceHealth(serviceProbe{service: s}, newWorkloads(workload{suspended: true}), now)
+	assert.NoError(t, err)
+	assert.Equal(t, "CronJob is suspended", output)
+
+	_, err = checkServiceHealth(serviceProbe{service: s}, nil, now)
+	assert.EqualError(t, err, "cannot find CronJob for service with name default/content-cleanup")
+
+	s.maxTimeSinceSuccess = 0
+	_, err = checkServiceHealth(serviceProbe{service: s}, newWorkloads(workload{lastSuccess: now.Add(-3 * time.Hour)}), now)
+	assert.NoError(t, err, "The default maximum time since success should be a day")
+}
+
+func TestGetMaxTimeSinceSuccess(t *testing.T) {
+	newK8sCronJob := func(annotations map[string]string) *batchv1.CronJob {
+		return &batchv1.CronJob{ObjectMeta: k8smeta.ObjectMeta{Name: "content-cleanup", Annotations: annotations}}
+	}
+
+	assert.Equal(t, time.Duration(0), getMaxTimeSinceSuccess(newK8sCronJob(nil)))
+	assert.Equal(t, 90*time.Minute, getMaxTimeSinceSuccess(newK8sCronJob(map[string]string{maxTimeSinceSuccessAnnotation: "90m"})))
+	assert.Equal(t, time.Duration(0), getMaxTimeSinceSuccess(newK8sCronJob(map[string]string{maxTimeSinceSuccessAnnotation: "90"})))
+	assert.Equal(t, time.Duration(0), getMaxTimeSinceSuccess(newK8sCronJob(map[string]string{maxTimeSinceSuccessAnnotation: "-1h"})))
+}
+
+func TestCronJobInformerPublishesServiceEvents(t *testing.T) {
+	hcService := initializeMockService(t, nil, newTestService("content-cleanup", k8score.NamespaceDefault))
+	drainServiceEvents(t, hcService, 1)
+	waitForWatch(t, hcService, "cronjobs")
+
+	cronJobs := hcService.k8sClient.BatchV1().CronJobs(k8score.NamespaceDefault)
+	k8sCronJob := &batchv1.CronJob{ObjectMeta: k8smeta.ObjectMeta{
+		Name:        "reindexer",
+		Namespace:   k8score.NamespaceDefault,
+		Labels:      map[string]string{"hasHealthcheck": "true"},
+		Annotations: map[string]string{maxTimeSinceSuccessAnnotation: "6h"},
+	}}
+	_, err := cronJobs.Create(context.TODO(), k8sCronJob, k8smeta.CreateOptions{})
+	require.NoError(t, err)
+
+	select {
+	case event := <-hcService.getServiceEvents():
+		assert.Equal(t, serviceUpserted, event.eventType)
+		assert.True(t, event.service.isCronJob())
+		assert.Equal(t, 6*time.Hour, event.service.maxTimeSinceSuccess)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "Expected an event for the monitored CronJob")
+	}
+
+	// a CronJob with the same name as a monitored Service does not replace it, nor does its removal remove it
+	clashing := k8sCronJob.DeepCopy()
+	clashing.Name = "content-cleanup"
+	_, err = cronJobs.Create(context.TODO(), clashing, k8smeta.CreateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, cronJobs.Delete(context.TODO(), clashing.Name, k8smeta.DeleteOptions{}))
+	require.NoError(t, cronJobs.Delete(context.TODO(), k8sCronJob.Name, k8smeta.DeleteOptions{}))
+
+	select {
+	case event := <-hcService.getServiceEvents():
+		assert.Equal(t, serviceRemoved, event.eventType)
+		assert.Equal(t, "default/reindexer", event.service.key())
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "Expected the removal of the monitored CronJob")
+	}
+
+	s, err := hcService.getServiceByName("content-cleanup")
+	require.NoError(t, err)
+	assert.False(t, s.isCronJob())
+}
+
+func TestGetDeploymentRollout(t *testing.T) {
+	replicas := int32(3)
+	newDeployment := func(generation int64, status appsv1.DeploymentStatus) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: k8smeta.ObjectMeta{Name: "service1", Generation: generation},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+			Status:     status,
+		}
+	}
+	rolledOut := appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 3}
+
+	tests := []struct {
+		name            string
+		deployment      *appsv1.Deployment
+		expectedState   rolloutState
+		expectedMessage string
+	}{
+		{name: "rolled out", deployment: newDeployment(2, rolledOut)},
+		{name: "new spec", deployment: newDeployment(3, rolledOut), expectedState: rolloutInProgress, expectedMessage: "waiting for the new spec to be observed"},
+		{
+			name:            "updating",
+			deployment:      newDeployment(2, appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 4, UpdatedReplicas: 1, AvailableReplicas: 3}),
+			expectedState:   rolloutInProgress,
+			expectedMessage: "1 of 3 pods updated",
+		},
+		{
+			name:            "terminating",
+			deployment:      newDeployment(2, appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 4, UpdatedReplicas: 3, AvailableReplicas: 3}),
+			expectedState:   rolloutInProgress,
+			expectedMessage: "1 old pods terminating",
+		},
+		{
+			name: "updated pods starting",
+			deployment: newDeployment(2, appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 2,
+				Conditions: []appsv1.DeploymentCondition{{Type: appsv1.DeploymentProgressing, Status: k8score.ConditionTrue, Reason: "ReplicaSetUpdated"}}}),
+			expectedState:   rolloutInProgress,
+			expectedMessage: "2 of 3 updated pods available",
+		},
+		{
+			name: "rolled out with an unavailable pod",
+			deployment: newDeployment(2, appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 2,
+				Conditions: []appsv1.DeploymentCondition{{Type: appsv1.DeploymentProgressing, Status: k8score.ConditionTrue, Reason: deploymentNewReplicaSetAvailable}}}),
+		},
+		{
+			name:       "unavailable pod without a Progressing condition",
+			deployment: newDeployment(2, appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 2}),
+		},
+		{
+			name: "stuck",
+			deployment: newDeployment(2, appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 4, UpdatedReplicas: 1, Conditions: []appsv1.DeploymentCondition{{
+				Type:    appsv1.DeploymentProgressing,
+				Status:  k8score.ConditionFalse,
+				Reason:  deploymentProgressDeadlineExceeded,
+				Message: `ReplicaSet "service1-7d9f8" has timed out progressing.`,
+			}}}),
+			expectedState:   rolloutStuck,
+			expectedMessage: `ReplicaSet "service1-7d9f8" has timed out progressing.`,
+		},
+	}
+	for _, test := range tests {
+		state, message := getDeploymentRollout(test.deployment)
+		assert.Equal(t, test.expectedState, state, test.name)
+		assert.Equal(t, test.expectedMessage, message, test.name)
+	}
+
+	paused := newDeployment(3, rolledOut)
+	paused.Spec.Paused = true
+	state, _ := getDeploymentRollout(paused)
+	assert.Equal(t, rolloutState(""), state, "A paused Deployment should not be rolling out")
+}
+
+func TestCheckServiceHealthDuringRollout(t *testing.T) {
+	rollingOut := map[string]workload{"default/service1": {
+		kind:            deploymentWorkload,
+		name:            "service1",
+		desiredReplicas: 3,
+		readyReplicas:   1,
+		rollout:         rolloutInProgress,
+		rolloutMessage:  "1 of 3 pods updated",
+	}}
+
+	output, err := checkServiceHealth(newQuorumServiceProbe(nil, 1, 2), rollingOut, time.Now())
+	assert.NoError(t, err, "A service rolling out should not fail")
+	assert.Equal(t, "1/3 pods available, rolling out (1 of 3 pods updated), degraded - notok-pod: failing checks check (severity 1); notok-pod: failing checks check (severity 1)", output)
+	assert.True(t, newCheckBreakdown(newQuorumServiceProbe(nil, 1, 2), rollingOut, time.Now()).quorum.Degraded)
+
+	stuck := map[string]workload{"default/service1": {
+		kind:            deploymentWorkload,
+		name:            "service1",
+		desiredReplicas: 3,
+		readyReplicas:   3,
+		rollout:         rolloutStuck,
+		rolloutMessage:  `ReplicaSet "service1-7d9f8" has timed out progressing.`,
+	}}
+	_, err = checkServiceHealth(newQuorumServiceProbe(nil, 3, 0), stuck, time.Now())
+	assert.EqualError(t, err, `3/3 pods available, rollout stuck (ReplicaSet "service1-7d9f8" has timed out progressing.)`,
+		"A stuck rollout should fail even with all the pods healthy")
+}